@@ -1,16 +1,30 @@
 package main
 
 import (
-	_ "github.com/miguoliang/arch-go/configs"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/health"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/metrics"
 	"github.com/miguoliang/arch-go/internal/resource"
-	"gopkg.in/Graylog2/go-gelf.v2/gelf"
-	"io"
-	"log"
+	"github.com/miguoliang/arch-go/internal/rpc"
+	"github.com/miguoliang/arch-go/internal/secret"
+	"github.com/miguoliang/arch-go/internal/server"
+	"github.com/miguoliang/arch-go/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
-)
-
-const (
-	graylogAddr = "localhost:12201"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 // @title Arch-Go API
@@ -24,26 +38,234 @@ const (
 // @contact.email boymgl@qq.com
 // @contact.url https://miguoliang.com
 func main() {
+	configFile := flag.String("config", "", "config file, config.yaml in "+strings.Join(configs.SearchPaths, ", ")+" when empty")
+	profile := flag.String("profile", os.Getenv(configs.ProfileEnv), "profile overlaid on the config file: dev, test or prod")
+	flag.Parse()
+
+	cfg, err := configs.Load(configs.Options{File: *configFile, Profile: *profile})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logs, err := logging.Setup(cfg.Logging)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "logging:", err)
+		os.Exit(1)
+	}
+	defer logs.Close()
+	slog.Info("configuration loaded", "file", cfg.File, "profile", cfg.Profile)
 
-	setupLog()
+	var secrets secret.Provider
+	if cfg.Secrets.Provider != "" {
+		cache, err := secret.New(cfg.Secrets)
+		if err != nil {
+			logging.Fatal("failed to set up secrets", "error", err)
+		}
+		defer cache.Stop()
+		secrets = cache
+	}
+	keycloak.Configure(cfg.AdminOptions(secrets))
 
-	r := resource.SetupRoutes()
+	watcher := configs.NewWatcher(cfg)
+	watcher.OnChange(func(old *configs.Config, new *configs.Config) {
+		applyConfig(old, new, secrets)
+	})
+	resource.ConfigWatcher = watcher
+	if cfg.Reload.Watch {
+		watcher.Watch()
+	}
+	go reloadOnHangup(watcher)
 
-	err := r.Run("0.0.0.0:8081")
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatalf(err.Error())
-		return
+		logging.Fatal("failed to set up tracing", "error", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	log.Println("Started!")
+	recorder, err := resource.NewAuditRecorder(cfg.Audit, logs.Gelf)
+	if err != nil {
+		logging.Fatal("failed to set up audit", "error", err)
+	}
+	resource.Audit = recorder
+
+	if cfg.Health.Startup.Wait {
+		waitForKeycloak(cfg)
+	}
+
+	options := cfg.Server
+	deps := resource.NewDependencies(cfg)
+	httpServer, err := server.New(resource.SetupRoutes(deps), options)
+	if err != nil {
+		logging.Fatal("failed to set up the server", "error", err)
+	}
+	// Event streams never finish on their own, end them so they do not hold
+	// up the shutdown.
+	httpServer.RegisterOnShutdown(func() {
+		if resource.Stream != nil {
+			resource.Stream.Close()
+		}
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 3)
+	var grpcServer *grpc.Server
+	if cfg.Grpc.Enabled {
+		grpcServer = serveGRPC(cfg.Grpc, deps, errs)
+	}
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		metricsServer = serveMetrics(cfg.Metrics, errs)
+	}
+	go func() {
+		slog.Info("listening", "address", httpServer.Addr, "tls", httpServer.TLSConfig != nil)
+		if err := server.ListenAndServe(httpServer); err != nil {
+			errs <- fmt.Errorf("http: %w", err)
+		}
+	}()
+
+	failed := false
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down", "timeout", options.ShutdownTimeout)
+	case err := <-errs:
+		slog.Error("server failed, shutting down", "error", err)
+		failed = true
+	}
+	stop()
+
+	shutdown(httpServer, grpcServer, metricsServer, options.ShutdownTimeout)
+	if deps.Cache != nil {
+		_ = deps.Cache.Close()
+	}
+	if failed {
+		shutdownTracing(context.Background())
+		logs.Close()
+		os.Exit(1)
+	}
+	slog.Info("stopped")
+}
+
+// shutdown stops taking requests, waits for the ones in flight for at most
+// timeout, and then stops the background workers. The metrics are served
+// until the end, so the drain can be watched.
+func shutdown(httpServer *http.Server, grpcServer *grpc.Server, metricsServer *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("http requests did not finish in time", "error", err)
+		}
+	}()
+	if grpcServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				slog.Warn("grpc calls did not finish in time")
+				grpcServer.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	resource.StopWorkers()
+
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Warn("failed to stop the metrics server", "error", err)
+		}
+	}
+}
+
+// applyConfig applies the settings that can change without a restart. The
+// secrets provider is kept, changes to it take a restart.
+func applyConfig(old *configs.Config, new *configs.Config, secrets secret.Provider) {
+	if new.AdminOptions(secrets) != old.AdminOptions(secrets) {
+		keycloak.Configure(new.AdminOptions(secrets))
+		slog.Info("keycloak admin credentials replaced")
+	}
+	if new.Logging.Level != old.Logging.Level {
+		if err := logging.SetLevel(new.Logging.Level); err != nil {
+			slog.Error("failed to change the log level", "error", err)
+		}
+	}
 }
 
-func setupLog() {
+// reloadOnHangup reloads the configuration on SIGHUP, e.g. after a secret
+// file was rotated.
+func reloadOnHangup(watcher *configs.Watcher) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		_ = watcher.Reload()
+	}
+}
 
-	gelfWriter, err := gelf.NewUDPWriter(graylogAddr)
+// waitForKeycloak blocks until Keycloak is ready, so the service does not
+// start answering with errors while Keycloak is still booting.
+func waitForKeycloak(cfg *configs.Config) {
+	url := cfg.KeycloakHealthURL()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Health.Startup.Timeout)
+	defer cancel()
+	slog.Info("waiting for keycloak", "url", url)
+	if err := health.WaitFor(ctx, url, cfg.Health.Startup.Interval); err != nil {
+		logging.Fatal("keycloak did not become ready", "error", err)
+	}
+}
+
+func serveGRPC(options configs.Grpc, deps resource.Dependencies, errs chan<- error) *grpc.Server {
+	listener, err := net.Listen("tcp", options.Address)
 	if err != nil {
-		log.Fatalf("gelf.NewWriter: %s", err)
+		logging.Fatal("failed to listen for grpc", "error", err)
+	}
+	grpcServer := rpc.NewServer(rpc.Options{
+		Users:       deps.Users,
+		Groups:      deps.Groups,
+		Roles:       deps.Roles,
+		Events:      resource.Events,
+		Audit:       resource.Audit,
+		AuditHeader: deps.Config.Audit.Header,
+		RateLimiter: resource.RateLimiter,
+		Reflection:  options.Reflection,
+	}, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	go func() {
+		slog.Info("grpc listening", "address", listener.Addr().String())
+		if err := grpcServer.Serve(listener); err != nil {
+			errs <- fmt.Errorf("grpc: %w", err)
+		}
+	}()
+	return grpcServer
+}
+
+// serveMetrics exposes the metrics on their own port, so they are not
+// reachable through the public API.
+func serveMetrics(options configs.Metrics, errs chan<- error) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(options.Path, metrics.Handler())
+	metricsServer := &http.Server{
+		Addr:              options.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
-	// log to both stderr and graylog2
-	log.SetOutput(io.MultiWriter(os.Stderr, gelfWriter))
+	go func() {
+		slog.Info("metrics listening", "address", metricsServer.Addr)
+		if err := server.ListenAndServe(metricsServer); err != nil {
+			errs <- fmt.Errorf("metrics: %w", err)
+		}
+	}()
+	return metricsServer
 }
@@ -0,0 +1,49 @@
+// Command seal writes the file of the encrypted-file secrets provider. It
+// reads a JSON object of secret names and values from stdin, and encrypts
+// it with the base64 encoded key in the variable named by -key-env:
+//
+//	echo '{"keycloak-admin-password":"..."}' | go run ./cmd/seal > secrets.enc
+//
+// A key is made with: openssl rand -base64 32
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/secret"
+	"io"
+	"os"
+)
+
+func main() {
+	keyEnv := flag.String("key-env", "ARCH_GO_SECRETS_KEY", "variable holding the base64 encoded 32 byte key")
+	flag.Parse()
+
+	if err := seal(*keyEnv); err != nil {
+		fmt.Fprintln(os.Stderr, "seal:", err)
+		os.Exit(1)
+	}
+}
+
+func seal(keyEnv string) error {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv(keyEnv))
+	if err != nil {
+		return fmt.Errorf("%s: %w", keyEnv, err)
+	}
+	plaintext, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("stdin must be a JSON object of strings: %w", err)
+	}
+	sealed, err := secret.Seal(key, plaintext)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(sealed)
+	return err
+}
@@ -1,19 +1,511 @@
+// Package configs loads the configuration of the service: the defaults in
+// config.yaml, a config file, a profile overlay, environment variables and
+// secrets read from files, in that order of precedence.
 package configs
 
 import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/idempotency"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/ratelimit"
+	"github.com/miguoliang/arch-go/internal/secret"
+	"github.com/miguoliang/arch-go/internal/server"
+	"github.com/miguoliang/arch-go/internal/tracing"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-func init() {
+const (
+	// EnvPrefix prefixes the environment variables that override keys, e.g.
+	// ARCH_GO_KEYCLOAK_ADMIN_PASSWORD for keycloak.admin.password. With the
+	// _FILE suffix, the value is read from the file the variable names.
+	EnvPrefix = "ARCH_GO"
+	// ProfileEnv selects the profile when no --profile flag is given.
+	ProfileEnv = EnvPrefix + "_PROFILE"
 
-	// Set configuration file paths based on Gin mode
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("../configs") // Look for configuration files in the current directory
+	ProfileDev  = "dev"
+	ProfileTest = "test"
+	ProfileProd = "prod"
+)
+
+// SearchPaths are where config.yaml is looked for when no file is given.
+var SearchPaths = []string{".", "./configs", "../configs", "/etc/arch-go"}
+
+//go:embed config.yaml config.*.yaml
+var embedded embed.FS
+
+// Config is the configuration of the service.
+type Config struct {
+	Server      server.Options      `mapstructure:"server"`
+	Keycloak    Keycloak            `mapstructure:"keycloak"`
+	Jobs        Jobs                `mapstructure:"jobs"`
+	Idempotency idempotency.Options `mapstructure:"idempotency"`
+	Audit       Audit               `mapstructure:"audit"`
+	Webhooks    Webhooks            `mapstructure:"webhooks"`
+	Events      Events              `mapstructure:"events"`
+	Outbox      Outbox              `mapstructure:"outbox"`
+	Grpc        Grpc                `mapstructure:"grpc"`
+	Graphql     Graphql             `mapstructure:"graphql"`
+	Metrics     Metrics             `mapstructure:"metrics"`
+	Tracing     tracing.Options     `mapstructure:"tracing"`
+	Logging     logging.Options     `mapstructure:"logging"`
+	Health      Health              `mapstructure:"health"`
+	Reload      Reload              `mapstructure:"reload"`
+	Secrets     secret.Options      `mapstructure:"secrets"`
+	Cache       cache.Options       `mapstructure:"cache"`
+	RateLimit   ratelimit.Options   `mapstructure:"rate-limit"`
+
+	// Profile and File tell where the configuration came from, File is
+	// empty when only the defaults were used.
+	Profile string `mapstructure:"-"`
+	File    string `mapstructure:"-"`
+}
+
+type Keycloak struct {
+	URL    string `mapstructure:"url"`
+	Custom struct {
+		Realm string `mapstructure:"realm"`
+	} `mapstructure:"custom"`
+	Admin struct {
+		Realm    string `mapstructure:"realm"`
+		ClientId string `mapstructure:"client-id"`
+		Username string `mapstructure:"username"`
+		Password string `mapstructure:"password"`
+	} `mapstructure:"admin"`
+	Client keycloak.ClientOptions `mapstructure:"client"`
+}
+
+type Jobs struct {
+	Store   string `mapstructure:"store"`
+	Dir     string `mapstructure:"dir"`
+	Workers int    `mapstructure:"workers"`
+}
+
+type Audit struct {
+	// Header is set by the gateway in front to the caller it verified, e.g.
+	// X-Authenticated-User; the entries are attributed to the ip without it.
+	Header string   `mapstructure:"header"`
+	Sinks  []string `mapstructure:"sinks"`
+	File   string   `mapstructure:"file"`
+	Gelf   string   `mapstructure:"gelf"`
+	SQL    struct {
+		Driver string `mapstructure:"driver"`
+		DSN    string `mapstructure:"dsn"`
+	} `mapstructure:"sql"`
+}
+
+type Webhooks struct {
+	Store          string        `mapstructure:"store"`
+	File           string        `mapstructure:"file"`
+	Workers        int           `mapstructure:"workers"`
+	MaxAttempts    int           `mapstructure:"max-attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial-backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max-backoff"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	QueueSize      int           `mapstructure:"queue-size"`
+	// AllowedNetworks are the loopback, link-local and private networks
+	// subscriptions may deliver to, none by default.
+	AllowedNetworks []string `mapstructure:"allowed-networks"`
+}
+
+type Events struct {
+	Stream struct {
+		Buffer          int           `mapstructure:"buffer"`
+		Heartbeat       time.Duration `mapstructure:"heartbeat"`
+		PollAdminEvents bool          `mapstructure:"poll-admin-events"`
+		PollInterval    time.Duration `mapstructure:"poll-interval"`
+		DedupWindow     time.Duration `mapstructure:"dedup-window"`
+	} `mapstructure:"stream"`
+}
+
+type Outbox struct {
+	Publisher  string        `mapstructure:"publisher"`
+	Store      string        `mapstructure:"store"`
+	File       string        `mapstructure:"file"`
+	Source     string        `mapstructure:"source"`
+	TypePrefix string        `mapstructure:"type-prefix"`
+	BatchSize  int           `mapstructure:"batch-size"`
+	Interval   time.Duration `mapstructure:"interval"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	MaxBackoff time.Duration `mapstructure:"max-backoff"`
+	Nats       struct {
+		URL           string `mapstructure:"url"`
+		SubjectPrefix string `mapstructure:"subject-prefix"`
+		JetStream     bool   `mapstructure:"jetstream"`
+	} `mapstructure:"nats"`
+	Kafka struct {
+		Brokers []string `mapstructure:"brokers"`
+		Topic   string   `mapstructure:"topic"`
+	} `mapstructure:"kafka"`
+}
+
+type Grpc struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	Address    string `mapstructure:"address"`
+	Reflection bool   `mapstructure:"reflection"`
+}
+
+type Graphql struct {
+	Enabled bool `mapstructure:"enabled"`
+	// The queries deeper or costlier than these are rejected before they
+	// run, see graph.Limits.
+	MaxDepth    int `mapstructure:"max-depth"`
+	MaxCost     int `mapstructure:"max-cost"`
+	MaxListSize int `mapstructure:"max-list-size"`
+}
+
+type Metrics struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Address string `mapstructure:"address"`
+	Path    string `mapstructure:"path"`
+}
+
+type Health struct {
+	CacheTTL time.Duration `mapstructure:"cache-ttl"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Startup  struct {
+		Wait     bool          `mapstructure:"wait"`
+		Path     string        `mapstructure:"path"`
+		Interval time.Duration `mapstructure:"interval"`
+		Timeout  time.Duration `mapstructure:"timeout"`
+	} `mapstructure:"startup"`
+}
 
-	viper.AutomaticEnv() // Enable automatic environment variable parsing
-	err := viper.ReadInConfig()
+type Reload struct {
+	Watch bool `mapstructure:"watch"`
+}
+
+// AdminOptions are the server and credentials of the Keycloak admin client.
+// The password is taken from secrets when given, and from the configuration
+// otherwise.
+func (c *Config) AdminOptions(secrets secret.Provider) keycloak.AdminOptions {
+	options := keycloak.AdminOptions{
+		URL:      c.Keycloak.URL,
+		Realm:    c.Keycloak.Admin.Realm,
+		ClientId: c.Keycloak.Admin.ClientId,
+		Username: c.Keycloak.Admin.Username,
+		Password: c.Keycloak.Admin.Password,
+		Client:   c.Keycloak.Client,
+	}
+	if secrets != nil {
+		options.Secrets = secrets
+		options.PasswordSecret = c.Secrets.AdminPassword
+	}
+	return options
+}
+
+// KeycloakHealthURL is the Keycloak readiness endpoint the service waits for
+// on startup, the one the compose healthcheck probes.
+func (c *Config) KeycloakHealthURL() string {
+	return strings.TrimSuffix(c.Keycloak.URL, "/") + c.Health.Startup.Path
+}
+
+// Options select the configuration to load.
+type Options struct {
+	// File is the config file, looked for in SearchPaths when empty.
+	File string
+	// Profile is dev, test, prod or empty for none. It overlays
+	// config.<profile>.yaml on the config file.
+	Profile string
+	// Environ is the environment, os.Environ() when nil.
+	Environ []string
+}
+
+// Load reads and validates the configuration.
+func Load(options Options) (*Config, error) {
+	v, file, err := read(options)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{Profile: options.Profile, File: file}
+	if err := v.Unmarshal(config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))); err != nil {
+		return nil, fmt.Errorf("decode configuration: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func read(options Options) (*viper.Viper, string, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	defaults, err := embedded.ReadFile("config.yaml")
 	if err != nil {
-		panic("No configuration file loaded - using defaults")
+		return nil, "", err
+	}
+	if err := v.ReadConfig(bytes.NewReader(defaults)); err != nil {
+		return nil, "", fmt.Errorf("read default configuration: %w", err)
+	}
+
+	file := options.File
+	if file == "" {
+		file = search("config.yaml")
+	}
+	if file != "" {
+		if err := merge(v, file); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if options.Profile != "" {
+		if err := mergeProfile(v, file, options.Profile); err != nil {
+			return nil, "", err
+		}
+	}
+
+	environ := options.Environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	if err := applyEnv(v, environ); err != nil {
+		return nil, "", err
+	}
+	return v, file, nil
+}
+
+func search(name string) string {
+	for _, dir := range SearchPaths {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+func merge(v *viper.Viper, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	if err := v.MergeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("parse config file %s: %w", file, err)
+	}
+	return nil
+}
+
+// mergeProfile overlays config.<profile>.yaml, from the directory of the
+// config file, the search paths, or the profiles built in, in that order.
+func mergeProfile(v *viper.Viper, file string, profile string) error {
+	if path := profileFile(file, profile); path != "" {
+		return merge(v, path)
+	}
+	name := "config." + profile + ".yaml"
+	data, err := embedded.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("unknown profile %q: no %s found", profile, name)
+	}
+	if err := v.MergeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("parse profile %s: %w", profile, err)
+	}
+	return nil
+}
+
+// applyEnv overrides the keys that have an environment variable, and reads
+// the secrets of those that have one with the _FILE suffix.
+func applyEnv(v *viper.Viper, environ []string) error {
+	env := map[string]string{}
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+	for _, key := range v.AllKeys() {
+		name := EnvName(key)
+		if path, ok := env[name+"_FILE"]; ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("%s_FILE: %w", name, err)
+			}
+			v.Set(key, strings.TrimRight(string(data), "\r\n"))
+			continue
+		}
+		if value, ok := env[name]; ok {
+			v.Set(key, value)
+		}
+	}
+	return nil
+}
+
+// EnvName is the environment variable that overrides key.
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// Validate reports every invalid setting at once, so a broken deployment is
+// fixed in one go.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	oneOf := func(value string, key string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+
+	check(c.Server.Address != "", "server.address", "is required")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown-timeout", "must be positive")
+	check(c.Server.MaxHeaderBytes >= 0, "server.max-header-bytes", "must not be negative")
+	check((c.Server.TLS.CertFile == "") == (c.Server.TLS.KeyFile == ""), "server.tls", "cert-file and key-file must be set together")
+	check(c.Server.TLS.ClientCAFile == "" || c.Server.TLS.Enabled(), "server.tls.client-ca-file", "requires cert-file and key-file")
+
+	check(strings.HasPrefix(c.Keycloak.URL, "http://") || strings.HasPrefix(c.Keycloak.URL, "https://"), "keycloak.url", "must be an http or https URL, got %q", c.Keycloak.URL)
+	check(c.Keycloak.Custom.Realm != "", "keycloak.custom.realm", "is required")
+	check(c.Keycloak.Admin.Realm != "", "keycloak.admin.realm", "is required")
+	check(c.Keycloak.Admin.ClientId != "", "keycloak.admin.client-id", "is required")
+	check(c.Keycloak.Admin.Username != "", "keycloak.admin.username", "is required")
+	check(c.Keycloak.Admin.Password != "" || c.Secrets.Provider != "", "keycloak.admin.password", "is required without secrets.provider, set %s or %s_FILE", EnvName("keycloak.admin.password"), EnvName("keycloak.admin.password"))
+
+	client := c.Keycloak.Client
+	check(client.Timeout >= 0, "keycloak.client.timeout", "must not be negative")
+	check(client.Retry.MaxAttempts > 0, "keycloak.client.retry.max-attempts", "must be positive")
+	check(client.Retry.InitialBackoff >= 0 && client.Retry.InitialBackoff <= client.Retry.MaxBackoff, "keycloak.client.retry", "initial-backoff must be between 0 and max-backoff")
+	check(client.Breaker.Failures >= 0, "keycloak.client.breaker.failures", "must not be negative")
+	check(client.Breaker.Failures == 0 || client.Breaker.OpenTimeout > 0, "keycloak.client.breaker.open-timeout", "must be positive when the breaker is enabled")
+
+	if c.Secrets.Provider != "" {
+		oneOf(c.Secrets.Provider, "secrets.provider", secret.ProviderEnv, secret.ProviderFile, secret.ProviderEncryptedFile, secret.ProviderVault)
+		check(c.Secrets.AdminPassword != "", "secrets.admin-password", "is required with a secrets provider")
+		check(c.Secrets.Refresh >= 0, "secrets.refresh", "must not be negative")
+		switch c.Secrets.Provider {
+		case secret.ProviderFile:
+			check(c.Secrets.File.Dir != "", "secrets.file.dir", "is required with the file provider")
+		case secret.ProviderEncryptedFile:
+			check(c.Secrets.EncryptedFile.Path != "", "secrets.encrypted-file.path", "is required with the encrypted-file provider")
+			check(c.Secrets.EncryptedFile.KeyEnv != "", "secrets.encrypted-file.key-env", "is required with the encrypted-file provider")
+		case secret.ProviderVault:
+			check(strings.HasPrefix(c.Secrets.Vault.Address, "http://") || strings.HasPrefix(c.Secrets.Vault.Address, "https://"), "secrets.vault.address", "must be an http or https URL, got %q", c.Secrets.Vault.Address)
+			check(c.Secrets.Vault.Token != "", "secrets.vault.token", "is required with the vault provider, set %s or %s_FILE", EnvName("secrets.vault.token"), EnvName("secrets.vault.token"))
+			check(c.Secrets.Vault.Mount != "" && c.Secrets.Vault.Path != "", "secrets.vault", "mount and path are required with the vault provider")
+		}
+	}
+
+	if c.Cache.Enabled {
+		oneOf(c.Cache.Backend, "cache.backend", cache.BackendMemory, cache.BackendRedis)
+		check(c.Cache.TTL.Users >= 0 && c.Cache.TTL.Groups >= 0 && c.Cache.TTL.Roles >= 0, "cache.ttl", "must not be negative")
+		check(c.Cache.Backend != cache.BackendRedis || c.Cache.Redis.Address != "", "cache.redis.address", "is required with the redis backend")
+	}
+
+	if c.RateLimit.Enabled {
+		oneOf(c.RateLimit.Key, "rate-limit.key", ratelimit.KeyIP, ratelimit.KeyHeader, ratelimit.KeySubject, ratelimit.KeyClient)
+		check(c.RateLimit.Key != ratelimit.KeyHeader || c.RateLimit.Header != "", "rate-limit.header", "is required with the header key")
+		oneOf(c.RateLimit.Backend, "rate-limit.backend", ratelimit.BackendMemory, ratelimit.BackendRedis)
+		checkLimit := func(limit ratelimit.Limit, key string) {
+			check(limit.Requests > 0, key+".requests", "must be positive")
+			check(limit.Period > 0, key+".period", "must be positive")
+			check(limit.Burst >= 0, key+".burst", "must not be negative")
+		}
+		checkLimit(c.RateLimit.Default, "rate-limit.default")
+		for i, route := range c.RateLimit.Routes {
+			key := fmt.Sprintf("rate-limit.routes[%d]", i)
+			fields := strings.Fields(route.Route)
+			check(len(fields) == 2 && strings.HasPrefix(fields[1], "/"), key+".route", "must be a method and a path, e.g. \"DELETE /api/v1/users/:id\", got %q", route.Route)
+			checkLimit(route.Limit, key)
+		}
+		check(c.RateLimit.Backend != ratelimit.BackendRedis || c.RateLimit.Redis.Address != "", "rate-limit.redis.address", "is required with the redis backend")
+	}
+
+	oneOf(c.Idempotency.Backend, "idempotency.backend", idempotency.BackendMemory, idempotency.BackendRedis)
+	check(c.Idempotency.TTL >= 0, "idempotency.ttl", "must not be negative")
+	check(c.Idempotency.MaxBodySize >= 0, "idempotency.max-body-size", "must not be negative")
+	check(c.Idempotency.Backend != idempotency.BackendRedis || c.Idempotency.Redis.Address != "", "idempotency.redis.address", "is required with the redis backend")
+
+	oneOf(c.Jobs.Store, "jobs.store", "memory", "file")
+	check(c.Jobs.Store != "file" || c.Jobs.Dir != "", "jobs.dir", "is required with the file store")
+	check(c.Jobs.Workers > 0, "jobs.workers", "must be positive")
+
+	for _, sink := range c.Audit.Sinks {
+		oneOf(sink, "audit.sinks", "file", "gelf", "sql")
+		switch sink {
+		case "file":
+			check(c.Audit.File != "", "audit.file", "is required with the file sink")
+		case "sql":
+			check(c.Audit.SQL.Driver != "" && c.Audit.SQL.DSN != "", "audit.sql", "driver and dsn are required with the sql sink")
+		}
+	}
+
+	oneOf(c.Webhooks.Store, "webhooks.store", "memory", "file")
+	check(c.Webhooks.Store != "file" || c.Webhooks.File != "", "webhooks.file", "is required with the file store")
+	check(c.Webhooks.Workers > 0, "webhooks.workers", "must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max-attempts", "must be positive")
+	check(c.Webhooks.QueueSize > 0, "webhooks.queue-size", "must be positive")
+	for _, network := range c.Webhooks.AllowedNetworks {
+		_, err := netip.ParsePrefix(network)
+		check(err == nil, "webhooks.allowed-networks", "%q is not a CIDR", network)
+	}
+
+	check(c.Events.Stream.Buffer > 0, "events.stream.buffer", "must be positive")
+
+	oneOf(c.Outbox.Publisher, "outbox.publisher", "none", "memory", "nats", "kafka")
+	oneOf(c.Outbox.Store, "outbox.store", "memory", "file")
+	check(c.Outbox.Store != "file" || c.Outbox.File != "", "outbox.file", "is required with the file store")
+	check(c.Outbox.Publisher != "nats" || c.Outbox.Nats.URL != "", "outbox.nats.url", "is required with the nats publisher")
+	check(c.Outbox.Publisher != "kafka" || len(c.Outbox.Kafka.Brokers) > 0, "outbox.kafka.brokers", "are required with the kafka publisher")
+
+	check(!c.Grpc.Enabled || c.Grpc.Address != "", "grpc.address", "is required when grpc is enabled")
+	check(c.Graphql.MaxDepth >= 0, "graphql.max-depth", "must not be negative")
+	check(c.Graphql.MaxCost >= 0, "graphql.max-cost", "must not be negative")
+	check(c.Graphql.MaxListSize >= 0, "graphql.max-list-size", "must not be negative")
+	check(!c.Metrics.Enabled || c.Metrics.Address != "", "metrics.address", "is required when metrics are enabled")
+	check(!c.Metrics.Enabled || strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path", "must start with /, got %q", c.Metrics.Path)
+
+	oneOf(c.Tracing.Exporter, "tracing.exporter", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOtlp)
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample-ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	if c.Tracing.Exporter == tracing.ExporterOtlp {
+		oneOf(c.Tracing.Otlp.Protocol, "tracing.otlp.protocol", "grpc", "http")
+		check(c.Tracing.Otlp.Endpoint != "", "tracing.otlp.endpoint", "is required with the otlp exporter")
+	}
+
+	oneOf(c.Logging.Level, "logging.level", "debug", "info", "warn", "error")
+	oneOf(c.Logging.Format, "logging.format", logging.FormatJson, logging.FormatText)
+	for _, output := range c.Logging.Outputs {
+		oneOf(output, "logging.outputs", logging.OutputStderr, logging.OutputFile, logging.OutputGelf)
+		switch output {
+		case logging.OutputFile:
+			check(c.Logging.File != "", "logging.file", "is required with the file output")
+		case logging.OutputGelf:
+			oneOf(c.Logging.Gelf.Protocol, "logging.gelf.protocol", "udp", "tcp")
+			check(c.Logging.Gelf.Address != "", "logging.gelf.address", "is required with the gelf output")
+		}
+	}
+
+	check(c.Health.Timeout > 0, "health.timeout", "must be positive")
+	check(c.Health.CacheTTL >= 0, "health.cache-ttl", "must not be negative")
+	check(!c.Health.Startup.Wait || strings.HasPrefix(c.Health.Startup.Path, "/"), "health.startup.path", "must start with /, got %q", c.Health.Startup.Path)
+	check(!c.Health.Startup.Wait || c.Health.Startup.Interval > 0, "health.startup.interval", "must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %w", joinLines(errs))
+	}
+	return nil
+}
+
+// joinLines joins errs one per line, errors.Join puts no indentation.
+func joinLines(errs []error) error {
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
 	}
+	return errors.New(strings.Join(lines, "\n  "))
 }
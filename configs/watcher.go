@@ -0,0 +1,134 @@
+package configs
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Watcher reloads the configuration at runtime and hands every valid new
+// one to the listeners. An invalid configuration is rejected and the active
+// one kept.
+type Watcher struct {
+	options Options
+
+	mutex     sync.Mutex
+	current   *Config
+	status    Status
+	listeners []func(old *Config, new *Config)
+}
+
+// Status tells which configuration is active and how the last reload went.
+type Status struct {
+	// Version starts at 1 and is incremented by every reload that changed
+	// the configuration.
+	Version  int       `json:"version"`
+	LoadedAt time.Time `json:"loadedAt"`
+	File     string    `json:"file,omitempty"`
+	Profile  string    `json:"profile,omitempty"`
+	// Error is why the last reload was rejected, empty when it succeeded.
+	Error      string     `json:"error,omitempty"`
+	RejectedAt *time.Time `json:"rejectedAt,omitempty"`
+}
+
+// NewWatcher watches the file and profile cfg was loaded from, with cfg as
+// version 1.
+func NewWatcher(cfg *Config) *Watcher {
+	return &Watcher{
+		options: Options{File: cfg.File, Profile: cfg.Profile},
+		current: cfg,
+		status:  Status{Version: 1, LoadedAt: time.Now().UTC(), File: cfg.File, Profile: cfg.Profile},
+	}
+}
+
+// OnChange registers listener to be called with the old and the new
+// configuration after every reload that changed it. Listeners are called
+// one at a time, in the order they were registered, and must not call back
+// into the Watcher.
+func (w *Watcher) OnChange(listener func(old *Config, new *Config)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.listeners = append(w.listeners, listener)
+}
+
+// Current returns the active configuration. It must not be modified.
+func (w *Watcher) Current() *Config {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.current
+}
+
+func (w *Watcher) Status() Status {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.status
+}
+
+// Reload loads the configuration again, files, environment and secrets
+// alike, and activates it when it is valid.
+func (w *Watcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	cfg, err := Load(w.options)
+	if err != nil {
+		now := time.Now().UTC()
+		w.status.Error, w.status.RejectedAt = err.Error(), &now
+		slog.Error("configuration rejected, keeping version", "version", w.status.Version, "error", err)
+		return err
+	}
+	w.status.Error, w.status.RejectedAt = "", nil
+	if reflect.DeepEqual(cfg, w.current) {
+		return nil
+	}
+
+	old := w.current
+	w.current = cfg
+	w.status.Version++
+	w.status.LoadedAt = time.Now().UTC()
+	for _, listener := range w.listeners {
+		listener(old, cfg)
+	}
+	slog.Info("configuration reloaded", "version", w.status.Version)
+	return nil
+}
+
+// Watch reloads the configuration whenever the config file or the profile
+// overlay is written or replaced, e.g. when a Kubernetes ConfigMap is
+// updated. The profiles built in never change and are not watched.
+func (w *Watcher) Watch() {
+	files := []string{w.options.File}
+	if w.options.Profile != "" {
+		files = append(files, profileFile(w.options.File, w.options.Profile))
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		v := viper.New()
+		v.SetConfigFile(file)
+		v.OnConfigChange(func(fsnotify.Event) {
+			_ = w.Reload()
+		})
+		v.WatchConfig()
+		slog.Debug("watching configuration", "file", file)
+	}
+}
+
+// profileFile is the file the profile overlay is read from, empty when it
+// is built in.
+func profileFile(file string, profile string) string {
+	name := "config." + profile + ".yaml"
+	if file != "" {
+		path := filepath.Join(filepath.Dir(file), name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return search(name)
+}
@@ -0,0 +1,55 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/auth"
+)
+
+// Attribution tells who made a change.
+type Attribution struct {
+	// Caller is who sent the request as far as this service can tell: the
+	// caller the gateway in front verified, header:<caller>, or else the
+	// client, ip:<address>.
+	Caller string `json:"caller"`
+	// ClaimedSubject and ClaimedUsername are the sub and preferred_username
+	// claims of the bearer token. This service does not verify the token, so
+	// they are who the caller claims to be, not who it is.
+	ClaimedSubject  string `json:"claimedSubject,omitempty"`
+	ClaimedUsername string `json:"claimedUsername,omitempty"`
+}
+
+// Attribute attributes a request with the headers from ip. callerHeader is
+// the header the gateway in front sets to the caller it verified, e.g.
+// X-Authenticated-User; without it the caller is the ip.
+func Attribute(header func(name string) string, callerHeader string, ip string) Attribution {
+	attribution := Attribution{Caller: auth.GatewayCaller(header, callerHeader, ip)}
+	if claims, ok := auth.BearerClaims(header("Authorization")); ok {
+		attribution.ClaimedSubject = claims.Subject
+		attribution.ClaimedUsername = claims.PreferredUsername
+	}
+	return attribution
+}
+
+const attributionKey = "audit.attribution"
+
+// Attributor attributes the requests for AttributionOf, on routes that are
+// not audited by Middleware but record entries themselves.
+func Attributor(callerHeader string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attribute(c, callerHeader)
+		c.Next()
+	}
+}
+
+func attribute(c *gin.Context, callerHeader string) {
+	c.Set(attributionKey, Attribute(c.GetHeader, callerHeader, c.ClientIP()))
+}
+
+// AttributionOf returns the attribution Attributor or Middleware made of a
+// request, the client ip without either.
+func AttributionOf(c *gin.Context) Attribution {
+	if attribution, ok := c.Get(attributionKey); ok {
+		return attribution.(Attribution)
+	}
+	return Attribute(func(string) string { return "" }, "", c.ClientIP())
+}
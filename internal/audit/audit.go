@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// ErrNotQueryable is returned by Recorder.Query when none of its sinks can be
+// queried.
+var ErrNotQueryable = errors.New("no queryable audit sink configured")
+
+// Entry records one mutating request.
+type Entry struct {
+	Id        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"requestId,omitempty"`
+	Attribution
+	Method       string          `json:"method"`
+	Route        string          `json:"route"`
+	Path         string          `json:"path"`
+	ResourceType string          `json:"resourceType,omitempty"`
+	ResourceId   string          `json:"resourceId,omitempty"`
+	StatusCode   int             `json:"statusCode"`
+	Outcome      string          `json:"outcome"`
+	Error        string          `json:"error,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	Changes      []Change        `json:"changes,omitempty"`
+}
+
+// Filter selects audit entries. Empty fields are not filtered on.
+type Filter struct {
+	Caller       string
+	ResourceType string
+	ResourceId   string
+	RequestId    string
+	Outcome      string
+	From         time.Time
+	To           time.Time
+	First        int
+	Max          int
+}
+
+// Matches reports whether entry passes the filter.
+func (f *Filter) Matches(entry *Entry) bool {
+	return (f.Caller == "" || f.Caller == entry.Caller) &&
+		(f.ResourceType == "" || f.ResourceType == entry.ResourceType) &&
+		(f.ResourceId == "" || f.ResourceId == entry.ResourceId) &&
+		(f.RequestId == "" || f.RequestId == entry.RequestId) &&
+		(f.Outcome == "" || f.Outcome == entry.Outcome) &&
+		(f.From.IsZero() || !entry.Time.Before(f.From)) &&
+		(f.To.IsZero() || entry.Time.Before(f.To))
+}
+
+// Sink stores audit entries.
+type Sink interface {
+	Write(entry *Entry) error
+}
+
+// Querier is implemented by sinks that can read entries back, newest first.
+type Querier interface {
+	Query(filter *Filter) ([]Entry, error)
+}
+
+// Recorder writes every entry to all of its sinks.
+type Recorder struct {
+	sinks []Sink
+}
+
+func NewRecorder(sinks ...Sink) *Recorder {
+	return &Recorder{sinks: sinks}
+}
+
+// Record writes entry to every sink. A failing sink does not keep the entry
+// from the others, and never fails the audited request.
+func (r *Recorder) Record(entry *Entry) {
+	for _, sink := range r.sinks {
+		if err := sink.Write(entry); err != nil {
+			slog.Error("failed to write audit entry", "audit_id", entry.Id, "request_id", entry.RequestId, "error", err)
+		}
+	}
+}
+
+// Query reads entries from the first sink that supports it.
+func (r *Recorder) Query(filter *Filter) ([]Entry, error) {
+	for _, sink := range r.sinks {
+		if querier, ok := sink.(Querier); ok {
+			return querier.Query(filter)
+		}
+	}
+	return nil, ErrNotQueryable
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Change is a value that differs between the before and after
+// representation of a resource. Path uses dots for object keys and
+// brackets for array indexes, e.g. attributes.department[0].
+type Change struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff compares two JSON documents and lists the changed leaf values, sorted
+// by path. Either document may be empty.
+func Diff(before json.RawMessage, after json.RawMessage) []Change {
+	var b, a interface{}
+	if len(before) > 0 {
+		_ = json.Unmarshal(before, &b)
+	}
+	if len(after) > 0 {
+		_ = json.Unmarshal(after, &a)
+	}
+	var changes []Change
+	diffValue("", b, a, &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func diffValue(path string, before interface{}, after interface{}, changes *[]Change) {
+	beforeObject, beforeIsObject := before.(map[string]interface{})
+	afterObject, afterIsObject := after.(map[string]interface{})
+	if beforeIsObject && afterIsObject {
+		for key, value := range beforeObject {
+			diffValue(join(path, key), value, afterObject[key], changes)
+		}
+		for key, value := range afterObject {
+			if _, ok := beforeObject[key]; !ok {
+				diffValue(join(path, key), nil, value, changes)
+			}
+		}
+		return
+	}
+	beforeArray, beforeIsArray := before.([]interface{})
+	afterArray, afterIsArray := after.([]interface{})
+	if beforeIsArray && afterIsArray && len(beforeArray) == len(afterArray) {
+		for i := range beforeArray {
+			diffValue(path+"["+strconv.Itoa(i)+"]", beforeArray[i], afterArray[i], changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, Change{Path: path, Before: before, After: after})
+	}
+}
+
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSink appends entries to a JSON Lines file, one entry per line.
+type FileSink struct {
+	mutex sync.Mutex
+	path  string
+	file  *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create audit directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file: %w", err)
+	}
+	return &FileSink{path: path, file: file}, nil
+}
+
+func (f *FileSink) Write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	// A single write per line keeps lines whole even if another process
+	// appends to the same file.
+	_, err = f.file.Write(append(data, '\n'))
+	return err
+}
+
+// Query scans the whole file, it is meant for modest audit volumes; use the
+// SQL sink for anything larger.
+func (f *FileSink) Query(filter *Filter) ([]Entry, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var matches []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if filter.Matches(&entry) {
+			matches = append(matches, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The file is in chronological order, entries are returned newest first.
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return page(matches, filter.First, filter.Max), nil
+}
+
+func (f *FileSink) Close() error {
+	return f.file.Close()
+}
+
+func page(entries []Entry, first int, max int) []Entry {
+	if first >= len(entries) {
+		return []Entry{}
+	}
+	entries = entries[first:]
+	if max > 0 && max < len(entries) {
+		entries = entries[:max]
+	}
+	return entries
+}
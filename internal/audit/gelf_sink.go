@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/Graylog2/go-gelf.v2/gelf"
+	"os"
+)
+
+// GelfSink sends entries to Graylog as structured GELF messages. It cannot be
+// queried, search the entries in Graylog instead.
+type GelfSink struct {
+	writer gelf.Writer
+	host   string
+}
+
+func NewGelfSink(writer gelf.Writer) *GelfSink {
+	host, _ := os.Hostname()
+	return &GelfSink{writer: writer, host: host}
+}
+
+func (g *GelfSink) Write(entry *Entry) error {
+	level := int32(gelf.LOG_INFO)
+	if entry.Outcome == OutcomeFailure {
+		level = gelf.LOG_WARNING
+	}
+	extra := map[string]interface{}{
+		"_audit":       true,
+		"_audit_id":    entry.Id,
+		"_request_id":  entry.RequestId,
+		"_caller":      entry.Caller,
+		"_method":      entry.Method,
+		"_route":       entry.Route,
+		"_path":        entry.Path,
+		"_status_code": entry.StatusCode,
+		"_outcome":     entry.Outcome,
+	}
+	if entry.ClaimedSubject != "" || entry.ClaimedUsername != "" {
+		extra["_claimed_subject"] = entry.ClaimedSubject
+		extra["_claimed_username"] = entry.ClaimedUsername
+	}
+	if entry.ResourceType != "" {
+		extra["_resource_type"] = entry.ResourceType
+		extra["_resource_id"] = entry.ResourceId
+	}
+	if entry.Error != "" {
+		extra["_error"] = entry.Error
+	}
+	full := ""
+	if len(entry.Changes) > 0 {
+		changes, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return err
+		}
+		full = string(changes)
+	}
+	return g.writer.WriteMessage(&gelf.Message{
+		Version:  "1.1",
+		Host:     g.host,
+		Short:    fmt.Sprintf("%s %s %d", entry.Method, entry.Path, entry.StatusCode),
+		Full:     full,
+		TimeUnix: float64(entry.Time.UnixNano()) / 1e9,
+		Level:    level,
+		Facility: "audit",
+		Extra:    extra,
+	})
+}
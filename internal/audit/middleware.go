@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/capture"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"net/http"
+	"time"
+)
+
+// Resource tells the middleware how to identify and load the resource a
+// route changes, so entries carry its representation before and after the
+// change.
+type Resource struct {
+	Type string
+	// IdParam is the path parameter holding the resource id. Routes that
+	// create a resource leave it empty, the id is then taken from the id
+	// field of the response body.
+	IdParam string
+	// Load returns the current representation of the resource.
+	Load func(ctx context.Context, id string) (interface{}, error)
+}
+
+// Middleware records every POST, PUT, PATCH and DELETE request. resources
+// maps route templates, as returned by gin.Context.FullPath, to the resource
+// they change; requests to other routes are recorded without
+// representations. The requests are attributed with callerHeader, see
+// Attribute, also for AttributionOf.
+func Middleware(recorder *Recorder, resources map[string]Resource, callerHeader string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attribute(c, callerHeader)
+		if !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		resource, known := resources[route]
+		entry := &Entry{
+			Id:           str.NewUUID(),
+			Time:         time.Now().UTC(),
+			RequestId:    requestid.Get(c),
+			Attribution:  AttributionOf(c),
+			Method:       c.Request.Method,
+			Route:        route,
+			Path:         c.Request.URL.Path,
+			ResourceType: resource.Type,
+		}
+		if known && resource.IdParam != "" {
+			entry.ResourceId = c.Param(resource.IdParam)
+		}
+		recorder.Track(c.Request.Context(), entry, resource, func() (int, []byte) {
+			writer := capture.Wrap(c)
+			c.Next()
+			return writer.Status(), writer.Body()
+		})
+	}
+}
+
+// Track records entry for the change run makes, e.g. an operation of a
+// batch, with the representations of the resource before and after when it
+// can be loaded. The resource is entry.ResourceId, or for a create the id
+// field of the body run returns along with the status.
+func (r *Recorder) Track(ctx context.Context, entry *Entry, resource Resource, run func() (statusCode int, body []byte)) {
+	if resource.Load != nil && entry.ResourceId != "" {
+		entry.Before = load(ctx, resource, entry.ResourceId)
+	}
+
+	statusCode, body := run()
+
+	entry.StatusCode = statusCode
+	entry.Outcome = OutcomeSuccess
+	if entry.StatusCode >= http.StatusBadRequest {
+		entry.Outcome = OutcomeFailure
+		// message in this API's error format, detail in SCIM's.
+		var response struct {
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		}
+		if json.Unmarshal(body, &response) == nil {
+			entry.Error = response.Message
+			if entry.Error == "" {
+				entry.Error = response.Detail
+			}
+		}
+	}
+	if resource.Load != nil && entry.Outcome == OutcomeSuccess {
+		if entry.ResourceId == "" {
+			var created struct {
+				Id string `json:"id"`
+			}
+			if json.Unmarshal(body, &created) == nil {
+				entry.ResourceId = created.Id
+			}
+		}
+		// Deleting a resource leaves nothing to load, but deleting a
+		// sub-resource such as a group membership does.
+		if entry.ResourceId != "" {
+			entry.After = load(ctx, resource, entry.ResourceId)
+		}
+	}
+	if entry.Before != nil || entry.After != nil {
+		entry.Changes = Diff(entry.Before, entry.After)
+	}
+	r.Record(entry)
+}
+
+func load(ctx context.Context, resource Resource, id string) json.RawMessage {
+	representation, err := resource.Load(ctx, id)
+	if err != nil || representation == nil {
+		return nil
+	}
+	data, err := json.Marshal(representation)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to marshal resource for audit", "resource_type", resource.Type, "resource_id", id, "error", err)
+		return nil
+	}
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const createAuditTable = `CREATE TABLE IF NOT EXISTS audit_log (
+	id               VARCHAR(36) PRIMARY KEY,
+	time             TIMESTAMP NOT NULL,
+	request_id       VARCHAR(64),
+	caller           VARCHAR(255) NOT NULL,
+	claimed_subject  VARCHAR(255),
+	claimed_username VARCHAR(255),
+	method           VARCHAR(16) NOT NULL,
+	route            VARCHAR(255) NOT NULL,
+	path             VARCHAR(2048) NOT NULL,
+	resource_type    VARCHAR(64),
+	resource_id      VARCHAR(64),
+	status_code      INTEGER NOT NULL,
+	outcome          VARCHAR(16) NOT NULL,
+	error            TEXT,
+	before_json      TEXT,
+	after_json       TEXT,
+	changes_json     TEXT
+)`
+
+const auditColumns = "id, time, request_id, caller, claimed_subject, claimed_username, method, route, path, resource_type, resource_id, status_code, outcome, error, before_json, after_json, changes_json"
+
+// SQLSink stores entries in the audit_log table, which it creates if needed.
+// Only INSERT and SELECT are issued, the table can be made append-only with
+// grants.
+type SQLSink struct {
+	db *sql.DB
+	// numbered placeholders ($1, $2, ...) for PostgreSQL, ? otherwise
+	numbered bool
+}
+
+func NewSQLSink(db *sql.DB, driver string) (*SQLSink, error) {
+	if _, err := db.Exec(createAuditTable); err != nil {
+		return nil, fmt.Errorf("create audit table: %w", err)
+	}
+	return &SQLSink{db: db, numbered: driver == "postgres" || driver == "pgx"}, nil
+}
+
+func (s *SQLSink) placeholder(n int) string {
+	if s.numbered {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (s *SQLSink) Write(entry *Entry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	placeholders := make([]string, 17)
+	for i := range placeholders {
+		placeholders[i] = s.placeholder(i + 1)
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO audit_log ("+auditColumns+") VALUES ("+strings.Join(placeholders, ", ")+")",
+		entry.Id, entry.Time, nullable(entry.RequestId), entry.Caller,
+		nullable(entry.ClaimedSubject), nullable(entry.ClaimedUsername),
+		entry.Method, entry.Route, entry.Path, nullable(entry.ResourceType), nullable(entry.ResourceId),
+		entry.StatusCode, entry.Outcome, nullable(entry.Error),
+		nullable(string(entry.Before)), nullable(string(entry.After)), string(changes),
+	)
+	return err
+}
+
+func (s *SQLSink) Query(filter *Filter) ([]Entry, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, column+s.placeholder(len(args)))
+	}
+	if filter.Caller != "" {
+		where("caller = ", filter.Caller)
+	}
+	if filter.ResourceType != "" {
+		where("resource_type = ", filter.ResourceType)
+	}
+	if filter.ResourceId != "" {
+		where("resource_id = ", filter.ResourceId)
+	}
+	if filter.RequestId != "" {
+		where("request_id = ", filter.RequestId)
+	}
+	if filter.Outcome != "" {
+		where("outcome = ", filter.Outcome)
+	}
+	if !filter.From.IsZero() {
+		where("time >= ", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("time < ", filter.To)
+	}
+
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY time DESC LIMIT %d OFFSET %d", filter.Max, filter.First)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var (
+			entry                                      Entry
+			requestId, claimedSubject, claimedUsername sql.NullString
+			resourceType, resourceId                   sql.NullString
+			errorMessage, before, after, changes       sql.NullString
+		)
+		err := rows.Scan(&entry.Id, &entry.Time, &requestId, &entry.Caller, &claimedSubject, &claimedUsername,
+			&entry.Method, &entry.Route, &entry.Path, &resourceType, &resourceId,
+			&entry.StatusCode, &entry.Outcome, &errorMessage, &before, &after, &changes)
+		if err != nil {
+			return nil, err
+		}
+		entry.RequestId = requestId.String
+		entry.ClaimedSubject = claimedSubject.String
+		entry.ClaimedUsername = claimedUsername.String
+		entry.ResourceType = resourceType.String
+		entry.ResourceId = resourceId.String
+		entry.Error = errorMessage.String
+		if before.Valid {
+			entry.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			entry.After = json.RawMessage(after.String)
+		}
+		if changes.Valid {
+			_ = json.Unmarshal([]byte(changes.String), &entry.Changes)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func nullable(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
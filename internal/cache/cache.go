@@ -0,0 +1,220 @@
+// Package cache keeps the answers of slow lookups for a while, in memory or
+// in Redis, which the replicas of the service share.
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+type Options struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Backend is memory or redis.
+	Backend string `mapstructure:"backend"`
+	// MaxEntries bounds the memory backend, the least recently used
+	// entries are evicted first.
+	MaxEntries int          `mapstructure:"max-entries"`
+	TTL        TTLs         `mapstructure:"ttl"`
+	Redis      RedisOptions `mapstructure:"redis"`
+}
+
+// TTLs are how long the lookups of each kind of entity are kept.
+type TTLs struct {
+	Users  time.Duration `mapstructure:"users"`
+	Groups time.Duration `mapstructure:"groups"`
+	Roles  time.Duration `mapstructure:"roles"`
+}
+
+// Store keeps values until they expire. A ttl of zero keeps them until
+// they are deleted or evicted.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Add sets the value unless the key is set, and reports whether it did.
+	Add(ctx context.Context, key string, value []byte) (bool, error)
+	Delete(ctx context.Context, keys ...string) error
+	Close() error
+}
+
+// Cache loads values through a Store. The keys are grouped in namespaces,
+// which are invalidated at once by moving them to a new generation; the
+// entries of the old one are no longer read and expire.
+type Cache struct {
+	store Store
+
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is a load in progress, which the concurrent lookups of the same
+// key wait for instead of loading again.
+type flight struct {
+	done       chan struct{}
+	value      []byte
+	statusCode int
+	err        error
+}
+
+// entry is what is stored for a key.
+type entry struct {
+	Value      json.RawMessage `json:"value"`
+	StatusCode int             `json:"statusCode"`
+}
+
+// New creates the cache with the store options.Backend selects.
+func New(options Options) (*Cache, error) {
+	switch options.Backend {
+	case "", BackendMemory:
+		return NewWithStore(NewMemoryStore(options.MaxEntries)), nil
+	case BackendRedis:
+		return NewWithStore(NewRedisStore(options.Redis)), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", options.Backend)
+	}
+}
+
+func NewWithStore(store Store) *Cache {
+	return &Cache{store: store, flights: map[string]*flight{}}
+}
+
+func (c *Cache) Close() error {
+	return c.store.Close()
+}
+
+// Load returns the value cached under key in namespace, or calls load and
+// keeps what it returns for ttl when it succeeds. Concurrent lookups of a
+// key that is not cached share one call to load. When the store fails,
+// load is called as if nothing was cached.
+func Load[T any](ctx context.Context, c *Cache, namespace string, key string, ttl time.Duration, load func(ctx context.Context) (T, int, error)) (T, int, error) {
+	var value T
+	storeKey := c.key(ctx, namespace, key)
+	if data, ok, err := c.store.Get(ctx, storeKey); err != nil {
+		slog.WarnContext(ctx, "failed to read from cache", "key", storeKey, "error", err)
+	} else if ok {
+		var cached entry
+		if err := json.Unmarshal(data, &cached); err == nil && json.Unmarshal(cached.Value, &value) == nil {
+			metrics.CacheLookup(namespace, true)
+			return value, cached.StatusCode, nil
+		}
+	}
+	metrics.CacheLookup(namespace, false)
+
+	data, statusCode, err := c.share(storeKey, func() ([]byte, int, error) {
+		// The lookups waiting for the load must not fail because the one
+		// that started it was cancelled.
+		loaded, statusCode, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, statusCode, err
+		}
+		data, err := json.Marshal(loaded)
+		if err != nil {
+			return nil, 500, err
+		}
+		c.set(ctx, storeKey, entry{Value: data, StatusCode: statusCode}, ttl)
+		return data, statusCode, nil
+	})
+	if err != nil {
+		return value, statusCode, err
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, 500, err
+	}
+	return value, statusCode, nil
+}
+
+// Delete drops keys of namespace.
+func (c *Cache) Delete(ctx context.Context, namespace string, keys ...string) {
+	storeKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		storeKeys = append(storeKeys, c.key(ctx, namespace, key))
+	}
+	if err := c.store.Delete(ctx, storeKeys...); err != nil {
+		slog.WarnContext(ctx, "failed to delete from cache", "namespace", namespace, "error", err)
+	}
+	metrics.CacheInvalidated(namespace)
+}
+
+// Invalidate drops every key of the namespaces.
+func (c *Cache) Invalidate(ctx context.Context, namespaces ...string) {
+	for _, namespace := range namespaces {
+		if err := c.store.Set(ctx, generationKey(namespace), []byte(newGeneration()), 0); err != nil {
+			slog.WarnContext(ctx, "failed to invalidate cache", "namespace", namespace, "error", err)
+		}
+		metrics.CacheInvalidated(namespace)
+	}
+}
+
+func (c *Cache) share(key string, load func() ([]byte, int, error)) ([]byte, int, error) {
+	c.mutex.Lock()
+	if f, ok := c.flights[key]; ok {
+		c.mutex.Unlock()
+		<-f.done
+		return f.value, f.statusCode, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	c.mutex.Unlock()
+
+	f.value, f.statusCode, f.err = load()
+	c.mutex.Lock()
+	delete(c.flights, key)
+	c.mutex.Unlock()
+	close(f.done)
+	return f.value, f.statusCode, f.err
+}
+
+func (c *Cache) set(ctx context.Context, key string, value entry, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = c.store.Set(ctx, key, data, ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "failed to write to cache", "key", key, "error", err)
+	}
+}
+
+// key prefixes key with the current generation of namespace. A generation
+// that was evicted is replaced by a new one, which only costs misses.
+func (c *Cache) key(ctx context.Context, namespace string, key string) string {
+	return namespace + ":" + c.generation(ctx, namespace) + ":" + key
+}
+
+func (c *Cache) generation(ctx context.Context, namespace string) string {
+	name := generationKey(namespace)
+	generation, ok, err := c.store.Get(ctx, name)
+	if err == nil && !ok {
+		generation = []byte(newGeneration())
+		var added bool
+		if added, err = c.store.Add(ctx, name, generation); err == nil && !added {
+			generation, _, err = c.store.Get(ctx, name)
+		}
+	}
+	if err != nil {
+		// The key is not shared with anything cached, so nothing stale is
+		// read while the store fails.
+		return "unavailable-" + newGeneration()
+	}
+	return string(generation)
+}
+
+func generationKey(namespace string) string {
+	return "generation:" + namespace
+}
+
+func newGeneration() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps at most maxEntries values in this process, and evicts
+// the least recently used ones first.
+type MemoryStore struct {
+	maxEntries int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	// recency has the most recently used entry at the front.
+	recency *list.List
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryStore bounds the store to maxEntries, 10000 when not positive.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		recency:    list.New(),
+	}
+}
+
+func (m *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := element.Value.(*memoryEntry)
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		m.remove(element)
+		return nil, false, nil
+	}
+	m.recency.MoveToFront(element)
+	return e.value, true, nil
+}
+
+func (m *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *MemoryStore) Add(_ context.Context, key string, value []byte) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if element, ok := m.entries[key]; ok {
+		e := element.Value.(*memoryEntry)
+		if e.expiresAt.IsZero() || time.Now().Before(e.expiresAt) {
+			return false, nil
+		}
+	}
+	m.set(key, value, 0)
+	return true, nil
+}
+
+func (m *MemoryStore) Delete(_ context.Context, keys ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.remove(element)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// Len is the number of entries held, expired ones included until they are
+// read or evicted.
+func (m *MemoryStore) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.entries)
+}
+
+// set stores the value; the caller must hold the lock.
+func (m *MemoryStore) set(key string, value []byte, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	if element, ok := m.entries[key]; ok {
+		e := element.Value.(*memoryEntry)
+		e.value, e.expiresAt = value, expiresAt
+		m.recency.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.recency.PushFront(&memoryEntry{key: key, value: value, expiresAt: expiresAt})
+	for len(m.entries) > m.maxEntries {
+		m.remove(m.recency.Back())
+	}
+}
+
+func (m *MemoryStore) remove(element *list.Element) {
+	m.recency.Remove(element)
+	delete(m.entries, element.Value.(*memoryEntry).key)
+}
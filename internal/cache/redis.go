@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+type RedisOptions struct {
+	Address  string `mapstructure:"address"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	DB       int    `mapstructure:"db"`
+	// KeyPrefix is put before every key, so deployments can share a Redis.
+	KeyPrefix string        `mapstructure:"key-prefix"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// RedisStore keeps the values in Redis, where they expire on their own.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects lazily, so Redis being down does not keep the
+// service from starting; the lookups are then made without the cache.
+func NewRedisStore(options RedisOptions) *RedisStore {
+	return &RedisStore{client: NewRedisClient(options), prefix: options.KeyPrefix}
+}
+
+// NewRedisClient creates a client of the Redis options point to, for the
+// other uses of Redis to share the settings. The key prefix is left to the
+// caller.
+func NewRedisClient(options RedisOptions) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         options.Address,
+		Username:     options.Username,
+		Password:     options.Password,
+		DB:           options.DB,
+		DialTimeout:  options.Timeout,
+		ReadTimeout:  options.Timeout,
+		WriteTimeout: options.Timeout,
+	})
+}
+
+func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *RedisStore) Add(ctx context.Context, key string, value []byte) (bool, error) {
+	return r.client.SetNX(ctx, r.prefix+key, value, 0).Result()
+}
+
+func (r *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, r.prefix+key)
+	}
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,34 @@
+package capture
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+)
+
+// Writer passes the response through while keeping a copy of its body.
+type Writer struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Wrap replaces the response writer of c with a capturing Writer.
+func Wrap(c *gin.Context) *Writer {
+	w := &Writer{ResponseWriter: c.Writer}
+	c.Writer = w
+	return w
+}
+
+func (w *Writer) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *Writer) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Body returns the response body written so far.
+func (w *Writer) Body() []byte {
+	return w.body.Bytes()
+}
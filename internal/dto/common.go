@@ -1,5 +1,10 @@
 package dto
 
+import (
+	"encoding/json"
+	"github.com/miguoliang/keycloakadminclient"
+)
+
 type ErrorResponse struct {
 	Message string `json:"message"`
 }
@@ -7,3 +12,51 @@ type ErrorResponse struct {
 type CreatedResponse struct {
 	Id string `json:"id"`
 }
+
+type UserExportRecord struct {
+	User   keycloakadminclient.UserRepresentation `json:"user"`
+	Groups []string                               `json:"groups,omitempty"`
+	Roles  []string                               `json:"roles,omitempty"`
+}
+
+type GroupMembersRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+type GroupMemberResult struct {
+	UserId     string `json:"userId"`
+	Action     string `json:"action"`
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message,omitempty"`
+}
+
+type BatchRequest struct {
+	StopOnError bool             `json:"stopOnError"`
+	Operations  []BatchOperation `json:"operations"`
+}
+
+type BatchOperation struct {
+	Ref    string            `json:"ref,omitempty"`
+	Op     string            `json:"op"`
+	Params map[string]string `json:"params,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+type BatchResult struct {
+	Ref        string      `json:"ref,omitempty"`
+	Op         string      `json:"op"`
+	StatusCode int         `json:"statusCode"`
+	Body       interface{} `json:"body,omitempty"`
+	Skipped    bool        `json:"skipped,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+type WebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes" binding:"required"`
+	Secret     string   `json:"secret"`
+}
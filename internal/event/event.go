@@ -0,0 +1,81 @@
+package event
+
+import (
+	"github.com/miguoliang/arch-go/pkg/str"
+	"sync"
+	"time"
+)
+
+// Types of the identity change events.
+const (
+	UserCreated      = "user.created"
+	UserUpdated      = "user.updated"
+	UserDisabled     = "user.disabled"
+	UserDeleted      = "user.deleted"
+	UserJoinedGroup  = "user.group.joined"
+	UserLeftGroup    = "user.group.left"
+	UserRolesAdded   = "user.roles.added"
+	UserRolesRemoved = "user.roles.removed"
+	GroupCreated     = "group.created"
+	GroupUpdated     = "group.updated"
+	GroupDeleted     = "group.deleted"
+	RoleCreated      = "role.created"
+	RoleUpdated      = "role.updated"
+	RoleDeleted      = "role.deleted"
+)
+
+// Types lists every event type.
+var Types = []string{
+	UserCreated, UserUpdated, UserDisabled, UserDeleted,
+	UserJoinedGroup, UserLeftGroup, UserRolesAdded, UserRolesRemoved,
+	GroupCreated, GroupUpdated, GroupDeleted,
+	RoleCreated, RoleUpdated, RoleDeleted,
+}
+
+// Event describes a change made to a user, group or role.
+type Event struct {
+	Id   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Subject is the id of the changed user, group or role.
+	Subject string      `json:"subject"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func New(eventType string, subject string, data interface{}) Event {
+	return Event{
+		Id:      str.NewUUID(),
+		Type:    eventType,
+		Time:    time.Now().UTC(),
+		Subject: subject,
+		Data:    data,
+	}
+}
+
+// Handler receives published events. It is called synchronously by Publish
+// and must not block.
+type Handler func(event Event)
+
+// Bus fans events out to its subscribers.
+type Bus struct {
+	mutex    sync.RWMutex
+	handlers []Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(handler Handler) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func (b *Bus) Publish(event Event) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,163 @@
+package graph
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+	cursorPrefix    = "offset:"
+)
+
+// connection is a page of nodes, in the shape of the Relay connection spec.
+// Cursors are opaque offsets, the Keycloak admin API pages by offset.
+type connection struct {
+	Edges      []edge   `json:"edges"`
+	PageInfo   pageInfo `json:"pageInfo"`
+	TotalCount *int     `json:"totalCount"`
+}
+
+type edge struct {
+	Cursor string      `json:"cursor"`
+	Node   interface{} `json:"node"`
+}
+
+type pageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+var connectionArgs = graphql.FieldConfigArgument{
+	"first": &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: fmt.Sprintf("Number of nodes, %d by default and at most %d", defaultPageSize, maxPageSize),
+	},
+	"after": &graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "Cursor of the edge the page starts after",
+	},
+}
+
+var pageInfoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PageInfo",
+	Fields: graphql.Fields{
+		"hasNextPage":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"hasPreviousPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"startCursor":     &graphql.Field{Type: graphql.String},
+		"endCursor":       &graphql.Field{Type: graphql.String},
+	},
+})
+
+func connectionType(nodeType *graphql.Object) *graphql.Object {
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: nodeType.Name() + "Edge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: graphql.NewNonNull(nodeType)},
+		},
+	})
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: nodeType.Name() + "Connection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType)))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(nodeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					c := p.Source.(*connection)
+					nodes := make([]interface{}, len(c.Edges))
+					for i, e := range c.Edges {
+						nodes[i] = e.Node
+					}
+					return nodes, nil
+				},
+			},
+			"totalCount": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Number of nodes in all pages, null when Keycloak does not tell",
+			},
+		},
+	})
+}
+
+// pageArgs reads first and after into an offset and a page size of at most
+// maxSize.
+func pageArgs(args map[string]interface{}, maxSize int) (int, int, error) {
+	limit := min(defaultPageSize, maxSize)
+	if first, ok := args["first"].(int); ok {
+		if first < 0 || first > maxSize {
+			return 0, 0, badUserInput(fmt.Sprintf("first must be between 0 and %d", maxSize))
+		}
+		limit = first
+	}
+	offset := 0
+	if after, ok := args["after"].(string); ok && after != "" {
+		decoded, err := decodeCursor(after)
+		if err != nil {
+			return 0, 0, badUserInput("invalid cursor")
+		}
+		offset = decoded + 1
+	}
+	return offset, limit, nil
+}
+
+func encodeCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(string(data), cursorPrefix) {
+		return 0, fmt.Errorf("not a cursor")
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), cursorPrefix))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("not a cursor")
+	}
+	return offset, nil
+}
+
+// newConnection builds a connection from a page of items that starts at
+// offset.
+func newConnection[T any](items []T, offset int, hasNextPage bool) *connection {
+	c := &connection{
+		Edges: make([]edge, len(items)),
+		PageInfo: pageInfo{
+			HasNextPage:     hasNextPage,
+			HasPreviousPage: offset > 0,
+		},
+	}
+	for i := range items {
+		c.Edges[i] = edge{Cursor: encodeCursor(offset + i), Node: &items[i]}
+	}
+	if len(c.Edges) > 0 {
+		c.PageInfo.StartCursor = &c.Edges[0].Cursor
+		c.PageInfo.EndCursor = &c.Edges[len(c.Edges)-1].Cursor
+	}
+	return c
+}
+
+// sliceConnection pages through items that were fetched in full.
+func sliceConnection[T any](items []T, offset int, limit int) *connection {
+	total := len(items)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	c := newConnection(items[offset:end], offset, end < total)
+	c.TotalCount = &total
+	return c
+}
@@ -0,0 +1,60 @@
+package graph
+
+import (
+	"net/http"
+)
+
+// Error is a resolver error that keeps the HTTP status Keycloak answered.
+// GraphQL answers 200 regardless, so the status and a code derived from it
+// are exposed in the extensions of the error.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func newError(statusCode int, err error) error {
+	if statusCode < http.StatusBadRequest {
+		statusCode = http.StatusInternalServerError
+	}
+	return &Error{StatusCode: statusCode, Message: err.Error()}
+}
+
+func badUserInput(message string) error {
+	return &Error{StatusCode: http.StatusBadRequest, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":       errorCode(e.StatusCode),
+		"statusCode": e.StatusCode,
+	}
+}
+
+func errorCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "BAD_USER_INPUT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusTooManyRequests:
+		return "TOO_MANY_REQUESTS"
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "UNAVAILABLE"
+	}
+	return "INTERNAL_SERVER_ERROR"
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
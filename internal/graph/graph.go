@@ -0,0 +1,80 @@
+// Package graph serves the users, groups and roles and their relationships
+// as a GraphQL schema, so clients can fetch a user with its groups and roles
+// in one request.
+package graph
+
+import (
+	"context"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+)
+
+type Options struct {
+	// Users, Groups and Roles serve the queries and mutations, like the services in
+	// resource.Dependencies serve the REST handlers.
+	Users  keycloak.UserService
+	Groups keycloak.GroupService
+	Roles  keycloak.RoleService
+	// Events receives the change events, like the REST handlers publish them.
+	Events *event.Bus
+	// Audit records the mutations when set.
+	Audit  *audit.Recorder
+	Limits Limits
+}
+
+func (o *Options) publish(eventType string, subject string, data interface{}) {
+	if o.Events != nil {
+		o.Events.Publish(event.New(eventType, subject, data))
+	}
+}
+
+// Request is the body of a GraphQL request over HTTP.
+type Request struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type Server struct {
+	schema  graphql.Schema
+	options *Options
+}
+
+func NewServer(options Options) (*Server, error) {
+	schema, err := newSchema(&options)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{schema: schema, options: &options}, nil
+}
+
+// Execute runs a query or mutation, unless it exceeds the limits. Every
+// call gets its own loaders, so nothing is cached across requests.
+func (s *Server) Execute(ctx context.Context, request Request) *graphql.Result {
+	if err := s.options.Limits.check(&s.schema, request); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(gqlerrors.NewError(err.Error(), nil, "", nil, nil, err))}
+	}
+	return graphql.Do(graphql.Params{
+		Schema:         s.schema,
+		RequestString:  request.Query,
+		OperationName:  request.OperationName,
+		VariableValues: request.Variables,
+		Context:        context.WithValue(ctx, loadersKey{}, newLoaders(s.options)),
+	})
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx that attributes the audit entries of
+// mutations.
+func WithCaller(ctx context.Context, attribution audit.Attribution) context.Context {
+	return context.WithValue(ctx, callerKey{}, attribution)
+}
+
+func callerFromContext(ctx context.Context) audit.Attribution {
+	value, _ := ctx.Value(callerKey{}).(audit.Attribution)
+	return value
+}
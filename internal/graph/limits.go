@@ -0,0 +1,195 @@
+package graph
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxCost is where the cost of a query stops adding up, so it cannot
+// overflow.
+const maxCost = math.MaxInt32
+
+// Limits bound a query before it runs, as every node of a nested list can
+// cost a Keycloak call. A limit of 0 is no limit.
+type Limits struct {
+	// MaxDepth is how deeply the fields of a query may nest.
+	MaxDepth int
+	// MaxCost bounds the fields a query may resolve, counting the fields
+	// under a list once for every node the list may hold.
+	MaxCost int
+	// MaxListSize caps the lists nested in a node, e.g. the groups of a user
+	// or the members of a group, and the page size of the members.
+	MaxListSize int
+}
+
+// listSize is the number of nodes a nested list is assumed to hold when
+// MaxListSize does not cap them.
+func (l Limits) listSize() int {
+	if l.MaxListSize > 0 {
+		return l.MaxListSize
+	}
+	return maxPageSize
+}
+
+// check rejects a query deeper or costlier than the limits. A query that
+// does not parse or does not select the operation is left to the executor,
+// which reports it.
+func (l Limits) check(schema *graphql.Schema, request Request) error {
+	if l.MaxDepth <= 0 && l.MaxCost <= 0 {
+		return nil
+	}
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(request.Query)})})
+	if err != nil {
+		return nil
+	}
+	a := &analysis{limits: l, schema: schema, variables: request.Variables, fragments: map[string]*ast.FragmentDefinition{}}
+	var operations []*ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch d := definition.(type) {
+		case *ast.OperationDefinition:
+			if request.OperationName == "" || d.Name != nil && d.Name.Value == request.OperationName {
+				operations = append(operations, d)
+			}
+		case *ast.FragmentDefinition:
+			a.fragments[d.Name.Value] = d
+		}
+	}
+	if len(operations) != 1 {
+		return nil
+	}
+	root := schema.QueryType()
+	if operations[0].Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	depth, cost := a.selections(operations[0].SelectionSet, root, 1, map[string]bool{})
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return badUserInput(fmt.Sprintf("query depth %d exceeds the maximum of %d", depth, l.MaxDepth))
+	}
+	if l.MaxCost > 0 && cost > l.MaxCost {
+		return badUserInput(fmt.Sprintf("query cost %d exceeds the maximum of %d", cost, l.MaxCost))
+	}
+	return nil
+}
+
+type analysis struct {
+	limits    Limits
+	schema    *graphql.Schema
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// selections returns how deeply the fields of set nest, from depth, and
+// what they cost.
+func (a *analysis) selections(set *ast.SelectionSet, parent graphql.Type, depth int, spread map[string]bool) (int, int) {
+	if set == nil {
+		return depth - 1, 0
+	}
+	maxDepth, cost := depth-1, 0
+	add := func(d int, c int) {
+		maxDepth = max(maxDepth, d)
+		cost = min(cost+c, maxCost)
+	}
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			add(a.field(s, parent, depth, spread))
+		case *ast.InlineFragment:
+			t := parent
+			if s.TypeCondition != nil {
+				t = a.schema.Type(s.TypeCondition.Name.Value)
+			}
+			add(a.selections(s.SelectionSet, t, depth, spread))
+		case *ast.FragmentSpread:
+			// A fragment spread within itself is invalid, the executor
+			// reports it.
+			fragment, ok := a.fragments[s.Name.Value]
+			if !ok || spread[s.Name.Value] {
+				continue
+			}
+			spread[s.Name.Value] = true
+			add(a.selections(fragment.SelectionSet, a.schema.Type(fragment.TypeCondition.Name.Value), depth, spread))
+			delete(spread, s.Name.Value)
+		}
+	}
+	return maxDepth, cost
+}
+
+func (a *analysis) field(f *ast.Field, parent graphql.Type, depth int, spread map[string]bool) (int, int) {
+	name := f.Name.Value
+	// Introspection does not reach Keycloak.
+	if strings.HasPrefix(name, "__") {
+		return depth, 1
+	}
+	object, ok := parent.(*graphql.Object)
+	if !ok {
+		return depth, 1
+	}
+	definition, ok := object.Fields()[name]
+	if !ok {
+		return depth, 1
+	}
+	t, list := unwrap(definition.Type)
+	nodes := 1
+	switch {
+	case strings.HasSuffix(t.Name(), "Connection"):
+		nodes = a.pageSize(f)
+	case list && !strings.HasSuffix(object.Name(), "Connection"):
+		// The edges and nodes of a connection are counted by its page size.
+		nodes = a.limits.listSize()
+	}
+	d, c := a.selections(f.SelectionSet, t, depth+1, spread)
+	return max(depth, d), min(1+min(nodes, maxCost)*c, maxCost)
+}
+
+// pageSize is the first argument of a connection, or the default, within
+// what pageArgs accepts.
+func (a *analysis) pageSize(f *ast.Field) int {
+	return max(0, min(a.first(f), maxPageSize))
+}
+
+func (a *analysis) first(f *ast.Field) int {
+	for _, argument := range f.Arguments {
+		if argument.Name.Value != "first" {
+			continue
+		}
+		var value interface{}
+		switch v := argument.Value.(type) {
+		case *ast.IntValue:
+			value = v.Value
+		case *ast.Variable:
+			value = a.variables[v.Name.Value]
+		}
+		switch v := value.(type) {
+		case string:
+			if first, err := strconv.Atoi(v); err == nil {
+				return first
+			}
+		case int:
+			return v
+		case float64:
+			return int(v)
+		}
+	}
+	return defaultPageSize
+}
+
+// unwrap returns the named type of t and whether it is a list.
+func unwrap(t graphql.Type) (graphql.Type, bool) {
+	list := false
+	for {
+		switch wrapped := t.(type) {
+		case *graphql.NonNull:
+			t = wrapped.OfType
+		case *graphql.List:
+			t, list = wrapped.OfType, true
+		default:
+			return t, list
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package graph
+
+import (
+	"context"
+	"github.com/graph-gophers/dataloader/v7"
+	"github.com/graphql-go/graphql"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/keycloakadminclient"
+	"sync"
+	"time"
+)
+
+const (
+	// loaderWait is how long a loader collects keys before it fetches them.
+	// Resolvers of sibling fields run back to back, so it can be short.
+	loaderWait = 2 * time.Millisecond
+	// maxConcurrentFetches bounds the Keycloak calls a batch makes at once.
+	maxConcurrentFetches = 8
+)
+
+type loadersKey struct{}
+
+// loaders batch and deduplicate the Keycloak calls of one request. Keycloak
+// has no bulk lookups, so a batch fetches its distinct keys concurrently;
+// what it saves is the repeated and sequential calls of resolving every
+// field on its own.
+type loaders struct {
+	// maxListSize caps the nested lists, 0 leaves them whole.
+	maxListSize int
+
+	users  keycloak.UserService
+	groups keycloak.GroupService
+	roles  keycloak.RoleService
+
+	user           *dataloader.Loader[string, *keycloakadminclient.UserRepresentation]
+	group          *dataloader.Loader[string, *keycloakadminclient.GroupRepresentation]
+	role           *dataloader.Loader[string, *keycloakadminclient.RoleRepresentation]
+	userGroups     *dataloader.Loader[string, []keycloakadminclient.GroupRepresentation]
+	userRoles      *dataloader.Loader[string, []keycloakadminclient.RoleRepresentation]
+	effectiveRoles *dataloader.Loader[string, []keycloakadminclient.RoleRepresentation]
+	groupMembers   *dataloader.Loader[string, []keycloakadminclient.UserRepresentation]
+	subGroups      *dataloader.Loader[string, []keycloakadminclient.GroupRepresentation]
+	composites     *dataloader.Loader[string, []keycloakadminclient.RoleRepresentation]
+}
+
+func newLoaders(options *Options) *loaders {
+	l := &loaders{maxListSize: options.Limits.MaxListSize}
+	if options.Users != nil {
+		l.users = options.Users
+		l.user = newLoader(l.users.GetUserById)
+		l.userGroups = newLoader(list(l.users.ListGroups))
+		l.userRoles = newLoader(list(l.users.ListRoleMappings))
+		l.effectiveRoles = newLoader(list(l.users.ListEffectiveRoles))
+	}
+	if options.Groups != nil {
+		l.groups = options.Groups
+		l.group = newLoader(l.groups.GetGroup)
+		l.groupMembers = newLoader(list(l.groups.ListMembers))
+		l.subGroups = newLoader(list(l.groups.ListSubGroups))
+	}
+	if options.Roles != nil {
+		l.roles = options.Roles
+		l.role = newLoader(l.roles.GetRoleById)
+		l.composites = newLoader(list(l.roles.ListComposites))
+	}
+	return l
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+func newLoader[V any](fetch func(ctx context.Context, key string) (V, int, error)) *dataloader.Loader[string, V] {
+	return dataloader.NewBatchedLoader(fetchAll(fetch), dataloader.WithWait[string, V](loaderWait))
+}
+
+// fetchAll turns a single-key fetch into a batch function.
+func fetchAll[V any](fetch func(ctx context.Context, key string) (V, int, error)) dataloader.BatchFunc[string, V] {
+	return func(ctx context.Context, keys []string) []*dataloader.Result[V] {
+		results := make([]*dataloader.Result[V], len(keys))
+		semaphore := make(chan struct{}, maxConcurrentFetches)
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			semaphore <- struct{}{}
+			go func(i int, key string) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				value, statusCode, err := fetch(ctx, key)
+				if err != nil {
+					results[i] = &dataloader.Result[V]{Error: newError(statusCode, err)}
+					return
+				}
+				results[i] = &dataloader.Result[V]{Data: value}
+			}(i, key)
+		}
+		wg.Wait()
+		return results
+	}
+}
+
+// list adapts the services, which return pointers to slices.
+func list[T any](fetch func(ctx context.Context, key string) (*[]T, int, error)) func(ctx context.Context, key string) ([]T, int, error) {
+	return func(ctx context.Context, key string) ([]T, int, error) {
+		items, statusCode, err := fetch(ctx, key)
+		if err != nil || items == nil {
+			return nil, statusCode, err
+		}
+		return *items, statusCode, nil
+	}
+}
+
+// load resolves a field through a loader. The returned thunk is called by
+// the executor after the sibling fields registered their keys.
+func load[V any](p graphql.ResolveParams, loader *dataloader.Loader[string, V], key string) (interface{}, error) {
+	thunk := loader.Load(p.Context, key)
+	return func() (interface{}, error) {
+		return thunk()
+	}, nil
+}
+
+// loadList resolves a list field through a loader, with pointers to the
+// items as the sources of their fields, capped at maxListSize.
+func loadList[T any](p graphql.ResolveParams, loader *dataloader.Loader[string, []T], key string) (interface{}, error) {
+	maxListSize := loadersFrom(p.Context).maxListSize
+	thunk := loader.Load(p.Context, key)
+	return func() (interface{}, error) {
+		items, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		if maxListSize > 0 && len(items) > maxListSize {
+			items = items[:maxListSize]
+		}
+		return pointers(items), nil
+	}, nil
+}
+
+func pointers[T any](items []T) []*T {
+	result := make([]*T, len(items))
+	for i := range items {
+		result[i] = &items[i]
+	}
+	return result
+}
@@ -0,0 +1,482 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/graphql-go/graphql"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"time"
+)
+
+var attributeInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "AttributeInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"values": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+	},
+})
+
+var userInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "UserInput",
+	Description: "Fields left out are not changed by an update",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"username":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"email":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"firstName":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"lastName":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"enabled":       &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		"emailVerified": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		"attributes":    &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(attributeInput))},
+	},
+})
+
+var groupInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "GroupInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"attributes": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(attributeInput))},
+	},
+})
+
+var roleInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "RoleInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"description": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"attributes":  &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(attributeInput))},
+	},
+})
+
+func (b *schemaBuilder) mutationFields() graphql.Fields {
+	id := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}
+	membership := graphql.FieldConfigArgument{"userId": id, "groupId": id}
+	roleMapping := graphql.FieldConfigArgument{
+		"userId": id,
+		"roles":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))), Description: "Role names"},
+	}
+	deleted := graphql.NewNonNull(graphql.ID)
+
+	return graphql.Fields{
+		"createUser": {
+			Type: graphql.NewNonNull(b.userType),
+			Args: graphql.FieldConfigArgument{
+				"input":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInput)},
+				"password": &graphql.ArgumentConfig{Type: graphql.String, Description: "Initial password"},
+			},
+			Resolve: b.mutation("user", "", b.createUser),
+		},
+		"updateUser": {
+			Type:    graphql.NewNonNull(b.userType),
+			Args:    graphql.FieldConfigArgument{"id": id, "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(userInput)}},
+			Resolve: b.mutation("user", "id", b.updateUser),
+		},
+		"deleteUser": {
+			Type:        deleted,
+			Description: "Deletes a user and returns its id",
+			Args:        graphql.FieldConfigArgument{"id": id},
+			Resolve:     b.mutation("user", "id", b.deleteUser),
+		},
+		"joinGroup": {
+			Type:    graphql.NewNonNull(b.userType),
+			Args:    membership,
+			Resolve: b.mutation("user", "userId", b.updateMembership(true)),
+		},
+		"leaveGroup": {
+			Type:    graphql.NewNonNull(b.userType),
+			Args:    membership,
+			Resolve: b.mutation("user", "userId", b.updateMembership(false)),
+		},
+		"addRoleMappings": {
+			Type:    graphql.NewNonNull(b.userType),
+			Args:    roleMapping,
+			Resolve: b.mutation("user", "userId", b.updateRoleMappings(true)),
+		},
+		"removeRoleMappings": {
+			Type:    graphql.NewNonNull(b.userType),
+			Args:    roleMapping,
+			Resolve: b.mutation("user", "userId", b.updateRoleMappings(false)),
+		},
+		"createGroup": {
+			Type:    graphql.NewNonNull(b.groupType),
+			Args:    graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(groupInput)}},
+			Resolve: b.mutation("group", "", b.createGroup),
+		},
+		"updateGroup": {
+			Type:    graphql.NewNonNull(b.groupType),
+			Args:    graphql.FieldConfigArgument{"id": id, "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(groupInput)}},
+			Resolve: b.mutation("group", "id", b.updateGroup),
+		},
+		"deleteGroup": {
+			Type:        deleted,
+			Description: "Deletes a group and returns its id",
+			Args:        graphql.FieldConfigArgument{"id": id},
+			Resolve:     b.mutation("group", "id", b.deleteGroup),
+		},
+		"createRole": {
+			Type:    graphql.NewNonNull(b.roleType),
+			Args:    graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(roleInput)}},
+			Resolve: b.mutation("role", "", b.createRole),
+		},
+		"updateRole": {
+			Type:    graphql.NewNonNull(b.roleType),
+			Args:    graphql.FieldConfigArgument{"id": id, "input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(roleInput)}},
+			Resolve: b.mutation("role", "id", b.updateRole),
+		},
+		"deleteRole": {
+			Type:        deleted,
+			Description: "Deletes a role and returns its id",
+			Args:        graphql.FieldConfigArgument{"id": id},
+			Resolve:     b.mutation("role", "id", b.deleteRole),
+		},
+	}
+}
+
+func (b *schemaBuilder) createUser(p graphql.ResolveParams) (interface{}, error) {
+	input := p.Args["input"].(map[string]interface{})
+	user := userFromInput(input)
+	if user.GetUsername() == "" {
+		return nil, badUserInput("input.username is required")
+	}
+	if password, ok := p.Args["password"].(string); ok && password != "" {
+		user.Credentials = []keycloakadminclient.CredentialRepresentation{{
+			Type:      keycloakadminclient.PtrString("password"),
+			Value:     keycloakadminclient.PtrString(password),
+			Temporary: keycloakadminclient.PtrBool(false),
+		}}
+	}
+	l := loadersFrom(p.Context)
+	userId, statusCode, err := l.users.CreateUser(p.Context, user)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	created, statusCode, err := l.users.GetUserById(p.Context, userId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	b.options.publish(event.UserCreated, userId, created)
+	return created, nil
+}
+
+func (b *schemaBuilder) updateUser(p graphql.ResolveParams) (interface{}, error) {
+	userId := p.Args["id"].(string)
+	user := userFromInput(p.Args["input"].(map[string]interface{}))
+	user.Id = &userId
+	l := loadersFrom(p.Context)
+	current, statusCode, err := l.users.GetUserById(p.Context, userId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	updated, statusCode, err := l.users.UpdateUser(p.Context, user)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.user.Clear(p.Context, userId)
+	b.options.publish(event.UserUpdated, userId, updated)
+	if current.GetEnabled() && user.Enabled != nil && !*user.Enabled {
+		b.options.publish(event.UserDisabled, userId, nil)
+	}
+	// UpdateUser answers with what was sent, load the whole user.
+	return l.fetchUser(p.Context, userId)
+}
+
+func (b *schemaBuilder) deleteUser(p graphql.ResolveParams) (interface{}, error) {
+	userId := p.Args["id"].(string)
+	l := loadersFrom(p.Context)
+	if statusCode, err := l.users.DeleteUser(p.Context, userId); err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.user.Clear(p.Context, userId)
+	b.options.publish(event.UserDeleted, userId, nil)
+	return userId, nil
+}
+
+func (b *schemaBuilder) updateMembership(join bool) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		userId, groupId := p.Args["userId"].(string), p.Args["groupId"].(string)
+		l := loadersFrom(p.Context)
+		update, eventType := l.users.LeaveGroup, event.UserLeftGroup
+		if join {
+			update, eventType = l.users.JoinGroup, event.UserJoinedGroup
+		}
+		if statusCode, err := update(p.Context, userId, groupId); err != nil {
+			return nil, newError(statusCode, err)
+		}
+		l.userGroups.Clear(p.Context, userId)
+		l.effectiveRoles.Clear(p.Context, userId)
+		l.groupMembers.Clear(p.Context, groupId)
+		b.options.publish(eventType, userId, map[string]string{"groupId": groupId})
+		return l.fetchUser(p.Context, userId)
+	}
+}
+
+func (b *schemaBuilder) updateRoleMappings(add bool) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		userId := p.Args["userId"].(string)
+		names := stringList(p.Args["roles"])
+		if len(names) == 0 {
+			return nil, badUserInput("roles must not be empty")
+		}
+		l := loadersFrom(p.Context)
+		roles := make([]keycloakadminclient.RoleRepresentation, 0, len(names))
+		for _, name := range names {
+			role, statusCode, err := l.roles.GetRoleByName(p.Context, name)
+			if err != nil {
+				return nil, newError(statusCode, err)
+			}
+			roles = append(roles, *role)
+		}
+		update, eventType := l.users.RemoveRoleMappings, event.UserRolesRemoved
+		if add {
+			update, eventType = l.users.AddRoleMappings, event.UserRolesAdded
+		}
+		if statusCode, err := update(p.Context, userId, roles); err != nil {
+			return nil, newError(statusCode, err)
+		}
+		l.userRoles.Clear(p.Context, userId)
+		l.effectiveRoles.Clear(p.Context, userId)
+		b.options.publish(eventType, userId, map[string][]string{"roles": names})
+		return l.fetchUser(p.Context, userId)
+	}
+}
+
+func (b *schemaBuilder) createGroup(p graphql.ResolveParams) (interface{}, error) {
+	group := groupFromInput(p.Args["input"].(map[string]interface{}))
+	if group.GetName() == "" {
+		return nil, badUserInput("input.name is required")
+	}
+	l := loadersFrom(p.Context)
+	groupId, statusCode, err := l.groups.CreateGroup(p.Context, group)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	created, statusCode, err := l.groups.GetGroup(p.Context, groupId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	b.options.publish(event.GroupCreated, groupId, created)
+	return created, nil
+}
+
+func (b *schemaBuilder) updateGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupId := p.Args["id"].(string)
+	l := loadersFrom(p.Context)
+	// Keycloak replaces the group, so the input is applied to the current one.
+	group, statusCode, err := l.groups.GetGroup(p.Context, groupId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	changes := groupFromInput(p.Args["input"].(map[string]interface{}))
+	if changes.Name != nil {
+		group.Name = changes.Name
+	}
+	if changes.Attributes != nil {
+		group.Attributes = changes.Attributes
+	}
+	if statusCode, err := l.groups.UpdateGroup(p.Context, groupId, group); err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.group.Clear(p.Context, groupId)
+	b.options.publish(event.GroupUpdated, groupId, group)
+	return group, nil
+}
+
+func (b *schemaBuilder) deleteGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupId := p.Args["id"].(string)
+	l := loadersFrom(p.Context)
+	if statusCode, err := l.groups.DeleteGroup(p.Context, groupId); err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.group.Clear(p.Context, groupId)
+	b.options.publish(event.GroupDeleted, groupId, nil)
+	return groupId, nil
+}
+
+func (b *schemaBuilder) createRole(p graphql.ResolveParams) (interface{}, error) {
+	role := roleFromInput(p.Args["input"].(map[string]interface{}))
+	if role.GetName() == "" {
+		return nil, badUserInput("input.name is required")
+	}
+	l := loadersFrom(p.Context)
+	roleId, statusCode, err := l.roles.CreateRole(p.Context, role)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	created, statusCode, err := l.roles.GetRoleById(p.Context, roleId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	b.options.publish(event.RoleCreated, roleId, created)
+	return created, nil
+}
+
+func (b *schemaBuilder) updateRole(p graphql.ResolveParams) (interface{}, error) {
+	roleId := p.Args["id"].(string)
+	l := loadersFrom(p.Context)
+	role, statusCode, err := l.roles.GetRoleById(p.Context, roleId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	changes := roleFromInput(p.Args["input"].(map[string]interface{}))
+	if changes.Name != nil {
+		role.Name = changes.Name
+	}
+	if changes.Description != nil {
+		role.Description = changes.Description
+	}
+	if changes.Attributes != nil {
+		role.Attributes = changes.Attributes
+	}
+	updated, statusCode, err := l.roles.UpdateRole(p.Context, roleId, role)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.role.Clear(p.Context, roleId)
+	b.options.publish(event.RoleUpdated, roleId, updated)
+	return updated, nil
+}
+
+func (b *schemaBuilder) deleteRole(p graphql.ResolveParams) (interface{}, error) {
+	roleId := p.Args["id"].(string)
+	l := loadersFrom(p.Context)
+	if statusCode, err := l.roles.DeleteRole(p.Context, roleId); err != nil {
+		return nil, newError(statusCode, err)
+	}
+	l.role.Clear(p.Context, roleId)
+	b.options.publish(event.RoleDeleted, roleId, nil)
+	return roleId, nil
+}
+
+// mutation records a mutation in the audit trail like audit.Middleware
+// records a mutating request. idArg names the argument with the id of the
+// changed resource, the result's id is used when there is none.
+func (b *schemaBuilder) mutation(resourceType string, idArg string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		recorder := b.options.Audit
+		if recorder == nil {
+			return resolve(p)
+		}
+		entry := &audit.Entry{
+			Id:           str.NewUUID(),
+			Time:         time.Now().UTC(),
+			RequestId:    requestid.FromContext(p.Context),
+			Attribution:  callerFromContext(p.Context),
+			Method:       "GRAPHQL",
+			Route:        "/graphql",
+			Path:         "mutation." + p.Info.FieldName,
+			ResourceType: resourceType,
+		}
+		if idArg != "" {
+			entry.ResourceId, _ = p.Args[idArg].(string)
+		}
+
+		result, err := resolve(p)
+
+		entry.StatusCode = http.StatusOK
+		entry.Outcome = audit.OutcomeSuccess
+		if err != nil {
+			entry.StatusCode = http.StatusInternalServerError
+			if e, ok := err.(*Error); ok {
+				entry.StatusCode = e.StatusCode
+			}
+			entry.Outcome = audit.OutcomeFailure
+			entry.Error = err.Error()
+		} else if _, isId := result.(string); !isId {
+			if data, marshalErr := json.Marshal(result); marshalErr == nil {
+				entry.After = data
+				if entry.ResourceId == "" {
+					var created struct {
+						Id string `json:"id"`
+					}
+					if json.Unmarshal(data, &created) == nil {
+						entry.ResourceId = created.Id
+					}
+				}
+			}
+		}
+		recorder.Record(entry)
+		return result, err
+	}
+}
+
+// fetchUser loads a user again after a mutation changed it.
+func (l *loaders) fetchUser(ctx context.Context, userId string) (interface{}, error) {
+	user, statusCode, err := l.users.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, newError(statusCode, err)
+	}
+	return user, nil
+}
+
+func userFromInput(input map[string]interface{}) *keycloakadminclient.UserRepresentation {
+	return &keycloakadminclient.UserRepresentation{
+		Username:      stringArg(input, "username"),
+		Email:         stringArg(input, "email"),
+		FirstName:     stringArg(input, "firstName"),
+		LastName:      stringArg(input, "lastName"),
+		Enabled:       boolArg(input, "enabled"),
+		EmailVerified: boolArg(input, "emailVerified"),
+		Attributes:    attributesArg(input),
+	}
+}
+
+func groupFromInput(input map[string]interface{}) *keycloakadminclient.GroupRepresentation {
+	return &keycloakadminclient.GroupRepresentation{
+		Name:       stringArg(input, "name"),
+		Attributes: attributesArg(input),
+	}
+}
+
+func roleFromInput(input map[string]interface{}) *keycloakadminclient.RoleRepresentation {
+	return &keycloakadminclient.RoleRepresentation{
+		Name:        stringArg(input, "name"),
+		Description: stringArg(input, "description"),
+		Attributes:  attributesArg(input),
+	}
+}
+
+// stringArg returns nil for a field that was left out, so updates leave it
+// as it is.
+func stringArg(input map[string]interface{}, name string) *string {
+	if value, ok := input[name].(string); ok {
+		return &value
+	}
+	return nil
+}
+
+func boolArg(input map[string]interface{}, name string) *bool {
+	if value, ok := input[name].(bool); ok {
+		return &value
+	}
+	return nil
+}
+
+func attributesArg(input map[string]interface{}) *map[string][]string {
+	values, ok := input["attributes"].([]interface{})
+	if !ok {
+		return nil
+	}
+	attributes := make(map[string][]string, len(values))
+	for _, value := range values {
+		if attribute, ok := value.(map[string]interface{}); ok {
+			name, _ := attribute["name"].(string)
+			attributes[name] = stringList(attribute["values"])
+		}
+	}
+	return &attributes
+}
+
+func stringList(value interface{}) []string {
+	values, _ := value.([]interface{})
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,327 @@
+package graph
+
+import (
+	"github.com/graphql-go/graphql"
+	"github.com/miguoliang/keycloakadminclient"
+	"sort"
+	"time"
+)
+
+type schemaBuilder struct {
+	options *Options
+
+	userType   *graphql.Object
+	groupType  *graphql.Object
+	roleType   *graphql.Object
+	userPage   *graphql.Object
+	groupPage  *graphql.Object
+	rolePage   *graphql.Object
+	attributes *graphql.Object
+}
+
+func newSchema(options *Options) (graphql.Schema, error) {
+	b := &schemaBuilder{options: options}
+	b.attributes = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Attribute",
+		Fields: graphql.Fields{
+			"name":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"values": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+		},
+	})
+	// The types refer to each other, so their fields are thunks.
+	b.userType = graphql.NewObject(graphql.ObjectConfig{Name: "User", Fields: graphql.FieldsThunk(b.userFields)})
+	b.groupType = graphql.NewObject(graphql.ObjectConfig{Name: "Group", Fields: graphql.FieldsThunk(b.groupFields)})
+	b.roleType = graphql.NewObject(graphql.ObjectConfig{Name: "Role", Fields: graphql.FieldsThunk(b.roleFields)})
+	b.userPage = connectionType(b.userType)
+	b.groupPage = connectionType(b.groupType)
+	b.rolePage = connectionType(b.roleType)
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: b.queryFields()}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: b.mutationFields()}),
+	})
+}
+
+func (b *schemaBuilder) userFields() graphql.Fields {
+	return graphql.Fields{
+		"id":        field(graphql.NewNonNull(graphql.ID), func(u *keycloakadminclient.UserRepresentation) interface{} { return u.Id }),
+		"username":  field(graphql.NewNonNull(graphql.String), func(u *keycloakadminclient.UserRepresentation) interface{} { return u.Username }),
+		"email":     field(graphql.String, func(u *keycloakadminclient.UserRepresentation) interface{} { return u.Email }),
+		"firstName": field(graphql.String, func(u *keycloakadminclient.UserRepresentation) interface{} { return u.FirstName }),
+		"lastName":  field(graphql.String, func(u *keycloakadminclient.UserRepresentation) interface{} { return u.LastName }),
+		"enabled":   field(graphql.NewNonNull(graphql.Boolean), func(u *keycloakadminclient.UserRepresentation) interface{} { return u.GetEnabled() }),
+		"emailVerified": field(graphql.NewNonNull(graphql.Boolean), func(u *keycloakadminclient.UserRepresentation) interface{} {
+			return u.GetEmailVerified()
+		}),
+		"createdAt": field(graphql.DateTime, func(u *keycloakadminclient.UserRepresentation) interface{} {
+			if u.CreatedTimestamp == nil {
+				return nil
+			}
+			return time.UnixMilli(*u.CreatedTimestamp).UTC()
+		}),
+		"attributes": field(b.attributeList(), func(u *keycloakadminclient.UserRepresentation) interface{} {
+			return attributes(u.Attributes)
+		}),
+		"groups": {
+			Type:        b.nonNullList(b.groupType),
+			Description: "Groups the user is a direct member of",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadList(p, loadersFrom(p.Context).userGroups, p.Source.(*keycloakadminclient.UserRepresentation).GetId())
+			},
+		},
+		"roles": {
+			Type:        b.nonNullList(b.roleType),
+			Description: "Realm roles assigned to the user directly",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadList(p, loadersFrom(p.Context).userRoles, p.Source.(*keycloakadminclient.UserRepresentation).GetId())
+			},
+		},
+		"effectiveRoles": {
+			Type:        b.nonNullList(b.roleType),
+			Description: "Realm roles of the user, including those of its groups and composite roles",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadList(p, loadersFrom(p.Context).effectiveRoles, p.Source.(*keycloakadminclient.UserRepresentation).GetId())
+			},
+		},
+	}
+}
+
+func (b *schemaBuilder) groupFields() graphql.Fields {
+	return graphql.Fields{
+		"id":   field(graphql.NewNonNull(graphql.ID), func(g *keycloakadminclient.GroupRepresentation) interface{} { return g.Id }),
+		"name": field(graphql.NewNonNull(graphql.String), func(g *keycloakadminclient.GroupRepresentation) interface{} { return g.Name }),
+		"path": field(graphql.String, func(g *keycloakadminclient.GroupRepresentation) interface{} { return g.Path }),
+		"attributes": field(b.attributeList(), func(g *keycloakadminclient.GroupRepresentation) interface{} {
+			return attributes(g.Attributes)
+		}),
+		"subGroups": {
+			Type:        b.nonNullList(b.groupType),
+			Description: "Direct children of the group",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadList(p, loadersFrom(p.Context).subGroups, p.Source.(*keycloakadminclient.GroupRepresentation).GetId())
+			},
+		},
+		"members": {
+			Type:        graphql.NewNonNull(b.userPage),
+			Description: "Direct members of the group",
+			Args:        connectionArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				maxSize := maxPageSize
+				if maxListSize := loadersFrom(p.Context).maxListSize; maxListSize > 0 {
+					maxSize = min(maxSize, maxListSize)
+				}
+				offset, limit, err := pageArgs(p.Args, maxSize)
+				if err != nil {
+					return nil, err
+				}
+				thunk := loadersFrom(p.Context).groupMembers.Load(p.Context, p.Source.(*keycloakadminclient.GroupRepresentation).GetId())
+				return func() (interface{}, error) {
+					members, err := thunk()
+					if err != nil {
+						return nil, err
+					}
+					return sliceConnection(members, offset, limit), nil
+				}, nil
+			},
+		},
+	}
+}
+
+func (b *schemaBuilder) roleFields() graphql.Fields {
+	return graphql.Fields{
+		"id":          field(graphql.NewNonNull(graphql.ID), func(r *keycloakadminclient.RoleRepresentation) interface{} { return r.Id }),
+		"name":        field(graphql.NewNonNull(graphql.String), func(r *keycloakadminclient.RoleRepresentation) interface{} { return r.Name }),
+		"description": field(graphql.String, func(r *keycloakadminclient.RoleRepresentation) interface{} { return r.Description }),
+		"composite":   field(graphql.NewNonNull(graphql.Boolean), func(r *keycloakadminclient.RoleRepresentation) interface{} { return r.GetComposite() }),
+		"attributes": field(b.attributeList(), func(r *keycloakadminclient.RoleRepresentation) interface{} {
+			return attributes(r.Attributes)
+		}),
+		"composites": {
+			Type:        b.nonNullList(b.roleType),
+			Description: "Roles the role is composed of, empty unless it is composite",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				role := p.Source.(*keycloakadminclient.RoleRepresentation)
+				if !role.GetComposite() {
+					return []*keycloakadminclient.RoleRepresentation{}, nil
+				}
+				return loadList(p, loadersFrom(p.Context).composites, role.GetId())
+			},
+		},
+	}
+}
+
+func (b *schemaBuilder) queryFields() graphql.Fields {
+	return graphql.Fields{
+		"user": {
+			Type:        b.userType,
+			Description: "A user by id or username, null when there is none",
+			Args: graphql.FieldConfigArgument{
+				"id":       &graphql.ArgumentConfig{Type: graphql.ID},
+				"username": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				l := loadersFrom(p.Context)
+				if id, ok := p.Args["id"].(string); ok {
+					return orNull(load(p, l.user, id))
+				}
+				username, ok := p.Args["username"].(string)
+				if !ok {
+					return nil, badUserInput("id or username is required")
+				}
+				user, statusCode, err := l.users.GetUserByUsername(p.Context, username)
+				if err != nil {
+					return nil, newError(statusCode, err)
+				}
+				if user == nil {
+					return nil, nil
+				}
+				return user, nil
+			},
+		},
+		"users": {
+			Type: graphql.NewNonNull(b.userPage),
+			Args: connectionArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				offset, limit, err := pageArgs(p.Args, maxPageSize)
+				if err != nil {
+					return nil, err
+				}
+				// One more than asked tells whether there is a next page.
+				users, statusCode, err := loadersFrom(p.Context).users.ListUsersPage(p.Context, int32(offset), int32(limit+1))
+				if err != nil {
+					return nil, newError(statusCode, err)
+				}
+				page := *users
+				hasNextPage := len(page) > limit
+				if hasNextPage {
+					page = page[:limit]
+				}
+				return newConnection(page, offset, hasNextPage), nil
+			},
+		},
+		"group": {
+			Type:        b.groupType,
+			Description: "A group by id, null when there is none",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return orNull(load(p, loadersFrom(p.Context).group, p.Args["id"].(string)))
+			},
+		},
+		"groups": {
+			Type:        graphql.NewNonNull(b.groupPage),
+			Description: "Top level groups",
+			Args:        connectionArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				offset, limit, err := pageArgs(p.Args, maxPageSize)
+				if err != nil {
+					return nil, err
+				}
+				groups, statusCode, err := loadersFrom(p.Context).groups.ListGroups(p.Context)
+				if err != nil {
+					return nil, newError(statusCode, err)
+				}
+				return sliceConnection(*groups, offset, limit), nil
+			},
+		},
+		"role": {
+			Type:        b.roleType,
+			Description: "A realm role by id or name, null when there is none",
+			Args: graphql.FieldConfigArgument{
+				"id":   &graphql.ArgumentConfig{Type: graphql.ID},
+				"name": &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				l := loadersFrom(p.Context)
+				if id, ok := p.Args["id"].(string); ok {
+					return orNull(load(p, l.role, id))
+				}
+				name, ok := p.Args["name"].(string)
+				if !ok {
+					return nil, badUserInput("id or name is required")
+				}
+				role, statusCode, err := l.roles.GetRoleByName(p.Context, name)
+				if err != nil {
+					if statusCode == 404 {
+						return nil, nil
+					}
+					return nil, newError(statusCode, err)
+				}
+				return role, nil
+			},
+		},
+		"roles": {
+			Type: graphql.NewNonNull(b.rolePage),
+			Args: connectionArgs,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				offset, limit, err := pageArgs(p.Args, maxPageSize)
+				if err != nil {
+					return nil, err
+				}
+				roles, statusCode, err := loadersFrom(p.Context).roles.ListRoles(p.Context)
+				if err != nil {
+					return nil, newError(statusCode, err)
+				}
+				return sliceConnection(*roles, offset, limit), nil
+			},
+		},
+	}
+}
+
+func (b *schemaBuilder) nonNullList(t *graphql.Object) graphql.Output {
+	return graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(t)))
+}
+
+func (b *schemaBuilder) attributeList() graphql.Output {
+	return b.nonNullList(b.attributes)
+}
+
+// field resolves a field of a Keycloak representation.
+func field[T any](t graphql.Output, get func(source *T) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			source, ok := p.Source.(*T)
+			if !ok || source == nil {
+				return nil, nil
+			}
+			return get(source), nil
+		},
+	}
+}
+
+type attribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// attributes lists the attributes by name, a map has no order.
+func attributes(values *map[string][]string) []attribute {
+	if values == nil {
+		return []attribute{}
+	}
+	result := make([]attribute, 0, len(*values))
+	for name, v := range *values {
+		result = append(result, attribute{Name: name, Values: v})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// orNull turns a not found error of a loaded field into null.
+func orNull(value interface{}, err error) (interface{}, error) {
+	thunk, ok := value.(func() (interface{}, error))
+	if err != nil || !ok {
+		return value, err
+	}
+	return func() (interface{}, error) {
+		value, err := thunk()
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return value, err
+	}, nil
+}
@@ -0,0 +1,149 @@
+// Package health runs the readiness checks of the service and waits for its
+// dependencies on startup.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check is a named readiness check. Run returns why the dependency is not
+// usable, nil when it is.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// Report is the outcome of all checks. Status is up only when every check is.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs the checks and reuses their results for a while, so frequent
+// probes from several replicas do not turn into load on Keycloak.
+type Checker struct {
+	checks  []Check
+	ttl     time.Duration
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	report  *Report
+	checked time.Time
+}
+
+// NewChecker returns a checker that reuses results for ttl and gives each
+// check at most timeout.
+func NewChecker(ttl time.Duration, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, ttl: ttl, timeout: timeout}
+}
+
+// Check runs the checks concurrently, unless the last results are recent
+// enough. Concurrent callers wait for one run instead of starting their own.
+// The checks are bounded by the timeout only: the results are shared, so a
+// prober that hangs up must not leave "context canceled" cached for the rest.
+func (c *Checker) Check(ctx context.Context) *Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.report != nil && time.Since(c.checked) < c.ttl {
+		return c.report
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusUp, Checks: make(map[string]Result, len(c.checks))}
+	for i, check := range c.checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	c.report = report
+	c.checked = time.Now()
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) (result Result) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = Result{Status: StatusDown, Error: fmt.Sprint("panic: ", recovered)}
+		}
+		result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+		result.CheckedAt = start.UTC()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return Result{Status: StatusDown, Error: err.Error()}
+		}
+		return Result{Status: StatusUp}
+	case <-ctx.Done():
+		return Result{Status: StatusDown, Error: fmt.Sprintf("timed out after %s", c.timeout)}
+	}
+}
+
+// WaitFor polls url until it answers 200, or ctx is done.
+func WaitFor(ctx context.Context, url string, interval time.Duration) error {
+	client := &http.Client{Timeout: interval}
+	for {
+		err := probe(ctx, client, url)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s not ready: %w", url, err)
+		case <-time.After(interval):
+		}
+	}
+}
+
+func probe(ctx context.Context, client *http.Client, url string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", response.StatusCode)
+	}
+	return nil
+}
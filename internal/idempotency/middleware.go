@@ -0,0 +1,165 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/auth"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/capture"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+
+	defaultTTL         = 24 * time.Hour
+	defaultMaxBodySize = 1 << 20
+)
+
+type Options struct {
+	// TTL is how long a response is replayed, 24h when 0.
+	TTL time.Duration `mapstructure:"ttl"`
+	// Header is set by the gateway in front to the caller it verified, e.g.
+	// X-Authenticated-User. The keys are scoped by it, and by the client ip
+	// without it. The token claims are not verified here, so they cannot
+	// scope the keys: anyone could replay the responses of someone else.
+	Header string `mapstructure:"header"`
+	// MaxBodySize bounds the request bodies read for the fingerprint, in
+	// bytes, 1 MiB when 0.
+	MaxBodySize int64 `mapstructure:"max-body-size"`
+	// Backend is memory or redis; only redis gives idempotency across the
+	// replicas of the service.
+	Backend string             `mapstructure:"backend"`
+	Redis   cache.RedisOptions `mapstructure:"redis"`
+}
+
+// Middleware makes POST, PUT, PATCH and DELETE requests carrying an
+// Idempotency-Key header safe to retry. The first response for a caller and
+// key is stored for the ttl and replayed for later requests with the same
+// key.
+// Reusing a key for a different request is rejected with 422, and a retry
+// arriving while the first request is still running gets 409.
+// Server errors are not stored, so those requests can simply be retried.
+func Middleware(store Store, options Options) gin.HandlerFunc {
+	ttl := options.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	maxBodySize := options.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if key == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, dto.ErrorResponse{Message: "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+		if err != nil {
+			statusCode := http.StatusBadRequest
+			if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+				statusCode = http.StatusRequestEntityTooLarge
+			}
+			c.AbortWithStatusJSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		storeKey := auth.GatewayCaller(c.GetHeader, options.Header, c.ClientIP()) + "|" + key
+		requestFingerprint := fingerprint(c.Request, body)
+		entry, reserved, err := store.Reserve(storeKey, requestFingerprint, ttl)
+		switch {
+		case errors.Is(err, ErrInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, dto.ErrorResponse{Message: err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+			return
+		case !reserved:
+			if entry.Fingerprint != requestFingerprint {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, dto.ErrorResponse{Message: "Idempotency-Key was already used for a different request"})
+				return
+			}
+			replay(c, entry.Response)
+			return
+		}
+
+		recorder := capture.Wrap(c)
+		defer func() {
+			// Release on panic as well, a stuck reservation would turn every
+			// retry into a 409 until the key expires.
+			if p := recover(); p != nil {
+				release(store, storeKey)
+				panic(p)
+			}
+			if recorder.Status() >= 500 {
+				release(store, storeKey)
+				return
+			}
+			response := &Response{
+				StatusCode:  recorder.Status(),
+				ContentType: recorder.Header().Get("Content-Type"),
+				Location:    recorder.Header().Get("Location"),
+				Body:        recorder.Body(),
+			}
+			if err := store.Complete(storeKey, response); err != nil {
+				logging.FromContext(c.Request.Context()).Error("failed to store idempotent response", "error", err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+func release(store Store, key string) {
+	if err := store.Release(key); err != nil {
+		slog.Error("failed to release idempotency key", "key", key, "error", err)
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint identifies the request a key was first used with.
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replay(c *gin.Context, response *Response) {
+	c.Header(HeaderReplayed, "true")
+	if response.Location != "" {
+		c.Header("Location", response.Location)
+	}
+	if len(response.Body) == 0 {
+		c.AbortWithStatus(response.StatusCode)
+		return
+	}
+	c.Data(response.StatusCode, response.ContentType, response.Body)
+	c.Abort()
+}
@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// redisStore keeps the entries in Redis, which the replicas of the service
+// share, so a retry reaching another replica is replayed as well.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects lazily. While Redis is down the requests with a key
+// fail with 500, rather than risk being run twice.
+func NewRedisStore(options cache.RedisOptions) Store {
+	return &redisStore{client: cache.NewRedisClient(options), prefix: options.KeyPrefix + "idempotency:"}
+}
+
+func (r *redisStore) Reserve(key string, fingerprint string, ttl time.Duration) (*Entry, bool, error) {
+	ctx := context.Background()
+	entry := &Entry{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(ttl)}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return nil, false, err
+	}
+	// The key may expire between the two calls, then it is reserved anew.
+	for attempt := 0; attempt < 2; attempt++ {
+		reserved, err := r.client.SetNX(ctx, r.prefix+key, value, ttl).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		if reserved {
+			return entry, true, nil
+		}
+		stored, err := r.client.Get(ctx, r.prefix+key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		var existing Entry
+		if err := json.Unmarshal(stored, &existing); err != nil {
+			return nil, false, err
+		}
+		if existing.Response == nil {
+			return &existing, false, ErrInProgress
+		}
+		return &existing, false, nil
+	}
+	return nil, false, ErrInProgress
+}
+
+func (r *redisStore) Complete(key string, response *Response) error {
+	ctx := context.Background()
+	stored, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entry Entry
+	if err := json.Unmarshal(stored, &entry); err != nil {
+		return err
+	}
+	entry.Response = response
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// XX leaves a key that expired meanwhile unset.
+	err = r.client.SetArgs(ctx, r.prefix+key, value, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+func (r *redisStore) Release(key string) error {
+	return r.client.Del(context.Background(), r.prefix+key).Err()
+}
+
+func (r *redisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,118 @@
+package idempotency
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInProgress is returned by Store.Reserve when another request with
+	// the same key has not completed yet.
+	ErrInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// Response is the first response given to a request with an idempotency key.
+type Response struct {
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+	Location    string `json:"location,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+// Entry is the state kept for an idempotency key.
+type Entry struct {
+	Fingerprint string    `json:"fingerprint"`
+	Response    *Response `json:"response,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// Store keeps idempotency entries until they expire.
+type Store interface {
+	// Reserve claims key for a new request. If the key is already known the
+	// existing entry is returned with reserved set to false; if that entry has
+	// no response yet, ErrInProgress is returned as well.
+	Reserve(key string, fingerprint string, ttl time.Duration) (entry *Entry, reserved bool, err error)
+	// Complete stores the response for a reserved key.
+	Complete(key string, response *Response) error
+	// Release forgets a reserved key so the request can be retried.
+	Release(key string) error
+	Close() error
+}
+
+// New creates the store options.Backend selects.
+func New(options Options) (Store, error) {
+	switch options.Backend {
+	case "", BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendRedis:
+		return NewRedisStore(options.Redis), nil
+	default:
+		return nil, fmt.Errorf("unknown idempotency backend %q", options.Backend)
+	}
+}
+
+type memoryStore struct {
+	mutex     sync.Mutex
+	entries   map[string]*Entry
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns a Store local to this process, which gives no
+// idempotency across the replicas of the service.
+func NewMemoryStore() Store {
+	return &memoryStore{entries: map[string]*Entry{}}
+}
+
+func (m *memoryStore) Reserve(key string, fingerprint string, ttl time.Duration) (*Entry, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+	if entry, ok := m.entries[key]; ok && now.Before(entry.ExpiresAt) {
+		copied := *entry
+		if entry.Response == nil {
+			return &copied, false, ErrInProgress
+		}
+		return &copied, false, nil
+	}
+	entry := &Entry{Fingerprint: fingerprint, ExpiresAt: now.Add(ttl)}
+	m.entries[key] = entry
+	copied := *entry
+	return &copied, true, nil
+}
+
+func (m *memoryStore) Complete(key string, response *Response) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if entry, ok := m.entries[key]; ok {
+		entry.Response = response
+	}
+	return nil
+}
+
+func (m *memoryStore) Release(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// sweep drops expired entries, at most once a minute.
+func (m *memoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, entry := range m.entries {
+		if !now.Before(entry.ExpiresAt) {
+			delete(m.entries, key)
+		}
+	}
+}
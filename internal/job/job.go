@@ -0,0 +1,63 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Job is a unit of long-running work executed by a Runner.
+type Job interface {
+	// Type names the kind of job, e.g. "group-membership".
+	Type() string
+	// Run does the work. It must return promptly once ctx is cancelled and
+	// should call progress whenever it completes a step. The returned value
+	// is stored as the job result and must be JSON serializable.
+	Run(ctx context.Context, progress Progress) (interface{}, error)
+}
+
+// Progress reports that done out of total steps are complete.
+type Progress func(done int, total int)
+
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCanceled  State = "canceled"
+)
+
+// Finished reports whether the job reached a terminal state.
+func (s State) Finished() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCanceled
+}
+
+var (
+	ErrNotFound      = errors.New("job not found")
+	ErrFinished      = errors.New("job already finished")
+	ErrRunnerStopped = errors.New("job runner stopped")
+)
+
+// Record is the persisted state of a job.
+type Record struct {
+	Id         string          `json:"id"`
+	Type       string          `json:"type"`
+	State      State           `json:"state"`
+	Done       int             `json:"done"`
+	Total      int             `json:"total"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Store persists job records.
+type Store interface {
+	Save(record *Record) error
+	Get(id string) (*Record, error)
+	List() ([]Record, error)
+}
@@ -0,0 +1,209 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Runner executes jobs in the background, at most workers at a time, and
+// keeps their state in a Store.
+type Runner struct {
+	store   Store
+	slots   chan struct{}
+	ctx     context.Context
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	active  map[string]*Record
+	cancels map[string]context.CancelFunc
+}
+
+// NewRunner creates a Runner. Jobs that were pending or running when the
+// previous process exited cannot be resumed and are marked as failed.
+func NewRunner(store Store, workers int) (*Runner, error) {
+	if workers <= 0 {
+		workers = 1
+	}
+	records, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("load jobs: %w", err)
+	}
+	now := time.Now().UTC()
+	for i := range records {
+		record := &records[i]
+		if record.State.Finished() {
+			continue
+		}
+		record.State = StateFailed
+		record.Error = "interrupted by service restart"
+		record.FinishedAt = &now
+		if err := store.Save(record); err != nil {
+			return nil, fmt.Errorf("recover job %s: %w", record.Id, err)
+		}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Runner{
+		store:   store,
+		slots:   make(chan struct{}, workers),
+		ctx:     ctx,
+		stop:    stop,
+		active:  map[string]*Record{},
+		cancels: map[string]context.CancelFunc{},
+	}, nil
+}
+
+// Submit queues a job and returns its initial record.
+func (r *Runner) Submit(job Job) (*Record, error) {
+	if r.ctx.Err() != nil {
+		return nil, ErrRunnerStopped
+	}
+	record := &Record{
+		Id:        str.NewUUID(),
+		Type:      job.Type(),
+		State:     StatePending,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := r.store.Save(record); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+
+	r.mutex.Lock()
+	r.active[record.Id] = record
+	r.cancels[record.Id] = cancel
+	snapshot := *record
+	r.mutex.Unlock()
+
+	r.wg.Add(1)
+	go r.run(ctx, record.Id, job)
+	return &snapshot, nil
+}
+
+// Get returns the current state of a job.
+func (r *Runner) Get(id string) (*Record, error) {
+	r.mutex.Lock()
+	if record, ok := r.active[id]; ok {
+		snapshot := *record
+		r.mutex.Unlock()
+		return &snapshot, nil
+	}
+	r.mutex.Unlock()
+	return r.store.Get(id)
+}
+
+// List returns all known jobs, newest first.
+func (r *Runner) List() ([]Record, error) {
+	return r.store.List()
+}
+
+// Cancel requests cancellation of a pending or running job. The job reaches
+// the canceled state once its Run returns.
+func (r *Runner) Cancel(id string) (*Record, error) {
+	r.mutex.Lock()
+	cancel, ok := r.cancels[id]
+	r.mutex.Unlock()
+	if !ok {
+		record, err := r.store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return record, ErrFinished
+	}
+	cancel()
+	return r.Get(id)
+}
+
+// Stop cancels every job that is still pending or running and waits for
+// them to return. No new jobs are accepted afterwards.
+func (r *Runner) Stop() {
+	r.stop()
+	r.wg.Wait()
+}
+
+func (r *Runner) run(ctx context.Context, id string, job Job) {
+	defer r.wg.Done()
+
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		r.finish(ctx, id, nil, ctx.Err())
+		return
+	}
+
+	r.update(id, func(record *Record) {
+		now := time.Now().UTC()
+		record.State = StateRunning
+		record.StartedAt = &now
+	})
+
+	var (
+		result interface{}
+		err    error
+	)
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panicked: %v", p)
+			}
+		}()
+		result, err = job.Run(ctx, func(done int, total int) {
+			r.update(id, func(record *Record) {
+				record.Done = done
+				record.Total = total
+			})
+		})
+	}()
+	r.finish(ctx, id, result, err)
+}
+
+func (r *Runner) finish(ctx context.Context, id string, result interface{}, err error) {
+	var data json.RawMessage
+	if err == nil && result != nil {
+		data, err = json.Marshal(result)
+	}
+	r.update(id, func(record *Record) {
+		now := time.Now().UTC()
+		record.FinishedAt = &now
+		record.Result = data
+		switch {
+		case ctx.Err() != nil:
+			record.State = StateCanceled
+			record.Error = ctx.Err().Error()
+		case err != nil:
+			record.State = StateFailed
+			record.Error = err.Error()
+		default:
+			record.State = StateSucceeded
+		}
+	})
+
+	r.mutex.Lock()
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+	}
+	delete(r.cancels, id)
+	delete(r.active, id)
+	r.mutex.Unlock()
+}
+
+func (r *Runner) update(id string, fn func(record *Record)) {
+	r.mutex.Lock()
+	record, ok := r.active[id]
+	if !ok {
+		r.mutex.Unlock()
+		return
+	}
+	fn(record)
+	snapshot := *record
+	r.mutex.Unlock()
+
+	if err := r.store.Save(&snapshot); err != nil {
+		slog.Error("failed to persist job", "job", id, "error", err)
+	}
+}
@@ -0,0 +1,138 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type memoryStore struct {
+	mutex   sync.RWMutex
+	records map[string]Record
+}
+
+// NewMemoryStore returns a Store that keeps records in memory only; they are
+// lost on restart.
+func NewMemoryStore() Store {
+	return &memoryStore{records: map[string]Record{}}
+}
+
+func (m *memoryStore) Save(record *Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records[record.Id] = *record
+	return nil
+}
+
+func (m *memoryStore) Get(id string) (*Record, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	record, ok := m.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &record, nil
+}
+
+func (m *memoryStore) List() ([]Record, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	records := make([]Record, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+type fileStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewFileStore returns a Store that keeps one JSON document per job in dir, so
+// job state survives restarts.
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create job store directory: %w", err)
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+func (f *fileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *fileStore) Save(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	// Write to a temporary file and rename it so readers never see a
+	// partially written record.
+	tmp, err := os.CreateTemp(f.dir, record.Id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(record.Id))
+}
+
+func (f *fileStore) Get(id string) (*Record, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("job %s: %w", id, err)
+	}
+	return &record, nil
+}
+
+func (f *fileStore) List() ([]Record, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		record, err := f.Get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, *record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// sortRecords orders records from newest to oldest.
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.After(records[j].CreatedAt)
+	})
+}
@@ -1,12 +1,15 @@
 package keycloak
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"github.com/miguoliang/arch-go/internal/secret"
 	"github.com/miguoliang/keycloakadminclient"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
@@ -14,155 +17,226 @@ import (
 	"time"
 )
 
-var (
-	keycloakServerURL = viper.GetString("keycloak.url")
-	realmName         = viper.GetString("keycloak.admin.realm")
-	clientID          = viper.GetString("keycloak.admin.client-id")
-	username          = viper.GetString("keycloak.admin.username")
-	password          = viper.GetString("keycloak.admin.password")
-)
+// AdminOptions are the Keycloak server and the credentials the admin client
+// logs in with.
+type AdminOptions struct {
+	URL      string
+	Realm    string
+	ClientId string
+	Username string
+	Password string
+	// Secrets, when set, provides the password under the name
+	// PasswordSecret instead of Password. It is asked on every login, so a
+	// rotated password is used from the next login on.
+	Secrets        secret.Provider
+	PasswordSecret string
+	// Client bounds, retries and fails fast the calls, the token requests
+	// included.
+	Client ClientOptions
+}
 
 var (
 	mutex             sync.Mutex
+	admin             AdminOptions
 	accessToken       string
 	refreshToken      string
 	expiryTime        time.Time
 	keycloakApiClient *keycloakadminclient.APIClient
+	// transport makes the calls of keycloakApiClient and the token requests.
+	transport *resilientTransport
+	pool      *http.Transport
 )
 
-func refreshAccessToken() {
+// Configure sets the server and credentials of the admin client. The token
+// and client of earlier options are dropped, the next call logs in again.
+func Configure(options AdminOptions) {
 	mutex.Lock()
 	defer mutex.Unlock()
-
-	if accessToken == "" {
-		initAccessToken()
-		return
+	admin = options
+	accessToken = ""
+	refreshToken = ""
+	expiryTime = time.Time{}
+	keycloakApiClient = nil
+	if pool != nil {
+		pool.CloseIdleConnections()
 	}
+	transport, pool = nil, nil
+	breaker.Store(nil)
+}
 
-	if time.Now().Before(expiryTime.Add(-5 * time.Minute)) { // Refresh before expiry - adjust time as needed
-		tokenData, err := retrieveTokenFromRemote()
-		if err != nil {
-			return
-		}
-
-		newAccessToken, ok := tokenData["access_token"].(string)
-		if !ok {
-			log.Fatalf("access_token missing in response")
-			return
-		}
-		accessToken = newAccessToken
-
-		refreshToken, ok = tokenData["refresh_token"].(string)
-		if !ok {
-			log.Fatalf("refresh_token missing in response")
-			return
-		}
-
-		expiry, ok := tokenData["expires_in"].(float64)
-		if !ok {
-			log.Fatalf("expires_in missing in response")
-			return
-		}
-
-		expiryTime = time.Now().Add(time.Duration(expiry) * time.Second)
+// httpTransport returns the transport of the calls to Keycloak. Must be
+// called with mutex held.
+func httpTransport() *resilientTransport {
+	if transport == nil {
+		pool = newPool(admin.Client.Pool)
+		transport = newResilientTransport(metrics.Transport(pool), admin.Client)
+		breaker.Store(transport.breaker)
 	}
+	return transport
 }
 
-func retrieveTokenFromRemote() (map[string]interface{}, error) {
-	tokenURL := fmt.Sprintf("%s/auth/realms/%s/protocol/openid-connect/token", keycloakServerURL, realmName)
+// tokenRefreshMargin is how long before expiry the admin token is renewed,
+// so a request does not go out with a token that expires on the way.
+const tokenRefreshMargin = 30 * time.Second
 
-	formData := url.Values{}
-	formData.Set("grant_type", "refresh_token") // Assuming you have a refresh token mechanism
-	formData.Set("client_id", clientID)
-	formData.Set("refresh_token", refreshToken)
-
-	requestBody := strings.NewReader(formData.Encode())
+// refreshAccessToken obtains an admin token when there is none, and renews
+// it when it is about to expire: with the refresh token first, and with the
+// password again when the refresh token was rejected or expired as well.
+// The token requests end with ctx, so a caller that gives up does not keep
+// the mutex held.
+func refreshAccessToken(ctx context.Context) error {
+	mutex.Lock()
+	defer mutex.Unlock()
 
-	req, err := http.NewRequest(http.MethodPost, tokenURL, requestBody)
-	if err != nil {
-		log.Fatalf(err.Error())
-		return nil, err
+	if accessToken != "" && time.Now().Before(expiryTime.Add(-tokenRefreshMargin)) {
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if refreshToken != "" {
+		err := requestToken(ctx, "refresh_token", url.Values{"refresh_token": {refreshToken}})
+		if err == nil {
+			return nil
+		}
+		slog.Warn("failed to refresh admin token", "error", err)
+	}
+	password, err := adminPassword(ctx)
 	if err != nil {
-		log.Fatalf(err.Error())
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
+	return requestToken(ctx, "password", url.Values{"username": {admin.Username}, "password": {password}})
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("failed to refresh token: status code %d", resp.StatusCode)
-		return nil, err
+// adminPassword returns the password of the admin user. Must be called with
+// mutex held.
+func adminPassword(ctx context.Context) (string, error) {
+	if admin.Secrets == nil {
+		return admin.Password, nil
 	}
-
-	var tokenData map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&tokenData)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	password, err := admin.Secrets.Get(ctx, admin.PasswordSecret)
 	if err != nil {
-		log.Fatalf(err.Error())
-		return nil, err
+		return "", fmt.Errorf("fetch admin password: %w", err)
 	}
-	return tokenData, nil
+	return password, nil
 }
 
-func initAccessToken() {
-
-	form := url.Values{}
-	form.Add("client_id", clientID)
-	form.Add("username", username)
-	form.Add("password", password)
-	form.Add("grant_type", "password")
-
-	endpoint := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", keycloakServerURL, realmName)
-	resp, err := http.PostForm(endpoint, form)
+// requestToken requests an admin token with the given grant and keeps it.
+// Must be called with mutex held.
+func requestToken(ctx context.Context, grant string, form url.Values) error {
+	form.Set("grant_type", grant)
+	form.Set("client_id", admin.ClientId)
+	endpoint := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", admin.URL, admin.Realm)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
 	if err != nil {
-		log.Fatalf(err.Error())
-		return
+		return fmt.Errorf("request admin token: %w", err)
 	}
-
-	defer resp.Body.Close()
-
-	responseBody, err := io.ReadAll(resp.Body)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := &http.Client{Transport: httpTransport()}
+	response, err := client.Do(request)
 	if err != nil {
-		log.Fatalf(err.Error())
-		return
+		metrics.TokenRefreshed(grant, err, time.Time{})
+		return fmt.Errorf("request admin token: %w", err)
 	}
+	defer response.Body.Close()
 
-	var jsonData map[string]interface{}
-	err = json.Unmarshal(responseBody, &jsonData)
-	if err != nil {
-		log.Fatalf(err.Error())
-		return
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		err = fmt.Errorf("request admin token: status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+		metrics.TokenRefreshed(grant, err, time.Time{})
+		return err
+	}
+	var token struct {
+		AccessToken  string  `json:"access_token"`
+		RefreshToken string  `json:"refresh_token"`
+		ExpiresIn    float64 `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		metrics.TokenRefreshed(grant, err, time.Time{})
+		return fmt.Errorf("decode admin token: %w", err)
+	}
+	if token.AccessToken == "" {
+		err = fmt.Errorf("access_token missing in token response")
+		metrics.TokenRefreshed(grant, err, time.Time{})
+		return err
 	}
 
-	accessToken = jsonData["access_token"].(string)
-	refreshToken = jsonData["refresh_token"].(string)
-	expiry := jsonData["expires_in"].(float64)
-	expiryTime = time.Now().Add(time.Duration(expiry) * time.Second)
+	accessToken = token.AccessToken
+	refreshToken = token.RefreshToken
+	expiryTime = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	metrics.TokenRefreshed(grant, nil, expiryTime)
+	return nil
 }
 
-func GetAdminClient() *keycloakadminclient.APIClient {
+// CheckToken obtains or renews the admin token as needed, and reports why
+// no valid token is held.
+func CheckToken(ctx context.Context) error {
+	if err := refreshAccessToken(ctx); err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !time.Now().Before(expiryTime) {
+		return fmt.Errorf("admin token expired at %s", expiryTime.Format(time.RFC3339))
+	}
+	return nil
+}
 
-	refreshAccessToken()
+// CheckRealm reports whether the realm can be read with the admin token.
+func CheckRealm(ctx context.Context, realmName string) (int, error) {
+	_, h, err := GetAdminClient().RealmsAdminAPI.AdminRealmsRealmGet(ctx, realmName).Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	return CheckResponse(h, err)
+}
+
+// GetAdminClient returns the admin client. The client adds the current
+// admin token to every request, so it can be kept; it is replaced by
+// Configure.
+func GetAdminClient() *keycloakadminclient.APIClient {
+	mutex.Lock()
+	defer mutex.Unlock()
 
 	if keycloakApiClient != nil {
-		keycloakApiClient.GetConfig().DefaultHeader["Authorization"] = "Bearer " + accessToken
 		return keycloakApiClient
 	}
 
 	configuration := keycloakadminclient.NewConfiguration()
-	configuration.AddDefaultHeader("Authorization", "Bearer "+accessToken)
+	configuration.HTTPClient = &http.Client{Transport: otelhttp.NewTransport(
+		tokenTransport{next: httpTransport()},
+		otelhttp.WithSpanNameFormatter(func(_ string, request *http.Request) string {
+			return metrics.Operation(request.Method, request.URL.Path)
+		}),
+	)}
 	configuration.Servers = keycloakadminclient.ServerConfigurations{
 		{
-			URL: keycloakServerURL,
+			URL: admin.URL,
 		},
 	}
 	keycloakApiClient = keycloakadminclient.NewAPIClient(configuration)
 	return keycloakApiClient
 }
 
+// tokenTransport adds the admin token to every request, and renews it
+// first when it is about to expire. When no token can be obtained, the
+// request goes out with the last one and fails with 401.
+type tokenTransport struct {
+	next http.RoundTripper
+}
+
+func (t tokenTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := refreshAccessToken(request.Context()); err != nil {
+		slog.ErrorContext(request.Context(), "failed to obtain admin token", "error", err)
+	}
+	mutex.Lock()
+	token := accessToken
+	mutex.Unlock()
+
+	request = request.Clone(request.Context())
+	request.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(request)
+}
+
 func CheckResponse(h *http.Response, err error) (int, error) {
 	if err == nil {
 		if h != nil {
@@ -174,6 +248,9 @@ func CheckResponse(h *http.Response, err error) (int, error) {
 	if h != nil {
 		return h.StatusCode, err
 	}
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout, err
+	}
 
 	return 500, err
 }
@@ -0,0 +1,292 @@
+package keycloak
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/keycloakadminclient"
+	"strings"
+	"time"
+)
+
+// The namespaces of the cached answers. The lookups by username depend on
+// every user, so they are kept apart from the lookups by id.
+const (
+	cacheUsers     = "users"
+	cacheUsernames = "usernames"
+	cacheGroups    = "groups"
+	cacheRoles     = "roles"
+)
+
+// userCacheKeys are the keys of the lookups of one user.
+func userCacheKeys(userId string) []string {
+	return []string{"user:" + userId, "user:" + userId + ":groups", "user:" + userId + ":roles", "user:" + userId + ":effective-roles"}
+}
+
+type cachedUserService struct {
+	next  UserService
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+// CacheUserService wraps a UserService to keep the lookups of single users
+// for ttl. The changes made through it drop what they make stale.
+func CacheUserService(next UserService, c *cache.Cache, ttl time.Duration) UserService {
+	return &cachedUserService{next: next, cache: c, ttl: ttl}
+}
+
+func (c *cachedUserService) GetUserById(ctx context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheUsers, "user:"+userId, c.ttl, func(ctx context.Context) (*keycloakadminclient.UserRepresentation, int, error) {
+		return c.next.GetUserById(ctx, userId)
+	})
+}
+
+func (c *cachedUserService) GetUserByUsername(ctx context.Context, username string) (*keycloakadminclient.UserRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheUsernames, strings.ToLower(username), c.ttl, func(ctx context.Context) (*keycloakadminclient.UserRepresentation, int, error) {
+		return c.next.GetUserByUsername(ctx, username)
+	})
+}
+
+func (c *cachedUserService) ListUsers(ctx context.Context) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	return c.next.ListUsers(ctx)
+}
+
+func (c *cachedUserService) ListUsersPage(ctx context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	return c.next.ListUsersPage(ctx, first, max)
+}
+
+func (c *cachedUserService) SearchUsers(ctx context.Context, query UserQuery) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	return c.next.SearchUsers(ctx, query)
+}
+
+func (c *cachedUserService) CountUsers(ctx context.Context) (int32, int, error) {
+	return c.next.CountUsers(ctx)
+}
+
+func (c *cachedUserService) CreateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error) {
+	userId, statusCode, err := c.next.CreateUser(ctx, user)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheUsernames)
+	}
+	return userId, statusCode, err
+}
+
+func (c *cachedUserService) UpdateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error) {
+	updated, statusCode, err := c.next.UpdateUser(ctx, user)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, userCacheKeys(user.GetId())...)
+		c.cache.Invalidate(ctx, cacheUsernames)
+	}
+	return updated, statusCode, err
+}
+
+func (c *cachedUserService) DeleteUser(ctx context.Context, userId string) (int, error) {
+	statusCode, err := c.next.DeleteUser(ctx, userId)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, userCacheKeys(userId)...)
+		c.cache.Invalidate(ctx, cacheUsernames)
+	}
+	return statusCode, err
+}
+
+func (c *cachedUserService) ListGroups(ctx context.Context, userId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheUsers, "user:"+userId+":groups", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+		return c.next.ListGroups(ctx, userId)
+	})
+}
+
+// JoinGroup drops the effective roles as well, the user inherits the roles
+// of the group.
+func (c *cachedUserService) JoinGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	statusCode, err := c.next.JoinGroup(ctx, userId, groupId)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, userCacheKeys(userId)...)
+	}
+	return statusCode, err
+}
+
+func (c *cachedUserService) LeaveGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	statusCode, err := c.next.LeaveGroup(ctx, userId, groupId)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, userCacheKeys(userId)...)
+	}
+	return statusCode, err
+}
+
+func (c *cachedUserService) ListEffectiveRoles(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheUsers, "user:"+userId+":effective-roles", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.ListEffectiveRoles(ctx, userId)
+	})
+}
+
+func (c *cachedUserService) ListRoleMappings(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheUsers, "user:"+userId+":roles", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.ListRoleMappings(ctx, userId)
+	})
+}
+
+func (c *cachedUserService) AddRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	statusCode, err := c.next.AddRoleMappings(ctx, userId, roles)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, "user:"+userId+":roles", "user:"+userId+":effective-roles")
+	}
+	return statusCode, err
+}
+
+func (c *cachedUserService) RemoveRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	statusCode, err := c.next.RemoveRoleMappings(ctx, userId, roles)
+	if err == nil {
+		c.cache.Delete(ctx, cacheUsers, "user:"+userId+":roles", "user:"+userId+":effective-roles")
+	}
+	return statusCode, err
+}
+
+type cachedGroupService struct {
+	next  GroupService
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+// CacheGroupService wraps a GroupService to keep the group listings and
+// lookups for ttl. The members are not kept, they change with the users.
+func CacheGroupService(next GroupService, c *cache.Cache, ttl time.Duration) GroupService {
+	return &cachedGroupService{next: next, cache: c, ttl: ttl}
+}
+
+func (c *cachedGroupService) CreateGroup(ctx context.Context, group *keycloakadminclient.GroupRepresentation) (string, int, error) {
+	groupId, statusCode, err := c.next.CreateGroup(ctx, group)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheGroups)
+	}
+	return groupId, statusCode, err
+}
+
+func (c *cachedGroupService) GetGroup(ctx context.Context, groupId string) (*keycloakadminclient.GroupRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheGroups, "group:"+groupId, c.ttl, func(ctx context.Context) (*keycloakadminclient.GroupRepresentation, int, error) {
+		return c.next.GetGroup(ctx, groupId)
+	})
+}
+
+// UpdateGroup drops the groups of the users as well, they hold the names.
+func (c *cachedGroupService) UpdateGroup(ctx context.Context, groupId string, group *keycloakadminclient.GroupRepresentation) (int, error) {
+	statusCode, err := c.next.UpdateGroup(ctx, groupId, group)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheGroups, cacheUsers)
+	}
+	return statusCode, err
+}
+
+func (c *cachedGroupService) DeleteGroup(ctx context.Context, groupId string) (int, error) {
+	statusCode, err := c.next.DeleteGroup(ctx, groupId)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheGroups, cacheUsers)
+	}
+	return statusCode, err
+}
+
+func (c *cachedGroupService) ListGroups(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheGroups, "groups", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+		return c.next.ListGroups(ctx)
+	})
+}
+
+func (c *cachedGroupService) ListMembers(ctx context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	return c.next.ListMembers(ctx, groupId)
+}
+
+func (c *cachedGroupService) ListSubGroups(ctx context.Context, groupId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheGroups, "group:"+groupId+":children", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+		return c.next.ListSubGroups(ctx, groupId)
+	})
+}
+
+type cachedRoleService struct {
+	next  RoleService
+	cache *cache.Cache
+	ttl   time.Duration
+}
+
+// CacheRoleService wraps a RoleService to keep the role listings and
+// lookups for ttl.
+func CacheRoleService(next RoleService, c *cache.Cache, ttl time.Duration) RoleService {
+	return &cachedRoleService{next: next, cache: c, ttl: ttl}
+}
+
+func (c *cachedRoleService) ListRoles(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheRoles, "roles", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.ListRoles(ctx)
+	})
+}
+
+func (c *cachedRoleService) GetRoleById(ctx context.Context, roleId string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheRoles, "role:"+roleId, c.ttl, func(ctx context.Context) (*keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.GetRoleById(ctx, roleId)
+	})
+}
+
+func (c *cachedRoleService) GetRoleByName(ctx context.Context, roleName string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheRoles, "role-name:"+roleName, c.ttl, func(ctx context.Context) (*keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.GetRoleByName(ctx, roleName)
+	})
+}
+
+func (c *cachedRoleService) CreateRole(ctx context.Context, role *keycloakadminclient.RoleRepresentation) (string, int, error) {
+	roleId, statusCode, err := c.next.CreateRole(ctx, role)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheRoles)
+	}
+	return roleId, statusCode, err
+}
+
+// UpdateRole drops the roles of the users as well, they hold the names.
+func (c *cachedRoleService) UpdateRole(ctx context.Context, roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error) {
+	updated, statusCode, err := c.next.UpdateRole(ctx, roleId, role)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheRoles, cacheUsers)
+	}
+	return updated, statusCode, err
+}
+
+func (c *cachedRoleService) DeleteRole(ctx context.Context, roleId string) (int, error) {
+	statusCode, err := c.next.DeleteRole(ctx, roleId)
+	if err == nil {
+		c.cache.Invalidate(ctx, cacheRoles, cacheUsers)
+	}
+	return statusCode, err
+}
+
+func (c *cachedRoleService) ListComposites(ctx context.Context, roleId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	return cache.Load(ctx, c.cache, cacheRoles, "role:"+roleId+":composites", c.ttl, func(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+		return c.next.ListComposites(ctx, roleId)
+	})
+}
+
+// cacheInvalidationTimeout bounds how long an event waits for the store.
+const cacheInvalidationTimeout = 5 * time.Second
+
+// InvalidateCache returns a handler that drops what the changes made
+// outside of the cached services make stale, such as the ones the Keycloak
+// admin events tell about.
+func InvalidateCache(c *cache.Cache) event.Handler {
+	return func(e event.Event) {
+		ctx, cancel := context.WithTimeout(context.Background(), cacheInvalidationTimeout)
+		defer cancel()
+		switch e.Type {
+		case event.UserCreated:
+			c.Invalidate(ctx, cacheUsernames)
+		case event.UserUpdated, event.UserDisabled, event.UserDeleted:
+			c.Delete(ctx, cacheUsers, userCacheKeys(e.Subject)...)
+			c.Invalidate(ctx, cacheUsernames)
+		case event.UserJoinedGroup, event.UserLeftGroup, event.UserRolesAdded, event.UserRolesRemoved:
+			c.Delete(ctx, cacheUsers, userCacheKeys(e.Subject)...)
+		case event.GroupCreated:
+			c.Invalidate(ctx, cacheGroups)
+		case event.GroupUpdated, event.GroupDeleted:
+			c.Invalidate(ctx, cacheGroups, cacheUsers)
+		case event.RoleCreated:
+			c.Invalidate(ctx, cacheRoles)
+		case event.RoleUpdated, event.RoleDeleted:
+			c.Invalidate(ctx, cacheRoles, cacheUsers)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package keycloak
+
+import (
+	"context"
+	"github.com/miguoliang/keycloakadminclient"
+)
+
+// EventQuery filters login events. Empty fields are not filtered on.
+type EventQuery struct {
+	User      string
+	Client    string
+	IpAddress string
+	Types     []string
+	DateFrom  string
+	DateTo    string
+	First     int32
+	Max       int32
+}
+
+// AdminEventQuery filters admin events. Empty fields are not filtered on.
+type AdminEventQuery struct {
+	AuthUser       string
+	AuthClient     string
+	AuthIpAddress  string
+	OperationTypes []string
+	ResourceTypes  []string
+	ResourcePath   string
+	DateFrom       string
+	DateTo         string
+	First          int32
+	Max            int32
+}
+
+type EventService interface {
+	ListEvents(ctx context.Context, query *EventQuery) (*[]keycloakadminclient.EventRepresentation, int, error)
+	ListAdminEvents(ctx context.Context, query *AdminEventQuery) (*[]keycloakadminclient.AdminEventRepresentation, int, error)
+	GetEventsConfig(ctx context.Context) (*keycloakadminclient.RealmEventsConfigRepresentation, int, error)
+	UpdateEventsConfig(ctx context.Context, config *keycloakadminclient.RealmEventsConfigRepresentation) (int, error)
+}
+
+type eventService struct {
+	realmName string
+}
+
+func NewEventService(realmName string) EventService {
+	return TraceEventService(&eventService{
+		realmName: realmName,
+	}, realmName)
+}
+
+// ListEvents lists login events, newest first.
+func (e *eventService) ListEvents(ctx context.Context, query *EventQuery) (*[]keycloakadminclient.EventRepresentation, int, error) {
+	request := GetAdminClient().RealmsAdminAPI.
+		AdminRealmsRealmEventsGet(ctx, e.realmName).
+		First(query.First).
+		Max(query.Max)
+	if query.User != "" {
+		request = request.User(query.User)
+	}
+	if query.Client != "" {
+		request = request.Client(query.Client)
+	}
+	if query.IpAddress != "" {
+		request = request.IpAddress(query.IpAddress)
+	}
+	if len(query.Types) > 0 {
+		request = request.Type_(query.Types)
+	}
+	if query.DateFrom != "" {
+		request = request.DateFrom(query.DateFrom)
+	}
+	if query.DateTo != "" {
+		request = request.DateTo(query.DateTo)
+	}
+	events, h, err := request.Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &events, statusCode, nil
+}
+
+// ListAdminEvents lists admin events, newest first.
+func (e *eventService) ListAdminEvents(ctx context.Context, query *AdminEventQuery) (*[]keycloakadminclient.AdminEventRepresentation, int, error) {
+	request := GetAdminClient().RealmsAdminAPI.
+		AdminRealmsRealmAdminEventsGet(ctx, e.realmName).
+		First(query.First).
+		Max(query.Max)
+	if query.AuthUser != "" {
+		request = request.AuthUser(query.AuthUser)
+	}
+	if query.AuthClient != "" {
+		request = request.AuthClient(query.AuthClient)
+	}
+	if query.AuthIpAddress != "" {
+		request = request.AuthIpAddress(query.AuthIpAddress)
+	}
+	if len(query.OperationTypes) > 0 {
+		request = request.OperationTypes(query.OperationTypes)
+	}
+	if len(query.ResourceTypes) > 0 {
+		request = request.ResourceTypes(query.ResourceTypes)
+	}
+	if query.ResourcePath != "" {
+		request = request.ResourcePath(query.ResourcePath)
+	}
+	if query.DateFrom != "" {
+		request = request.DateFrom(query.DateFrom)
+	}
+	if query.DateTo != "" {
+		request = request.DateTo(query.DateTo)
+	}
+	events, h, err := request.Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &events, statusCode, nil
+}
+
+// GetEventsConfig gets which events the realm records and for how long.
+func (e *eventService) GetEventsConfig(ctx context.Context) (*keycloakadminclient.RealmEventsConfigRepresentation, int, error) {
+	config, h, err := GetAdminClient().RealmsAdminAPI.
+		AdminRealmsRealmEventsConfigGet(ctx, e.realmName).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return config, statusCode, nil
+}
+
+// UpdateEventsConfig updates which events the realm records and for how long.
+func (e *eventService) UpdateEventsConfig(ctx context.Context, config *keycloakadminclient.RealmEventsConfigRepresentation) (int, error) {
+	h, err := GetAdminClient().RealmsAdminAPI.
+		AdminRealmsRealmEventsConfigPut(ctx, e.realmName).
+		RealmEventsConfigRepresentation(*config).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	return CheckResponse(h, err)
+}
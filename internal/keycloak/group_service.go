@@ -4,26 +4,27 @@ import (
 	"context"
 	"fmt"
 	"github.com/miguoliang/keycloakadminclient"
-	"log"
+	"log/slog"
 )
 
 type GroupService interface {
-	CreateGroup(group *keycloakadminclient.GroupRepresentation) (string, int, error)
-	GetGroup(groupId string) (*keycloakadminclient.GroupRepresentation, int, error)
-	UpdateGroup(groupId string, group *keycloakadminclient.GroupRepresentation) (int, error)
-	DeleteGroup(groupId string) (int, error)
-	ListGroups() (*[]keycloakadminclient.GroupRepresentation, int, error)
+	CreateGroup(ctx context.Context, group *keycloakadminclient.GroupRepresentation) (string, int, error)
+	GetGroup(ctx context.Context, groupId string) (*keycloakadminclient.GroupRepresentation, int, error)
+	UpdateGroup(ctx context.Context, groupId string, group *keycloakadminclient.GroupRepresentation) (int, error)
+	DeleteGroup(ctx context.Context, groupId string) (int, error)
+	ListGroups(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error)
+	ListMembers(ctx context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error)
+	ListSubGroups(ctx context.Context, groupId string) (*[]keycloakadminclient.GroupRepresentation, int, error)
 }
 
 type groupService struct {
-	keycloakClient *keycloakadminclient.APIClient
-	realmName      string
+	realmName string
 }
 
 // CreateGroup creates a new group.
-func (g *groupService) CreateGroup(group *keycloakadminclient.GroupRepresentation) (string, int, error) {
-	h, err := g.keycloakClient.GroupsAPI.
-		AdminRealmsRealmGroupsPost(context.Background(), g.realmName).
+func (g *groupService) CreateGroup(ctx context.Context, group *keycloakadminclient.GroupRepresentation) (string, int, error) {
+	h, err := GetAdminClient().GroupsAPI.
+		AdminRealmsRealmGroupsPost(ctx, g.realmName).
 		GroupRepresentation(*group).
 		Execute()
 	if h != nil {
@@ -35,10 +36,10 @@ func (g *groupService) CreateGroup(group *keycloakadminclient.GroupRepresentatio
 	}
 
 	if h == nil {
-		log.Println("http response is nil, but no error occurred.")
+		slog.Error("http response is nil, but no error occurred")
 		return "", 500, fmt.Errorf("http response is nil, but no error occurred")
 	} else if h.StatusCode != 201 {
-		log.Println("Unexpected status code:", h.StatusCode)
+		slog.Error("unexpected status code", "status", h.StatusCode)
 		return "", h.StatusCode, fmt.Errorf("unexpected status code: %d", h.StatusCode)
 	}
 
@@ -48,9 +49,9 @@ func (g *groupService) CreateGroup(group *keycloakadminclient.GroupRepresentatio
 }
 
 // GetGroup gets a group by its id.
-func (g *groupService) GetGroup(groupId string) (*keycloakadminclient.GroupRepresentation, int, error) {
-	groupRepresentation, h, err := g.keycloakClient.GroupsAPI.
-		AdminRealmsRealmGroupsGroupIdGet(context.Background(), g.realmName, groupId).
+func (g *groupService) GetGroup(ctx context.Context, groupId string) (*keycloakadminclient.GroupRepresentation, int, error) {
+	groupRepresentation, h, err := GetAdminClient().GroupsAPI.
+		AdminRealmsRealmGroupsGroupIdGet(ctx, g.realmName, groupId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -63,9 +64,9 @@ func (g *groupService) GetGroup(groupId string) (*keycloakadminclient.GroupRepre
 }
 
 // UpdateGroup updates a group.
-func (g *groupService) UpdateGroup(groupId string, group *keycloakadminclient.GroupRepresentation) (int, error) {
-	h, err := g.keycloakClient.GroupsAPI.
-		AdminRealmsRealmGroupsGroupIdPut(context.Background(), g.realmName, groupId).
+func (g *groupService) UpdateGroup(ctx context.Context, groupId string, group *keycloakadminclient.GroupRepresentation) (int, error) {
+	h, err := GetAdminClient().GroupsAPI.
+		AdminRealmsRealmGroupsGroupIdPut(ctx, g.realmName, groupId).
 		GroupRepresentation(*group).
 		Execute()
 	if h != nil {
@@ -75,9 +76,9 @@ func (g *groupService) UpdateGroup(groupId string, group *keycloakadminclient.Gr
 }
 
 // DeleteGroup deletes a group by its id.
-func (g *groupService) DeleteGroup(groupId string) (int, error) {
-	h, err := g.keycloakClient.GroupsAPI.
-		AdminRealmsRealmGroupsGroupIdDelete(context.Background(), g.realmName, groupId).
+func (g *groupService) DeleteGroup(ctx context.Context, groupId string) (int, error) {
+	h, err := GetAdminClient().GroupsAPI.
+		AdminRealmsRealmGroupsGroupIdDelete(ctx, g.realmName, groupId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -86,9 +87,9 @@ func (g *groupService) DeleteGroup(groupId string) (int, error) {
 }
 
 // ListGroups gets all groups.
-func (g *groupService) ListGroups() (*[]keycloakadminclient.GroupRepresentation, int, error) {
-	groups, h, err := g.keycloakClient.GroupsAPI.
-		AdminRealmsRealmGroupsGet(context.Background(), g.realmName).
+func (g *groupService) ListGroups(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	groups, h, err := GetAdminClient().GroupsAPI.
+		AdminRealmsRealmGroupsGet(ctx, g.realmName).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -100,9 +101,57 @@ func (g *groupService) ListGroups() (*[]keycloakadminclient.GroupRepresentation,
 	return &groups, statusCode, nil
 }
 
-func NewGroupService(realmName string) GroupService {
-	return &groupService{
-		keycloakClient: GetAdminClient(),
-		realmName:      realmName,
+// ListMembers gets the users who are direct members of a group.
+func (g *groupService) ListMembers(ctx context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	const pageSize = 500
+	var members []keycloakadminclient.UserRepresentation
+	for first := int32(0); ; first += pageSize {
+		page, h, err := GetAdminClient().GroupsAPI.
+			AdminRealmsRealmGroupsGroupIdMembersGet(ctx, g.realmName, groupId).
+			BriefRepresentation(true).
+			First(first).
+			Max(pageSize).
+			Execute()
+		if h != nil {
+			h.Body.Close()
+		}
+		statusCode, err := CheckResponse(h, err)
+		if err != nil {
+			return nil, statusCode, err
+		}
+		members = append(members, page...)
+		if len(page) < pageSize {
+			return &members, statusCode, nil
+		}
+	}
+}
+
+// ListSubGroups gets the direct children of a group.
+func (g *groupService) ListSubGroups(ctx context.Context, groupId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	const pageSize = 500
+	var subGroups []keycloakadminclient.GroupRepresentation
+	for first := int32(0); ; first += pageSize {
+		page, h, err := GetAdminClient().GroupsAPI.
+			AdminRealmsRealmGroupsGroupIdChildrenGet(ctx, g.realmName, groupId).
+			First(first).
+			Max(pageSize).
+			Execute()
+		if h != nil {
+			h.Body.Close()
+		}
+		statusCode, err := CheckResponse(h, err)
+		if err != nil {
+			return nil, statusCode, err
+		}
+		subGroups = append(subGroups, page...)
+		if len(page) < pageSize {
+			return &subGroups, statusCode, nil
+		}
 	}
 }
+
+func NewGroupService(realmName string) GroupService {
+	return TraceGroupService(&groupService{
+		realmName: realmName,
+	}, realmName)
+}
@@ -0,0 +1,126 @@
+package keycloaktest
+
+import (
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+func (s *Server) routeEvents(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/realms/{realm}/events", s.inRealm(listEvents))
+	mux.HandleFunc("GET /admin/realms/{realm}/events/config", s.inRealm(getEventsConfig))
+	mux.HandleFunc("PUT /admin/realms/{realm}/events/config", s.inRealm(updateEventsConfig))
+	mux.HandleFunc("GET /admin/realms/{realm}/admin-events", s.inRealm(listAdminEvents))
+}
+
+// timeRange reads the dateFrom and dateTo query parameters, whole days in
+// UTC, as milliseconds.
+func timeRange(w http.ResponseWriter, query url.Values) (int64, int64, bool) {
+	from, to := int64(0), int64(1<<62)
+	if value := query.Get("dateFrom"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid value for 'Date(From)', expected format is yyyy-MM-dd")
+			return 0, 0, false
+		}
+		from = date.UnixMilli()
+	}
+	if value := query.Get("dateTo"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid value for 'Date(To)', expected format is yyyy-MM-dd")
+			return 0, 0, false
+		}
+		to = date.AddDate(0, 0, 1).UnixMilli() - 1
+	}
+	return from, to, true
+}
+
+// matches reports whether value is filter, or any value when filter is
+// empty.
+func matches(filter string, value string) bool {
+	return filter == "" || filter == value
+}
+
+// matchesPath reports whether path matches pattern, in which * stands for
+// any characters.
+func matchesPath(pattern string, path string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(path, part)
+		}
+		index := strings.Index(path, part)
+		if index < 0 {
+			return false
+		}
+		path = path[index+len(part):]
+	}
+	return path == ""
+}
+
+func listEvents(w http.ResponseWriter, r *http.Request, realm *realm) {
+	query := r.URL.Query()
+	from, to, ok := timeRange(w, query)
+	if !ok {
+		return
+	}
+	events := []keycloakadminclient.EventRepresentation{}
+	for i := len(realm.loginEvents) - 1; i >= 0; i-- {
+		event := realm.loginEvents[i]
+		if event.GetTime() < from || event.GetTime() > to ||
+			(len(query["type"]) > 0 && !slices.Contains(query["type"], event.GetType())) ||
+			!matches(query.Get("user"), event.GetUserId()) ||
+			!matches(query.Get("client"), event.GetClientId()) ||
+			!matches(query.Get("ipAddress"), event.GetIpAddress()) {
+			continue
+		}
+		events = append(events, event)
+	}
+	writeJSON(w, http.StatusOK, page(r, events, 100))
+}
+
+func listAdminEvents(w http.ResponseWriter, r *http.Request, realm *realm) {
+	query := r.URL.Query()
+	from, to, ok := timeRange(w, query)
+	if !ok {
+		return
+	}
+	events := []keycloakadminclient.AdminEventRepresentation{}
+	for i := len(realm.adminEvents) - 1; i >= 0; i-- {
+		event := realm.adminEvents[i]
+		if event.GetTime() < from || event.GetTime() > to ||
+			(len(query["operationTypes"]) > 0 && !slices.Contains(query["operationTypes"], event.GetOperationType())) ||
+			(len(query["resourceTypes"]) > 0 && !slices.Contains(query["resourceTypes"], event.GetResourceType())) ||
+			(query.Get("resourcePath") != "" && !matchesPath(query.Get("resourcePath"), event.GetResourcePath())) {
+			continue
+		}
+		events = append(events, event)
+	}
+	writeJSON(w, http.StatusOK, page(r, events, 100))
+}
+
+func getEventsConfig(w http.ResponseWriter, _ *http.Request, realm *realm) {
+	config := realm.events
+	config.EventsEnabled = ptr(config.GetEventsEnabled())
+	config.AdminEventsEnabled = ptr(config.GetAdminEventsEnabled())
+	config.AdminEventsDetailsEnabled = ptr(config.GetAdminEventsDetailsEnabled())
+	writeJSON(w, http.StatusOK, config)
+}
+
+func updateEventsConfig(w http.ResponseWriter, r *http.Request, realm *realm) {
+	var config keycloakadminclient.RealmEventsConfigRepresentation
+	if !decode(w, r, &config) {
+		return
+	}
+	realm.events = config
+	realm.recordAdminEvent("UPDATE", "REALM", "events/config", config)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,182 @@
+package keycloaktest
+
+import (
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) routeGroups(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/realms/{realm}/groups", s.inRealm(listGroups))
+	mux.HandleFunc("POST /admin/realms/{realm}/groups", s.inRealm(s.createGroup))
+	mux.HandleFunc("GET /admin/realms/{realm}/groups/{id}", s.inRealm(withGroup(getGroup)))
+	mux.HandleFunc("PUT /admin/realms/{realm}/groups/{id}", s.inRealm(withGroup(updateGroup)))
+	mux.HandleFunc("DELETE /admin/realms/{realm}/groups/{id}", s.inRealm(withGroup(deleteGroup)))
+	mux.HandleFunc("GET /admin/realms/{realm}/groups/{id}/members", s.inRealm(withGroup(listMembers)))
+	mux.HandleFunc("GET /admin/realms/{realm}/groups/{id}/children", s.inRealm(withGroup(listChildren)))
+	mux.HandleFunc("POST /admin/realms/{realm}/groups/{id}/children", s.inRealm(withGroup(s.createChild)))
+}
+
+// withGroup serves the requests of an existing group.
+func withGroup(handler func(w http.ResponseWriter, r *http.Request, realm *realm, group *group)) func(http.ResponseWriter, *http.Request, *realm) {
+	return func(w http.ResponseWriter, r *http.Request, realm *realm) {
+		found, ok := realm.groups[r.PathValue("id")]
+		if !ok {
+			notFound(w, "Could not find group by id")
+			return
+		}
+		handler(w, r, realm, found)
+	}
+}
+
+func (g *group) id() string {
+	return g.representation.GetId()
+}
+
+// children returns the groups whose parent is the group with the id, the
+// top level groups when it is empty.
+func (r *realm) children(id string) map[string]*group {
+	children := map[string]*group{}
+	for childId, child := range r.groups {
+		if child.parent == id {
+			children[childId] = child
+		}
+	}
+	return children
+}
+
+func (r *realm) groupPath(g *group) string {
+	path := "/" + g.representation.GetName()
+	if parent, ok := r.groups[g.parent]; ok {
+		return r.groupPath(parent) + path
+	}
+	return path
+}
+
+func (r *realm) groupRepresentation(g *group) keycloakadminclient.GroupRepresentation {
+	representation := g.representation
+	representation.Path = ptr(r.groupPath(g))
+	representation.SubGroupCount = ptr(int64(len(r.children(g.id()))))
+	if g.parent != "" {
+		representation.ParentId = ptr(g.parent)
+	}
+	return representation
+}
+
+func (r *realm) groupRepresentations(groups map[string]*group) []keycloakadminclient.GroupRepresentation {
+	representations := []keycloakadminclient.GroupRepresentation{}
+	for _, found := range sorted(groups, func(g *group) string { return g.representation.GetName() }) {
+		representations = append(representations, r.groupRepresentation(found))
+	}
+	return representations
+}
+
+// addGroup creates a group under the parent with the id, or at the top
+// level when it is empty, and answers 201 with its location.
+func (s *Server) addGroup(w http.ResponseWriter, r *http.Request, realm *realm, parent string) {
+	var representation keycloakadminclient.GroupRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	name := strings.TrimSpace(representation.GetName())
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "Group name is missing")
+		return
+	}
+	for _, sibling := range realm.children(parent) {
+		if sibling.representation.GetName() == name {
+			writeError(w, http.StatusConflict, "Sibling group named '"+name+"' already exists.")
+			return
+		}
+	}
+	created := &group{
+		representation: keycloakadminclient.GroupRepresentation{
+			Id:         ptr(str.NewUUID()),
+			Name:       ptr(name),
+			Attributes: representation.Attributes,
+		},
+		parent: parent,
+	}
+	realm.groups[created.id()] = created
+	path := "groups/" + created.id()
+	realm.recordAdminEvent("CREATE", "GROUP", path, realm.groupRepresentation(created))
+	w.Header().Set("Location", s.URL+"/admin/realms/"+realm.name+"/"+path)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func listGroups(w http.ResponseWriter, r *http.Request, realm *realm) {
+	search := strings.ToLower(r.URL.Query().Get("search"))
+	groups := map[string]*group{}
+	for id, found := range realm.children("") {
+		if strings.Contains(strings.ToLower(found.representation.GetName()), search) {
+			groups[id] = found
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, realm.groupRepresentations(groups), -1))
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request, realm *realm) {
+	s.addGroup(w, r, realm, "")
+}
+
+func (s *Server) createChild(w http.ResponseWriter, r *http.Request, realm *realm, parent *group) {
+	s.addGroup(w, r, realm, parent.id())
+}
+
+func getGroup(w http.ResponseWriter, _ *http.Request, realm *realm, found *group) {
+	writeJSON(w, http.StatusOK, realm.groupRepresentation(found))
+}
+
+func updateGroup(w http.ResponseWriter, r *http.Request, realm *realm, found *group) {
+	var representation keycloakadminclient.GroupRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	if name := strings.TrimSpace(representation.GetName()); name != "" && name != found.representation.GetName() {
+		for _, sibling := range realm.children(found.parent) {
+			if sibling.representation.GetName() == name {
+				writeError(w, http.StatusConflict, "Sibling group named '"+name+"' already exists.")
+				return
+			}
+		}
+		found.representation.Name = ptr(name)
+	}
+	if representation.Attributes != nil {
+		found.representation.Attributes = representation.Attributes
+	}
+	realm.recordAdminEvent("UPDATE", "GROUP", "groups/"+found.id(), realm.groupRepresentation(found))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteGroup deletes the group with its sub groups, and the memberships
+// of all of them.
+func deleteGroup(w http.ResponseWriter, _ *http.Request, realm *realm, found *group) {
+	realm.removeGroup(found.id())
+	realm.recordAdminEvent("DELETE", "GROUP", "groups/"+found.id(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *realm) removeGroup(id string) {
+	for childId := range r.children(id) {
+		r.removeGroup(childId)
+	}
+	delete(r.groups, id)
+	for _, member := range r.users {
+		delete(member.groups, id)
+	}
+}
+
+func listMembers(w http.ResponseWriter, r *http.Request, realm *realm, found *group) {
+	members := []keycloakadminclient.UserRepresentation{}
+	for _, member := range sorted(realm.users, func(u *user) string { return u.representation.GetUsername() }) {
+		if member.groups[found.id()] {
+			members = append(members, member.representation)
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, members, 100))
+}
+
+func listChildren(w http.ResponseWriter, r *http.Request, realm *realm, found *group) {
+	writeJSON(w, http.StatusOK, page(r, realm.groupRepresentations(realm.children(found.id())), 10))
+}
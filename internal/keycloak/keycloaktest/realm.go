@@ -0,0 +1,170 @@
+package keycloaktest
+
+import (
+	"encoding/json"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"sort"
+	"time"
+)
+
+// realmRepresentation is the part of a realm export the fake imports.
+type realmRepresentation struct {
+	Id          string                                  `json:"id,omitempty"`
+	Realm       string                                  `json:"realm"`
+	Enabled     *bool                                   `json:"enabled,omitempty"`
+	DefaultRole *keycloakadminclient.RoleRepresentation `json:"defaultRole,omitempty"`
+	Roles       *struct {
+		Realm []keycloakadminclient.RoleRepresentation `json:"realm,omitempty"`
+	} `json:"roles,omitempty"`
+	EventsEnabled             *bool    `json:"eventsEnabled,omitempty"`
+	EventsExpiration          *int64   `json:"eventsExpiration,omitempty"`
+	EventsListeners           []string `json:"eventsListeners,omitempty"`
+	EnabledEventTypes         []string `json:"enabledEventTypes,omitempty"`
+	AdminEventsEnabled        *bool    `json:"adminEventsEnabled,omitempty"`
+	AdminEventsDetailsEnabled *bool    `json:"adminEventsDetailsEnabled,omitempty"`
+}
+
+type realm struct {
+	id      string
+	name    string
+	enabled bool
+	// defaultRole is the id of the composite role every new user gets.
+	defaultRole string
+
+	users  map[string]*user
+	groups map[string]*group
+	roles  map[string]*role
+	events keycloakadminclient.RealmEventsConfigRepresentation
+	// loginEvents and adminEvents are kept oldest first.
+	loginEvents []keycloakadminclient.EventRepresentation
+	adminEvents []keycloakadminclient.AdminEventRepresentation
+}
+
+type user struct {
+	representation keycloakadminclient.UserRepresentation
+	// groups and roles are the ids of the groups joined and of the realm
+	// roles assigned directly.
+	groups map[string]bool
+	roles  map[string]bool
+}
+
+type group struct {
+	representation keycloakadminclient.GroupRepresentation
+	// parent is the id of the parent group, empty for top level groups.
+	parent string
+}
+
+type role struct {
+	representation keycloakadminclient.RoleRepresentation
+	// composites are the ids of the roles this one includes.
+	composites map[string]bool
+}
+
+// newRealm creates a realm with the roles Keycloak creates in every realm.
+func newRealm(id string, name string) *realm {
+	r := &realm{
+		id:      id,
+		name:    name,
+		enabled: true,
+		users:   map[string]*user{},
+		groups:  map[string]*group{},
+		roles:   map[string]*role{},
+	}
+	defaultRole := r.addRole(keycloakadminclient.RoleRepresentation{Name: ptr("default-roles-" + name)})
+	offlineAccess := r.addRole(keycloakadminclient.RoleRepresentation{Name: ptr("offline_access")})
+	umaAuthorization := r.addRole(keycloakadminclient.RoleRepresentation{Name: ptr("uma_authorization")})
+	defaultRole.composites[offlineAccess.id()] = true
+	defaultRole.composites[umaAuthorization.id()] = true
+	defaultRole.representation.Composite = ptr(true)
+	r.defaultRole = defaultRole.id()
+	return r
+}
+
+func importRealm(representation *realmRepresentation) *realm {
+	id := representation.Id
+	if id == "" {
+		id = str.NewUUID()
+	}
+	r := newRealm(id, representation.Realm)
+	if representation.Enabled != nil {
+		r.enabled = *representation.Enabled
+	}
+	if representation.DefaultRole != nil && representation.DefaultRole.GetName() != "" {
+		r.roles[r.defaultRole].representation.Name = representation.DefaultRole.Name
+	}
+	if representation.Roles != nil {
+		for _, imported := range representation.Roles.Realm {
+			if imported.GetName() != "" && r.roleByName(imported.GetName()) == nil {
+				r.addRole(imported)
+			}
+		}
+	}
+	r.events = keycloakadminclient.RealmEventsConfigRepresentation{
+		EventsEnabled:             representation.EventsEnabled,
+		EventsExpiration:          representation.EventsExpiration,
+		EventsListeners:           representation.EventsListeners,
+		EnabledEventTypes:         representation.EnabledEventTypes,
+		AdminEventsEnabled:        representation.AdminEventsEnabled,
+		AdminEventsDetailsEnabled: representation.AdminEventsDetailsEnabled,
+	}
+	return r
+}
+
+func (r *realm) representation() map[string]interface{} {
+	return map[string]interface{}{
+		"id":                        r.id,
+		"realm":                     r.name,
+		"enabled":                   r.enabled,
+		"eventsEnabled":             r.events.GetEventsEnabled(),
+		"adminEventsEnabled":        r.events.GetAdminEventsEnabled(),
+		"adminEventsDetailsEnabled": r.events.GetAdminEventsDetailsEnabled(),
+	}
+}
+
+// recordAdminEvent records a change made through the admin API, when the
+// realm has admin events enabled.
+func (r *realm) recordAdminEvent(operation string, resourceType string, resourcePath string, representation interface{}) {
+	if !r.events.GetAdminEventsEnabled() {
+		return
+	}
+	adminEvent := keycloakadminclient.AdminEventRepresentation{
+		Time:          ptr(time.Now().UnixMilli()),
+		RealmId:       ptr(r.id),
+		OperationType: ptr(operation),
+		ResourceType:  ptr(resourceType),
+		ResourcePath:  ptr(resourcePath),
+	}
+	if r.events.GetAdminEventsDetailsEnabled() && representation != nil {
+		if b, err := json.Marshal(representation); err == nil {
+			adminEvent.Representation = ptr(string(b))
+		}
+	}
+	r.adminEvents = append(r.adminEvents, adminEvent)
+}
+
+func (r *realm) addEvent(event keycloakadminclient.EventRepresentation) {
+	if event.Time == nil {
+		event.Time = ptr(time.Now().UnixMilli())
+	}
+	if event.RealmId == nil {
+		event.RealmId = ptr(r.id)
+	}
+	r.loginEvents = append(r.loginEvents, event)
+}
+
+// sorted returns the values of items ordered by key.
+func sorted[T any](items map[string]T, key func(T) string) []T {
+	values := make([]T, 0, len(items))
+	for _, item := range items {
+		values = append(values, item)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return key(values[i]) < key(values[j])
+	})
+	return values
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
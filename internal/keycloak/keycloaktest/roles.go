@@ -0,0 +1,162 @@
+package keycloaktest
+
+import (
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (s *Server) routeRoles(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/realms/{realm}/roles", s.inRealm(listRoles))
+	mux.HandleFunc("POST /admin/realms/{realm}/roles", s.inRealm(s.createRole))
+	mux.HandleFunc("GET /admin/realms/{realm}/roles-by-id/{id}", s.inRealm(getRole))
+	mux.HandleFunc("PUT /admin/realms/{realm}/roles-by-id/{id}", s.inRealm(updateRole))
+	mux.HandleFunc("DELETE /admin/realms/{realm}/roles-by-id/{id}", s.inRealm(deleteRole))
+	mux.HandleFunc("GET /admin/realms/{realm}/roles-by-id/{id}/composites", s.inRealm(listComposites))
+}
+
+func (r *role) id() string {
+	return r.representation.GetId()
+}
+
+func (r *realm) addRole(representation keycloakadminclient.RoleRepresentation) *role {
+	representation.Id = ptr(str.NewUUID())
+	representation.Composite = ptr(false)
+	representation.Composites = nil
+	representation.ClientRole = ptr(false)
+	representation.ContainerId = ptr(r.id)
+	added := &role{representation: representation, composites: map[string]bool{}}
+	r.roles[added.id()] = added
+	return added
+}
+
+func (r *realm) roleByName(name string) *role {
+	for _, candidate := range r.roles {
+		if candidate.representation.GetName() == name {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// effectiveRoles adds the roles with the given ids and the roles they are
+// composed of to effective.
+func (r *realm) effectiveRoles(ids map[string]bool, effective map[string]*role) {
+	for id := range ids {
+		found, ok := r.roles[id]
+		if !ok || effective[id] != nil {
+			continue
+		}
+		effective[id] = found
+		r.effectiveRoles(found.composites, effective)
+	}
+}
+
+func roleRepresentations(roles map[string]*role) []keycloakadminclient.RoleRepresentation {
+	representations := []keycloakadminclient.RoleRepresentation{}
+	for _, found := range sorted(roles, func(r *role) string { return r.representation.GetName() }) {
+		representations = append(representations, found.representation)
+	}
+	return representations
+}
+
+func listRoles(w http.ResponseWriter, r *http.Request, realm *realm) {
+	search := strings.ToLower(r.URL.Query().Get("search"))
+	matching := map[string]*role{}
+	for id, found := range realm.roles {
+		if strings.Contains(strings.ToLower(found.representation.GetName()), search) {
+			matching[id] = found
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, roleRepresentations(matching), -1))
+}
+
+func (s *Server) createRole(w http.ResponseWriter, r *http.Request, realm *realm) {
+	var representation keycloakadminclient.RoleRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	if representation.GetName() == "" {
+		writeError(w, http.StatusBadRequest, "Role name is missing")
+		return
+	}
+	if realm.roleByName(representation.GetName()) != nil {
+		writeError(w, http.StatusConflict, "Role with name "+representation.GetName()+" already exists")
+		return
+	}
+	created := realm.addRole(representation)
+	path := "roles/" + url.PathEscape(created.representation.GetName())
+	realm.recordAdminEvent("CREATE", "REALM_ROLE", path, created.representation)
+	w.Header().Set("Location", s.URL+"/admin/realms/"+realm.name+"/"+path)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getRole(w http.ResponseWriter, r *http.Request, realm *realm) {
+	found, ok := realm.roles[r.PathValue("id")]
+	if !ok {
+		notFound(w, "Could not find role")
+		return
+	}
+	writeJSON(w, http.StatusOK, found.representation)
+}
+
+func updateRole(w http.ResponseWriter, r *http.Request, realm *realm) {
+	found, ok := realm.roles[r.PathValue("id")]
+	if !ok {
+		notFound(w, "Could not find role")
+		return
+	}
+	var representation keycloakadminclient.RoleRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	if name := representation.GetName(); name != "" && name != found.representation.GetName() {
+		if realm.roleByName(name) != nil {
+			writeError(w, http.StatusConflict, "Role with name "+name+" already exists")
+			return
+		}
+		found.representation.Name = representation.Name
+	}
+	if representation.Description != nil {
+		found.representation.Description = representation.Description
+	}
+	if representation.Attributes != nil {
+		found.representation.Attributes = representation.Attributes
+	}
+	realm.recordAdminEvent("UPDATE", "REALM_ROLE", "roles-by-id/"+found.id(), found.representation)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteRole(w http.ResponseWriter, r *http.Request, realm *realm) {
+	id := r.PathValue("id")
+	if _, ok := realm.roles[id]; !ok {
+		notFound(w, "Could not find role")
+		return
+	}
+	delete(realm.roles, id)
+	for _, other := range realm.roles {
+		delete(other.composites, id)
+	}
+	for _, member := range realm.users {
+		delete(member.roles, id)
+	}
+	realm.recordAdminEvent("DELETE", "REALM_ROLE", "roles-by-id/"+id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listComposites(w http.ResponseWriter, r *http.Request, realm *realm) {
+	found, ok := realm.roles[r.PathValue("id")]
+	if !ok {
+		notFound(w, "Could not find role")
+		return
+	}
+	composites := map[string]*role{}
+	for id := range found.composites {
+		if composite, ok := realm.roles[id]; ok {
+			composites[id] = composite
+		}
+	}
+	writeJSON(w, http.StatusOK, roleRepresentations(composites))
+}
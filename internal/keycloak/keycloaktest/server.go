@@ -0,0 +1,243 @@
+// Package keycloaktest provides an in-memory fake of the part of the
+// Keycloak admin REST API this service uses, for tests.
+package keycloaktest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options are the admin credentials the fake accepts.
+type Options struct {
+	// Realm is the realm the admin logs in to, master when empty.
+	Realm    string
+	Username string
+	Password string
+	// TokenLifespan is how long the access tokens are valid, 5 minutes when
+	// zero.
+	TokenLifespan time.Duration
+}
+
+// Server serves the token endpoint and the users, groups, roles and events
+// of the admin API like Keycloak does: ids are UUIDs, creates answer 201
+// with a Location header, duplicates 409, and unknown ids 404. The state
+// is kept in memory, and the realms are created with POST /admin/realms.
+type Server struct {
+	*httptest.Server
+	options Options
+
+	mutex         sync.Mutex
+	realms        map[string]*realm
+	accessTokens  map[string]time.Time
+	refreshTokens map[string]bool
+}
+
+// NewServer starts a fake with the admin realm only. Close it when done.
+func NewServer(options Options) *Server {
+	if options.Realm == "" {
+		options.Realm = "master"
+	}
+	if options.TokenLifespan <= 0 {
+		options.TokenLifespan = 5 * time.Minute
+	}
+	s := &Server{
+		options:       options,
+		realms:        map[string]*realm{},
+		accessTokens:  map[string]time.Time{},
+		refreshTokens: map[string]bool{},
+	}
+	s.realms[options.Realm] = newRealm(str.NewUUID(), options.Realm)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /realms/{realm}/protocol/openid-connect/token", s.token)
+	mux.HandleFunc("POST /admin/realms", s.authorized(s.createRealm))
+	mux.HandleFunc("GET /admin/realms/{realm}", s.inRealm(getRealm))
+	mux.HandleFunc("DELETE /admin/realms/{realm}", s.authorized(s.deleteRealm))
+	s.routeUsers(mux)
+	s.routeGroups(mux)
+	s.routeRoles(mux)
+	s.routeEvents(mux)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddRealm creates an empty realm, like importing one with only a name.
+func (s *Server) AddRealm(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.realms[name] = newRealm(str.NewUUID(), name)
+}
+
+// AddEvent records a login event in a realm, as if a user had logged in.
+func (s *Server) AddEvent(realmName string, event keycloakadminclient.EventRepresentation) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r, ok := s.realms[realmName]; ok {
+		r.addEvent(event)
+	}
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.PathValue("realm") != s.options.Realm {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Invalid client or Invalid client credentials")
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		if r.PostForm.Get("username") != s.options.Username || r.PostForm.Get("password") != s.options.Password {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_grant", "Invalid user credentials")
+			return
+		}
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if !s.refreshTokens[refreshToken] {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		delete(s.refreshTokens, refreshToken)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+		return
+	}
+
+	accessToken, refreshToken := randomToken(), randomToken()
+	s.accessTokens[accessToken] = time.Now().Add(s.options.TokenLifespan)
+	s.refreshTokens[refreshToken] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":       accessToken,
+		"expires_in":         int(s.options.TokenLifespan.Seconds()),
+		"refresh_token":      refreshToken,
+		"refresh_expires_in": int(s.options.TokenLifespan.Seconds()),
+		"token_type":         "Bearer",
+	})
+}
+
+// authorized answers 401 unless the request carries an unexpired access
+// token, and serves it with the mutex held otherwise.
+func (s *Server) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if expiry, known := s.accessTokens[token]; !ok || !known || time.Now().After(expiry) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "HTTP 401 Unauthorized"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// inRealm serves the requests of an existing realm, see authorized.
+func (s *Server) inRealm(handler func(w http.ResponseWriter, r *http.Request, realm *realm)) http.HandlerFunc {
+	return s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		realm, ok := s.realms[r.PathValue("realm")]
+		if !ok {
+			notFound(w, "Realm not found.")
+			return
+		}
+		handler(w, r, realm)
+	})
+}
+
+func (s *Server) createRealm(w http.ResponseWriter, r *http.Request) {
+	var representation realmRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	if representation.Realm == "" {
+		writeError(w, http.StatusBadRequest, "Realm name cannot be empty")
+		return
+	}
+	if _, ok := s.realms[representation.Realm]; ok {
+		writeError(w, http.StatusConflict, "Conflict detected. See logs for details")
+		return
+	}
+	s.realms[representation.Realm] = importRealm(&representation)
+	w.Header().Set("Location", s.URL+"/admin/realms/"+representation.Realm)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getRealm(w http.ResponseWriter, _ *http.Request, realm *realm) {
+	writeJSON(w, http.StatusOK, realm.representation())
+}
+
+func (s *Server) deleteRealm(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("realm")
+	if _, ok := s.realms[name]; !ok {
+		notFound(w, "Realm not found.")
+		return
+	}
+	delete(s.realms, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(value); err != nil {
+		writeError(w, http.StatusBadRequest, "Cannot parse the JSON")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// writeError answers like Keycloak does for invalid and conflicting
+// representations.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"errorMessage": message})
+}
+
+func notFound(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": message})
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+// page applies the first and max query parameters, max defaulting to
+// defaultMax, or all when negative.
+func page[T any](r *http.Request, items []T, defaultMax int) []T {
+	first, _ := strconv.Atoi(r.URL.Query().Get("first"))
+	max, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil {
+		max = defaultMax
+	}
+	if first < 0 {
+		first = 0
+	}
+	if first >= len(items) {
+		return []T{}
+	}
+	items = items[first:]
+	if max >= 0 && max < len(items) {
+		items = items[:max]
+	}
+	return items
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
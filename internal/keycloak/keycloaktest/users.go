@@ -0,0 +1,278 @@
+package keycloaktest
+
+import (
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *Server) routeUsers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/realms/{realm}/users", s.inRealm(listUsers))
+	mux.HandleFunc("POST /admin/realms/{realm}/users", s.inRealm(s.createUser))
+	mux.HandleFunc("GET /admin/realms/{realm}/users/{id}", s.inRealm(withUser(getUser)))
+	mux.HandleFunc("PUT /admin/realms/{realm}/users/{id}", s.inRealm(withUser(updateUser)))
+	mux.HandleFunc("DELETE /admin/realms/{realm}/users/{id}", s.inRealm(withUser(deleteUser)))
+	mux.HandleFunc("GET /admin/realms/{realm}/users/{id}/groups", s.inRealm(withUser(listUserGroups)))
+	mux.HandleFunc("PUT /admin/realms/{realm}/users/{id}/groups/{groupId}", s.inRealm(withUser(joinGroup)))
+	mux.HandleFunc("DELETE /admin/realms/{realm}/users/{id}/groups/{groupId}", s.inRealm(withUser(leaveGroup)))
+	mux.HandleFunc("GET /admin/realms/{realm}/users/{id}/role-mappings/realm", s.inRealm(withUser(listRoleMappings)))
+	mux.HandleFunc("POST /admin/realms/{realm}/users/{id}/role-mappings/realm", s.inRealm(withUser(addRoleMappings)))
+	mux.HandleFunc("DELETE /admin/realms/{realm}/users/{id}/role-mappings/realm", s.inRealm(withUser(removeRoleMappings)))
+	mux.HandleFunc("GET /admin/realms/{realm}/users/{id}/role-mappings/realm/composite", s.inRealm(withUser(listEffectiveRoles)))
+}
+
+// withUser serves the requests of an existing user.
+func withUser(handler func(w http.ResponseWriter, r *http.Request, realm *realm, user *user)) func(http.ResponseWriter, *http.Request, *realm) {
+	return func(w http.ResponseWriter, r *http.Request, realm *realm) {
+		found, ok := realm.users[r.PathValue("id")]
+		if !ok {
+			notFound(w, "User not found")
+			return
+		}
+		handler(w, r, realm, found)
+	}
+}
+
+// userConflict tells why another user than id cannot have the username or
+// the email, if so.
+func (r *realm) userConflict(id string, username string, email string) string {
+	for _, other := range r.users {
+		if other.representation.GetId() == id {
+			continue
+		}
+		if other.representation.GetUsername() == username {
+			return "User exists with same username"
+		}
+		if email != "" && strings.EqualFold(other.representation.GetEmail(), email) {
+			return "User exists with same email"
+		}
+	}
+	return ""
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request, realm *realm) {
+	query := r.URL.Query()
+	exact := query.Get("exact") == "true"
+	matches := func(value string, filter string) bool {
+		if filter == "" {
+			return true
+		}
+		if exact {
+			return strings.EqualFold(value, filter)
+		}
+		return strings.Contains(strings.ToLower(value), strings.ToLower(filter))
+	}
+	search := strings.ToLower(strings.Trim(query.Get("search"), "*"))
+	users := []keycloakadminclient.UserRepresentation{}
+	for _, found := range sorted(realm.users, func(u *user) string { return u.representation.GetUsername() }) {
+		representation := found.representation
+		if !matches(representation.GetUsername(), query.Get("username")) ||
+			!matches(representation.GetEmail(), query.Get("email")) ||
+			!matches(representation.GetFirstName(), query.Get("firstName")) ||
+			!matches(representation.GetLastName(), query.Get("lastName")) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(strings.Join([]string{
+			representation.GetUsername(), representation.GetEmail(), representation.GetFirstName(), representation.GetLastName(),
+		}, " ")), search) {
+			continue
+		}
+		users = append(users, representation)
+	}
+	// Keycloak returns at most 100 users unless asked for more.
+	writeJSON(w, http.StatusOK, page(r, users, 100))
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request, realm *realm) {
+	var representation keycloakadminclient.UserRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(representation.GetUsername()))
+	if username == "" {
+		writeError(w, http.StatusBadRequest, "User name is missing")
+		return
+	}
+	if message := realm.userConflict("", username, representation.GetEmail()); message != "" {
+		writeError(w, http.StatusConflict, message)
+		return
+	}
+
+	representation.Id = ptr(str.NewUUID())
+	representation.Username = ptr(username)
+	representation.CreatedTimestamp = ptr(time.Now().UnixMilli())
+	representation.Enabled = ptr(representation.GetEnabled())
+	representation.EmailVerified = ptr(representation.GetEmailVerified())
+	representation.Totp = ptr(false)
+	representation.Credentials = nil
+	representation.Groups = nil
+	representation.RealmRoles = nil
+	created := &user{
+		representation: representation,
+		groups:         map[string]bool{},
+		roles:          map[string]bool{realm.defaultRole: true},
+	}
+	realm.users[representation.GetId()] = created
+	path := "users/" + representation.GetId()
+	realm.recordAdminEvent("CREATE", "USER", path, representation)
+	w.Header().Set("Location", s.URL+"/admin/realms/"+realm.name+"/"+path)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func getUser(w http.ResponseWriter, _ *http.Request, _ *realm, found *user) {
+	writeJSON(w, http.StatusOK, found.representation)
+}
+
+func updateUser(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	var representation keycloakadminclient.UserRepresentation
+	if !decode(w, r, &representation) {
+		return
+	}
+	updated := found.representation
+	if representation.Username != nil {
+		updated.Username = ptr(strings.ToLower(strings.TrimSpace(representation.GetUsername())))
+	}
+	if representation.Email != nil {
+		updated.Email = representation.Email
+	}
+	if message := realm.userConflict(updated.GetId(), updated.GetUsername(), updated.GetEmail()); message != "" {
+		writeError(w, http.StatusConflict, message)
+		return
+	}
+	if representation.FirstName != nil {
+		updated.FirstName = representation.FirstName
+	}
+	if representation.LastName != nil {
+		updated.LastName = representation.LastName
+	}
+	if representation.Enabled != nil {
+		updated.Enabled = representation.Enabled
+	}
+	if representation.EmailVerified != nil {
+		updated.EmailVerified = representation.EmailVerified
+	}
+	if representation.Attributes != nil {
+		updated.Attributes = representation.Attributes
+	}
+	if representation.RequiredActions != nil {
+		updated.RequiredActions = representation.RequiredActions
+	}
+	found.representation = updated
+	realm.recordAdminEvent("UPDATE", "USER", "users/"+updated.GetId(), representation)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteUser(w http.ResponseWriter, _ *http.Request, realm *realm, found *user) {
+	delete(realm.users, found.representation.GetId())
+	realm.recordAdminEvent("DELETE", "USER", "users/"+found.representation.GetId(), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listUserGroups(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	groups := map[string]*group{}
+	for id := range found.groups {
+		if joined, ok := realm.groups[id]; ok {
+			groups[id] = joined
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, realm.groupRepresentations(groups), -1))
+}
+
+func joinGroup(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	groupId := r.PathValue("groupId")
+	joined, ok := realm.groups[groupId]
+	if !ok {
+		notFound(w, "Could not find group by id")
+		return
+	}
+	if !found.groups[groupId] {
+		found.groups[groupId] = true
+		realm.recordAdminEvent("CREATE", "GROUP_MEMBERSHIP",
+			"users/"+found.representation.GetId()+"/groups/"+groupId, realm.groupRepresentation(joined))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func leaveGroup(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	groupId := r.PathValue("groupId")
+	left, ok := realm.groups[groupId]
+	if !ok {
+		notFound(w, "Could not find group by id")
+		return
+	}
+	if found.groups[groupId] {
+		delete(found.groups, groupId)
+		realm.recordAdminEvent("DELETE", "GROUP_MEMBERSHIP",
+			"users/"+found.representation.GetId()+"/groups/"+groupId, realm.groupRepresentation(left))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listRoleMappings(w http.ResponseWriter, _ *http.Request, realm *realm, found *user) {
+	roles := map[string]*role{}
+	for id := range found.roles {
+		if assigned, ok := realm.roles[id]; ok {
+			roles[id] = assigned
+		}
+	}
+	writeJSON(w, http.StatusOK, roleRepresentations(roles))
+}
+
+// listEffectiveRoles lists the roles assigned directly and the roles they
+// are composed of.
+func listEffectiveRoles(w http.ResponseWriter, _ *http.Request, realm *realm, found *user) {
+	effective := map[string]*role{}
+	realm.effectiveRoles(found.roles, effective)
+	writeJSON(w, http.StatusOK, roleRepresentations(effective))
+}
+
+// mappedRoles looks up the roles of a role mappings request by name, as
+// Keycloak does, and answers 404 when one does not exist.
+func mappedRoles(w http.ResponseWriter, r *http.Request, realm *realm) ([]*role, bool) {
+	var representations []keycloakadminclient.RoleRepresentation
+	if !decode(w, r, &representations) {
+		return nil, false
+	}
+	roles := make([]*role, 0, len(representations))
+	for _, representation := range representations {
+		found := realm.roleByName(representation.GetName())
+		if found == nil {
+			notFound(w, "Role not found")
+			return nil, false
+		}
+		roles = append(roles, found)
+	}
+	return roles, true
+}
+
+func addRoleMappings(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	roles, ok := mappedRoles(w, r, realm)
+	if !ok {
+		return
+	}
+	representations := make([]keycloakadminclient.RoleRepresentation, 0, len(roles))
+	for _, assigned := range roles {
+		found.roles[assigned.id()] = true
+		representations = append(representations, assigned.representation)
+	}
+	realm.recordAdminEvent("CREATE", "REALM_ROLE_MAPPING",
+		"users/"+found.representation.GetId()+"/role-mappings/realm", representations)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func removeRoleMappings(w http.ResponseWriter, r *http.Request, realm *realm, found *user) {
+	roles, ok := mappedRoles(w, r, realm)
+	if !ok {
+		return
+	}
+	representations := make([]keycloakadminclient.RoleRepresentation, 0, len(roles))
+	for _, unassigned := range roles {
+		delete(found.roles, unassigned.id())
+		representations = append(representations, unassigned.representation)
+	}
+	realm.recordAdminEvent("DELETE", "REALM_ROLE_MAPPING",
+		"users/"+found.representation.GetId()+"/role-mappings/realm", representations)
+	w.WriteHeader(http.StatusNoContent)
+}
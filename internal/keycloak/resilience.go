@@ -0,0 +1,368 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientOptions tune the calls to the Keycloak admin API.
+type ClientOptions struct {
+	// Timeout bounds each attempt of a call, 0 leaves it unbounded.
+	Timeout time.Duration  `mapstructure:"timeout"`
+	Retry   RetryOptions   `mapstructure:"retry"`
+	Breaker BreakerOptions `mapstructure:"breaker"`
+	Pool    PoolOptions    `mapstructure:"pool"`
+}
+
+// RetryOptions are how the calls that failed on the way, or that Keycloak
+// answered with 429, 502 or 503, are made again. Calls that may have been
+// carried out are only made again when they are idempotent.
+type RetryOptions struct {
+	// MaxAttempts counts the first attempt, 1 or less disables the retries.
+	MaxAttempts    int           `mapstructure:"max-attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial-backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max-backoff"`
+}
+
+// BreakerOptions are when the calls fail fast instead of waiting on a
+// Keycloak that is down.
+type BreakerOptions struct {
+	// Failures in a row open the circuit, 0 disables the breaker.
+	Failures int `mapstructure:"failures"`
+	// OpenTimeout is how long the circuit stays open before one call is let
+	// through to probe Keycloak.
+	OpenTimeout time.Duration `mapstructure:"open-timeout"`
+}
+
+// PoolOptions size the pool of connections to Keycloak, the Go defaults are
+// kept for the ones left at 0.
+type PoolOptions struct {
+	MaxIdleConns        int           `mapstructure:"max-idle-conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"max-idle-conns-per-host"`
+	MaxConnsPerHost     int           `mapstructure:"max-conns-per-host"`
+	IdleConnTimeout     time.Duration `mapstructure:"idle-conn-timeout"`
+}
+
+// breaker is the circuit breaker of the current admin client, nil when
+// there is none yet or it is disabled.
+var breaker atomic.Pointer[circuitBreaker]
+
+// RetryAfter is how long until the calls to Keycloak are made again, 0
+// while the circuit is closed.
+func RetryAfter() time.Duration {
+	if b := breaker.Load(); b != nil {
+		return b.retryAfter()
+	}
+	return 0
+}
+
+// newPool creates the transport that holds the connections to Keycloak.
+func newPool(options PoolOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.MaxIdleConns > 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+	if options.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = options.MaxConnsPerHost
+	}
+	if options.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = options.IdleConnTimeout
+	}
+	return transport
+}
+
+// resilientTransport bounds, retries and, through the breaker, fails fast
+// the calls made through next.
+type resilientTransport struct {
+	next    http.RoundTripper
+	options ClientOptions
+	// breaker is nil when disabled.
+	breaker *circuitBreaker
+}
+
+func newResilientTransport(next http.RoundTripper, options ClientOptions) *resilientTransport {
+	t := &resilientTransport{next: next, options: options}
+	if options.Breaker.Failures > 0 {
+		t.breaker = &circuitBreaker{options: options.Breaker}
+	}
+	return t
+}
+
+func (t *resilientTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		var probe uint64
+		if t.breaker != nil {
+			var retryAfter time.Duration
+			var ok bool
+			if probe, retryAfter, ok = t.breaker.allow(); !ok {
+				metrics.CircuitRejected()
+				return unavailable(request, retryAfter), nil
+			}
+		}
+		response, err := t.attempt(request)
+		if t.breaker != nil {
+			t.breaker.record(probe, t.outcome(request, response, err))
+		}
+
+		wait, retry := t.shouldRetry(request, response, err, attempt)
+		if !retry {
+			return response, err
+		}
+		if response != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+			response.Body.Close()
+		}
+		if request.Body != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(request.Context())
+			request.Body = body
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		case <-timer.C:
+		}
+		metrics.KeycloakRetried(request.Method, request.URL.Path)
+	}
+}
+
+// attempt makes one call, bounded by the timeout until its body is closed.
+func (t *resilientTransport) attempt(request *http.Request) (*http.Response, error) {
+	if t.options.Timeout <= 0 {
+		return t.next.RoundTrip(request)
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), t.options.Timeout)
+	response, err := t.next.RoundTrip(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+func (t *resilientTransport) shouldRetry(request *http.Request, response *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= t.options.Retry.MaxAttempts || request.Context().Err() != nil {
+		return 0, false
+	}
+	if request.Body != nil && request.GetBody == nil {
+		return 0, false
+	}
+	wait := t.backoff(attempt)
+	if err != nil {
+		return wait, idempotent(request.Method)
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		// Keycloak turned the call down without carrying it out.
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		if !idempotent(request.Method) {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+		if retryAfter > t.options.Retry.MaxBackoff {
+			return 0, false
+		}
+		wait = retryAfter
+	}
+	return wait, true
+}
+
+// backoff is a random wait of up to twice the one before, starting from
+// InitialBackoff and bounded by MaxBackoff, so the retries of concurrent
+// calls do not arrive together.
+func (t *resilientTransport) backoff(attempt int) time.Duration {
+	ceiling := t.options.Retry.InitialBackoff << (attempt - 1)
+	if ceiling <= 0 || ceiling > t.options.Retry.MaxBackoff {
+		ceiling = t.options.Retry.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// outcome tells the breaker whether Keycloak failed the call. A call the
+// caller gave up on says nothing about Keycloak.
+func (t *resilientTransport) outcome(request *http.Request, response *http.Response, err error) outcome {
+	if err != nil {
+		if request.Context().Err() != nil {
+			return outcomeUnknown
+		}
+		return outcomeFailure
+	}
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter reads the Retry-After header given in seconds; the date
+// form is not used by Keycloak.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// unavailable is the answer to the calls the open circuit turns down.
+func unavailable(request *http.Request, retryAfter time.Duration) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Retry-After", retryAfterSeconds(retryAfter))
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(`{"error":"keycloak is unavailable"}`)),
+		ContentLength: -1,
+		Request:       request,
+	}
+}
+
+// retryAfterSeconds formats d for the Retry-After header, rounded up.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
+
+// cancelBody releases the timeout of a call when its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeUnknown
+)
+
+// circuitBreaker opens after a number of failures in a row. While open, the
+// calls fail fast; after OpenTimeout one call probes Keycloak, and closes
+// the circuit when it succeeds or opens it again when it fails. The calls
+// that were under way when the circuit opened do not change it.
+type circuitBreaker struct {
+	options BreakerOptions
+
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	// probe is the token of the call probing Keycloak, 0 when there is none.
+	probe  uint64
+	probes uint64
+}
+
+// allow reports whether a call can be made, and when not, how long until
+// one can. The probe token is not 0 for the call that probes an open
+// circuit, and is passed back to record with its outcome.
+func (b *circuitBreaker) allow() (uint64, time.Duration, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.openUntil.IsZero() {
+		return 0, 0, true
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return 0, wait, false
+	}
+	if b.probe != 0 {
+		return 0, time.Second, false
+	}
+	b.probes++
+	b.probe = b.probes
+	return b.probe, 0, true
+}
+
+func (b *circuitBreaker) record(probe uint64, o outcome) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.openUntil.IsZero() {
+		// Only the probe decides on an open circuit.
+		if probe == 0 || probe != b.probe {
+			return
+		}
+		b.probe = 0
+		switch o {
+		case outcomeSuccess:
+			b.failures, b.openUntil = 0, time.Time{}
+			metrics.CircuitChanged(false)
+		case outcomeFailure:
+			b.openUntil = time.Now().Add(b.options.OpenTimeout)
+		}
+		return
+	}
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+	case outcomeFailure:
+		b.failures++
+		if b.failures >= b.options.Failures {
+			b.openUntil = time.Now().Add(b.options.OpenTimeout)
+			metrics.CircuitChanged(true)
+		}
+	}
+}
+
+func (b *circuitBreaker) retryAfter() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.openUntil.IsZero() {
+		return 0
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return wait
+	}
+	return time.Second
+}
+
+// isTimeout reports whether err is a call that ran out of time.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
@@ -4,33 +4,32 @@ import (
 	"context"
 	"fmt"
 	"github.com/miguoliang/keycloakadminclient"
-	"log"
+	"log/slog"
 )
 
 type RoleService interface {
-	ListRoles() (*[]keycloakadminclient.RoleRepresentation, int, error)
-	GetRoleById(roleId string) (*keycloakadminclient.RoleRepresentation, int, error)
-	GetRoleByName(roleName string) (*keycloakadminclient.RoleRepresentation, int, error)
-	CreateRole(role *keycloakadminclient.RoleRepresentation) (string, int, error)
-	UpdateRole(roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error)
-	DeleteRole(roleId string) (int, error)
+	ListRoles(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error)
+	GetRoleById(ctx context.Context, roleId string) (*keycloakadminclient.RoleRepresentation, int, error)
+	GetRoleByName(ctx context.Context, roleName string) (*keycloakadminclient.RoleRepresentation, int, error)
+	CreateRole(ctx context.Context, role *keycloakadminclient.RoleRepresentation) (string, int, error)
+	UpdateRole(ctx context.Context, roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error)
+	DeleteRole(ctx context.Context, roleId string) (int, error)
+	ListComposites(ctx context.Context, roleId string) (*[]keycloakadminclient.RoleRepresentation, int, error)
 }
 
 type roleService struct {
-	client    *keycloakadminclient.APIClient
 	realmName string
 }
 
 func NewRoleService(realmName string) RoleService {
-	return &roleService{
-		client:    GetAdminClient(),
+	return TraceRoleService(&roleService{
 		realmName: realmName,
-	}
+	}, realmName)
 }
 
-func (r *roleService) ListRoles() (*[]keycloakadminclient.RoleRepresentation, int, error) {
-	roles, h, err := r.client.RolesAPI.
-		AdminRealmsRealmRolesGet(context.Background(), r.realmName).
+func (r *roleService) ListRoles(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	roles, h, err := GetAdminClient().RolesAPI.
+		AdminRealmsRealmRolesGet(ctx, r.realmName).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -42,9 +41,9 @@ func (r *roleService) ListRoles() (*[]keycloakadminclient.RoleRepresentation, in
 	return &roles, statusCode, nil
 }
 
-func (r *roleService) GetRoleById(roleId string) (*keycloakadminclient.RoleRepresentation, int, error) {
-	role, h, err := r.client.RolesByIDAPI.
-		AdminRealmsRealmRolesByIdRoleIdGet(context.Background(), r.realmName, roleId).
+func (r *roleService) GetRoleById(ctx context.Context, roleId string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	role, h, err := GetAdminClient().RolesByIDAPI.
+		AdminRealmsRealmRolesByIdRoleIdGet(ctx, r.realmName, roleId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -56,8 +55,8 @@ func (r *roleService) GetRoleById(roleId string) (*keycloakadminclient.RoleRepre
 	return role, statusCode, nil
 }
 
-func (r *roleService) GetRoleByName(roleName string) (*keycloakadminclient.RoleRepresentation, int, error) {
-	roles, _, err := r.ListRoles()
+func (r *roleService) GetRoleByName(ctx context.Context, roleName string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	roles, _, err := r.ListRoles(ctx)
 	if err != nil {
 		return nil, 500, err
 	}
@@ -69,9 +68,9 @@ func (r *roleService) GetRoleByName(roleName string) (*keycloakadminclient.RoleR
 	return nil, 404, fmt.Errorf("role name %s not found", roleName)
 }
 
-func (r *roleService) CreateRole(role *keycloakadminclient.RoleRepresentation) (string, int, error) {
-	h, err := r.client.RolesAPI.
-		AdminRealmsRealmRolesPost(context.Background(), r.realmName).
+func (r *roleService) CreateRole(ctx context.Context, role *keycloakadminclient.RoleRepresentation) (string, int, error) {
+	h, err := GetAdminClient().RolesAPI.
+		AdminRealmsRealmRolesPost(ctx, r.realmName).
 		RoleRepresentation(*role).
 		Execute()
 	if h != nil {
@@ -79,23 +78,23 @@ func (r *roleService) CreateRole(role *keycloakadminclient.RoleRepresentation) (
 	}
 	statusCode, err := CheckResponse(h, err)
 	if h == nil {
-		log.Println("http response is nil, but no error occurred.")
+		slog.Error("http response is nil, but no error occurred")
 		return "", 500, fmt.Errorf("http response is nil, but no error occurred")
 	} else if h.StatusCode != 201 {
-		log.Println("Unexpected status code:", h.StatusCode)
+		slog.Error("unexpected status code", "status", h.StatusCode)
 		return "", h.StatusCode, fmt.Errorf("unexpected status code: %d", h.StatusCode)
 	}
 
-	newRole, statusCode, err := r.GetRoleByName(*role.Name)
+	newRole, statusCode, err := r.GetRoleByName(ctx, *role.Name)
 	if err != nil {
 		return "", statusCode, err
 	}
 	return newRole.GetId(), 201, nil
 }
 
-func (r *roleService) UpdateRole(roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error) {
-	h, err := r.client.RolesByIDAPI.
-		AdminRealmsRealmRolesByIdRoleIdPut(context.Background(), r.realmName, roleId).
+func (r *roleService) UpdateRole(ctx context.Context, roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error) {
+	h, err := GetAdminClient().RolesByIDAPI.
+		AdminRealmsRealmRolesByIdRoleIdPut(ctx, r.realmName, roleId).
 		RoleRepresentation(*role).
 		Execute()
 	if h != nil {
@@ -108,9 +107,9 @@ func (r *roleService) UpdateRole(roleId string, role *keycloakadminclient.RoleRe
 	return role, statusCode, nil
 }
 
-func (r *roleService) DeleteRole(roleId string) (int, error) {
-	h, err := r.client.RolesByIDAPI.
-		AdminRealmsRealmRolesByIdRoleIdDelete(context.Background(), r.realmName, roleId).
+func (r *roleService) DeleteRole(ctx context.Context, roleId string) (int, error) {
+	h, err := GetAdminClient().RolesByIDAPI.
+		AdminRealmsRealmRolesByIdRoleIdDelete(ctx, r.realmName, roleId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -121,3 +120,17 @@ func (r *roleService) DeleteRole(roleId string) (int, error) {
 	}
 	return statusCode, nil
 }
+
+func (r *roleService) ListComposites(ctx context.Context, roleId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	roles, h, err := GetAdminClient().RolesByIDAPI.
+		AdminRealmsRealmRolesByIdRoleIdCompositesGet(ctx, r.realmName, roleId).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &roles, statusCode, nil
+}
@@ -0,0 +1,342 @@
+package keycloak
+
+import (
+	"context"
+	"github.com/miguoliang/keycloakadminclient"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+const instrumentationName = "github.com/miguoliang/arch-go/internal/keycloak"
+
+// startSpan starts the span of a service method. The outbound HTTP calls the
+// method makes are its children.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attributes...))
+}
+
+// endSpan records the status Keycloak answered and ends the span.
+func endSpan(span trace.Span, statusCode int, err error) {
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if statusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+	span.End()
+}
+
+func realmAttribute(realmName string) attribute.KeyValue {
+	return attribute.String("keycloak.realm", realmName)
+}
+
+type tracedUserService struct {
+	next      UserService
+	realmName string
+}
+
+// TraceUserService wraps a UserService to start a span for every method.
+func TraceUserService(next UserService, realmName string) UserService {
+	return &tracedUserService{next: next, realmName: realmName}
+}
+
+func (t *tracedUserService) span(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, "UserService."+name, append(attributes, realmAttribute(t.realmName))...)
+}
+
+func (t *tracedUserService) GetUserById(ctx context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetUserById", attribute.String("keycloak.user.id", userId))
+	user, statusCode, err := t.next.GetUserById(ctx, userId)
+	endSpan(span, statusCode, err)
+	return user, statusCode, err
+}
+
+func (t *tracedUserService) GetUserByUsername(ctx context.Context, username string) (*keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetUserByUsername", attribute.String("keycloak.user.username", username))
+	user, statusCode, err := t.next.GetUserByUsername(ctx, username)
+	endSpan(span, statusCode, err)
+	return user, statusCode, err
+}
+
+func (t *tracedUserService) ListUsers(ctx context.Context) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListUsers")
+	users, statusCode, err := t.next.ListUsers(ctx)
+	endSpan(span, statusCode, err)
+	return users, statusCode, err
+}
+
+func (t *tracedUserService) ListUsersPage(ctx context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListUsersPage", attribute.Int("keycloak.first", int(first)), attribute.Int("keycloak.max", int(max)))
+	users, statusCode, err := t.next.ListUsersPage(ctx, first, max)
+	endSpan(span, statusCode, err)
+	return users, statusCode, err
+}
+
+func (t *tracedUserService) SearchUsers(ctx context.Context, query UserQuery) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "SearchUsers")
+	users, statusCode, err := t.next.SearchUsers(ctx, query)
+	endSpan(span, statusCode, err)
+	return users, statusCode, err
+}
+
+func (t *tracedUserService) CountUsers(ctx context.Context) (int32, int, error) {
+	ctx, span := t.span(ctx, "CountUsers")
+	count, statusCode, err := t.next.CountUsers(ctx)
+	endSpan(span, statusCode, err)
+	return count, statusCode, err
+}
+
+func (t *tracedUserService) CreateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error) {
+	ctx, span := t.span(ctx, "CreateUser")
+	userId, statusCode, err := t.next.CreateUser(ctx, user)
+	if userId != "" {
+		span.SetAttributes(attribute.String("keycloak.user.id", userId))
+	}
+	endSpan(span, statusCode, err)
+	return userId, statusCode, err
+}
+
+func (t *tracedUserService) UpdateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "UpdateUser", attribute.String("keycloak.user.id", user.GetId()))
+	updated, statusCode, err := t.next.UpdateUser(ctx, user)
+	endSpan(span, statusCode, err)
+	return updated, statusCode, err
+}
+
+func (t *tracedUserService) DeleteUser(ctx context.Context, userId string) (int, error) {
+	ctx, span := t.span(ctx, "DeleteUser", attribute.String("keycloak.user.id", userId))
+	statusCode, err := t.next.DeleteUser(ctx, userId)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedUserService) ListGroups(ctx context.Context, userId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListGroups", attribute.String("keycloak.user.id", userId))
+	groups, statusCode, err := t.next.ListGroups(ctx, userId)
+	endSpan(span, statusCode, err)
+	return groups, statusCode, err
+}
+
+func (t *tracedUserService) JoinGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	ctx, span := t.span(ctx, "JoinGroup", attribute.String("keycloak.user.id", userId), attribute.String("keycloak.group.id", groupId))
+	statusCode, err := t.next.JoinGroup(ctx, userId, groupId)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedUserService) LeaveGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	ctx, span := t.span(ctx, "LeaveGroup", attribute.String("keycloak.user.id", userId), attribute.String("keycloak.group.id", groupId))
+	statusCode, err := t.next.LeaveGroup(ctx, userId, groupId)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedUserService) ListEffectiveRoles(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListEffectiveRoles", attribute.String("keycloak.user.id", userId))
+	roles, statusCode, err := t.next.ListEffectiveRoles(ctx, userId)
+	endSpan(span, statusCode, err)
+	return roles, statusCode, err
+}
+
+func (t *tracedUserService) ListRoleMappings(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListRoleMappings", attribute.String("keycloak.user.id", userId))
+	roles, statusCode, err := t.next.ListRoleMappings(ctx, userId)
+	endSpan(span, statusCode, err)
+	return roles, statusCode, err
+}
+
+func (t *tracedUserService) AddRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	ctx, span := t.span(ctx, "AddRoleMappings", attribute.String("keycloak.user.id", userId), attribute.Int("keycloak.roles", len(roles)))
+	statusCode, err := t.next.AddRoleMappings(ctx, userId, roles)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedUserService) RemoveRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	ctx, span := t.span(ctx, "RemoveRoleMappings", attribute.String("keycloak.user.id", userId), attribute.Int("keycloak.roles", len(roles)))
+	statusCode, err := t.next.RemoveRoleMappings(ctx, userId, roles)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+type tracedGroupService struct {
+	next      GroupService
+	realmName string
+}
+
+// TraceGroupService wraps a GroupService to start a span for every method.
+func TraceGroupService(next GroupService, realmName string) GroupService {
+	return &tracedGroupService{next: next, realmName: realmName}
+}
+
+func (t *tracedGroupService) span(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, "GroupService."+name, append(attributes, realmAttribute(t.realmName))...)
+}
+
+func (t *tracedGroupService) CreateGroup(ctx context.Context, group *keycloakadminclient.GroupRepresentation) (string, int, error) {
+	ctx, span := t.span(ctx, "CreateGroup")
+	groupId, statusCode, err := t.next.CreateGroup(ctx, group)
+	if groupId != "" {
+		span.SetAttributes(attribute.String("keycloak.group.id", groupId))
+	}
+	endSpan(span, statusCode, err)
+	return groupId, statusCode, err
+}
+
+func (t *tracedGroupService) GetGroup(ctx context.Context, groupId string) (*keycloakadminclient.GroupRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetGroup", attribute.String("keycloak.group.id", groupId))
+	group, statusCode, err := t.next.GetGroup(ctx, groupId)
+	endSpan(span, statusCode, err)
+	return group, statusCode, err
+}
+
+func (t *tracedGroupService) UpdateGroup(ctx context.Context, groupId string, group *keycloakadminclient.GroupRepresentation) (int, error) {
+	ctx, span := t.span(ctx, "UpdateGroup", attribute.String("keycloak.group.id", groupId))
+	statusCode, err := t.next.UpdateGroup(ctx, groupId, group)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedGroupService) DeleteGroup(ctx context.Context, groupId string) (int, error) {
+	ctx, span := t.span(ctx, "DeleteGroup", attribute.String("keycloak.group.id", groupId))
+	statusCode, err := t.next.DeleteGroup(ctx, groupId)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedGroupService) ListGroups(ctx context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListGroups")
+	groups, statusCode, err := t.next.ListGroups(ctx)
+	endSpan(span, statusCode, err)
+	return groups, statusCode, err
+}
+
+func (t *tracedGroupService) ListMembers(ctx context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListMembers", attribute.String("keycloak.group.id", groupId))
+	users, statusCode, err := t.next.ListMembers(ctx, groupId)
+	endSpan(span, statusCode, err)
+	return users, statusCode, err
+}
+
+func (t *tracedGroupService) ListSubGroups(ctx context.Context, groupId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListSubGroups", attribute.String("keycloak.group.id", groupId))
+	groups, statusCode, err := t.next.ListSubGroups(ctx, groupId)
+	endSpan(span, statusCode, err)
+	return groups, statusCode, err
+}
+
+type tracedRoleService struct {
+	next      RoleService
+	realmName string
+}
+
+// TraceRoleService wraps a RoleService to start a span for every method.
+func TraceRoleService(next RoleService, realmName string) RoleService {
+	return &tracedRoleService{next: next, realmName: realmName}
+}
+
+func (t *tracedRoleService) span(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, "RoleService."+name, append(attributes, realmAttribute(t.realmName))...)
+}
+
+func (t *tracedRoleService) ListRoles(ctx context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListRoles")
+	roles, statusCode, err := t.next.ListRoles(ctx)
+	endSpan(span, statusCode, err)
+	return roles, statusCode, err
+}
+
+func (t *tracedRoleService) GetRoleById(ctx context.Context, roleId string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetRoleById", attribute.String("keycloak.role.id", roleId))
+	role, statusCode, err := t.next.GetRoleById(ctx, roleId)
+	endSpan(span, statusCode, err)
+	return role, statusCode, err
+}
+
+func (t *tracedRoleService) GetRoleByName(ctx context.Context, roleName string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetRoleByName", attribute.String("keycloak.role.name", roleName))
+	role, statusCode, err := t.next.GetRoleByName(ctx, roleName)
+	endSpan(span, statusCode, err)
+	return role, statusCode, err
+}
+
+func (t *tracedRoleService) CreateRole(ctx context.Context, role *keycloakadminclient.RoleRepresentation) (string, int, error) {
+	ctx, span := t.span(ctx, "CreateRole", attribute.String("keycloak.role.name", role.GetName()))
+	roleId, statusCode, err := t.next.CreateRole(ctx, role)
+	if roleId != "" {
+		span.SetAttributes(attribute.String("keycloak.role.id", roleId))
+	}
+	endSpan(span, statusCode, err)
+	return roleId, statusCode, err
+}
+
+func (t *tracedRoleService) UpdateRole(ctx context.Context, roleId string, role *keycloakadminclient.RoleRepresentation) (*keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "UpdateRole", attribute.String("keycloak.role.id", roleId))
+	updated, statusCode, err := t.next.UpdateRole(ctx, roleId, role)
+	endSpan(span, statusCode, err)
+	return updated, statusCode, err
+}
+
+func (t *tracedRoleService) DeleteRole(ctx context.Context, roleId string) (int, error) {
+	ctx, span := t.span(ctx, "DeleteRole", attribute.String("keycloak.role.id", roleId))
+	statusCode, err := t.next.DeleteRole(ctx, roleId)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
+
+func (t *tracedRoleService) ListComposites(ctx context.Context, roleId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListComposites", attribute.String("keycloak.role.id", roleId))
+	roles, statusCode, err := t.next.ListComposites(ctx, roleId)
+	endSpan(span, statusCode, err)
+	return roles, statusCode, err
+}
+
+type tracedEventService struct {
+	next      EventService
+	realmName string
+}
+
+// TraceEventService wraps an EventService to start a span for every method.
+func TraceEventService(next EventService, realmName string) EventService {
+	return &tracedEventService{next: next, realmName: realmName}
+}
+
+func (t *tracedEventService) span(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startSpan(ctx, "EventService."+name, append(attributes, realmAttribute(t.realmName))...)
+}
+
+func (t *tracedEventService) ListEvents(ctx context.Context, query *EventQuery) (*[]keycloakadminclient.EventRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListEvents")
+	events, statusCode, err := t.next.ListEvents(ctx, query)
+	endSpan(span, statusCode, err)
+	return events, statusCode, err
+}
+
+func (t *tracedEventService) ListAdminEvents(ctx context.Context, query *AdminEventQuery) (*[]keycloakadminclient.AdminEventRepresentation, int, error) {
+	ctx, span := t.span(ctx, "ListAdminEvents")
+	events, statusCode, err := t.next.ListAdminEvents(ctx, query)
+	endSpan(span, statusCode, err)
+	return events, statusCode, err
+}
+
+func (t *tracedEventService) GetEventsConfig(ctx context.Context) (*keycloakadminclient.RealmEventsConfigRepresentation, int, error) {
+	ctx, span := t.span(ctx, "GetEventsConfig")
+	config, statusCode, err := t.next.GetEventsConfig(ctx)
+	endSpan(span, statusCode, err)
+	return config, statusCode, err
+}
+
+func (t *tracedEventService) UpdateEventsConfig(ctx context.Context, config *keycloakadminclient.RealmEventsConfigRepresentation) (int, error) {
+	ctx, span := t.span(ctx, "UpdateEventsConfig")
+	statusCode, err := t.next.UpdateEventsConfig(ctx, config)
+	endSpan(span, statusCode, err)
+	return statusCode, err
+}
@@ -4,36 +4,53 @@ import (
 	"context"
 	"fmt"
 	"github.com/miguoliang/keycloakadminclient"
-	"log"
+	"log/slog"
+	"strings"
 )
 
 type UserService interface {
-	GetUserById(userId string) (*keycloakadminclient.UserRepresentation, int, error)
-	GetUserByUsername(username string) (*keycloakadminclient.UserRepresentation, int, error)
-	ListUsers() (*[]keycloakadminclient.UserRepresentation, int, error)
-	CreateUser(user *keycloakadminclient.UserRepresentation) (string, int, error)
-	UpdateUser(user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error)
-	DeleteUser(userId string) (int, error)
-	ListGroups(userId string) (*[]keycloakadminclient.GroupRepresentation, int, error)
-	JoinGroup(userId string, groupId string) (int, error)
-	LeaveGroup(userId string, groupId string) (int, error)
+	GetUserById(ctx context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error)
+	GetUserByUsername(ctx context.Context, username string) (*keycloakadminclient.UserRepresentation, int, error)
+	ListUsers(ctx context.Context) (*[]keycloakadminclient.UserRepresentation, int, error)
+	ListUsersPage(ctx context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error)
+	SearchUsers(ctx context.Context, query UserQuery) (*[]keycloakadminclient.UserRepresentation, int, error)
+	CountUsers(ctx context.Context) (int32, int, error)
+	CreateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error)
+	UpdateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error)
+	DeleteUser(ctx context.Context, userId string) (int, error)
+	ListGroups(ctx context.Context, userId string) (*[]keycloakadminclient.GroupRepresentation, int, error)
+	JoinGroup(ctx context.Context, userId string, groupId string) (int, error)
+	LeaveGroup(ctx context.Context, userId string, groupId string) (int, error)
+	ListEffectiveRoles(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error)
+	ListRoleMappings(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error)
+	AddRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error)
+	RemoveRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error)
+}
+
+// UserQuery selects the users whose fields equal the ones set, Attributes
+// included.
+type UserQuery struct {
+	Username   string
+	Email      string
+	FirstName  string
+	LastName   string
+	Enabled    *bool
+	Attributes map[string]string
 }
 
 type userService struct {
-	keycloakClient *keycloakadminclient.APIClient
-	realmName      string
+	realmName string
 }
 
 func NewUserService(realmName string) UserService {
-	return &userService{
-		keycloakClient: GetAdminClient(),
-		realmName:      realmName,
-	}
+	return TraceUserService(&userService{
+		realmName: realmName,
+	}, realmName)
 }
 
-func (u *userService) ListGroups(userId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
-	groups, h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdGroupsGet(context.Background(), u.realmName, userId).
+func (u *userService) ListGroups(ctx context.Context, userId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	groups, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdGroupsGet(ctx, u.realmName, userId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -45,9 +62,9 @@ func (u *userService) ListGroups(userId string) (*[]keycloakadminclient.GroupRep
 	return &groups, statusCode, nil
 }
 
-func (u *userService) GetUserById(userId string) (*keycloakadminclient.UserRepresentation, int, error) {
-	user, h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdGet(context.Background(), u.realmName, userId).
+func (u *userService) GetUserById(ctx context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	user, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdGet(ctx, u.realmName, userId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -59,9 +76,9 @@ func (u *userService) GetUserById(userId string) (*keycloakadminclient.UserRepre
 	return user, statusCode, nil
 }
 
-func (u *userService) GetUserByUsername(username string) (*keycloakadminclient.UserRepresentation, int, error) {
-	users, h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersGet(context.Background(), u.realmName).
+func (u *userService) GetUserByUsername(ctx context.Context, username string) (*keycloakadminclient.UserRepresentation, int, error) {
+	users, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersGet(ctx, u.realmName).
 		Username(username).
 		Execute()
 	if h != nil {
@@ -77,9 +94,9 @@ func (u *userService) GetUserByUsername(username string) (*keycloakadminclient.U
 	return nil, statusCode, nil
 }
 
-func (u *userService) ListUsers() (*[]keycloakadminclient.UserRepresentation, int, error) {
-	users, h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersGet(context.Background(), u.realmName).
+func (u *userService) ListUsers(ctx context.Context) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	users, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersGet(ctx, u.realmName).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -91,9 +108,78 @@ func (u *userService) ListUsers() (*[]keycloakadminclient.UserRepresentation, in
 	return &users, statusCode, nil
 }
 
-func (u *userService) CreateUser(user *keycloakadminclient.UserRepresentation) (string, int, error) {
-	h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersPost(context.Background(), u.realmName).
+// ListUsersPage lists at most max users starting at offset first.
+func (u *userService) ListUsersPage(ctx context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	users, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersGet(ctx, u.realmName).
+		First(first).
+		Max(max).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &users, statusCode, nil
+}
+
+// SearchUsers lists the users query selects, with Keycloak's exact search.
+func (u *userService) SearchUsers(ctx context.Context, query UserQuery) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	request := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersGet(ctx, u.realmName).
+		Exact(true)
+	if query.Username != "" {
+		request = request.Username(query.Username)
+	}
+	if query.Email != "" {
+		request = request.Email(query.Email)
+	}
+	if query.FirstName != "" {
+		request = request.FirstName(query.FirstName)
+	}
+	if query.LastName != "" {
+		request = request.LastName(query.LastName)
+	}
+	if query.Enabled != nil {
+		request = request.Enabled(*query.Enabled)
+	}
+	if len(query.Attributes) > 0 {
+		var q []string
+		for name, value := range query.Attributes {
+			q = append(q, name+":"+value)
+		}
+		request = request.Q(strings.Join(q, " "))
+	}
+	users, h, err := request.Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &users, statusCode, nil
+}
+
+func (u *userService) CountUsers(ctx context.Context) (int32, int, error) {
+	count, h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersCountGet(ctx, u.realmName).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return 0, statusCode, err
+	}
+	return count, statusCode, nil
+}
+
+func (u *userService) CreateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error) {
+	h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersPost(ctx, u.realmName).
 		UserRepresentation(*user).
 		Execute()
 	if h != nil {
@@ -105,10 +191,10 @@ func (u *userService) CreateUser(user *keycloakadminclient.UserRepresentation) (
 	}
 
 	if h == nil {
-		log.Println("http response is nil, but no error occurred.")
+		slog.Error("http response is nil, but no error occurred")
 		return "", 500, fmt.Errorf("http response is nil, but no error occurred")
 	} else if h.StatusCode != 201 {
-		log.Println("Unexpected status code:", h.StatusCode)
+		slog.Error("unexpected status code", "status", h.StatusCode)
 		return "", h.StatusCode, fmt.Errorf("unexpected status code: %d", h.StatusCode)
 	}
 
@@ -117,9 +203,9 @@ func (u *userService) CreateUser(user *keycloakadminclient.UserRepresentation) (
 	return userId, statusCode, nil
 }
 
-func (u *userService) UpdateUser(user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error) {
-	h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdPut(context.Background(), u.realmName, *user.Id).
+func (u *userService) UpdateUser(ctx context.Context, user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error) {
+	h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdPut(ctx, u.realmName, *user.Id).
 		UserRepresentation(*user).
 		Execute()
 	if h != nil {
@@ -132,9 +218,9 @@ func (u *userService) UpdateUser(user *keycloakadminclient.UserRepresentation) (
 	return user, statusCode, nil
 }
 
-func (u *userService) DeleteUser(userId string) (int, error) {
-	h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdDelete(context.Background(), u.realmName, userId).
+func (u *userService) DeleteUser(ctx context.Context, userId string) (int, error) {
+	h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdDelete(ctx, u.realmName, userId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -146,9 +232,9 @@ func (u *userService) DeleteUser(userId string) (int, error) {
 	return statusCode, nil
 }
 
-func (u *userService) JoinGroup(userId string, groupId string) (int, error) {
-	h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdGroupsGroupIdPut(context.Background(), u.realmName, userId, groupId).
+func (u *userService) JoinGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdGroupsGroupIdPut(ctx, u.realmName, userId, groupId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -160,9 +246,9 @@ func (u *userService) JoinGroup(userId string, groupId string) (int, error) {
 	return statusCode, nil
 }
 
-func (u *userService) LeaveGroup(userId string, groupId string) (int, error) {
-	h, err := u.keycloakClient.UsersAPI.
-		AdminRealmsRealmUsersUserIdGroupsGroupIdDelete(context.Background(), u.realmName, userId, groupId).
+func (u *userService) LeaveGroup(ctx context.Context, userId string, groupId string) (int, error) {
+	h, err := GetAdminClient().UsersAPI.
+		AdminRealmsRealmUsersUserIdGroupsGroupIdDelete(ctx, u.realmName, userId, groupId).
 		Execute()
 	if h != nil {
 		defer h.Body.Close()
@@ -173,3 +259,80 @@ func (u *userService) LeaveGroup(userId string, groupId string) (int, error) {
 	}
 	return statusCode, nil
 }
+
+// ListEffectiveRoles lists the realm roles of a user, including the ones
+// inherited from groups and composite roles.
+func (u *userService) ListEffectiveRoles(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	roles, h, err := GetAdminClient().RoleMapperAPI.
+		AdminRealmsRealmUsersUserIdRoleMappingsRealmCompositeGet(ctx, u.realmName, userId).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &roles, statusCode, nil
+}
+
+// ListRoleMappings lists the realm roles directly assigned to a user.
+func (u *userService) ListRoleMappings(ctx context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	roles, h, err := GetAdminClient().RoleMapperAPI.
+		AdminRealmsRealmUsersUserIdRoleMappingsRealmGet(ctx, u.realmName, userId).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	statusCode, err := CheckResponse(h, err)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return &roles, statusCode, nil
+}
+
+// AddRoleMappings assigns realm roles to a user. Keycloak needs both the id
+// and the name of every role.
+func (u *userService) AddRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	h, err := GetAdminClient().RoleMapperAPI.
+		AdminRealmsRealmUsersUserIdRoleMappingsRealmPost(ctx, u.realmName, userId).
+		RoleRepresentation(roles).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	return CheckResponse(h, err)
+}
+
+// RemoveRoleMappings unassigns realm roles from a user.
+func (u *userService) RemoveRoleMappings(ctx context.Context, userId string, roles []keycloakadminclient.RoleRepresentation) (int, error) {
+	h, err := GetAdminClient().RoleMapperAPI.
+		AdminRealmsRealmUsersUserIdRoleMappingsRealmDelete(ctx, u.realmName, userId).
+		RoleRepresentation(roles).
+		Execute()
+	if h != nil {
+		defer h.Body.Close()
+	}
+	return CheckResponse(h, err)
+}
+
+// EachUser pages through all users of the realm, pageSize users per call, and
+// invokes fn for every user until the realm is exhausted or fn returns an error.
+func EachUser(ctx context.Context, service UserService, pageSize int32, fn func(user *keycloakadminclient.UserRepresentation) error) (int, error) {
+	var first int32
+	for {
+		users, statusCode, err := service.ListUsersPage(ctx, first, pageSize)
+		if err != nil {
+			return statusCode, err
+		}
+		for i := range *users {
+			if err := fn(&(*users)[i]); err != nil {
+				return 500, err
+			}
+		}
+		if int32(len(*users)) < pageSize {
+			return 200, nil
+		}
+		first += pageSize
+	}
+}
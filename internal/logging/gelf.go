@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/Graylog2/go-gelf.v2/gelf"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// GelfHandler sends records to Graylog as GELF messages, with the attributes
+// as additional fields. Attributes in groups are named group.key.
+type GelfHandler struct {
+	writer gelf.Writer
+	host   string
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+func NewGelfHandler(writer gelf.Writer, level slog.Leveler) *GelfHandler {
+	host, _ := os.Hostname()
+	return &GelfHandler{writer: writer, host: host, level: level}
+}
+
+func (h *GelfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *GelfHandler) Handle(_ context.Context, record slog.Record) error {
+	extra := map[string]interface{}{}
+	for _, attr := range h.attrs {
+		addField(extra, "", attr)
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		addField(extra, h.prefix, attr)
+		return true
+	})
+	return h.writer.WriteMessage(&gelf.Message{
+		Version:  "1.1",
+		Host:     h.host,
+		Short:    record.Message,
+		TimeUnix: float64(record.Time.UnixNano()) / float64(time.Second),
+		Level:    gelfLevel(record.Level),
+		Extra:    extra,
+	})
+}
+
+func (h *GelfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, attr := range attrs {
+		attr.Key = h.prefix + attr.Key
+		clone.attrs = append(clone.attrs, attr)
+	}
+	return &clone
+}
+
+func (h *GelfHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+func addField(extra map[string]interface{}, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			addField(extra, prefix, member)
+		}
+		return
+	}
+	key := "_" + prefix + attr.Key
+	// _id is reserved by GELF.
+	if key == "_id" {
+		key = "_id_"
+	}
+	switch attr.Value.Kind() {
+	case slog.KindTime:
+		extra[key] = attr.Value.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		extra[key] = attr.Value.Duration().Seconds()
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			extra[key] = err.Error()
+		} else {
+			extra[key] = fmt.Sprint(attr.Value.Any())
+		}
+	default:
+		extra[key] = attr.Value.Any()
+	}
+}
+
+func gelfLevel(level slog.Level) int32 {
+	switch {
+	case level >= slog.LevelError:
+		return gelf.LOG_ERR
+	case level >= slog.LevelWarn:
+		return gelf.LOG_WARNING
+	case level >= slog.LevelInfo:
+		return gelf.LOG_INFO
+	}
+	return gelf.LOG_DEBUG
+}
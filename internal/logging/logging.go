@@ -0,0 +1,192 @@
+// Package logging configures the structured slog logger of the service and
+// hands out request-scoped loggers.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"gopkg.in/Graylog2/go-gelf.v2/gelf"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	FormatJson = "json"
+	FormatText = "text"
+
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputGelf   = "gelf"
+)
+
+// Options configure the outputs and the level of the logger.
+type Options struct {
+	// Level is debug, info, warn or error.
+	Level string `mapstructure:"level"`
+	// Format is json or text. GELF messages are structured regardless.
+	Format string `mapstructure:"format"`
+	// Outputs are any of stderr, file and gelf.
+	Outputs []string    `mapstructure:"outputs"`
+	File    string      `mapstructure:"file"`
+	Gelf    GelfOptions `mapstructure:"gelf"`
+}
+
+type GelfOptions struct {
+	// Protocol is udp or tcp.
+	Protocol string `mapstructure:"protocol"`
+	Address  string `mapstructure:"address"`
+}
+
+// level is shared by all handlers, so it can be changed at runtime.
+var level = new(slog.LevelVar)
+
+// SetLevel changes the level of the logger set up by Setup.
+func SetLevel(name string) error {
+	if name == "" {
+		name = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level %q", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+// Logging is the logger set up from the options and the outputs it writes
+// to.
+type Logging struct {
+	Logger *slog.Logger
+	// Gelf is the GELF writer when gelf is one of the outputs, so the audit
+	// sink can share the connection.
+	Gelf    gelf.Writer
+	closers []io.Closer
+}
+
+// Setup builds the logger and makes it the slog default, which the standard
+// log package writes through as well. A GELF output that cannot be
+// connected is left out with a warning rather than failing the start.
+func Setup(options Options) (*Logging, error) {
+	if err := SetLevel(options.Level); err != nil {
+		return nil, err
+	}
+	if len(options.Outputs) == 0 {
+		options.Outputs = []string{OutputStderr}
+	}
+
+	logging := &Logging{}
+	var handlers []slog.Handler
+	var warnings []string
+	for _, output := range options.Outputs {
+		switch output {
+		case OutputStderr:
+			handler, err := newHandler(os.Stderr, options.Format)
+			if err != nil {
+				return nil, err
+			}
+			handlers = append(handlers, handler)
+		case OutputFile:
+			file, err := os.OpenFile(options.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+			if err != nil {
+				_ = logging.Close()
+				return nil, fmt.Errorf("open log file: %w", err)
+			}
+			logging.closers = append(logging.closers, file)
+			handler, err := newHandler(file, options.Format)
+			if err != nil {
+				_ = logging.Close()
+				return nil, err
+			}
+			handlers = append(handlers, handler)
+		case OutputGelf:
+			writer, err := newGelfWriter(options.Gelf)
+			if err != nil {
+				warnings = append(warnings, err.Error())
+				continue
+			}
+			logging.Gelf = writer
+			logging.closers = append(logging.closers, writer)
+			handlers = append(handlers, NewGelfHandler(writer, level))
+		default:
+			_ = logging.Close()
+			return nil, fmt.Errorf("unknown log output %q", output)
+		}
+	}
+
+	logging.Logger = slog.New(fanout(handlers))
+	slog.SetDefault(logging.Logger)
+	for _, warning := range warnings {
+		logging.Logger.Warn("gelf output disabled", "error", warning)
+	}
+	return logging, nil
+}
+
+// Close closes the file and GELF outputs.
+func (l *Logging) Close() error {
+	var first error
+	for _, closer := range l.closers {
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func newHandler(w io.Writer, format string) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", FormatJson:
+		return slog.NewJSONHandler(w, options), nil
+	case FormatText:
+		return slog.NewTextHandler(w, options), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+func newGelfWriter(options GelfOptions) (gelf.Writer, error) {
+	switch strings.ToLower(options.Protocol) {
+	case "", "udp":
+		writer, err := gelf.NewUDPWriter(options.Address)
+		if err != nil {
+			return nil, fmt.Errorf("connect gelf udp %s: %w", options.Address, err)
+		}
+		return writer, nil
+	case "tcp":
+		writer, err := gelf.NewTCPWriter(options.Address)
+		if err != nil {
+			return nil, fmt.Errorf("connect gelf tcp %s: %w", options.Address, err)
+		}
+		return writer, nil
+	}
+	return nil, fmt.Errorf("unknown gelf protocol %q", options.Protocol)
+}
+
+// Fatal logs an error with the default logger and exits, for failures the
+// service cannot start without.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the request ctx belongs to. Without one,
+// it returns the default logger with the request id ctx carries, if any.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	logger := slog.Default()
+	if id := requestid.FromContext(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	return logger
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// idPattern matches the ids Keycloak puts in paths.
+var idPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Transport instruments the calls made through next, labelled with the
+// operation they invoke.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(request *http.Request) (*http.Response, error) {
+		operation := Operation(request.Method, request.URL.Path)
+		start := time.Now()
+		response, err := next.RoundTrip(request)
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(response.StatusCode)
+		}
+		keycloakDuration.WithLabelValues(operation, status).Observe(time.Since(start).Seconds())
+		if err != nil || response.StatusCode >= http.StatusBadRequest {
+			keycloakErrors.WithLabelValues(operation, status).Inc()
+		}
+		return response, err
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (r roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	return r(request)
+}
+
+// Operation names a call by its method and path template, e.g.
+// "GET /admin/realms/{realm}/users/{id}/groups". Roles are addressed by
+// name as well as by id, "GET /admin/realms/{realm}/roles/{name}".
+func Operation(method string, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments); i++ {
+		switch segment := segments[i]; {
+		case i > 0 && segments[i-1] == "realms":
+			segments[i] = "{realm}"
+		case i > 0 && segments[i-1] == "roles":
+			segments[i] = "{name}"
+		case i > 0 && segments[i-1] == "roles-by-id", idPattern.MatchString(segment):
+			segments[i] = "{id}"
+		case i > 0 && segments[i-1] == "group-by-path":
+			// The group path spans the rest of the segments.
+			segments = append(segments[:i], "{path}")
+		}
+	}
+	// Keycloak may be served under /auth, which is not part of the operation.
+	for len(segments) > 0 && segments[0] != "admin" && segments[0] != "realms" {
+		segments = segments[1:]
+	}
+	return method + " /" + strings.Join(segments, "/")
+}
+
+// KeycloakRetried records that a call is made again after a failure.
+func KeycloakRetried(method string, path string) {
+	keycloakRetries.WithLabelValues(Operation(method, path)).Inc()
+}
+
+// CircuitChanged records whether the calls to Keycloak fail fast.
+func CircuitChanged(open bool) {
+	if open {
+		keycloakCircuitOpen.Set(1)
+		return
+	}
+	keycloakCircuitOpen.Set(0)
+}
+
+// CircuitRejected records a call not made because the circuit was open.
+func CircuitRejected() {
+	keycloakRejected.Inc()
+}
+
+// TokenRefreshed records a token request with the grant type used.
+func TokenRefreshed(grant string, err error, expiry time.Time) {
+	if err != nil {
+		tokenRefreshes.WithLabelValues(grant, "failure").Inc()
+		return
+	}
+	tokenRefreshes.WithLabelValues(grant, "success").Inc()
+	tokenExpiry.Set(float64(expiry.Unix()))
+}
+
+// CacheLookup records a lookup in the cache of Keycloak answers.
+func CacheLookup(namespace string, hit bool) {
+	if hit {
+		cacheLookups.WithLabelValues(namespace, "hit").Inc()
+		return
+	}
+	cacheLookups.WithLabelValues(namespace, "miss").Inc()
+}
+
+// CacheInvalidated records that cached Keycloak answers were dropped.
+func CacheInvalidated(namespace string) {
+	cacheInvalidations.WithLabelValues(namespace).Inc()
+}
@@ -0,0 +1,94 @@
+// Package metrics instruments the HTTP server and the calls to Keycloak for
+// Prometheus.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+// Registry holds the metrics of this service, along with the Go runtime and
+// process metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route template and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	httpInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being handled, by route template.",
+	}, []string{"method", "route"})
+
+	keycloakDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "keycloak_request_duration_seconds",
+		Help:    "Time taken by calls to the Keycloak admin API, by operation and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "status"})
+	keycloakErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_request_errors_total",
+		Help: "Calls to the Keycloak admin API that failed or were answered with an error status, by operation and status.",
+	}, []string{"operation", "status"})
+
+	keycloakRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_request_retries_total",
+		Help: "Calls to the Keycloak admin API made again after a failure, by operation.",
+	}, []string{"operation"})
+	keycloakCircuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "keycloak_circuit_open",
+		Help: "1 while the calls to the Keycloak admin API fail fast, 0 otherwise.",
+	})
+	keycloakRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "keycloak_circuit_rejections_total",
+		Help: "Calls to the Keycloak admin API not made because the circuit was open.",
+	})
+
+	tokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "keycloak_token_refreshes_total",
+		Help: "Admin access tokens requested from Keycloak, by grant type and result.",
+	}, []string{"grant", "result"})
+	tokenExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "keycloak_token_expiry_timestamp_seconds",
+		Help: "Unix time the current admin access token expires at.",
+	})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Requests turned down with 429 because the caller ran out of tokens, by route.",
+	}, []string{"route"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_lookups_total",
+		Help: "Lookups of cached Keycloak answers, by namespace and result, hit or miss.",
+	}, []string{"namespace", "result"})
+	cacheInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_invalidations_total",
+		Help: "Invalidations of cached Keycloak answers, by namespace.",
+	}, []string{"namespace"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		keycloakDuration, keycloakErrors,
+		keycloakRetries, keycloakCircuitOpen, keycloakRejected,
+		tokenRefreshes, tokenExpiry,
+		cacheLookups, cacheInvalidations,
+		rateLimited,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
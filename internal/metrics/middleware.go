@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// unmatchedRoute labels requests that matched no route, so random paths do
+// not each get their own series.
+const unmatchedRoute = "unmatched"
+
+// Middleware records the count, latency and in-flight requests of every
+// route template.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		inFlight := httpInFlight.WithLabelValues(method, route)
+		inFlight.Inc()
+		start := time.Now()
+		defer func() {
+			inFlight.Dec()
+			statusCode := c.Writer.Status()
+			recovered := recover()
+			if recovered != nil {
+				// gin's recovery middleware answers 500 once the panic
+				// reaches it.
+				statusCode = http.StatusInternalServerError
+			}
+			status := strconv.Itoa(statusCode)
+			httpRequests.WithLabelValues(method, route, status).Inc()
+			httpDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// RateLimited records a request turned down by the rate limit of route,
+// e.g. "DELETE /api/v1/users/:id".
+func RateLimited(route string) {
+	rateLimited.WithLabelValues(route).Inc()
+}
@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	"encoding/json"
+	"github.com/miguoliang/arch-go/internal/event"
+	"time"
+)
+
+const (
+	SpecVersion = "1.0"
+	// ContentType is the media type of a CloudEvent in structured mode.
+	ContentType = "application/cloudevents+json"
+)
+
+// CloudEvent is the CloudEvents 1.0 envelope events are published in.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// NewCloudEvent wraps e. The event id is kept, so consumers can use it to
+// drop the duplicates at-least-once delivery implies.
+func NewCloudEvent(e event.Event, source string, typePrefix string) (*CloudEvent, error) {
+	cloudEvent := &CloudEvent{
+		SpecVersion: SpecVersion,
+		Id:          e.Id,
+		Source:      source,
+		Type:        typePrefix + e.Type,
+		Subject:     e.Subject,
+		Time:        e.Time,
+	}
+	if e.Data != nil {
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return nil, err
+		}
+		cloudEvent.DataContentType = "application/json"
+		cloudEvent.Data = data
+	}
+	return cloudEvent, nil
+}
@@ -0,0 +1,58 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/nats-io/nats.go"
+	"strings"
+)
+
+// NATSPublisher publishes structured mode CloudEvents to the subject
+// <subjectPrefix>.<event type>, the event type without the type prefix, e.g.
+// identity.user.created. With JetStream the publish waits for the stream to
+// store the event, otherwise for the server to receive it.
+type NATSPublisher struct {
+	conn          *nats.Conn
+	jetStream     nats.JetStreamContext
+	subjectPrefix string
+	typePrefix    string
+}
+
+func NewNATSPublisher(url string, subjectPrefix string, typePrefix string, jetStream bool) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("arch-go-outbox"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	publisher := &NATSPublisher{conn: conn, subjectPrefix: subjectPrefix, typePrefix: typePrefix}
+	if jetStream {
+		if publisher.jetStream, err = conn.JetStream(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return publisher, nil
+}
+
+func (n *NATSPublisher) Publish(ctx context.Context, event *CloudEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	message := nats.NewMsg(n.subjectPrefix + "." + strings.TrimPrefix(event.Type, n.typePrefix))
+	message.Header.Set("Content-Type", ContentType)
+	// Lets JetStream drop duplicates within its deduplication window.
+	message.Header.Set(nats.MsgIdHdr, event.Id)
+	message.Data = data
+	if n.jetStream != nil {
+		_, err = n.jetStream.PublishMsg(message, nats.Context(ctx))
+		return err
+	}
+	if err := n.conn.PublishMsg(message); err != nil {
+		return err
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *NATSPublisher) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,42 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// Publisher sends events to a message broker. Publish must only return nil
+// once the broker has accepted the event.
+type Publisher interface {
+	Publish(ctx context.Context, event *CloudEvent) error
+	Close() error
+}
+
+// MemoryPublisher keeps the published events, for tests and for running
+// without a broker.
+type MemoryPublisher struct {
+	mutex  sync.Mutex
+	events []CloudEvent
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (m *MemoryPublisher) Publish(_ context.Context, event *CloudEvent) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events = append(m.events, *event)
+	return nil
+}
+
+// Events returns the events published so far.
+func (m *MemoryPublisher) Events() []CloudEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]CloudEvent(nil), m.events...)
+}
+
+func (m *MemoryPublisher) Close() error {
+	return nil
+}
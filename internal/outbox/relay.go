@@ -0,0 +1,159 @@
+// Package outbox publishes the change events to a message broker at least
+// once. An event is appended to the outbox when it is published on the event
+// bus, which is after the Keycloak call that made the change returned; a
+// crash in between loses the event although the change was made.
+package outbox
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type Options struct {
+	// Source is the CloudEvents source attribute of every event.
+	Source string
+	// TypePrefix is prepended to the event type, e.g. com.example.identity.
+	TypePrefix string
+	BatchSize  int
+	// Interval is how often the outbox is polled when nothing wakes the relay.
+	Interval   time.Duration
+	Timeout    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Relay appends the events it handles to the outbox and publishes them in
+// order. An event is only removed from the outbox once the publisher
+// accepted it, so every event is published at least once, also across
+// restarts when the store is persistent.
+type Relay struct {
+	store     Store
+	publisher Publisher
+	options   Options
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	once      sync.Once
+}
+
+func NewRelay(store Store, publisher Publisher, options Options) *Relay {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.Interval <= 0 {
+		options.Interval = 5 * time.Second
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.MaxBackoff < options.Interval {
+		options.MaxBackoff = options.Interval
+	}
+	r := &Relay{
+		store:     store,
+		publisher: publisher,
+		options:   options,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Handle appends e to the outbox. It is meant to be subscribed to the event
+// bus, so the event is stored before the request that caused it completes.
+func (r *Relay) Handle(e event.Event) {
+	cloudEvent, err := NewCloudEvent(e, r.options.Source, r.options.TypePrefix)
+	if err == nil {
+		err = r.store.Append(&Record{Event: *cloudEvent, CreatedAt: time.Now().UTC()})
+	}
+	if err != nil {
+		slog.Error("failed to append event to the outbox", "event", e.Id, "error", err)
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop finishes the publish in progress and closes the publisher. Pending
+// events stay in the outbox.
+func (r *Relay) Stop() {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+		if err := r.publisher.Close(); err != nil {
+			slog.Error("failed to close outbox publisher", "error", err)
+		}
+	})
+}
+
+func (r *Relay) run() {
+	defer close(r.done)
+	delay := r.options.Interval
+	for {
+		if r.flush() {
+			delay = r.options.Interval
+		} else {
+			// Back off while the broker is unavailable.
+			delay *= 2
+			if delay > r.options.MaxBackoff {
+				delay = r.options.MaxBackoff
+			}
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-r.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// flush publishes the pending events until the outbox is empty, and reports
+// false when it stopped at an event that could not be published.
+func (r *Relay) flush() bool {
+	for {
+		records, err := r.store.Pending(r.options.BatchSize)
+		if err != nil {
+			slog.Error("failed to read the outbox", "error", err)
+			return false
+		}
+		if len(records) == 0 {
+			return true
+		}
+		for _, record := range records {
+			select {
+			case <-r.stop:
+				return true
+			default:
+			}
+			if !r.publish(&record) {
+				return false
+			}
+		}
+	}
+}
+
+func (r *Relay) publish(record *Record) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.Timeout)
+	defer cancel()
+	if err := r.publisher.Publish(ctx, &record.Event); err != nil {
+		slog.Warn("failed to publish event", "event", record.Event.Id, "error", err)
+		_ = r.store.Failed(record.Event.Id, err)
+		return false
+	}
+	if err := r.store.Published(record.Event.Id); err != nil {
+		// The event goes out again, which at-least-once allows.
+		slog.Error("failed to mark event as published", "event", record.Event.Id, "error", err)
+		return false
+	}
+	return true
+}
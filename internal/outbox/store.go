@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Record is an event waiting in the outbox to be published.
+type Record struct {
+	Event     CloudEvent `json:"event"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"lastError,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Store keeps the events that have not been published yet, in the order they
+// were appended.
+type Store interface {
+	Append(record *Record) error
+	// Pending returns up to limit of the oldest records.
+	Pending(limit int) ([]Record, error)
+	// Failed records a failed attempt to publish the record with id.
+	Failed(id string, err error) error
+	// Published removes the record with id.
+	Published(id string) error
+}
+
+// compactSize is the size of the log past which it is compacted, when it
+// also doubled since it was compacted last.
+const compactSize = 4 << 20
+
+type memoryStore struct {
+	mutex   sync.Mutex
+	records []Record
+	// log is the file at path every change is appended to, if set.
+	log  *os.File
+	path string
+	// size is the size of the log, compacted its size after the last
+	// compaction.
+	size      int64
+	compacted int64
+}
+
+// NewMemoryStore returns a Store local to this process. Pending events are
+// lost on restart.
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+// logEntry is a line of the file store: either an appended record or the id
+// of a record that was published.
+type logEntry struct {
+	Record    *Record `json:"record,omitempty"`
+	Published string  `json:"published,omitempty"`
+}
+
+// NewFileStore returns a Store that appends every change to a JSON lines
+// file and is rebuilt from it on start, so pending events survive restarts.
+// The file is compacted to the pending records when the store is opened,
+// truncated whenever nothing is pending and compacted when it grew large.
+func NewFileStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	store := &memoryStore{path: path}
+	if err := store.replay(path); err != nil {
+		return nil, fmt.Errorf("read outbox %s: %w", path, err)
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (m *memoryStore) replay(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn last line from a crash, the record was never acknowledged.
+			continue
+		}
+		switch {
+		case entry.Record != nil:
+			m.records = append(m.records, *entry.Record)
+		case entry.Published != "":
+			m.remove(entry.Published)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log with the pending records only; the caller must
+// hold the lock or own the store.
+func (m *memoryStore) compact() error {
+	tmp := m.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for i := range m.records {
+		if err := encoder.Encode(logEntry{Record: &m.records[i]}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(m.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if m.log != nil {
+		m.log.Close()
+	}
+	m.log, m.size, m.compacted = log, info.Size(), info.Size()
+	return nil
+}
+
+// shrink truncates the log when nothing is pending, or compacts it when it
+// grew large; the caller must hold the lock.
+func (m *memoryStore) shrink() error {
+	switch {
+	case m.log == nil:
+		return nil
+	case len(m.records) == 0 && m.size > 0:
+		if err := m.log.Truncate(0); err != nil {
+			return err
+		}
+		m.size, m.compacted = 0, 0
+		return m.log.Sync()
+	case m.size > compactSize && m.size > 2*m.compacted:
+		return m.compact()
+	}
+	return nil
+}
+
+// write appends entry to the log and syncs it; the caller must hold the lock.
+func (m *memoryStore) write(entry logEntry) error {
+	if m.log == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	n, err := m.log.Write(append(data, '\n'))
+	m.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return m.log.Sync()
+}
+
+func (m *memoryStore) Append(record *Record) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.write(logEntry{Record: record}); err != nil {
+		return err
+	}
+	m.records = append(m.records, *record)
+	return nil
+}
+
+func (m *memoryStore) Pending(limit int) ([]Record, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if limit <= 0 || limit > len(m.records) {
+		limit = len(m.records)
+	}
+	return append([]Record(nil), m.records[:limit]...), nil
+}
+
+// Failed only updates the record in memory, attempts are informational and
+// need not survive a restart.
+func (m *memoryStore) Failed(id string, err error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range m.records {
+		if m.records[i].Event.Id == id {
+			m.records[i].Attempts++
+			m.records[i].LastError = err.Error()
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) Published(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.write(logEntry{Published: id}); err != nil {
+		return err
+	}
+	m.remove(id)
+	if err := m.shrink(); err != nil {
+		// The log is still complete, only larger than it needs to be.
+		slog.Error("failed to compact outbox", "path", m.path, "error", err)
+	}
+	return nil
+}
+
+func (m *memoryStore) remove(id string) {
+	for i := range m.records {
+		if m.records[i].Event.Id == id {
+			m.records = append(m.records[:i], m.records[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+)
+
+// UnaryServerInterceptor limits the gRPC calls like Middleware limits the
+// requests, from the same buckets. The route of a call is "GRPC" and the
+// full method, e.g. "GRPC /identity.v1.UserService/DeleteUser", and the
+// headers are the metadata. A call turned down fails with
+// ResourceExhausted, the ratelimit-* and retry-after metadata tell when to
+// retry.
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		header := func(name string) string {
+			if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(name)); len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		taken, ok := l.take(ctx, "GRPC "+info.FullMethod, header, peerIP(ctx))
+		if !ok {
+			return handler(ctx, request)
+		}
+		headers := metadata.MD{}
+		for name, value := range taken.headers() {
+			headers.Set(name, value)
+		}
+		_ = grpc.SetHeader(ctx, headers)
+		if !taken.allowed {
+			return nil, status.Error(codes.ResourceExhausted, taken.message())
+		}
+		return handler(ctx, request)
+	}
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the buckets that filled up again are dropped.
+const sweepInterval = time.Minute
+
+type memoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket holds its capacity again, and can be dropped
+	// as a new one would be the same.
+	full time.Time
+}
+
+// NewMemoryStore returns a Store local to this process.
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+func (m *memoryStore) Take(_ context.Context, key string, capacity float64, rate float64) (float64, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((capacity - b.tokens) / rate * float64(time.Second)))
+	return b.tokens, allowed, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// sweep drops the full buckets; the caller must hold the lock.
+func (m *memoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/auth"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Middleware takes a token from the bucket of the caller for every request,
+// and turns the request down with 429 when there is none left. The
+// RateLimit-Limit, -Remaining, -Reset and -Policy headers tell the callers
+// where they stand. When the store fails the requests are let through, so
+// the limiter does not take the API down with it.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		taken, ok := l.take(c.Request.Context(), route, c.GetHeader, c.ClientIP())
+		if !ok {
+			c.Next()
+			return
+		}
+		for name, value := range taken.headers() {
+			c.Header(name, value)
+		}
+		if !taken.allowed {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, dto.ErrorResponse{Message: taken.message()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// taken is the outcome of taking a token.
+type taken struct {
+	limit   Limit
+	tokens  float64
+	allowed bool
+}
+
+// take takes a token for route from the bucket of the caller of a request
+// with the headers from ip. ok is false when no limit applies or the store
+// failed, and the request is let through.
+func (l *Limiter) take(ctx context.Context, route string, header func(name string) string, ip string) (taken, bool) {
+	options := l.options.Load()
+	if !options.Enabled {
+		return taken{}, false
+	}
+	limit, bucket := options.limit(route)
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return taken{}, false
+	}
+	tokens, allowed, err := l.store.Take(ctx, options.caller(header, ip)+"|"+bucket, limit.capacity(), limit.rate())
+	if err != nil {
+		slog.WarnContext(ctx, "failed to check the rate limit", "error", err)
+		return taken{}, false
+	}
+	if !allowed {
+		metrics.RateLimited(route)
+	}
+	return taken{limit: limit, tokens: tokens, allowed: allowed}, true
+}
+
+// headers tell the caller where it stands, and when to retry once turned
+// down.
+func (t taken) headers() map[string]string {
+	capacity, rate := t.limit.capacity(), t.limit.rate()
+	headers := map[string]string{
+		"RateLimit-Limit":     strconv.Itoa(int(capacity)),
+		"RateLimit-Remaining": strconv.Itoa(int(math.Floor(t.tokens))),
+		"RateLimit-Reset":     seconds((capacity - t.tokens) / rate),
+		"RateLimit-Policy":    t.limit.policy(),
+	}
+	if !t.allowed {
+		headers["Retry-After"] = t.retryAfter()
+	}
+	return headers
+}
+
+func (t taken) retryAfter() string {
+	return seconds((1 - t.tokens) / t.limit.rate())
+}
+
+func (t taken) message() string {
+	return fmt.Sprintf("rate limit exceeded, retry in %s seconds", t.retryAfter())
+}
+
+// caller is who the bucket of a request with the headers from ip belongs
+// to.
+func (o *Options) caller(header func(name string) string, ip string) string {
+	switch o.Key {
+	case KeyHeader:
+		return auth.GatewayCaller(header, o.Header, ip)
+	case KeySubject, KeyClient:
+		claims, ok := auth.BearerClaims(header("Authorization"))
+		switch {
+		case ok && o.Key == KeySubject && claims.Subject != "":
+			return "sub:" + claims.Subject
+		case ok && o.Key == KeyClient && claims.ClientId != "":
+			return "client:" + claims.ClientId
+		}
+	}
+	return "ip:" + ip
+}
+
+// seconds rounds s up to whole seconds.
+func seconds(s float64) string {
+	return strconv.Itoa(int(math.Ceil(s)))
+}
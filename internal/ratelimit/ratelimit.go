@@ -0,0 +1,136 @@
+// Package ratelimit limits how many requests each caller makes, with token
+// buckets kept in memory or in Redis, which the replicas of the service
+// share.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+
+	KeySubject = "subject"
+	KeyClient  = "client"
+	KeyHeader  = "header"
+	KeyIP      = "ip"
+)
+
+type Options struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Key tells the callers apart: by the ip, by Header, or by the token
+	// subject or client id. This service does not verify the tokens, so the
+	// subject and client keys are only safe behind a gateway that does,
+	// otherwise callers can make up subjects to get fresh buckets or to
+	// drain the bucket of someone else. Callers without the header or a
+	// token are told apart by ip.
+	Key string `mapstructure:"key"`
+	// Header is set by the gateway in front to the caller it verified, e.g.
+	// X-Authenticated-User, for the header key.
+	Header string `mapstructure:"header"`
+	// Backend is memory or redis.
+	Backend string `mapstructure:"backend"`
+	Default Limit  `mapstructure:"default"`
+	// Routes override Default for the routes they name, which get buckets
+	// of their own.
+	Routes []RouteLimit       `mapstructure:"routes"`
+	Redis  cache.RedisOptions `mapstructure:"redis"`
+}
+
+// Limit allows Requests per Period on average, and Burst at once.
+type Limit struct {
+	Requests int           `mapstructure:"requests"`
+	Period   time.Duration `mapstructure:"period"`
+	// Burst is Requests when 0.
+	Burst int `mapstructure:"burst"`
+}
+
+type RouteLimit struct {
+	// Route is the method and the path template, e.g.
+	// "DELETE /api/v1/users/:id".
+	Route string `mapstructure:"route"`
+	Limit `mapstructure:",squash"`
+}
+
+func (l Limit) capacity() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate is how many tokens the bucket gains per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// policy describes the limit for the RateLimit-Policy header.
+func (l Limit) policy() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d", l.Requests, int(l.Period.Seconds()), int(l.capacity()))
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket of key, which holds up to capacity
+	// tokens and gains rate of them per second, and returns how many are
+	// left. A new bucket is full.
+	Take(ctx context.Context, key string, capacity float64, rate float64) (tokens float64, allowed bool, err error)
+	Close() error
+}
+
+// Limiter limits the requests with the options last set, the backend is the
+// one it was created with.
+type Limiter struct {
+	store   Store
+	options atomic.Pointer[Options]
+}
+
+// New creates the limiter with the store options.Backend selects.
+func New(options Options) (*Limiter, error) {
+	switch options.Backend {
+	case "", BackendMemory:
+		return NewWithStore(NewMemoryStore(), options), nil
+	case BackendRedis:
+		return NewWithStore(NewRedisStore(options.Redis), options), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", options.Backend)
+	}
+}
+
+func NewWithStore(store Store, options Options) *Limiter {
+	l := &Limiter{store: store}
+	l.SetOptions(options)
+	return l
+}
+
+// SetOptions replaces the limits, e.g. after the configuration was
+// reloaded. The buckets are kept.
+func (l *Limiter) SetOptions(options Options) {
+	l.options.Store(&options)
+}
+
+func (l *Limiter) Close() error {
+	return l.store.Close()
+}
+
+// limit returns the limit of route and the bucket it takes from.
+func (o *Options) limit(route string) (Limit, string) {
+	for _, override := range o.Routes {
+		if sameRoute(override.Route, route) {
+			return override.Limit, route
+		}
+	}
+	return o.Default, "*"
+}
+
+// sameRoute compares "METHOD /path" routes, whatever the spacing and the
+// case of the method.
+func sameRoute(a string, b string) bool {
+	return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+}
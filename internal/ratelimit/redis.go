@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// takeScript refills and takes from a bucket in one step, so the replicas
+// taking from it at once do not overdraw it. The bucket expires once it
+// would be full again.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// redisStore keeps the buckets in Redis, or anything that speaks its
+// protocol and runs Lua scripts.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects lazily. While Redis is down the requests are let
+// through, see Middleware.
+func NewRedisStore(options cache.RedisOptions) Store {
+	return &redisStore{client: cache.NewRedisClient(options), prefix: options.KeyPrefix + "ratelimit:"}
+}
+
+func (r *redisStore) Take(ctx context.Context, key string, capacity float64, rate float64) (float64, bool, error) {
+	result, err := takeScript.Run(ctx, r.client, []string{r.prefix + key},
+		strconv.FormatFloat(capacity, 'f', -1, 64),
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		time.Now().UnixMilli(),
+	).Slice()
+	if err != nil {
+		return 0, false, err
+	}
+	allowed, _ := result[0].(int64)
+	tokens, err := strconv.ParseFloat(result[1].(string), 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return tokens, allowed == 1, nil
+}
+
+func (r *redisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,56 @@
+package requestid
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"regexp"
+)
+
+const (
+	Header     = "X-Request-Id"
+	contextKey = "requestId"
+)
+
+// validId limits accepted ids so arbitrary client input does not end up in
+// logs and audit records.
+var validId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware assigns every request an id, reusing the X-Request-Id header
+// sent by the client or a proxy when it is well-formed, and echoes it in the
+// response.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := Ensure(c.GetHeader(Header))
+		c.Set(contextKey, id)
+		c.Header(Header, id)
+		c.Next()
+	}
+}
+
+// Get returns the id of the request, or an empty string when Middleware did
+// not run.
+func Get(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
+
+// Ensure returns id when it is well-formed, and a new id otherwise.
+func Ensure(id string) string {
+	if !validId.MatchString(id) {
+		return str.NewUUID()
+	}
+	return id
+}
+
+type contextKeyType struct{}
+
+// NewContext returns a copy of ctx that carries the request id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKeyType{}, id)
+}
+
+// FromContext returns the request id carried by ctx, or an empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyType{}).(string)
+	return id
+}
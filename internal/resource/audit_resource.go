@@ -0,0 +1,151 @@
+package resource
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	_ "github.com/lib/pq"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"gopkg.in/Graylog2/go-gelf.v2/gelf"
+	"net/http"
+	"time"
+)
+
+// Audit records the mutations made through the API.
+var Audit *audit.Recorder
+
+// NewAuditRecorder creates the sinks listed in options. The gelf sink reuses
+// gelfWriter when given, and otherwise connects to options.Gelf.
+func NewAuditRecorder(options configs.Audit, gelfWriter gelf.Writer) (*audit.Recorder, error) {
+	var sinks []audit.Sink
+	for _, name := range options.Sinks {
+		switch name {
+		case "file":
+			sink, err := audit.NewFileSink(options.File)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		case "gelf":
+			if gelfWriter == nil {
+				writer, err := gelf.NewUDPWriter(options.Gelf)
+				if err != nil {
+					return nil, fmt.Errorf("connect audit gelf sink: %w", err)
+				}
+				gelfWriter = writer
+			}
+			sinks = append(sinks, audit.NewGelfSink(gelfWriter))
+		case "sql":
+			driver := options.SQL.Driver
+			db, err := sql.Open(driver, options.SQL.DSN)
+			if err != nil {
+				return nil, fmt.Errorf("open audit database: %w", err)
+			}
+			sink, err := audit.NewSQLSink(db, driver)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown audit sink %q", name)
+		}
+	}
+	return audit.NewRecorder(sinks...), nil
+}
+
+// auditResources tells the audit middleware which resource every mutating
+// route changes and how to load it.
+func (h *Handler) auditResources() map[string]audit.Resource {
+	loadUser := func(ctx context.Context, id string) (interface{}, error) {
+		user, _, err := h.Users.GetUserById(ctx, id)
+		return user, err
+	}
+	loadUserGroups := func(ctx context.Context, id string) (interface{}, error) {
+		groups, _, err := h.Users.ListGroups(ctx, id)
+		return groups, err
+	}
+	loadUserRoles := func(ctx context.Context, id string) (interface{}, error) {
+		roles, _, err := h.Users.ListRoleMappings(ctx, id)
+		return roles, err
+	}
+	loadGroup := func(ctx context.Context, id string) (interface{}, error) {
+		group, _, err := h.Groups.GetGroup(ctx, id)
+		return group, err
+	}
+	loadRole := func(ctx context.Context, id string) (interface{}, error) {
+		role, _, err := h.Roles.GetRoleById(ctx, id)
+		return role, err
+	}
+	return map[string]audit.Resource{
+		"/api/v1/users":                     {Type: "user", Load: loadUser},
+		"/api/v1/users/:id":                 {Type: "user", IdParam: "id", Load: loadUser},
+		"/api/v1/users/:id/groups/:groupId": {Type: "user", IdParam: "id", Load: loadUserGroups},
+		"/api/v1/users/:id/roles":           {Type: "user", IdParam: "id", Load: loadUserRoles},
+		"/api/v1/groups":                    {Type: "group", Load: loadGroup},
+		"/api/v1/groups/:id":                {Type: "group", IdParam: "id", Load: loadGroup},
+		"/api/v1/roles":                     {Type: "role", Load: loadRole},
+		"/api/v1/roles/:id":                 {Type: "role", IdParam: "id", Load: loadRole},
+		"/scim/v2/Users":                    {Type: "user", Load: loadUser},
+		"/scim/v2/Users/:id":                {Type: "user", IdParam: "id", Load: loadUser},
+		"/scim/v2/Groups":                   {Type: "group", Load: loadGroup},
+		"/scim/v2/Groups/:id":               {Type: "group", IdParam: "id", Load: loadGroup},
+	}
+}
+
+// ListAuditHandler list audit entries
+// @Summary List audit entries
+// @Description List the mutations made through this API, newest first
+// @Tags audit
+// @Produce json
+// @Param caller query string false "Caller, header:<verified caller> or ip:<address>"
+// @Param resourceType query string false "user, group or role"
+// @Param resourceId query string false "Resource ID"
+// @Param requestId query string false "Request ID"
+// @Param outcome query string false "success or failure"
+// @Param from query string false "From time (RFC 3339)"
+// @Param to query string false "To time (RFC 3339)"
+// @Param first query int false "Offset" default(0)
+// @Param max query int false "Page size" default(100)
+// @Success 200 {array} audit.Entry
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 501 {object} dto.ErrorResponse
+// @Router /audit [get]
+func ListAuditHandler(c *gin.Context) {
+	first, max, err := parsePage(c)
+	if err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	filter := &audit.Filter{
+		Caller:       c.Query("caller"),
+		ResourceType: c.Query("resourceType"),
+		ResourceId:   c.Query("resourceId"),
+		RequestId:    c.Query("requestId"),
+		Outcome:      c.Query("outcome"),
+		First:        int(first),
+		Max:          int(max),
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(400, dto.ErrorResponse{Message: name + " must be an RFC 3339 time"})
+				return
+			}
+			*target = parsed
+		}
+	}
+	entries, err := Audit.Query(filter)
+	if errors.Is(err, audit.ErrNotQueryable) {
+		c.JSON(http.StatusNotImplemented, dto.ErrorResponse{Message: err.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,431 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const maxBatchOperations = 500
+
+// referencePattern matches ${ref.field}, which resolves to field of the
+// response body of the earlier operation named ref.
+var referencePattern = regexp.MustCompile(`\$\{([A-Za-z0-9_-]+)\.([A-Za-z0-9_]+)}`)
+
+// BatchHandler execute operations in batch
+// @Summary Execute operations in batch
+// @Description Executes the operations in order. Params and bodies may reference the response of an earlier operation with ${ref.field}, e.g. ${alice.id}. Each executed operation is audited like the request it stands for.
+// @Tags batch
+// @Accept json
+// @Produce json
+// @Param batch body dto.BatchRequest true "Operations"
+// @Success 200 {object} dto.BatchResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /batch [post]
+func (h *Handler) BatchHandler(c *gin.Context) {
+	var request dto.BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if len(request.Operations) == 0 || len(request.Operations) > maxBatchOperations {
+		c.JSON(400, dto.ErrorResponse{Message: fmt.Sprintf("a batch must contain between 1 and %d operations", maxBatchOperations)})
+		return
+	}
+	refs := map[string]bool{}
+	for i, operation := range request.Operations {
+		if _, ok := batchOperations[operation.Op]; !ok {
+			c.JSON(400, dto.ErrorResponse{Message: fmt.Sprintf("operation %d: unknown op %q", i, operation.Op)})
+			return
+		}
+		if operation.Ref != "" {
+			if refs[operation.Ref] {
+				c.JSON(400, dto.ErrorResponse{Message: fmt.Sprintf("operation %d: duplicate ref %q", i, operation.Ref)})
+				return
+			}
+			refs[operation.Ref] = true
+		}
+	}
+
+	batch := &batchContext{
+		ctx:     c.Request.Context(),
+		users:   h.Users,
+		groups:  h.Groups,
+		roles:   h.Roles,
+		outputs: map[string]map[string]interface{}{},
+	}
+	if Audit != nil {
+		batch.audit = &batchAudit{
+			recorder:    Audit,
+			resources:   h.auditResources(),
+			requestId:   requestid.Get(c),
+			attribution: audit.AttributionOf(c),
+		}
+	}
+	response := dto.BatchResponse{Results: make([]dto.BatchResult, 0, len(request.Operations))}
+	failed := false
+	for i, operation := range request.Operations {
+		result := dto.BatchResult{Ref: operation.Ref, Op: operation.Op}
+		if failed && request.StopOnError {
+			result.Skipped = true
+			response.Results = append(response.Results, result)
+			continue
+		}
+		result.StatusCode, result.Body = batch.execute(i, operation)
+		if result.StatusCode >= 400 {
+			failed = true
+		}
+		response.Results = append(response.Results, result)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+type batchContext struct {
+	ctx     context.Context
+	users   keycloak.UserService
+	groups  keycloak.GroupService
+	roles   keycloak.RoleService
+	outputs map[string]map[string]interface{}
+	audit   *batchAudit
+}
+
+// batchAudit records an entry per operation, as if it were sent as the
+// request in batchRoutes.
+type batchAudit struct {
+	recorder    *audit.Recorder
+	resources   map[string]audit.Resource
+	requestId   string
+	attribution audit.Attribution
+}
+
+// batchRoute is the route an operation stands for, and the param with the
+// id of the changed resource.
+type batchRoute struct {
+	method  string
+	route   string
+	idParam string
+}
+
+var batchRoutes = map[string]batchRoute{
+	"createUser":        {http.MethodPost, "/api/v1/users", ""},
+	"updateUser":        {http.MethodPut, "/api/v1/users/:id", "userId"},
+	"deleteUser":        {http.MethodDelete, "/api/v1/users/:id", "userId"},
+	"joinGroup":         {http.MethodPost, "/api/v1/users/:id/groups/:groupId", "userId"},
+	"leaveGroup":        {http.MethodDelete, "/api/v1/users/:id/groups/:groupId", "userId"},
+	"addRoleMapping":    {http.MethodPost, "/api/v1/users/:id/roles", "userId"},
+	"removeRoleMapping": {http.MethodDelete, "/api/v1/users/:id/roles", "userId"},
+	"createGroup":       {http.MethodPost, "/api/v1/groups", ""},
+	"updateGroup":       {http.MethodPut, "/api/v1/groups/:id", "groupId"},
+	"deleteGroup":       {http.MethodDelete, "/api/v1/groups/:id", "groupId"},
+	"createRole":        {http.MethodPost, "/api/v1/roles", ""},
+	"updateRole":        {http.MethodPut, "/api/v1/roles/:id", "roleId"},
+	"deleteRole":        {http.MethodDelete, "/api/v1/roles/:id", "roleId"},
+}
+
+type batchOperation func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error)
+
+var batchOperations = map[string]batchOperation{
+	"createUser": func(b *batchContext, _ map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var user keycloakadminclient.UserRepresentation
+		if err := json.Unmarshal(body, &user); err != nil {
+			return 400, nil, err
+		}
+		userId, statusCode, err := b.users.CreateUser(b.ctx, &user)
+		if err == nil {
+			user.Id = str.Ptr(userId)
+			publish(event.UserCreated, userId, userEventData(&user))
+		}
+		return statusCode, dto.CreatedResponse{Id: userId}, err
+	},
+	"updateUser": func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var user keycloakadminclient.UserRepresentation
+		if err := json.Unmarshal(body, &user); err != nil {
+			return 400, nil, err
+		}
+		user.Id = str.Ptr(params["userId"])
+		disabling := false
+		if user.Enabled != nil && !*user.Enabled {
+			current, _, err := b.users.GetUserById(b.ctx, *user.Id)
+			disabling = err == nil && current.GetEnabled()
+		}
+		updated, statusCode, err := b.users.UpdateUser(b.ctx, &user)
+		if err == nil {
+			publish(event.UserUpdated, params["userId"], userEventData(updated))
+			if disabling {
+				publish(event.UserDisabled, params["userId"], nil)
+			}
+		}
+		return statusCode, updated, err
+	},
+	"deleteUser": func(b *batchContext, params map[string]string, _ json.RawMessage) (int, interface{}, error) {
+		statusCode, err := b.users.DeleteUser(b.ctx, params["userId"])
+		if err == nil {
+			publish(event.UserDeleted, params["userId"], nil)
+		}
+		return statusCode, nil, err
+	},
+	"joinGroup": func(b *batchContext, params map[string]string, _ json.RawMessage) (int, interface{}, error) {
+		statusCode, err := b.users.JoinGroup(b.ctx, params["userId"], params["groupId"])
+		if err == nil {
+			publish(event.UserJoinedGroup, params["userId"], gin.H{"groupId": params["groupId"]})
+		}
+		return statusCode, nil, err
+	},
+	"leaveGroup": func(b *batchContext, params map[string]string, _ json.RawMessage) (int, interface{}, error) {
+		statusCode, err := b.users.LeaveGroup(b.ctx, params["userId"], params["groupId"])
+		if err == nil {
+			publish(event.UserLeftGroup, params["userId"], gin.H{"groupId": params["groupId"]})
+		}
+		return statusCode, nil, err
+	},
+	"addRoleMapping": func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error) {
+		roles, statusCode, err := b.resolveRoles(body)
+		if err != nil {
+			return statusCode, nil, err
+		}
+		statusCode, err = b.users.AddRoleMappings(b.ctx, params["userId"], roles)
+		if err == nil {
+			publish(event.UserRolesAdded, params["userId"], gin.H{"roles": roleNames(roles)})
+		}
+		return statusCode, nil, err
+	},
+	"removeRoleMapping": func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error) {
+		roles, statusCode, err := b.resolveRoles(body)
+		if err != nil {
+			return statusCode, nil, err
+		}
+		statusCode, err = b.users.RemoveRoleMappings(b.ctx, params["userId"], roles)
+		if err == nil {
+			publish(event.UserRolesRemoved, params["userId"], gin.H{"roles": roleNames(roles)})
+		}
+		return statusCode, nil, err
+	},
+	"createGroup": func(b *batchContext, _ map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var group keycloakadminclient.GroupRepresentation
+		if err := json.Unmarshal(body, &group); err != nil {
+			return 400, nil, err
+		}
+		groupId, statusCode, err := b.groups.CreateGroup(b.ctx, &group)
+		if err == nil {
+			group.Id = &groupId
+			publish(event.GroupCreated, groupId, &group)
+		}
+		return statusCode, dto.CreatedResponse{Id: groupId}, err
+	},
+	"updateGroup": func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var group keycloakadminclient.GroupRepresentation
+		if err := json.Unmarshal(body, &group); err != nil {
+			return 400, nil, err
+		}
+		statusCode, err := b.groups.UpdateGroup(b.ctx, params["groupId"], &group)
+		if err == nil {
+			publish(event.GroupUpdated, params["groupId"], &group)
+		}
+		return statusCode, nil, err
+	},
+	"deleteGroup": func(b *batchContext, params map[string]string, _ json.RawMessage) (int, interface{}, error) {
+		statusCode, err := b.groups.DeleteGroup(b.ctx, params["groupId"])
+		if err == nil {
+			publish(event.GroupDeleted, params["groupId"], nil)
+		}
+		return statusCode, nil, err
+	},
+	"createRole": func(b *batchContext, _ map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var role keycloakadminclient.RoleRepresentation
+		if err := json.Unmarshal(body, &role); err != nil {
+			return 400, nil, err
+		}
+		roleId, statusCode, err := b.roles.CreateRole(b.ctx, &role)
+		if err == nil {
+			role.Id = &roleId
+			publish(event.RoleCreated, roleId, &role)
+		}
+		return statusCode, dto.CreatedResponse{Id: roleId}, err
+	},
+	"updateRole": func(b *batchContext, params map[string]string, body json.RawMessage) (int, interface{}, error) {
+		var role keycloakadminclient.RoleRepresentation
+		if err := json.Unmarshal(body, &role); err != nil {
+			return 400, nil, err
+		}
+		updated, statusCode, err := b.roles.UpdateRole(b.ctx, params["roleId"], &role)
+		if err == nil {
+			publish(event.RoleUpdated, params["roleId"], updated)
+		}
+		return statusCode, updated, err
+	},
+	"deleteRole": func(b *batchContext, params map[string]string, _ json.RawMessage) (int, interface{}, error) {
+		statusCode, err := b.roles.DeleteRole(b.ctx, params["roleId"])
+		if err == nil {
+			publish(event.RoleDeleted, params["roleId"], nil)
+		}
+		return statusCode, nil, err
+	},
+}
+
+// batchParams are the params each operation requires, the ids in the path
+// of its Keycloak call.
+var batchParams = map[string][]string{
+	"updateUser":        {"userId"},
+	"deleteUser":        {"userId"},
+	"joinGroup":         {"userId", "groupId"},
+	"leaveGroup":        {"userId", "groupId"},
+	"addRoleMapping":    {"userId"},
+	"removeRoleMapping": {"userId"},
+	"updateGroup":       {"groupId"},
+	"deleteGroup":       {"groupId"},
+	"updateRole":        {"roleId"},
+	"deleteRole":        {"roleId"},
+}
+
+// execute runs the operation at index and records its output for later
+// references.
+func (b *batchContext) execute(index int, operation dto.BatchOperation) (int, interface{}) {
+	params, body, err := b.resolveReferences(operation)
+	if err != nil {
+		return 400, dto.ErrorResponse{Message: err.Error()}
+	}
+	for _, name := range batchParams[operation.Op] {
+		if params[name] == "" {
+			return 400, dto.ErrorResponse{Message: fmt.Sprintf("param %s is required", name)}
+		}
+	}
+	statusCode, output := b.run(index, operation.Op, params, body)
+	if statusCode >= 400 {
+		return statusCode, output
+	}
+	if operation.Ref != "" && output != nil {
+		var fields map[string]interface{}
+		if data, err := json.Marshal(output); err == nil && json.Unmarshal(data, &fields) == nil {
+			b.outputs[operation.Ref] = fields
+		}
+	}
+	return statusCode, output
+}
+
+// run runs the operation at index with its resolved params and body, and
+// audits it.
+func (b *batchContext) run(index int, op string, params map[string]string, body json.RawMessage) (int, interface{}) {
+	run := func() (int, interface{}) {
+		statusCode, output, err := batchOperations[op](b, params, body)
+		if err != nil {
+			if statusCode < 400 {
+				statusCode = 500
+			}
+			return statusCode, dto.ErrorResponse{Message: err.Error()}
+		}
+		return statusCode, output
+	}
+	if b.audit == nil {
+		return run()
+	}
+
+	route := batchRoutes[op]
+	resource := b.audit.resources[route.route]
+	entry := &audit.Entry{
+		Id:           str.NewUUID(),
+		Time:         time.Now().UTC(),
+		RequestId:    b.audit.requestId,
+		Attribution:  b.audit.attribution,
+		Method:       route.method,
+		Route:        route.route,
+		Path:         fmt.Sprintf("/api/v1/batch#operations[%d].%s", index, op),
+		ResourceType: resource.Type,
+		ResourceId:   params[route.idParam],
+	}
+	var statusCode int
+	var output interface{}
+	b.audit.recorder.Track(b.ctx, entry, resource, func() (int, []byte) {
+		statusCode, output = run()
+		data, _ := json.Marshal(output)
+		return statusCode, data
+	})
+	return statusCode, output
+}
+
+// resolveReferences substitutes ${ref.field} in the params and in the string
+// values of the body.
+func (b *batchContext) resolveReferences(operation dto.BatchOperation) (map[string]string, json.RawMessage, error) {
+	params := make(map[string]string, len(operation.Params))
+	for name, value := range operation.Params {
+		resolved, err := b.resolveString(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("param %s: %w", name, err)
+		}
+		params[name] = resolved
+	}
+	if len(operation.Body) == 0 {
+		return params, operation.Body, nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(operation.Body, &body); err != nil {
+		return nil, nil, fmt.Errorf("body: %w", err)
+	}
+	body, err := b.resolveValue(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("body: %w", err)
+	}
+	data, err := json.Marshal(body)
+	return params, data, err
+}
+
+func (b *batchContext) resolveValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return b.resolveString(v)
+	case []interface{}:
+		for i := range v {
+			resolved, err := b.resolveValue(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = resolved
+		}
+	case map[string]interface{}:
+		for key := range v {
+			resolved, err := b.resolveValue(v[key])
+			if err != nil {
+				return nil, err
+			}
+			v[key] = resolved
+		}
+	}
+	return value, nil
+}
+
+func (b *batchContext) resolveString(value string) (string, error) {
+	var err error
+	resolved := referencePattern.ReplaceAllStringFunc(value, func(match string) string {
+		groups := referencePattern.FindStringSubmatch(match)
+		output, ok := b.outputs[groups[1]]
+		if !ok {
+			err = fmt.Errorf("unknown or failed reference %q", groups[1])
+			return match
+		}
+		field, ok := output[groups[2]]
+		if !ok {
+			err = fmt.Errorf("reference %q has no field %q", groups[1], groups[2])
+			return match
+		}
+		return fmt.Sprint(field)
+	})
+	return resolved, err
+}
+
+func (b *batchContext) resolveRoles(body json.RawMessage) ([]keycloakadminclient.RoleRepresentation, int, error) {
+	var roleNames []string
+	if err := json.Unmarshal(body, &roleNames); err != nil {
+		return nil, 400, err
+	}
+	return resolveRoles(b.ctx, b.roles, roleNames)
+}
@@ -0,0 +1,23 @@
+package resource
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"net/http"
+)
+
+// ConfigWatcher holds the active configuration. SetupRoutes creates one that
+// never reloads when none is set.
+var ConfigWatcher *configs.Watcher
+
+// GetConfigStatusHandler get configuration status
+// @Summary Get configuration status
+// @Description Get the version of the active configuration and why the last reload was rejected, if it was. The version is incremented by every reload that changed the configuration.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} configs.Status
+// @Router /admin/config [get]
+func GetConfigStatusHandler(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, ConfigWatcher.Status())
+}
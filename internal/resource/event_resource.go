@@ -0,0 +1,190 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/keycloakadminclient"
+	"regexp"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// datePattern is the date format Keycloak accepts for event date filters.
+var datePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// ListEventsHandler list login events
+// @Summary List login events
+// @Description List login events of the realm, newest first
+// @Tags event
+// @Accept json
+// @Produce json
+// @Param user query string false "User ID"
+// @Param client query string false "Client ID"
+// @Param type query []string false "Event types" collectionFormat(multi)
+// @Param ipAddress query string false "IP address"
+// @Param dateFrom query string false "From date (yyyy-MM-dd)"
+// @Param dateTo query string false "To date (yyyy-MM-dd)"
+// @Param first query int false "Offset" default(0)
+// @Param max query int false "Page size" default(100)
+// @Success 200 {array} keycloakadminclient.EventRepresentation
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /events [get]
+func (h *Handler) ListEventsHandler(c *gin.Context) {
+	first, max, err := parsePage(c)
+	if err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	dateFrom, dateTo, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	service := h.Events
+	events, statusCode, err := service.ListEvents(c.Request.Context(), &keycloak.EventQuery{
+		User:      c.Query("user"),
+		Client:    c.Query("client"),
+		IpAddress: c.Query("ipAddress"),
+		Types:     c.QueryArray("type"),
+		DateFrom:  dateFrom,
+		DateTo:    dateTo,
+		First:     first,
+		Max:       max,
+	})
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(statusCode, events)
+}
+
+// ListAdminEventsHandler list admin events
+// @Summary List admin events
+// @Description List admin events of the realm, newest first
+// @Tags event
+// @Accept json
+// @Produce json
+// @Param authUser query string false "ID of the user who made the change"
+// @Param authClient query string false "ID of the client the change was made with"
+// @Param authIpAddress query string false "IP address the change was made from"
+// @Param operationType query []string false "CREATE, UPDATE, DELETE or ACTION" collectionFormat(multi)
+// @Param resourceType query []string false "Resource types, e.g. USER or GROUP" collectionFormat(multi)
+// @Param resourcePath query string false "Resource path, * is a wildcard"
+// @Param dateFrom query string false "From date (yyyy-MM-dd)"
+// @Param dateTo query string false "To date (yyyy-MM-dd)"
+// @Param first query int false "Offset" default(0)
+// @Param max query int false "Page size" default(100)
+// @Success 200 {array} keycloakadminclient.AdminEventRepresentation
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /admin-events [get]
+func (h *Handler) ListAdminEventsHandler(c *gin.Context) {
+	first, max, err := parsePage(c)
+	if err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	dateFrom, dateTo, err := parseDateRange(c)
+	if err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	service := h.Events
+	events, statusCode, err := service.ListAdminEvents(c.Request.Context(), &keycloak.AdminEventQuery{
+		AuthUser:       c.Query("authUser"),
+		AuthClient:     c.Query("authClient"),
+		AuthIpAddress:  c.Query("authIpAddress"),
+		OperationTypes: c.QueryArray("operationType"),
+		ResourceTypes:  c.QueryArray("resourceType"),
+		ResourcePath:   c.Query("resourcePath"),
+		DateFrom:       dateFrom,
+		DateTo:         dateTo,
+		First:          first,
+		Max:            max,
+	})
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(statusCode, events)
+}
+
+// GetEventsConfigHandler get event configuration
+// @Summary Get event configuration
+// @Description Get which events the realm records and how long they are kept
+// @Tags event
+// @Accept json
+// @Produce json
+// @Success 200 {object} keycloakadminclient.RealmEventsConfigRepresentation
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /events/config [get]
+func (h *Handler) GetEventsConfigHandler(c *gin.Context) {
+	service := h.Events
+	config, statusCode, err := service.GetEventsConfig(c.Request.Context())
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(statusCode, config)
+}
+
+// UpdateEventsConfigHandler update event configuration
+// @Summary Update event configuration
+// @Description Update which events the realm records (enabledEventTypes) and how long they are kept (eventsExpiration, in seconds)
+// @Tags event
+// @Accept json
+// @Produce json
+// @Param config body keycloakadminclient.RealmEventsConfigRepresentation true "Event configuration"
+// @Success 204
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /events/config [put]
+func (h *Handler) UpdateEventsConfigHandler(c *gin.Context) {
+	var config keycloakadminclient.RealmEventsConfigRepresentation
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if config.EventsExpiration != nil && *config.EventsExpiration < 0 {
+		c.JSON(400, dto.ErrorResponse{Message: "eventsExpiration must not be negative"})
+		return
+	}
+	service := h.Events
+	statusCode, err := service.UpdateEventsConfig(c.Request.Context(), &config)
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.Status(statusCode)
+}
+
+// parsePage reads the first and max query parameters.
+func parsePage(c *gin.Context) (int32, int32, error) {
+	first, err := strconv.Atoi(c.DefaultQuery("first", "0"))
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("first must be a non-negative integer")
+	}
+	max, err := strconv.Atoi(c.DefaultQuery("max", strconv.Itoa(defaultPageSize)))
+	if err != nil || max <= 0 || max > maxPageSize {
+		return 0, 0, fmt.Errorf("max must be between 1 and %d", maxPageSize)
+	}
+	return int32(first), int32(max), nil
+}
+
+// parseDateRange reads the dateFrom and dateTo query parameters.
+func parseDateRange(c *gin.Context) (string, string, error) {
+	dateFrom, dateTo := c.Query("dateFrom"), c.Query("dateTo")
+	for name, value := range map[string]string{"dateFrom": dateFrom, "dateTo": dateTo} {
+		if value != "" && !datePattern.MatchString(value) {
+			return "", "", fmt.Errorf("%s must be formatted as yyyy-MM-dd", name)
+		}
+	}
+	if dateFrom != "" && dateTo != "" && dateFrom > dateTo {
+		return "", "", fmt.Errorf("dateFrom must not be after dateTo")
+	}
+	return dateFrom, dateTo, nil
+}
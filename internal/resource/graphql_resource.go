@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/graph"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"net/http"
+)
+
+// Graph serves the GraphQL schema.
+var Graph *graph.Server
+
+func newGraphServer(deps Dependencies) (*graph.Server, error) {
+	options := deps.Config.Graphql
+	return graph.NewServer(graph.Options{
+		Users:  deps.Users,
+		Groups: deps.Groups,
+		Roles:  deps.Roles,
+		Events: Events,
+		Audit:  Audit,
+		Limits: graph.Limits{
+			MaxDepth:    options.MaxDepth,
+			MaxCost:     options.MaxCost,
+			MaxListSize: options.MaxListSize,
+		},
+	})
+}
+
+// GraphqlHandler execute GraphQL query
+// @Summary Execute GraphQL query
+// @Description Queries users, groups and roles with their relationships, or changes them with mutations. Errors are returned in the errors field of the response, with the status Keycloak answered in their extensions.
+// @Tags graphql
+// @Accept json
+// @Produce json
+// @Param request body graph.Request true "GraphQL request"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /graphql [post]
+func GraphqlHandler(c *gin.Context) {
+	var request graph.Request
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx := requestid.NewContext(c.Request.Context(), requestid.Get(c))
+	ctx = graph.WithCaller(ctx, audit.AttributionOf(c))
+	result := Graph.Execute(ctx, request)
+	// GraphQL answers 200 regardless, the errors Keycloak being unavailable
+	// caused still tell when to try again.
+	for _, err := range result.Errors {
+		if err.Extensions["statusCode"] == http.StatusServiceUnavailable {
+			setRetryAfter(c.Writer.Header())
+			break
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/job"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+)
+
+// UpdateGroupMembersHandler add and remove group members in bulk
+// @Summary Add and remove group members in bulk
+// @Description Starts a job that adds and removes the given users to and from the group
+// @Tags group
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param members body dto.GroupMembersRequest true "Members"
+// @Success 202 {object} job.Record
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /groups/{id}/members [post]
+func (h *Handler) UpdateGroupMembersHandler(c *gin.Context) {
+	var request dto.GroupMembersRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if len(request.Add)+len(request.Remove) == 0 {
+		c.JSON(400, dto.ErrorResponse{Message: "no members to add or remove"})
+		return
+	}
+	submitJob(c, &groupMembersJob{
+		users:   h.Users,
+		groupId: c.Param("id"),
+		request: request,
+	})
+}
+
+type groupMembersJob struct {
+	users   keycloak.UserService
+	groupId string
+	request dto.GroupMembersRequest
+}
+
+func (g *groupMembersJob) Type() string {
+	return "group-members"
+}
+
+func (g *groupMembersJob) Run(ctx context.Context, progress job.Progress) (interface{}, error) {
+	service := g.users
+	total := len(g.request.Add) + len(g.request.Remove)
+	results := make([]dto.GroupMemberResult, 0, total)
+	progress(0, total)
+
+	apply := func(action string, eventType string, userIds []string, fn func(ctx context.Context, userId string, groupId string) (int, error)) error {
+		for _, userId := range userIds {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			statusCode, err := fn(ctx, userId, g.groupId)
+			result := dto.GroupMemberResult{UserId: userId, Action: action, StatusCode: statusCode}
+			if err != nil {
+				result.Message = err.Error()
+			} else {
+				publish(eventType, userId, gin.H{"groupId": g.groupId})
+			}
+			results = append(results, result)
+			progress(len(results), total)
+		}
+		return nil
+	}
+	if err := apply("add", event.UserJoinedGroup, g.request.Add, service.JoinGroup); err != nil {
+		return nil, err
+	}
+	if err := apply("remove", event.UserLeftGroup, g.request.Remove, service.LeaveGroup); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -3,7 +3,7 @@ package resource
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/miguoliang/arch-go/internal/dto"
-	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/event"
 	"github.com/miguoliang/keycloakadminclient"
 	"net/http"
 )
@@ -17,9 +17,9 @@ import (
 // @Success 200 {array} keycloakadminclient.GroupRepresentation
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /groups [get]
-func ListGroupsHandler(c *gin.Context) {
-	service := keycloak.NewGroupService(CustomRealmName)
-	groups, statusCode, err := service.ListGroups()
+func (h *Handler) ListGroupsHandler(c *gin.Context) {
+	service := h.Groups
+	groups, statusCode, err := service.ListGroups(c.Request.Context())
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -37,10 +37,10 @@ func ListGroupsHandler(c *gin.Context) {
 // @Success 200 {object} keycloakadminclient.GroupRepresentation
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /groups/{id} [get]
-func GetGroupHandler(c *gin.Context) {
-	service := keycloak.NewGroupService(CustomRealmName)
+func (h *Handler) GetGroupHandler(c *gin.Context) {
+	service := h.Groups
 	groupId := c.Param("id")
-	group, statusCode, err := service.GetGroup(groupId)
+	group, statusCode, err := service.GetGroup(c.Request.Context(), groupId)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -57,18 +57,20 @@ func GetGroupHandler(c *gin.Context) {
 // @Param group body keycloakadminclient.GroupRepresentation true "Group"
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /groups [post]
-func CreateGroupHandler(c *gin.Context) {
+func (h *Handler) CreateGroupHandler(c *gin.Context) {
 	group := keycloakadminclient.GroupRepresentation{}
 	if err := c.ShouldBindJSON(&group); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
-	service := keycloak.NewGroupService(CustomRealmName)
-	groupId, statusCode, err := service.CreateGroup(&group)
+	service := h.Groups
+	groupId, statusCode, err := service.CreateGroup(c.Request.Context(), &group)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	group.Id = &groupId
+	publish(event.GroupCreated, groupId, &group)
 	c.JSON(statusCode, dto.CreatedResponse{Id: groupId})
 }
 
@@ -83,19 +85,20 @@ func CreateGroupHandler(c *gin.Context) {
 // @Success 200
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /groups/{id} [put]
-func UpdateGroupHandler(c *gin.Context) {
+func (h *Handler) UpdateGroupHandler(c *gin.Context) {
 	group := keycloakadminclient.GroupRepresentation{}
 	if err := c.ShouldBindJSON(&group); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
-	service := keycloak.NewGroupService(CustomRealmName)
+	service := h.Groups
 	groupId := c.Param("id")
-	statusCode, err := service.UpdateGroup(groupId, &group)
+	statusCode, err := service.UpdateGroup(c.Request.Context(), groupId, &group)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.GroupUpdated, groupId, &group)
 	c.Status(statusCode)
 }
 
@@ -109,13 +112,14 @@ func UpdateGroupHandler(c *gin.Context) {
 // @Success 200
 // @Failure 500 {object} dto.ErrorResponse
 // @Router /groups/{id} [delete]
-func DeleteGroupHandler(c *gin.Context) {
-	service := keycloak.NewGroupService(CustomRealmName)
+func (h *Handler) DeleteGroupHandler(c *gin.Context) {
+	service := h.Groups
 	groupId := c.Param("id")
-	statusCode, err := service.DeleteGroup(groupId)
+	statusCode, err := service.DeleteGroup(c.Request.Context(), groupId)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.GroupDeleted, groupId, nil)
 	c.Status(statusCode)
 }
@@ -0,0 +1,80 @@
+package resource
+
+import (
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/logging"
+)
+
+// Handler serves the users, groups, roles and events with the services it
+// holds. The services are shared by all requests, so they can be wrapped
+// with decorators, or replaced by fakes in tests.
+type Handler struct {
+	Users  keycloak.UserService
+	Groups keycloak.GroupService
+	Roles  keycloak.RoleService
+	Events keycloak.EventService
+}
+
+// Dependencies are what SetupRoutes builds the routes with.
+type Dependencies struct {
+	Config *configs.Config
+	// The services left nil are the Keycloak ones of the custom realm.
+	Users  keycloak.UserService
+	Groups keycloak.GroupService
+	Roles  keycloak.RoleService
+	Events keycloak.EventService
+	// Cache keeps the lookups of the services withDefaults creates, nil when
+	// cache.enabled is off.
+	Cache *cache.Cache
+}
+
+// NewDependencies creates the Keycloak services of the custom realm, and
+// the cache in front of them when cache.enabled is on.
+func NewDependencies(cfg *configs.Config) Dependencies {
+	return Dependencies{Config: cfg}.withDefaults()
+}
+
+func (d Dependencies) withDefaults() Dependencies {
+	realmName := d.Config.Keycloak.Custom.Realm
+	options := d.Config.Cache
+	if d.Cache == nil && options.Enabled {
+		c, err := cache.New(options)
+		if err != nil {
+			logging.Fatal("failed to set up cache", "error", err)
+		}
+		d.Cache = c
+	}
+	if d.Users == nil {
+		d.Users = keycloak.NewUserService(realmName)
+		if d.Cache != nil {
+			d.Users = keycloak.CacheUserService(d.Users, d.Cache, options.TTL.Users)
+		}
+	}
+	if d.Groups == nil {
+		d.Groups = keycloak.NewGroupService(realmName)
+		if d.Cache != nil {
+			d.Groups = keycloak.CacheGroupService(d.Groups, d.Cache, options.TTL.Groups)
+		}
+	}
+	if d.Roles == nil {
+		d.Roles = keycloak.NewRoleService(realmName)
+		if d.Cache != nil {
+			d.Roles = keycloak.CacheRoleService(d.Roles, d.Cache, options.TTL.Roles)
+		}
+	}
+	if d.Events == nil {
+		d.Events = keycloak.NewEventService(realmName)
+	}
+	return d
+}
+
+func newHandler(deps Dependencies) *Handler {
+	return &Handler{
+		Users:  deps.Users,
+		Groups: deps.Groups,
+		Roles:  deps.Roles,
+		Events: deps.Events,
+	}
+}
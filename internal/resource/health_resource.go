@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/health"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"net/http"
+)
+
+// Readiness runs the checks behind GET /readyz.
+var Readiness *health.Checker
+
+func newReadinessChecker(options configs.Health) *health.Checker {
+	return health.NewChecker(
+		options.CacheTTL,
+		options.Timeout,
+		health.Check{
+			Name: "keycloak-token",
+			Run: func(ctx context.Context) error {
+				return keycloak.CheckToken(ctx)
+			},
+		},
+		health.Check{
+			Name: "keycloak-realm",
+			Run: func(ctx context.Context) error {
+				statusCode, err := keycloak.CheckRealm(ctx, CustomRealmName)
+				if err != nil {
+					return fmt.Errorf("realm %s: status %d: %w", CustomRealmName, statusCode, err)
+				}
+				return nil
+			},
+		},
+	)
+}
+
+// LivenessHandler liveness probe
+// @Summary Liveness probe
+// @Description Answers 200 while the process serves requests. It checks no dependencies, so Keycloak being down does not get the service restarted.
+// @Tags health
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// ReadinessHandler readiness probe
+// @Summary Readiness probe
+// @Description Checks that the admin token is valid and the custom realm is reachable. Results are reused for health.cache-ttl.
+// @Tags health
+// @Produce json
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report
+// @Router /readyz [get]
+func ReadinessHandler(c *gin.Context) {
+	report := Readiness.Check(c.Request.Context())
+	statusCode := http.StatusOK
+	if report.Status != health.StatusUp {
+		statusCode = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(statusCode, report)
+}
@@ -0,0 +1,132 @@
+package resource
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/job"
+	"net/http"
+)
+
+// Jobs runs the long-running operations started through the API.
+var Jobs *job.Runner
+
+func newJobRunner(options configs.Jobs) (*job.Runner, error) {
+	var store job.Store
+	switch options.Store {
+	case "file":
+		fileStore, err := job.NewFileStore(options.Dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	default:
+		store = job.NewMemoryStore()
+	}
+	return job.NewRunner(store, options.Workers)
+}
+
+// submitJob starts job in the background and answers 202 Accepted with the
+// job record and its status URL.
+func submitJob(c *gin.Context, j job.Job) {
+	record, err := Jobs.Submit(j)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.Header("Location", "/api/v1/jobs/"+record.Id)
+	c.JSON(http.StatusAccepted, record)
+}
+
+// ListJobsHandler list jobs
+// @Summary List jobs
+// @Description List jobs, newest first
+// @Tags job
+// @Produce json
+// @Success 200 {array} job.Record
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /jobs [get]
+func ListJobsHandler(c *gin.Context) {
+	records, err := Jobs.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// GetJobHandler get job status
+// @Summary Get job status
+// @Description Get the state and progress of a job
+// @Tags job
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} job.Record
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /jobs/{id} [get]
+func GetJobHandler(c *gin.Context) {
+	record, err := Jobs.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(jobErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, record)
+}
+
+// GetJobResultHandler get job result
+// @Summary Get job result
+// @Description Get the result of a succeeded job
+// @Tags job
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /jobs/{id}/result [get]
+func GetJobResultHandler(c *gin.Context) {
+	record, err := Jobs.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(jobErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if record.State != job.StateSucceeded {
+		c.JSON(http.StatusConflict, dto.ErrorResponse{Message: "job is " + string(record.State)})
+		return
+	}
+	if record.Result == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", record.Result)
+}
+
+// CancelJobHandler cancel job
+// @Summary Cancel job
+// @Description Cancel a pending or running job
+// @Tags job
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 202 {object} job.Record
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 409 {object} dto.ErrorResponse
+// @Router /jobs/{id} [delete]
+func CancelJobHandler(c *gin.Context) {
+	record, err := Jobs.Cancel(c.Param("id"))
+	if err != nil {
+		c.JSON(jobErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, record)
+}
+
+func jobErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, job.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, job.ErrFinished):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
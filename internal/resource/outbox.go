@@ -0,0 +1,60 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/outbox"
+)
+
+// Outbox publishes identity change events to the message broker, nil when
+// outbox.publisher is none.
+var Outbox *outbox.Relay
+
+func newOutboxRelay(options configs.Outbox) (*outbox.Relay, error) {
+	var publisher outbox.Publisher
+	switch name := options.Publisher; name {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		publisher = outbox.NewMemoryPublisher()
+	case "nats":
+		natsPublisher, err := outbox.NewNATSPublisher(
+			options.Nats.URL,
+			options.Nats.SubjectPrefix,
+			options.TypePrefix,
+			options.Nats.JetStream,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("connect to nats: %w", err)
+		}
+		publisher = natsPublisher
+	case "kafka":
+		publisher = outbox.NewKafkaPublisher(
+			options.Kafka.Brokers,
+			options.Kafka.Topic,
+		)
+	default:
+		return nil, fmt.Errorf("unknown outbox publisher %q", name)
+	}
+
+	var store outbox.Store
+	switch options.Store {
+	case "file":
+		fileStore, err := outbox.NewFileStore(options.File)
+		if err != nil {
+			_ = publisher.Close()
+			return nil, err
+		}
+		store = fileStore
+	default:
+		store = outbox.NewMemoryStore()
+	}
+	return outbox.NewRelay(store, publisher, outbox.Options{
+		Source:     options.Source,
+		TypePrefix: options.TypePrefix,
+		BatchSize:  options.BatchSize,
+		Interval:   options.Interval,
+		Timeout:    options.Timeout,
+		MaxBackoff: options.MaxBackoff,
+	}), nil
+}
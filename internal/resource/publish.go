@@ -0,0 +1,30 @@
+package resource
+
+import (
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/keycloakadminclient"
+)
+
+// Events carries the identity change events published by the handlers.
+var Events = event.NewBus()
+
+func publish(eventType string, subject string, data interface{}) {
+	Events.Publish(event.New(eventType, subject, data))
+}
+
+// userEventData strips what must not leave the service, such as initial
+// passwords, from a user before it is published.
+func userEventData(user *keycloakadminclient.UserRepresentation) *keycloakadminclient.UserRepresentation {
+	copied := *user
+	copied.Credentials = nil
+	return &copied
+}
+
+// roleNames lists the names of roles, for role mapping events.
+func roleNames(roles []keycloakadminclient.RoleRepresentation) []string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.GetName())
+	}
+	return names
+}
@@ -0,0 +1,22 @@
+package resource
+
+import (
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/ratelimit"
+)
+
+// RateLimiter limits the requests of every caller to the API.
+var RateLimiter *ratelimit.Limiter
+
+// newRateLimiter creates the limiter of cfg, which takes the limits of the
+// configurations watcher reloads.
+func newRateLimiter(cfg *configs.Config, watcher *configs.Watcher) (*ratelimit.Limiter, error) {
+	limiter, err := ratelimit.New(cfg.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	watcher.OnChange(func(_ *configs.Config, new *configs.Config) {
+		limiter.SetOptions(new.RateLimit)
+	})
+	return limiter, nil
+}
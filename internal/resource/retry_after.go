@@ -0,0 +1,40 @@
+package resource
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// retryAfterMiddleware tells the clients answered with 503 while the calls
+// to Keycloak fail fast when to try again.
+func retryAfterMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer = &retryAfterWriter{ResponseWriter: c.Writer}
+		c.Next()
+	}
+}
+
+type retryAfterWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *retryAfterWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable {
+		setRetryAfter(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// setRetryAfter sets Retry-After while the calls to Keycloak fail fast,
+// unless the response has one.
+func setRetryAfter(header http.Header) {
+	if header.Get("Retry-After") != "" {
+		return
+	}
+	if wait := keycloak.RetryAfter(); wait > 0 {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
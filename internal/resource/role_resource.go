@@ -3,7 +3,7 @@ package resource
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/miguoliang/arch-go/internal/dto"
-	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/event"
 	"github.com/miguoliang/keycloakadminclient"
 )
 
@@ -18,10 +18,10 @@ import (
 // @Failure 400
 // @Failure 404
 // @Router /roles/{roleId} [get]
-func GetRoleHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
+func (h *Handler) GetRoleHandler(c *gin.Context) {
+	service := h.Roles
 	roleId := c.Param("id")
-	role, statusCode, err := service.GetRoleById(roleId)
+	role, statusCode, err := service.GetRoleById(c.Request.Context(), roleId)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -39,9 +39,9 @@ func GetRoleHandler(c *gin.Context) {
 // @Failure 400
 // @Failure 404
 // @Router /roles [get]
-func ListRolesHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
-	roles, statusCode, err := service.ListRoles()
+func (h *Handler) ListRolesHandler(c *gin.Context) {
+	service := h.Roles
+	roles, statusCode, err := service.ListRoles(c.Request.Context())
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -60,18 +60,20 @@ func ListRolesHandler(c *gin.Context) {
 // @Failure 400
 // @Failure 409
 // @Router /roles [post]
-func CreateRoleHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
+func (h *Handler) CreateRoleHandler(c *gin.Context) {
+	service := h.Roles
 	var role keycloakadminclient.RoleRepresentation
 	if err := c.ShouldBindJSON(&role); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
-	roleId, statusCode, err := service.CreateRole(&role)
+	roleId, statusCode, err := service.CreateRole(c.Request.Context(), &role)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	role.Id = &roleId
+	publish(event.RoleCreated, roleId, &role)
 	c.JSON(statusCode, dto.CreatedResponse{Id: roleId})
 }
 
@@ -86,14 +88,15 @@ func CreateRoleHandler(c *gin.Context) {
 // @Failure 400
 // @Failure 404
 // @Router /roles/{roleId} [delete]
-func DeleteRoleHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
+func (h *Handler) DeleteRoleHandler(c *gin.Context) {
+	service := h.Roles
 	roleId := c.Param("id")
-	statusCode, err := service.DeleteRole(roleId)
+	statusCode, err := service.DeleteRole(c.Request.Context(), roleId)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.RoleDeleted, roleId, nil)
 	c.Status(statusCode)
 }
 
@@ -109,19 +112,20 @@ func DeleteRoleHandler(c *gin.Context) {
 // @Failure 400
 // @Failure 404
 // @Router /roles/{roleId} [put]
-func UpdateRoleHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
+func (h *Handler) UpdateRoleHandler(c *gin.Context) {
+	service := h.Roles
 	var role keycloakadminclient.RoleRepresentation
 	if err := c.ShouldBindJSON(&role); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
 	roleId := c.Param("id")
-	r, statusCode, err := service.UpdateRole(roleId, &role)
+	r, statusCode, err := service.UpdateRole(c.Request.Context(), roleId, &role)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.RoleUpdated, roleId, r)
 	c.JSON(statusCode, r)
 }
 
@@ -136,10 +140,10 @@ func UpdateRoleHandler(c *gin.Context) {
 // @Failure 400
 // @Failure 404
 // @Router /roles/check [get]
-func CheckRoleHandler(c *gin.Context) {
-	service := keycloak.NewRoleService(CustomRealmName)
+func (h *Handler) CheckRoleHandler(c *gin.Context) {
+	service := h.Roles
 	roleName := c.Query("roleName")
-	_, statusCode, err := service.GetRoleByName(roleName)
+	_, statusCode, err := service.GetRoleByName(c.Request.Context(), roleName)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -2,48 +2,240 @@ package resource
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/spf13/viper"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/idempotency"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-var CustomRealmName = viper.GetString("keycloak.custom.realm")
+// CustomRealmName is the realm the API manages, set by SetupRoutes.
+var CustomRealmName string
 
-func SetupRoutes() *gin.Engine {
+// Idempotency keeps the responses replayed for the Idempotency-Key header.
+var Idempotency idempotency.Store
 
-	r := gin.Default()
+// SetupRoutes starts the background workers deps.Config enables and returns
+// the router of the API. Workers already set, e.g. by a test, are kept.
+func SetupRoutes(deps Dependencies) *gin.Engine {
+	cfg := deps.Config
+	CustomRealmName = cfg.Keycloak.Custom.Realm
+	deps = deps.withDefaults()
+	h := newHandler(deps)
+
+	if Jobs == nil {
+		runner, err := newJobRunner(cfg.Jobs)
+		if err != nil {
+			logging.Fatal("failed to start job runner", "error", err)
+		}
+		Jobs = runner
+	}
+	if Webhooks == nil {
+		dispatcher, store, err := newWebhookDispatcher(cfg.Webhooks)
+		if err != nil {
+			logging.Fatal("failed to start webhook dispatcher", "error", err)
+		}
+		Webhooks, webhookStore = dispatcher, store
+		Events.Subscribe(Webhooks.Handle)
+	}
+	if Outbox == nil {
+		relay, err := newOutboxRelay(cfg.Outbox)
+		if err != nil {
+			logging.Fatal("failed to start outbox", "error", err)
+		}
+		if relay != nil {
+			Outbox = relay
+			Events.Subscribe(Outbox.Handle)
+		}
+	}
+	if Stream == nil {
+		Stream, AdminEvents = newStreamBroker(cfg.Events, deps.Events, deps.Cache)
+		Events.Subscribe(Stream.Handle)
+	}
+	if Audit == nil {
+		recorder, err := NewAuditRecorder(cfg.Audit, nil)
+		if err != nil {
+			logging.Fatal("failed to set up audit", "error", err)
+		}
+		Audit = recorder
+	}
+	if ConfigWatcher == nil {
+		ConfigWatcher = configs.NewWatcher(cfg)
+	}
+	if RateLimiter == nil {
+		limiter, err := newRateLimiter(cfg, ConfigWatcher)
+		if err != nil {
+			logging.Fatal("failed to set up rate limiting", "error", err)
+		}
+		RateLimiter = limiter
+	}
+	if Idempotency == nil {
+		store, err := idempotency.New(cfg.Idempotency)
+		if err != nil {
+			logging.Fatal("failed to set up idempotency", "error", err)
+		}
+		Idempotency = store
+	}
+	if Readiness == nil {
+		Readiness = newReadinessChecker(cfg.Health)
+	}
+	if Graph == nil && cfg.Graphql.Enabled {
+		server, err := newGraphServer(deps)
+		if err != nil {
+			logging.Fatal("failed to build graphql schema", "error", err)
+		}
+		Graph = server
+	}
+
+	r := gin.New()
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName))
+	r.Use(requestid.Middleware())
+	r.Use(logging.Middleware())
+	r.Use(logging.Recovery())
+	r.Use(metrics.Middleware())
+
+	// Probes stay outside /api/v1, so they are neither audited nor need a
+	// token at the gateway.
+	r.GET("/healthz", LivenessHandler)
+	r.GET("/readyz", ReadinessHandler)
+
+	// The probes are not limited, the API and the SCIM and GraphQL endpoints
+	// are.
+	rateLimit := RateLimiter.Middleware()
 
 	api := r.Group("/api/v1")
+	api.Use(rateLimit)
+	api.Use(retryAfterMiddleware())
+	api.Use(idempotency.Middleware(Idempotency, cfg.Idempotency))
+	api.Use(audit.Middleware(Audit, h.auditResources(), cfg.Audit.Header))
 
 	api.Group("/users").
-		DELETE("/:id", DeleteUserHandler).
-		DELETE("/:id/groups/:groupId", LeaveGroupHandler).
-		GET("", ListUsersHandler).
-		GET("/:id", GetUserHandler).
-		GET("/:id/groups", ListGroupsByUserHandler).
-		HEAD("", CheckUserHandler).
-		POST("", CreateUserHandler).
-		POST("/:id/groups/:groupId", JoinGroupHandler).
-		PUT("/:id", UpdateUserHandler)
+		DELETE("/:id", h.DeleteUserHandler).
+		DELETE("/:id/groups/:groupId", h.LeaveGroupHandler).
+		DELETE("/:id/roles", h.RemoveRoleMappingsHandler).
+		GET("", h.ListUsersHandler).
+		GET("/:id", h.GetUserHandler).
+		GET("/:id/groups", h.ListGroupsByUserHandler).
+		GET("/:id/roles", h.ListRoleMappingsHandler).
+		GET("/export", h.ExportUsersHandler).
+		HEAD("", h.CheckUserHandler).
+		POST("", h.CreateUserHandler).
+		POST("/:id/groups/:groupId", h.JoinGroupHandler).
+		POST("/:id/roles", h.AddRoleMappingsHandler).
+		PUT("/:id", h.UpdateUserHandler)
 
 	api.Group("/groups").
-		DELETE("/:id", DeleteGroupHandler).
-		GET("", ListGroupsHandler).
-		GET("/:id", GetGroupHandler).
-		POST("", CreateGroupHandler).
-		PUT("/:id", UpdateGroupHandler)
+		DELETE("/:id", h.DeleteGroupHandler).
+		GET("", h.ListGroupsHandler).
+		GET("/:id", h.GetGroupHandler).
+		POST("", h.CreateGroupHandler).
+		POST("/:id/members", h.UpdateGroupMembersHandler).
+		PUT("/:id", h.UpdateGroupHandler)
 
 	api.Group("/roles").
-		DELETE("/:id", DeleteRoleHandler).
-		GET("", ListRolesHandler).
-		GET("/:id", GetRoleHandler).
-		HEAD("", CheckRoleHandler).
-		POST("", CreateRoleHandler).
-		PUT("/:id", UpdateRoleHandler)
+		DELETE("/:id", h.DeleteRoleHandler).
+		GET("", h.ListRolesHandler).
+		GET("/:id", h.GetRoleHandler).
+		HEAD("", h.CheckRoleHandler).
+		POST("", h.CreateRoleHandler).
+		PUT("/:id", h.UpdateRoleHandler)
+
+	api.GET("/audit", ListAuditHandler)
+
+	api.POST("/batch", h.BatchHandler)
+
+	api.Group("/events").
+		GET("", h.ListEventsHandler).
+		GET("/config", h.GetEventsConfigHandler).
+		GET("/stream", StreamEventsHandler).
+		PUT("/config", h.UpdateEventsConfigHandler)
+
+	api.GET("/admin-events", h.ListAdminEventsHandler)
+
+	api.GET("/admin/config", GetConfigStatusHandler)
 
+	api.Group("/webhooks").
+		DELETE("/:id", DeleteWebhookHandler).
+		GET("", ListWebhooksHandler).
+		GET("/:id", GetWebhookHandler).
+		GET("/dead-letters", ListDeadLettersHandler).
+		POST("", CreateWebhookHandler).
+		POST("/dead-letters/:id/redeliver", RedeliverHandler)
+
+	api.Group("/jobs").
+		DELETE("/:id", CancelJobHandler).
+		GET("", ListJobsHandler).
+		GET("/:id", GetJobHandler).
+		GET("/:id/result", GetJobResultHandler)
+
+	// Deprecated: kept for existing clients, probes use /healthz and /readyz.
 	api.POST("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"error": 0,
 		})
 	})
 
+	// Mutations are audited one by one by the resolvers, not per request.
+	if Graph != nil {
+		r.POST("/graphql", rateLimit, audit.Attributor(cfg.Audit.Header), GraphqlHandler)
+	}
+
+	scimRoutes := r.Group("/scim/v2")
+	scimRoutes.Use(rateLimit)
+	scimRoutes.Use(retryAfterMiddleware())
+	scimRoutes.Use(audit.Middleware(Audit, h.auditResources(), cfg.Audit.Header))
+	scimRoutes.
+		GET("/ServiceProviderConfig", ScimServiceProviderConfigHandler).
+		GET("/ResourceTypes", ScimResourceTypesHandler).
+		GET("/ResourceTypes/:name", ScimResourceTypeHandler).
+		GET("/Schemas", ScimSchemasHandler).
+		GET("/Schemas/:id", ScimSchemaHandler)
+
+	scimRoutes.Group("/Users").
+		DELETE("/:id", h.DeleteScimUserHandler).
+		GET("", h.ListScimUsersHandler).
+		GET("/:id", h.GetScimUserHandler).
+		PATCH("/:id", h.PatchScimUserHandler).
+		POST("", h.CreateScimUserHandler).
+		PUT("/:id", h.ReplaceScimUserHandler)
+
+	scimRoutes.Group("/Groups").
+		DELETE("/:id", h.DeleteScimGroupHandler).
+		GET("", h.ListScimGroupsHandler).
+		GET("/:id", h.GetScimGroupHandler).
+		PATCH("/:id", h.PatchScimGroupHandler).
+		POST("", h.CreateScimGroupHandler).
+		PUT("/:id", h.ReplaceScimGroupHandler)
+
 	return r
 }
+
+// StopWorkers stops the background workers SetupRoutes started. It is meant
+// to be called once the servers stopped taking requests, so no work is
+// started behind it.
+func StopWorkers() {
+	if AdminEvents != nil {
+		AdminEvents.Stop()
+	}
+	if Stream != nil {
+		Stream.Close()
+	}
+	// Jobs publish events, so they stop before the event consumers.
+	if Jobs != nil {
+		Jobs.Stop()
+	}
+	if Webhooks != nil {
+		Webhooks.Stop()
+	}
+	if Outbox != nil {
+		Outbox.Stop()
+	}
+	if RateLimiter != nil {
+		_ = RateLimiter.Close()
+	}
+	if Idempotency != nil {
+		_ = Idempotency.Close()
+	}
+}
@@ -0,0 +1,724 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/scim"
+	"github.com/miguoliang/keycloakadminclient"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const scimDefaultCount = 100
+
+// ScimServiceProviderConfigHandler get SCIM service provider configuration
+// @Summary Get SCIM service provider configuration
+// @Tags scim
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /ServiceProviderConfig [get]
+func ScimServiceProviderConfigHandler(c *gin.Context) {
+	scimJSON(c, http.StatusOK, scim.ServiceProviderConfig(scimBaseURL(c)))
+}
+
+// ScimResourceTypesHandler list SCIM resource types
+// @Summary List SCIM resource types
+// @Tags scim
+// @Produce json
+// @Success 200 {object} scim.ListResponse
+// @Router /ResourceTypes [get]
+func ScimResourceTypesHandler(c *gin.Context) {
+	resourceTypes := scim.ResourceTypes(scimBaseURL(c))
+	scimJSON(c, http.StatusOK, scim.NewListResponse(resourceTypes, 1, len(resourceTypes)))
+}
+
+// ScimResourceTypeHandler get SCIM resource type
+// @Summary Get SCIM resource type
+// @Tags scim
+// @Produce json
+// @Param name path string true "User or Group"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} scim.Error
+// @Router /ResourceTypes/{name} [get]
+func ScimResourceTypeHandler(c *gin.Context) {
+	scimFind(c, scim.ResourceTypes(scimBaseURL(c)), c.Param("name"))
+}
+
+// ScimSchemasHandler list SCIM schemas
+// @Summary List SCIM schemas
+// @Tags scim
+// @Produce json
+// @Success 200 {object} scim.ListResponse
+// @Router /Schemas [get]
+func ScimSchemasHandler(c *gin.Context) {
+	schemas := scim.Schemas(scimBaseURL(c))
+	scimJSON(c, http.StatusOK, scim.NewListResponse(schemas, 1, len(schemas)))
+}
+
+// ScimSchemaHandler get SCIM schema
+// @Summary Get SCIM schema
+// @Tags scim
+// @Produce json
+// @Param id path string true "Schema URN"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} scim.Error
+// @Router /Schemas/{id} [get]
+func ScimSchemaHandler(c *gin.Context) {
+	scimFind(c, scim.Schemas(scimBaseURL(c)), c.Param("id"))
+}
+
+// ListScimUsersHandler list SCIM users
+// @Summary List SCIM users
+// @Description List users, optionally filtered, e.g. userName eq "bjensen". Filters comparing userName, externalId, emails, name.givenName, name.familyName or active with eq are searched in Keycloak, other filters scan the users. Groups are only returned when getting a single user.
+// @Tags scim
+// @Produce json
+// @Param filter query string false "Filter expression"
+// @Param startIndex query int false "1-based index of the first result" default(1)
+// @Param count query int false "Page size" default(100)
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Router /Users [get]
+func (h *Handler) ListScimUsersHandler(c *gin.Context) {
+	startIndex, count, filter, scimErr := parseScimQuery(c)
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	ctx := c.Request.Context()
+	service := h.Users
+	baseURL := scimBaseURL(c)
+	var resources []interface{}
+	add := func(user *keycloakadminclient.UserRepresentation) error {
+		resource := scim.UserFromKeycloak(user, nil, baseURL)
+		if matches, err := scimMatches(filter, resource); err != nil || !matches {
+			return err
+		}
+		resources = append(resources, resource)
+		return nil
+	}
+
+	if filter == nil {
+		total, statusCode, err := service.CountUsers(ctx)
+		if err != nil {
+			scimError(c, scimKeycloakError(statusCode, err))
+			return
+		}
+		users := &[]keycloakadminclient.UserRepresentation{}
+		if count > 0 && startIndex <= int(total) {
+			if users, statusCode, err = service.ListUsersPage(ctx, int32(startIndex-1), int32(count)); err != nil {
+				scimError(c, scimKeycloakError(statusCode, err))
+				return
+			}
+		}
+		for i := range *users {
+			_ = add(&(*users)[i])
+		}
+		scimJSON(c, http.StatusOK, scim.NewListPage(resources, startIndex, int(total)))
+		return
+	}
+	if query, ok := scimUserQuery(filter); ok {
+		users, statusCode, err := service.SearchUsers(ctx, query)
+		if err != nil {
+			scimError(c, scimKeycloakError(statusCode, err))
+			return
+		}
+		for i := range *users {
+			_ = add(&(*users)[i])
+		}
+		scimJSON(c, http.StatusOK, scim.NewListResponse(resources, startIndex, count))
+		return
+	}
+	if statusCode, err := keycloak.EachUser(ctx, service, 500, add); err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	scimJSON(c, http.StatusOK, scim.NewListResponse(resources, startIndex, count))
+}
+
+// GetScimUserHandler get SCIM user
+// @Summary Get SCIM user
+// @Tags scim
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.User
+// @Failure 404 {object} scim.Error
+// @Router /Users/{id} [get]
+func (h *Handler) GetScimUserHandler(c *gin.Context) {
+	user, _, scimErr := h.loadScimUser(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+// CreateScimUserHandler create SCIM user
+// @Summary Create SCIM user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param user body scim.User true "User"
+// @Success 201 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /Users [post]
+func (h *Handler) CreateScimUserHandler(c *gin.Context) {
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	if resource.UserName == "" {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "userName is required"))
+		return
+	}
+	var user keycloakadminclient.UserRepresentation
+	resource.ToKeycloak(&user)
+	userId, statusCode, err := h.Users.CreateUser(c.Request.Context(), &user)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	user.Id = &userId
+	publish(event.UserCreated, userId, userEventData(&user))
+
+	created, _, scimErr := h.loadScimUser(c, userId)
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	c.Header("Location", created.Meta.Location)
+	scimJSON(c, http.StatusCreated, created)
+}
+
+// ReplaceScimUserHandler replace SCIM user
+// @Summary Replace SCIM user
+// @Description Replace the attributes of a user. Group memberships are read-only, change them through the groups.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param user body scim.User true "User"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /Users/{id} [put]
+func (h *Handler) ReplaceScimUserHandler(c *gin.Context) {
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	h.updateScimUser(c, &resource)
+}
+
+// PatchScimUserHandler patch SCIM user
+// @Summary Patch SCIM user
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param patch body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /Users/{id} [patch]
+func (h *Handler) PatchScimUserHandler(c *gin.Context) {
+	var request scim.PatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	current, _, scimErr := h.loadScimUser(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	var patched scim.User
+	if scimErr := scimPatch(current, request.Operations, &patched); scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	h.updateScimUser(c, &patched)
+}
+
+// DeleteScimUserHandler delete SCIM user
+// @Summary Delete SCIM user
+// @Tags scim
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} scim.Error
+// @Router /Users/{id} [delete]
+func (h *Handler) DeleteScimUserHandler(c *gin.Context) {
+	userId := c.Param("id")
+	statusCode, err := h.Users.DeleteUser(c.Request.Context(), userId)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	publish(event.UserDeleted, userId, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// ListScimGroupsHandler list SCIM groups
+// @Summary List SCIM groups
+// @Description List top-level groups, optionally filtered, e.g. displayName eq "admins". Members are left out with excludedAttributes=members.
+// @Tags scim
+// @Produce json
+// @Param filter query string false "Filter expression"
+// @Param startIndex query int false "1-based index of the first result" default(1)
+// @Param count query int false "Page size" default(100)
+// @Param excludedAttributes query string false "members to leave out the members"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Router /Groups [get]
+func (h *Handler) ListScimGroupsHandler(c *gin.Context) {
+	startIndex, count, filter, scimErr := parseScimQuery(c)
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	withMembers := !strings.EqualFold(c.Query("excludedAttributes"), "members")
+	groups, statusCode, err := h.Groups.ListGroups(c.Request.Context())
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	// The members are listed only for the groups returned, unless the
+	// filter compares them.
+	filterMembers := scim.Refers(filter, "members")
+	var resources []interface{}
+	matched := map[string]*keycloakadminclient.GroupRepresentation{}
+	for i := range *groups {
+		group := &(*groups)[i]
+		resource, scimErr := h.scimGroup(c, group, filterMembers)
+		if scimErr != nil {
+			scimError(c, scimErr)
+			return
+		}
+		matches, err := scimMatches(filter, resource)
+		if err != nil {
+			scimError(c, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+			return
+		}
+		if matches {
+			resources = append(resources, resource)
+			matched[group.GetId()] = group
+		}
+	}
+	response := scim.NewListResponse(resources, startIndex, count)
+	if withMembers != filterMembers {
+		for i, resource := range response.Resources {
+			if response.Resources[i], scimErr = h.scimGroup(c, matched[resource.(*scim.Group).Id], withMembers); scimErr != nil {
+				scimError(c, scimErr)
+				return
+			}
+		}
+	}
+	scimJSON(c, http.StatusOK, response)
+}
+
+// GetScimGroupHandler get SCIM group
+// @Summary Get SCIM group
+// @Tags scim
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} scim.Group
+// @Failure 404 {object} scim.Error
+// @Router /Groups/{id} [get]
+func (h *Handler) GetScimGroupHandler(c *gin.Context) {
+	group, scimErr := h.loadScimGroup(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	scimJSON(c, http.StatusOK, group)
+}
+
+// CreateScimGroupHandler create SCIM group
+// @Summary Create SCIM group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param group body scim.Group true "Group"
+// @Success 201 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /Groups [post]
+func (h *Handler) CreateScimGroupHandler(c *gin.Context) {
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	if resource.DisplayName == "" {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "displayName is required"))
+		return
+	}
+	var group keycloakadminclient.GroupRepresentation
+	resource.ToKeycloak(&group)
+	groupId, statusCode, err := h.Groups.CreateGroup(c.Request.Context(), &group)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	group.Id = &groupId
+	publish(event.GroupCreated, groupId, &group)
+
+	if scimErr := h.updateScimMembers(c.Request.Context(), groupId, map[string]bool{}, resource.MemberIds()); scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	created, scimErr := h.loadScimGroup(c, groupId)
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	c.Header("Location", created.Meta.Location)
+	scimJSON(c, http.StatusCreated, created)
+}
+
+// ReplaceScimGroupHandler replace SCIM group
+// @Summary Replace SCIM group
+// @Description Replace the name and members of a group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param group body scim.Group true "Group"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /Groups/{id} [put]
+func (h *Handler) ReplaceScimGroupHandler(c *gin.Context) {
+	var resource scim.Group
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	current, scimErr := h.loadScimGroup(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	h.updateScimGroup(c, current, &resource)
+}
+
+// PatchScimGroupHandler patch SCIM group
+// @Summary Patch SCIM group
+// @Description Patch a group; adding and removing members joins and removes the users
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param patch body scim.PatchRequest true "Operations"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /Groups/{id} [patch]
+func (h *Handler) PatchScimGroupHandler(c *gin.Context) {
+	var request scim.PatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return
+	}
+	current, scimErr := h.loadScimGroup(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	var patched scim.Group
+	if scimErr := scimPatch(current, request.Operations, &patched); scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	h.updateScimGroup(c, current, &patched)
+}
+
+// DeleteScimGroupHandler delete SCIM group
+// @Summary Delete SCIM group
+// @Tags scim
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 404 {object} scim.Error
+// @Router /Groups/{id} [delete]
+func (h *Handler) DeleteScimGroupHandler(c *gin.Context) {
+	groupId := c.Param("id")
+	statusCode, err := h.Groups.DeleteGroup(c.Request.Context(), groupId)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	publish(event.GroupDeleted, groupId, nil)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) loadScimUser(c *gin.Context, userId string) (*scim.User, *keycloakadminclient.UserRepresentation, *scim.Error) {
+	service := h.Users
+	user, statusCode, err := service.GetUserById(c.Request.Context(), userId)
+	if err != nil {
+		return nil, nil, scimKeycloakError(statusCode, err)
+	}
+	groups, statusCode, err := service.ListGroups(c.Request.Context(), userId)
+	if err != nil {
+		return nil, nil, scimKeycloakError(statusCode, err)
+	}
+	return scim.UserFromKeycloak(user, *groups, scimBaseURL(c)), user, nil
+}
+
+func (h *Handler) updateScimUser(c *gin.Context, resource *scim.User) {
+	if resource.UserName == "" {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "userName is required"))
+		return
+	}
+	_, user, scimErr := h.loadScimUser(c, c.Param("id"))
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	wasEnabled := user.GetEnabled()
+	resource.ToKeycloak(user)
+	updated, statusCode, err := h.Users.UpdateUser(c.Request.Context(), user)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	publish(event.UserUpdated, user.GetId(), userEventData(updated))
+	if wasEnabled && !user.GetEnabled() {
+		publish(event.UserDisabled, user.GetId(), nil)
+	}
+	result, _, scimErr := h.loadScimUser(c, user.GetId())
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	scimJSON(c, http.StatusOK, result)
+}
+
+func (h *Handler) loadScimGroup(c *gin.Context, groupId string) (*scim.Group, *scim.Error) {
+	group, statusCode, err := h.Groups.GetGroup(c.Request.Context(), groupId)
+	if err != nil {
+		return nil, scimKeycloakError(statusCode, err)
+	}
+	return h.scimGroup(c, group, true)
+}
+
+// scimGroup converts group, with its members if withMembers.
+func (h *Handler) scimGroup(c *gin.Context, group *keycloakadminclient.GroupRepresentation, withMembers bool) (*scim.Group, *scim.Error) {
+	if !withMembers {
+		return scim.GroupFromKeycloak(group, nil, scimBaseURL(c)), nil
+	}
+	members, statusCode, err := h.Groups.ListMembers(c.Request.Context(), group.GetId())
+	if err != nil {
+		return nil, scimKeycloakError(statusCode, err)
+	}
+	return scim.GroupFromKeycloak(group, *members, scimBaseURL(c)), nil
+}
+
+func (h *Handler) updateScimGroup(c *gin.Context, current *scim.Group, resource *scim.Group) {
+	if resource.DisplayName == "" {
+		scimError(c, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "displayName is required"))
+		return
+	}
+	service := h.Groups
+	group, statusCode, err := service.GetGroup(c.Request.Context(), current.Id)
+	if err != nil {
+		scimError(c, scimKeycloakError(statusCode, err))
+		return
+	}
+	if group.GetName() != resource.DisplayName || current.ExternalId != resource.ExternalId {
+		resource.ToKeycloak(group)
+		if statusCode, err := service.UpdateGroup(c.Request.Context(), current.Id, group); err != nil {
+			scimError(c, scimKeycloakError(statusCode, err))
+			return
+		}
+		publish(event.GroupUpdated, current.Id, group)
+	}
+	if scimErr := h.updateScimMembers(c.Request.Context(), current.Id, current.MemberIds(), resource.MemberIds()); scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	result, scimErr := h.loadScimGroup(c, current.Id)
+	if scimErr != nil {
+		scimError(c, scimErr)
+		return
+	}
+	scimJSON(c, http.StatusOK, result)
+}
+
+// updateScimMembers joins the users that are only in wanted to the group and
+// removes the ones that are only in current.
+func (h *Handler) updateScimMembers(ctx context.Context, groupId string, current map[string]bool, wanted map[string]bool) *scim.Error {
+	service := h.Users
+	for userId := range wanted {
+		if current[userId] {
+			continue
+		}
+		if statusCode, err := service.JoinGroup(ctx, userId, groupId); err != nil {
+			return scimKeycloakError(statusCode, err)
+		}
+		publish(event.UserJoinedGroup, userId, gin.H{"groupId": groupId})
+	}
+	for userId := range current {
+		if wanted[userId] {
+			continue
+		}
+		if statusCode, err := service.LeaveGroup(ctx, userId, groupId); err != nil {
+			return scimKeycloakError(statusCode, err)
+		}
+		publish(event.UserLeftGroup, userId, gin.H{"groupId": groupId})
+	}
+	return nil
+}
+
+// scimPatch applies operations to the JSON form of current and decodes the
+// result into patched.
+func scimPatch(current interface{}, operations []scim.PatchOperation, patched interface{}) *scim.Error {
+	resource, err := scimMap(current)
+	if err != nil {
+		return scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	if err := scim.Patch(resource, operations); err != nil {
+		return err.(*scim.Error)
+	}
+	data, err := json.Marshal(resource)
+	if err == nil {
+		err = json.Unmarshal(data, patched)
+	}
+	if err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.InvalidValue, err.Error())
+	}
+	return nil
+}
+
+func parseScimQuery(c *gin.Context) (int, int, scim.Filter, *scim.Error) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		return 0, 0, nil, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "startIndex must be an integer")
+	}
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil {
+		return 0, 0, nil, scim.NewError(http.StatusBadRequest, scim.InvalidValue, "count must be an integer")
+	}
+	if count < 0 {
+		count = 0
+	} else if count > scim.MaxResults {
+		count = scim.MaxResults
+	}
+	var filter scim.Filter
+	if expression := c.Query("filter"); expression != "" {
+		if filter, err = scim.ParseFilter(expression); err != nil {
+			return 0, 0, nil, err.(*scim.Error)
+		}
+	}
+	return startIndex, count, filter, nil
+}
+
+// scimUserQuery translates a filter that only compares userName, externalId,
+// emails, name.givenName, name.familyName or active with eq, e.g.
+// userName eq "bjensen", to a Keycloak search instead of listing every user.
+func scimUserQuery(filter scim.Filter) (keycloak.UserQuery, bool) {
+	var query keycloak.UserQuery
+	equalities, ok := scim.Equalities(filter)
+	if !ok {
+		return query, false
+	}
+	for path, value := range equalities {
+		if path == "active" {
+			active, ok := value.(bool)
+			if !ok {
+				return query, false
+			}
+			query.Enabled = &active
+			continue
+		}
+		text, ok := value.(string)
+		if !ok || text == "" {
+			return query, false
+		}
+		switch path {
+		case "username":
+			query.Username = text
+		case "externalid":
+			query.Attributes = map[string]string{scim.ExternalIdAttribute: text}
+		case "emails", "emails.value":
+			query.Email = text
+		case "name.givenname":
+			query.FirstName = text
+		case "name.familyname":
+			query.LastName = text
+		default:
+			return query, false
+		}
+	}
+	return query, true
+}
+
+func scimMatches(filter scim.Filter, resource interface{}) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	fields, err := scimMap(resource)
+	if err != nil {
+		return false, err
+	}
+	return filter.Matches(fields), nil
+}
+
+func scimMap(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	return fields, json.Unmarshal(data, &fields)
+}
+
+func scimFind(c *gin.Context, resources []interface{}, id string) {
+	for _, resource := range resources {
+		if fields, ok := resource.(map[string]interface{}); ok && fields["id"] == id {
+			scimJSON(c, http.StatusOK, resource)
+			return
+		}
+	}
+	scimError(c, scim.NewError(http.StatusNotFound, "", id+" not found"))
+}
+
+// scimBaseURL is the absolute URL of the SCIM endpoint, for locations and
+// references.
+func scimBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + "/scim/v2"
+}
+
+func scimKeycloakError(statusCode int, err error) *scim.Error {
+	switch {
+	case statusCode == http.StatusConflict:
+		return scim.NewError(statusCode, scim.Uniqueness, err.Error())
+	case statusCode < 400:
+		return scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	return scim.NewError(statusCode, "", err.Error())
+}
+
+func scimError(c *gin.Context, err *scim.Error) {
+	scimJSON(c, err.StatusCode(), err)
+}
+
+func scimJSON(c *gin.Context, statusCode int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(statusCode, body)
+}
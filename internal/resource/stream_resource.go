@@ -0,0 +1,133 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/server"
+	"github.com/miguoliang/arch-go/internal/stream"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Stream keeps the recent identity change events for the SSE stream.
+var Stream *stream.Broker
+
+// AdminEvents feeds the changes made outside of this API into Stream, when
+// events.stream.poll-admin-events is on, and into the cache, when it is
+// enabled. It is nil when neither is.
+var AdminEvents *stream.AdminEventPoller
+
+// streamHeartbeat is how often a comment is sent on an idle stream.
+var streamHeartbeat = 15 * time.Second
+
+// newStreamBroker also polls the admin events to drop from c what they make
+// stale, whether or not they are streamed; c may be nil.
+func newStreamBroker(options configs.Events, events keycloak.EventService, c *cache.Cache) (*stream.Broker, *stream.AdminEventPoller) {
+	if options.Stream.Heartbeat > 0 {
+		streamHeartbeat = options.Stream.Heartbeat
+	}
+	broker := stream.NewBroker(options.Stream.Buffer, options.Stream.DedupWindow)
+	var handlers []event.Handler
+	if c != nil {
+		handlers = append(handlers, keycloak.InvalidateCache(c))
+	}
+	if options.Stream.PollAdminEvents {
+		handlers = append(handlers, broker.HandleExternal)
+	}
+	if len(handlers) == 0 {
+		return broker, nil
+	}
+	handler := func(e event.Event) {
+		for _, h := range handlers {
+			h(e)
+		}
+	}
+	poller := stream.NewAdminEventPoller(
+		events,
+		options.Stream.PollInterval,
+		handler,
+	)
+	return broker, poller
+}
+
+// StreamEventsHandler stream identity change events
+// @Summary Stream identity change events
+// @Description Push user, group and role changes as server-sent events, whether made through this API or elsewhere in Keycloak. Reconnect with the Last-Event-ID header to receive the events missed meanwhile. A comment is sent as heartbeat when there are no events.
+// @Tags event
+// @Produce text/event-stream
+// @Param type query []string false "Event types, or prefixes such as user or user.group" collectionFormat(multi)
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Success 200 {object} event.Event
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /events/stream [get]
+func StreamEventsHandler(c *gin.Context) {
+	types := c.QueryArray("type")
+	for _, t := range types {
+		if !isEventTypeOrPrefix(t) {
+			c.JSON(400, dto.ErrorResponse{Message: fmt.Sprintf("unknown event type %q", t)})
+			return
+		}
+	}
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("lastEventId")
+	}
+	subscription, backlog := Stream.Subscribe(lastEventId, types)
+	defer subscription.Close()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+
+	// The stream stays open for as long as the client listens.
+	server.LiftWriteDeadline(c.Writer)
+	// Set before the first write, which sends the headers; EventSource
+	// rejects any other type.
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Keeps nginx from buffering the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// Tells the client how many milliseconds to wait before reconnecting.
+	_, _ = c.Writer.WriteString("retry: 3000\n\n")
+	for _, entry := range backlog {
+		writeStreamEntry(c, entry)
+	}
+	c.Writer.Flush()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case entry, ok := <-subscription.C:
+			if !ok {
+				// Too far behind, the client resumes from its last event id.
+				return
+			}
+			writeStreamEntry(c, entry)
+		case <-ticker.C:
+			_, _ = c.Writer.WriteString(": heartbeat\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeStreamEntry(c *gin.Context, entry stream.Entry) {
+	c.Render(-1, sse.Event{Id: entry.Id, Event: entry.Event.Type, Data: entry.Event})
+}
+
+func isEventTypeOrPrefix(eventType string) bool {
+	for _, t := range event.Types {
+		if t == eventType || strings.HasPrefix(t, eventType+".") {
+			return true
+		}
+	}
+	return false
+}
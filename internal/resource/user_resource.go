@@ -1,8 +1,11 @@
 package resource
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
 	"github.com/miguoliang/arch-go/internal/keycloak"
 	"github.com/miguoliang/arch-go/pkg/str"
 	"github.com/miguoliang/keycloakadminclient"
@@ -19,10 +22,10 @@ import (
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Router /users/{id} [get]
-func GetUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) GetUserHandler(c *gin.Context) {
+	service := h.Users
 	userID := c.Param("id")
-	user, statusCode, err := service.GetUserById(userID)
+	user, statusCode, err := service.GetUserById(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -41,18 +44,20 @@ func GetUserHandler(c *gin.Context) {
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 409 {object} dto.ErrorResponse
 // @Router /users [post]
-func CreateUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) CreateUserHandler(c *gin.Context) {
+	service := h.Users
 	var user keycloakadminclient.UserRepresentation
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
-	userId, statusCode, err := service.CreateUser(&user)
+	userId, statusCode, err := service.CreateUser(c.Request.Context(), &user)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	user.Id = str.Ptr(userId)
+	publish(event.UserCreated, userId, userEventData(&user))
 	c.JSON(statusCode, dto.CreatedResponse{Id: userId})
 }
 
@@ -68,19 +73,28 @@ func CreateUserHandler(c *gin.Context) {
 // @Failure 400 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Router /users/{id} [put]
-func UpdateUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) UpdateUserHandler(c *gin.Context) {
+	service := h.Users
 	var user keycloakadminclient.UserRepresentation
 	if err := c.ShouldBindJSON(&user); err != nil {
 		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
 	user.Id = str.Ptr(c.Param("id"))
-	u, statusCode, err := service.UpdateUser(&user)
+	disabling := false
+	if user.Enabled != nil && !*user.Enabled {
+		current, _, err := service.GetUserById(c.Request.Context(), *user.Id)
+		disabling = err == nil && current.GetEnabled()
+	}
+	u, statusCode, err := service.UpdateUser(c.Request.Context(), &user)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.UserUpdated, *user.Id, userEventData(u))
+	if disabling {
+		publish(event.UserDisabled, *user.Id, nil)
+	}
 	c.JSON(statusCode, u)
 }
 
@@ -94,14 +108,15 @@ func UpdateUserHandler(c *gin.Context) {
 // @Success 204
 // @Failure 400 {object} dto.ErrorResponse
 // @Router /users/{id} [delete]
-func DeleteUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) DeleteUserHandler(c *gin.Context) {
+	service := h.Users
 	userID := c.Param("id")
-	statusCode, err := service.DeleteUser(userID)
+	statusCode, err := service.DeleteUser(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.UserDeleted, userID, nil)
 	c.Status(statusCode)
 }
 
@@ -114,9 +129,9 @@ func DeleteUserHandler(c *gin.Context) {
 // @Success 200 {array} keycloakadminclient.UserRepresentation
 // @Failure 400 {object} dto.ErrorResponse
 // @Router /users [get]
-func ListUsersHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
-	users, statusCode, err := service.ListUsers()
+func (h *Handler) ListUsersHandler(c *gin.Context) {
+	service := h.Users
+	users, statusCode, err := service.ListUsers(c.Request.Context())
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -135,15 +150,16 @@ func ListUsersHandler(c *gin.Context) {
 // @Success 204
 // @Failure 400 {object} dto.ErrorResponse
 // @Router /users/{id}/groups/{groupId} [post]
-func JoinGroupHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) JoinGroupHandler(c *gin.Context) {
+	service := h.Users
 	userID := c.Param("id")
 	groupID := c.Param("groupId")
-	statusCode, err := service.JoinGroup(userID, groupID)
+	statusCode, err := service.JoinGroup(c.Request.Context(), userID, groupID)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.UserJoinedGroup, userID, gin.H{"groupId": groupID})
 	c.Status(statusCode)
 }
 
@@ -158,15 +174,16 @@ func JoinGroupHandler(c *gin.Context) {
 // @Success 204
 // @Failure 400 {object} dto.ErrorResponse
 // @Router /users/{id}/groups/{groupId} [delete]
-func LeaveGroupHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) LeaveGroupHandler(c *gin.Context) {
+	service := h.Users
 	userID := c.Param("id")
 	groupID := c.Param("groupId")
-	statusCode, err := service.LeaveGroup(userID, groupID)
+	statusCode, err := service.LeaveGroup(c.Request.Context(), userID, groupID)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
 	}
+	publish(event.UserLeftGroup, userID, gin.H{"groupId": groupID})
 	c.Status(statusCode)
 }
 
@@ -179,10 +196,10 @@ func LeaveGroupHandler(c *gin.Context) {
 // @Param id path string true "User ID"
 // @Success 200 {array} keycloakadminclient.GroupRepresentation
 // @Failure 400 {object} dto.ErrorResponse
-func ListGroupsByUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) ListGroupsByUserHandler(c *gin.Context) {
+	service := h.Users
 	userID := c.Param("id")
-	groups, statusCode, err := service.ListGroups(userID)
+	groups, statusCode, err := service.ListGroups(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -200,10 +217,10 @@ func ListGroupsByUserHandler(c *gin.Context) {
 // @Success 200
 // @Failure 404
 // @Router /users [head]
-func CheckUserHandler(c *gin.Context) {
-	service := keycloak.NewUserService(CustomRealmName)
+func (h *Handler) CheckUserHandler(c *gin.Context) {
+	service := h.Users
 	username := c.Query("username")
-	user, statusCode, err := service.GetUserByUsername(username)
+	user, statusCode, err := service.GetUserByUsername(c.Request.Context(), username)
 	if err != nil {
 		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
 		return
@@ -214,3 +231,94 @@ func CheckUserHandler(c *gin.Context) {
 	}
 	c.Status(200)
 }
+
+// ListRoleMappingsHandler list roles of user
+// @Summary List roles of user
+// @Description List the realm roles directly assigned to a user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {array} keycloakadminclient.RoleRepresentation
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /users/{id}/roles [get]
+func (h *Handler) ListRoleMappingsHandler(c *gin.Context) {
+	service := h.Users
+	userID := c.Param("id")
+	roles, statusCode, err := service.ListRoleMappings(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(statusCode, roles)
+}
+
+// AddRoleMappingsHandler assign roles to user
+// @Summary Assign roles to user
+// @Description Assign realm roles, given by name, to a user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param roles body []string true "Role names"
+// @Success 204
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /users/{id}/roles [post]
+func (h *Handler) AddRoleMappingsHandler(c *gin.Context) {
+	h.updateRoleMappings(c, keycloak.UserService.AddRoleMappings, event.UserRolesAdded)
+}
+
+// RemoveRoleMappingsHandler unassign roles from user
+// @Summary Unassign roles from user
+// @Description Unassign realm roles, given by name, from a user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param roles body []string true "Role names"
+// @Success 204
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /users/{id}/roles [delete]
+func (h *Handler) RemoveRoleMappingsHandler(c *gin.Context) {
+	h.updateRoleMappings(c, keycloak.UserService.RemoveRoleMappings, event.UserRolesRemoved)
+}
+
+func (h *Handler) updateRoleMappings(c *gin.Context, update func(keycloak.UserService, context.Context, string, []keycloakadminclient.RoleRepresentation) (int, error), eventType string) {
+	var names []string
+	if err := c.ShouldBindJSON(&names); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	roles, statusCode, err := resolveRoles(c.Request.Context(), h.Roles, names)
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	userID := c.Param("id")
+	statusCode, err = update(h.Users, c.Request.Context(), userID, roles)
+	if err != nil {
+		c.JSON(statusCode, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	publish(eventType, userID, gin.H{"roles": roleNames(roles)})
+	c.Status(statusCode)
+}
+
+// resolveRoles looks up realm roles by name, Keycloak needs their ids to
+// change role mappings.
+func resolveRoles(ctx context.Context, service keycloak.RoleService, roleNames []string) ([]keycloakadminclient.RoleRepresentation, int, error) {
+	if len(roleNames) == 0 {
+		return nil, 400, fmt.Errorf("no role names given")
+	}
+	roles := make([]keycloakadminclient.RoleRepresentation, 0, len(roleNames))
+	for _, roleName := range roleNames {
+		role, statusCode, err := service.GetRoleByName(ctx, roleName)
+		if err != nil {
+			return nil, statusCode, err
+		}
+		roles = append(roles, *role)
+	}
+	return roles, 200, nil
+}
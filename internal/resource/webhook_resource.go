@@ -0,0 +1,222 @@
+package resource
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/webhook"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Webhooks delivers identity change events to the registered subscriptions.
+var Webhooks *webhook.Dispatcher
+
+var webhookStore webhook.Store
+
+func newWebhookDispatcher(options configs.Webhooks) (*webhook.Dispatcher, webhook.Store, error) {
+	var store webhook.Store
+	switch options.Store {
+	case "file":
+		fileStore, err := webhook.NewFileStore(options.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fileStore
+	default:
+		store = webhook.NewMemoryStore()
+	}
+	allowedNetworks, err := webhook.ParseNetworks(options.AllowedNetworks)
+	if err != nil {
+		return nil, nil, err
+	}
+	dispatcher := webhook.NewDispatcher(store, webhook.Options{
+		Workers:         options.Workers,
+		MaxAttempts:     options.MaxAttempts,
+		InitialBackoff:  options.InitialBackoff,
+		MaxBackoff:      options.MaxBackoff,
+		Timeout:         options.Timeout,
+		QueueSize:       options.QueueSize,
+		AllowedNetworks: allowedNetworks,
+	})
+	return dispatcher, store, nil
+}
+
+// CreateWebhookHandler create webhook subscription
+// @Summary Create webhook subscription
+// @Description Subscribe a URL to identity change events. Use "*" to receive every event type. A secret is generated when none is given; it is only returned by this call.
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param webhook body dto.WebhookRequest true "Subscription"
+// @Success 201 {object} webhook.Subscription
+// @Failure 400 {object} dto.ErrorResponse
+// @Router /webhooks [post]
+func CreateWebhookHandler(c *gin.Context) {
+	var request dto.WebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if err := validateWebhook(c.Request.Context(), &request); err != nil {
+		c.JSON(400, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if request.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+			return
+		}
+		request.Secret = hex.EncodeToString(secret)
+	}
+	subscription := &webhook.Subscription{
+		Id:         str.NewUUID(),
+		URL:        request.URL,
+		EventTypes: request.EventTypes,
+		Secret:     request.Secret,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := webhookStore.SaveSubscription(subscription); err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.Header("Location", "/api/v1/webhooks/"+subscription.Id)
+	c.JSON(http.StatusCreated, subscription)
+}
+
+// ListWebhooksHandler list webhook subscriptions
+// @Summary List webhook subscriptions
+// @Description List webhook subscriptions, without their secrets
+// @Tags webhook
+// @Produce json
+// @Success 200 {array} webhook.Subscription
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /webhooks [get]
+func ListWebhooksHandler(c *gin.Context) {
+	subscriptions, err := webhookStore.ListSubscriptions()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	for i := range subscriptions {
+		subscriptions[i] = subscriptions[i].Redacted()
+	}
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// GetWebhookHandler get webhook subscription
+// @Summary Get webhook subscription
+// @Description Get a webhook subscription, without its secret
+// @Tags webhook
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} webhook.Subscription
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /webhooks/{id} [get]
+func GetWebhookHandler(c *gin.Context) {
+	subscription, err := webhookStore.GetSubscription(c.Param("id"))
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, subscription.Redacted())
+}
+
+// DeleteWebhookHandler delete webhook subscription
+// @Summary Delete webhook subscription
+// @Description Delete a webhook subscription, pending deliveries are dropped
+// @Tags webhook
+// @Param id path string true "Subscription ID"
+// @Success 204
+// @Failure 404 {object} dto.ErrorResponse
+// @Router /webhooks/{id} [delete]
+func DeleteWebhookHandler(c *gin.Context) {
+	if err := webhookStore.DeleteSubscription(c.Param("id")); err != nil {
+		c.JSON(webhookErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeadLettersHandler list undeliverable webhook events
+// @Summary List undeliverable webhook events
+// @Description List the deliveries that ran out of attempts
+// @Tags webhook
+// @Produce json
+// @Success 200 {array} webhook.Delivery
+// @Failure 500 {object} dto.ErrorResponse
+// @Router /webhooks/dead-letters [get]
+func ListDeadLettersHandler(c *gin.Context) {
+	deliveries, err := webhookStore.ListDeadLetters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverHandler redeliver webhook event
+// @Summary Redeliver webhook event
+// @Description Queue a dead letter for delivery again, with a fresh set of attempts
+// @Tags webhook
+// @Produce json
+// @Param id path string true "Delivery ID"
+// @Success 202 {object} webhook.Delivery
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 503 {object} dto.ErrorResponse
+// @Router /webhooks/dead-letters/{id}/redeliver [post]
+func RedeliverHandler(c *gin.Context) {
+	delivery, err := Webhooks.Redeliver(c.Param("id"))
+	if err != nil {
+		c.JSON(webhookErrorStatus(err), dto.ErrorResponse{Message: err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func validateWebhook(ctx context.Context, request *dto.WebhookRequest) error {
+	target, err := url.Parse(request.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if err := Webhooks.CheckURL(ctx, request.URL); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if len(request.EventTypes) == 0 {
+		return fmt.Errorf("eventTypes must not be empty")
+	}
+	for _, eventType := range request.EventTypes {
+		if eventType != webhook.AllEvents && !isEventType(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+func isEventType(eventType string) bool {
+	for _, t := range event.Types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookErrorStatus(err error) int {
+	if errors.Is(err, webhook.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, webhook.ErrQueueFull) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"github.com/miguoliang/keycloakadminclient"
+)
+
+func toUser(user *keycloakadminclient.UserRepresentation) *identityv1.User {
+	var attributes map[string][]string
+	if user.Attributes != nil {
+		attributes = *user.Attributes
+	}
+	return &identityv1.User{
+		Id:               user.GetId(),
+		Username:         user.GetUsername(),
+		Email:            user.GetEmail(),
+		FirstName:        user.GetFirstName(),
+		LastName:         user.GetLastName(),
+		Enabled:          user.GetEnabled(),
+		EmailVerified:    user.GetEmailVerified(),
+		CreatedTimestamp: user.GetCreatedTimestamp(),
+		Attributes:       toValues(attributes),
+	}
+}
+
+func toUsers(users []keycloakadminclient.UserRepresentation) []*identityv1.User {
+	converted := make([]*identityv1.User, 0, len(users))
+	for i := range users {
+		converted = append(converted, toUser(&users[i]))
+	}
+	return converted
+}
+
+func fromUser(user *identityv1.User) *keycloakadminclient.UserRepresentation {
+	representation := &keycloakadminclient.UserRepresentation{
+		Username:      keycloakadminclient.PtrString(user.GetUsername()),
+		Email:         keycloakadminclient.PtrString(user.GetEmail()),
+		FirstName:     keycloakadminclient.PtrString(user.GetFirstName()),
+		LastName:      keycloakadminclient.PtrString(user.GetLastName()),
+		Enabled:       keycloakadminclient.PtrBool(user.GetEnabled()),
+		EmailVerified: keycloakadminclient.PtrBool(user.GetEmailVerified()),
+	}
+	if user.GetId() != "" {
+		representation.Id = keycloakadminclient.PtrString(user.GetId())
+	}
+	if attributes := fromValues(user.GetAttributes()); attributes != nil {
+		representation.Attributes = &attributes
+	}
+	return representation
+}
+
+func toGroup(group *keycloakadminclient.GroupRepresentation) *identityv1.Group {
+	var attributes map[string][]string
+	if group.Attributes != nil {
+		attributes = *group.Attributes
+	}
+	converted := &identityv1.Group{
+		Id:         group.GetId(),
+		Name:       group.GetName(),
+		Path:       group.GetPath(),
+		Attributes: toValues(attributes),
+	}
+	converted.SubGroups = toGroups(group.SubGroups)
+	return converted
+}
+
+func toGroups(groups []keycloakadminclient.GroupRepresentation) []*identityv1.Group {
+	converted := make([]*identityv1.Group, 0, len(groups))
+	for i := range groups {
+		converted = append(converted, toGroup(&groups[i]))
+	}
+	return converted
+}
+
+func fromGroup(group *identityv1.Group) *keycloakadminclient.GroupRepresentation {
+	representation := &keycloakadminclient.GroupRepresentation{
+		Name: keycloakadminclient.PtrString(group.GetName()),
+	}
+	if group.GetId() != "" {
+		representation.Id = keycloakadminclient.PtrString(group.GetId())
+	}
+	if attributes := fromValues(group.GetAttributes()); attributes != nil {
+		representation.Attributes = &attributes
+	}
+	return representation
+}
+
+func toRole(role *keycloakadminclient.RoleRepresentation) *identityv1.Role {
+	var attributes map[string][]string
+	if role.Attributes != nil {
+		attributes = *role.Attributes
+	}
+	return &identityv1.Role{
+		Id:          role.GetId(),
+		Name:        role.GetName(),
+		Description: role.GetDescription(),
+		Composite:   role.GetComposite(),
+		ClientRole:  role.GetClientRole(),
+		ContainerId: role.GetContainerId(),
+		Attributes:  toValues(attributes),
+	}
+}
+
+func toRoles(roles []keycloakadminclient.RoleRepresentation) []*identityv1.Role {
+	converted := make([]*identityv1.Role, 0, len(roles))
+	for i := range roles {
+		converted = append(converted, toRole(&roles[i]))
+	}
+	return converted
+}
+
+func fromRole(role *identityv1.Role) *keycloakadminclient.RoleRepresentation {
+	representation := &keycloakadminclient.RoleRepresentation{
+		Name:        keycloakadminclient.PtrString(role.GetName()),
+		Description: keycloakadminclient.PtrString(role.GetDescription()),
+	}
+	if role.GetId() != "" {
+		representation.Id = keycloakadminclient.PtrString(role.GetId())
+	}
+	if attributes := fromValues(role.GetAttributes()); attributes != nil {
+		representation.Attributes = &attributes
+	}
+	return representation
+}
+
+func toValues(attributes map[string][]string) map[string]*identityv1.Values {
+	if len(attributes) == 0 {
+		return nil
+	}
+	converted := make(map[string]*identityv1.Values, len(attributes))
+	for name, values := range attributes {
+		converted[name] = &identityv1.Values{Values: values}
+	}
+	return converted
+}
+
+func fromValues(attributes map[string]*identityv1.Values) map[string][]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+	converted := make(map[string][]string, len(attributes))
+	for name, values := range attributes {
+		converted[name] = values.GetValues()
+	}
+	return converted
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type groupServer struct {
+	identityv1.UnimplementedGroupServiceServer
+	options *Options
+}
+
+func (g *groupServer) GetGroup(ctx context.Context, request *identityv1.GetGroupRequest) (*identityv1.Group, error) {
+	group, statusCode, err := g.options.Groups.GetGroup(ctx, request.GetId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return toGroup(group), nil
+}
+
+func (g *groupServer) ListGroups(ctx context.Context, _ *identityv1.ListGroupsRequest) (*identityv1.ListGroupsResponse, error) {
+	groups, statusCode, err := g.options.Groups.ListGroups(ctx)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListGroupsResponse{Groups: toGroups(*groups)}, nil
+}
+
+func (g *groupServer) CreateGroup(ctx context.Context, request *identityv1.CreateGroupRequest) (*identityv1.Group, error) {
+	if request.GetGroup().GetName() == "" {
+		return nil, invalidArgument("group.name is required")
+	}
+	group := fromGroup(request.GetGroup())
+	group.Id = nil
+	service := g.options.Groups
+	groupId, statusCode, err := service.CreateGroup(ctx, group)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	created, statusCode, err := service.GetGroup(ctx, groupId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	g.options.publish(event.GroupCreated, groupId, created)
+	return toGroup(created), nil
+}
+
+func (g *groupServer) UpdateGroup(ctx context.Context, request *identityv1.UpdateGroupRequest) (*identityv1.Group, error) {
+	groupId := request.GetGroup().GetId()
+	if groupId == "" {
+		return nil, invalidArgument("group.id is required")
+	}
+	fields, err := maskedFields(request.GetUpdateMask(), request.GetGroup(), groupFields)
+	if err != nil {
+		return nil, err
+	}
+	service := g.options.Groups
+	current, statusCode, err := service.GetGroup(ctx, groupId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	applyGroup(current, request.GetGroup(), fields)
+	if statusCode, err := service.UpdateGroup(ctx, groupId, current); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	updated, statusCode, err := service.GetGroup(ctx, groupId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	g.options.publish(event.GroupUpdated, groupId, updated)
+	return toGroup(updated), nil
+}
+
+func (g *groupServer) DeleteGroup(ctx context.Context, request *identityv1.DeleteGroupRequest) (*emptypb.Empty, error) {
+	if statusCode, err := g.options.Groups.DeleteGroup(ctx, request.GetId()); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	g.options.publish(event.GroupDeleted, request.GetId(), nil)
+	return &emptypb.Empty{}, nil
+}
+
+func (g *groupServer) ListMembers(ctx context.Context, request *identityv1.ListMembersRequest) (*identityv1.ListUsersResponse, error) {
+	members, statusCode, err := g.options.Groups.ListMembers(ctx, request.GetGroupId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListUsersResponse{Users: toUsers(*members)}, nil
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// requestIdMetadata is the metadata key of the request id, the gRPC
+// counterpart of the X-Request-Id header.
+var requestIdMetadata = strings.ToLower(requestid.Header)
+
+// RecoveryInterceptor turns a panic in a handler into an Internal error, as
+// gin's recovery middleware turns it into a 500.
+func RecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				logging.FromContext(ctx).Error("panic serving call",
+					"method", info.FullMethod,
+					"panic", recovered,
+					"stack", string(debug.Stack()))
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, request)
+	}
+}
+
+// RequestIdInterceptor assigns every call a request id like
+// requestid.Middleware does, reusing the x-request-id metadata sent by the
+// client, and returns it in the response header.
+func RequestIdInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := requestid.Ensure(firstMetadata(ctx, requestIdMetadata))
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestIdMetadata, id))
+		return handler(requestid.NewContext(ctx, id), request)
+	}
+}
+
+// AuditInterceptor records the mutating calls like audit.Middleware records
+// the mutating requests, attributed with the callerHeader metadata.
+// Representations are not loaded, the entries only name the changed
+// resource.
+func AuditInterceptor(recorder *audit.Recorder, callerHeader string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+		if recorder == nil || !isMutating(method) {
+			return handler(ctx, request)
+		}
+		entry := &audit.Entry{
+			Id:        str.NewUUID(),
+			Time:      time.Now().UTC(),
+			RequestId: requestid.FromContext(ctx),
+			Attribution: audit.Attribute(func(name string) string {
+				return firstMetadata(ctx, strings.ToLower(name))
+			}, callerHeader, peerHost(ctx)),
+			Method:       "GRPC",
+			Route:        info.FullMethod,
+			Path:         info.FullMethod,
+			ResourceType: resourceType(info.FullMethod),
+			ResourceId:   resourceId(request),
+		}
+
+		response, err := handler(ctx, request)
+
+		code := status.Code(err)
+		entry.StatusCode = httpFromCode(code)
+		entry.Outcome = audit.OutcomeSuccess
+		if err != nil {
+			entry.Outcome = audit.OutcomeFailure
+			entry.Error = status.Convert(err).Message()
+		} else {
+			if entry.ResourceId == "" {
+				entry.ResourceId = resourceId(response)
+			}
+			if message, ok := response.(proto.Message); ok {
+				if data, marshalErr := protojson.Marshal(message); marshalErr == nil && string(data) != "{}" {
+					entry.After = data
+				}
+			}
+		}
+		recorder.Record(entry)
+		return response, err
+	}
+}
+
+func isMutating(method string) bool {
+	for _, prefix := range []string{"Create", "Update", "Delete", "Join", "Leave", "Add", "Remove"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceType derives user, group or role from /identity.v1.UserService/...
+func resourceType(fullMethod string) string {
+	service := fullMethod[:strings.LastIndex(fullMethod, "/")]
+	service = service[strings.LastIndex(service, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(service, "Service"))
+}
+
+// resourceId finds the id of the changed resource in a request or response.
+func resourceId(message interface{}) string {
+	switch m := message.(type) {
+	case interface{ GetUserId() string }:
+		return m.GetUserId()
+	case interface{ GetId() string }:
+		return m.GetId()
+	case *identityv1.UpdateUserRequest:
+		return m.GetUser().GetId()
+	case *identityv1.UpdateGroupRequest:
+		return m.GetGroup().GetId()
+	case *identityv1.UpdateRoleRequest:
+		return m.GetRole().GetId()
+	}
+	return ""
+}
+
+// peerHost is the address the call came from, without the port.
+func peerHost(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
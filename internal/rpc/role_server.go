@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type roleServer struct {
+	identityv1.UnimplementedRoleServiceServer
+	options *Options
+}
+
+func (r *roleServer) GetRole(ctx context.Context, request *identityv1.GetRoleRequest) (*identityv1.Role, error) {
+	role, statusCode, err := r.options.Roles.GetRoleById(ctx, request.GetId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return toRole(role), nil
+}
+
+func (r *roleServer) GetRoleByName(ctx context.Context, request *identityv1.GetRoleByNameRequest) (*identityv1.Role, error) {
+	role, statusCode, err := r.options.Roles.GetRoleByName(ctx, request.GetName())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return toRole(role), nil
+}
+
+func (r *roleServer) ListRoles(ctx context.Context, _ *identityv1.ListRolesRequest) (*identityv1.ListRolesResponse, error) {
+	roles, statusCode, err := r.options.Roles.ListRoles(ctx)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListRolesResponse{Roles: toRoles(*roles)}, nil
+}
+
+func (r *roleServer) CreateRole(ctx context.Context, request *identityv1.CreateRoleRequest) (*identityv1.Role, error) {
+	if request.GetRole().GetName() == "" {
+		return nil, invalidArgument("role.name is required")
+	}
+	role := fromRole(request.GetRole())
+	role.Id = nil
+	service := r.options.Roles
+	roleId, statusCode, err := service.CreateRole(ctx, role)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	created, statusCode, err := service.GetRoleById(ctx, roleId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	r.options.publish(event.RoleCreated, roleId, created)
+	return toRole(created), nil
+}
+
+func (r *roleServer) UpdateRole(ctx context.Context, request *identityv1.UpdateRoleRequest) (*identityv1.Role, error) {
+	roleId := request.GetRole().GetId()
+	if roleId == "" {
+		return nil, invalidArgument("role.id is required")
+	}
+	fields, err := maskedFields(request.GetUpdateMask(), request.GetRole(), roleFields)
+	if err != nil {
+		return nil, err
+	}
+	service := r.options.Roles
+	current, statusCode, err := service.GetRoleById(ctx, roleId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	applyRole(current, request.GetRole(), fields)
+	updated, statusCode, err := service.UpdateRole(ctx, roleId, current)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	r.options.publish(event.RoleUpdated, roleId, updated)
+	return toRole(updated), nil
+}
+
+func (r *roleServer) DeleteRole(ctx context.Context, request *identityv1.DeleteRoleRequest) (*emptypb.Empty, error) {
+	if statusCode, err := r.options.Roles.DeleteRole(ctx, request.GetId()); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	r.options.publish(event.RoleDeleted, request.GetId(), nil)
+	return &emptypb.Empty{}, nil
+}
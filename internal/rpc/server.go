@@ -0,0 +1,67 @@
+// Package rpc serves the users, groups and roles over gRPC, mirroring the
+// REST resources.
+package rpc
+
+import (
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/ratelimit"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+type Options struct {
+	// Users, Groups and Roles serve the calls, like the services in
+	// resource.Dependencies serve the REST handlers.
+	Users  keycloak.UserService
+	Groups keycloak.GroupService
+	Roles  keycloak.RoleService
+	// Events receives the change events, like the REST handlers publish them.
+	Events *event.Bus
+	// Audit records the mutating calls when set.
+	Audit *audit.Recorder
+	// AuditHeader is the metadata the gateway in front sets to the caller
+	// it verified, see audit.Attribute.
+	AuditHeader string
+	// RateLimiter limits the calls from the buckets of the REST requests
+	// when set.
+	RateLimiter *ratelimit.Limiter
+	Reflection  bool
+}
+
+func (o *Options) publish(eventType string, subject string, data interface{}) {
+	if o.Events != nil {
+		o.Events.Publish(event.New(eventType, subject, data))
+	}
+}
+
+// NewServer returns a gRPC server with the user, group and role services,
+// the standard health service and, if enabled, server reflection.
+func NewServer(options Options, serverOptions ...grpc.ServerOption) *grpc.Server {
+	interceptors := []grpc.UnaryServerInterceptor{RecoveryInterceptor(), RequestIdInterceptor()}
+	if options.RateLimiter != nil {
+		interceptors = append(interceptors, options.RateLimiter.UnaryServerInterceptor())
+	}
+	interceptors = append(interceptors, AuditInterceptor(options.Audit, options.AuditHeader))
+	serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(interceptors...))
+	server := grpc.NewServer(serverOptions...)
+	identityv1.RegisterUserServiceServer(server, &userServer{options: &options})
+	identityv1.RegisterGroupServiceServer(server, &groupServer{options: &options})
+	identityv1.RegisterRoleServiceServer(server, &roleServer{options: &options})
+
+	healthServer := health.NewServer()
+	for name := range server.GetServiceInfo() {
+		healthServer.SetServingStatus(name, healthv1.HealthCheckResponse_SERVING)
+	}
+	healthServer.SetServingStatus("", healthv1.HealthCheckResponse_SERVING)
+	healthv1.RegisterHealthServer(server, healthServer)
+
+	if options.Reflection {
+		reflection.Register(server)
+	}
+	return server
+}
@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"fmt"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"github.com/miguoliang/keycloakadminclient"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"slices"
+	"strings"
+)
+
+var (
+	userFields  = []string{"username", "email", "first_name", "last_name", "enabled", "email_verified", "attributes"}
+	groupFields = []string{"name", "attributes"}
+	roleFields  = []string{"name", "description", "attributes"}
+)
+
+// maskedFields returns the fields of message an update changes: the ones
+// mask names, all of them for "*", or the ones set in message without a
+// mask, as proto3 cannot tell a field left out from one set to its zero
+// value.
+func maskedFields(mask *fieldmaskpb.FieldMask, message proto.Message, updatable []string) ([]string, error) {
+	if len(mask.GetPaths()) == 0 {
+		var fields []string
+		message.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+			if name := string(field.Name()); slices.Contains(updatable, name) {
+				fields = append(fields, name)
+			}
+			return true
+		})
+		return fields, nil
+	}
+	if slices.Equal(mask.GetPaths(), []string{"*"}) {
+		return updatable, nil
+	}
+	for _, path := range mask.GetPaths() {
+		if !slices.Contains(updatable, path) {
+			return nil, invalidArgument(fmt.Sprintf("update_mask: %q cannot be updated, only %s", path, strings.Join(updatable, ", ")))
+		}
+	}
+	return mask.GetPaths(), nil
+}
+
+// applyUser sets the fields of user onto current.
+func applyUser(current *keycloakadminclient.UserRepresentation, user *identityv1.User, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "username":
+			current.Username = keycloakadminclient.PtrString(user.GetUsername())
+		case "email":
+			current.Email = keycloakadminclient.PtrString(user.GetEmail())
+		case "first_name":
+			current.FirstName = keycloakadminclient.PtrString(user.GetFirstName())
+		case "last_name":
+			current.LastName = keycloakadminclient.PtrString(user.GetLastName())
+		case "enabled":
+			current.Enabled = keycloakadminclient.PtrBool(user.GetEnabled())
+		case "email_verified":
+			current.EmailVerified = keycloakadminclient.PtrBool(user.GetEmailVerified())
+		case "attributes":
+			current.Attributes = replacedValues(user.GetAttributes())
+		}
+	}
+}
+
+func applyGroup(current *keycloakadminclient.GroupRepresentation, group *identityv1.Group, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "name":
+			current.Name = keycloakadminclient.PtrString(group.GetName())
+		case "attributes":
+			current.Attributes = replacedValues(group.GetAttributes())
+		}
+	}
+}
+
+func applyRole(current *keycloakadminclient.RoleRepresentation, role *identityv1.Role, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "name":
+			current.Name = keycloakadminclient.PtrString(role.GetName())
+		case "description":
+			current.Description = keycloakadminclient.PtrString(role.GetDescription())
+		case "attributes":
+			current.Attributes = replacedValues(role.GetAttributes())
+		}
+	}
+}
+
+// replacedValues are the attributes to replace the current ones with, an
+// empty map rather than nil to clear them.
+func replacedValues(attributes map[string]*identityv1.Values) *map[string][]string {
+	converted := fromValues(attributes)
+	if converted == nil {
+		converted = map[string][]string{}
+	}
+	return &converted
+}
@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"github.com/miguoliang/keycloakadminclient"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const defaultPageSize = 100
+
+type userServer struct {
+	identityv1.UnimplementedUserServiceServer
+	options *Options
+}
+
+func (u *userServer) GetUser(ctx context.Context, request *identityv1.GetUserRequest) (*identityv1.User, error) {
+	user, statusCode, err := u.options.Users.GetUserById(ctx, request.GetId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return toUser(user), nil
+}
+
+func (u *userServer) GetUserByUsername(ctx context.Context, request *identityv1.GetUserByUsernameRequest) (*identityv1.User, error) {
+	user, statusCode, err := u.options.Users.GetUserByUsername(ctx, request.GetUsername())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", request.GetUsername())
+	}
+	return toUser(user), nil
+}
+
+func (u *userServer) ListUsers(ctx context.Context, request *identityv1.ListUsersRequest) (*identityv1.ListUsersResponse, error) {
+	max := request.GetMax()
+	if max == 0 {
+		max = defaultPageSize
+	}
+	if request.GetFirst() < 0 || max < 0 {
+		return nil, invalidArgument("first and max must not be negative")
+	}
+	users, statusCode, err := u.options.Users.ListUsersPage(ctx, request.GetFirst(), max)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListUsersResponse{Users: toUsers(*users)}, nil
+}
+
+func (u *userServer) CreateUser(ctx context.Context, request *identityv1.CreateUserRequest) (*identityv1.User, error) {
+	if request.GetUser().GetUsername() == "" {
+		return nil, invalidArgument("user.username is required")
+	}
+	user := fromUser(request.GetUser())
+	user.Id = nil
+	if request.GetPassword() != "" {
+		user.Credentials = []keycloakadminclient.CredentialRepresentation{{
+			Type:      keycloakadminclient.PtrString("password"),
+			Value:     keycloakadminclient.PtrString(request.GetPassword()),
+			Temporary: keycloakadminclient.PtrBool(false),
+		}}
+	}
+	service := u.options.Users
+	userId, statusCode, err := service.CreateUser(ctx, user)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	created, statusCode, err := service.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(event.UserCreated, userId, created)
+	return toUser(created), nil
+}
+
+func (u *userServer) UpdateUser(ctx context.Context, request *identityv1.UpdateUserRequest) (*identityv1.User, error) {
+	if request.GetUser().GetId() == "" {
+		return nil, invalidArgument("user.id is required")
+	}
+	fields, err := maskedFields(request.GetUpdateMask(), request.GetUser(), userFields)
+	if err != nil {
+		return nil, err
+	}
+	service := u.options.Users
+	current, statusCode, err := service.GetUserById(ctx, request.GetUser().GetId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	wasEnabled := current.GetEnabled()
+	applyUser(current, request.GetUser(), fields)
+	updated, statusCode, err := service.UpdateUser(ctx, current)
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(event.UserUpdated, updated.GetId(), updated)
+	if wasEnabled && !updated.GetEnabled() {
+		u.options.publish(event.UserDisabled, updated.GetId(), nil)
+	}
+	return toUser(updated), nil
+}
+
+func (u *userServer) DeleteUser(ctx context.Context, request *identityv1.DeleteUserRequest) (*emptypb.Empty, error) {
+	if statusCode, err := u.options.Users.DeleteUser(ctx, request.GetId()); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(event.UserDeleted, request.GetId(), nil)
+	return &emptypb.Empty{}, nil
+}
+
+func (u *userServer) ListUserGroups(ctx context.Context, request *identityv1.ListUserGroupsRequest) (*identityv1.ListGroupsResponse, error) {
+	groups, statusCode, err := u.options.Users.ListGroups(ctx, request.GetUserId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListGroupsResponse{Groups: toGroups(*groups)}, nil
+}
+
+func (u *userServer) JoinGroup(ctx context.Context, request *identityv1.GroupMembershipRequest) (*emptypb.Empty, error) {
+	if statusCode, err := u.options.Users.JoinGroup(ctx, request.GetUserId(), request.GetGroupId()); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(event.UserJoinedGroup, request.GetUserId(), map[string]string{"groupId": request.GetGroupId()})
+	return &emptypb.Empty{}, nil
+}
+
+func (u *userServer) LeaveGroup(ctx context.Context, request *identityv1.GroupMembershipRequest) (*emptypb.Empty, error) {
+	if statusCode, err := u.options.Users.LeaveGroup(ctx, request.GetUserId(), request.GetGroupId()); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(event.UserLeftGroup, request.GetUserId(), map[string]string{"groupId": request.GetGroupId()})
+	return &emptypb.Empty{}, nil
+}
+
+func (u *userServer) ListRoleMappings(ctx context.Context, request *identityv1.ListRoleMappingsRequest) (*identityv1.ListRolesResponse, error) {
+	roles, statusCode, err := u.options.Users.ListRoleMappings(ctx, request.GetUserId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListRolesResponse{Roles: toRoles(*roles)}, nil
+}
+
+func (u *userServer) ListEffectiveRoles(ctx context.Context, request *identityv1.ListRoleMappingsRequest) (*identityv1.ListRolesResponse, error) {
+	roles, statusCode, err := u.options.Users.ListEffectiveRoles(ctx, request.GetUserId())
+	if err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	return &identityv1.ListRolesResponse{Roles: toRoles(*roles)}, nil
+}
+
+func (u *userServer) AddRoleMappings(ctx context.Context, request *identityv1.RoleMappingsRequest) (*emptypb.Empty, error) {
+	return u.updateRoleMappings(ctx, request, keycloak.UserService.AddRoleMappings, event.UserRolesAdded)
+}
+
+func (u *userServer) RemoveRoleMappings(ctx context.Context, request *identityv1.RoleMappingsRequest) (*emptypb.Empty, error) {
+	return u.updateRoleMappings(ctx, request, keycloak.UserService.RemoveRoleMappings, event.UserRolesRemoved)
+}
+
+func (u *userServer) updateRoleMappings(ctx context.Context, request *identityv1.RoleMappingsRequest, update func(keycloak.UserService, context.Context, string, []keycloakadminclient.RoleRepresentation) (int, error), eventType string) (*emptypb.Empty, error) {
+	if len(request.GetRoleNames()) == 0 {
+		return nil, invalidArgument("role_names must not be empty")
+	}
+	roleService := u.options.Roles
+	roles := make([]keycloakadminclient.RoleRepresentation, 0, len(request.GetRoleNames()))
+	for _, name := range request.GetRoleNames() {
+		role, statusCode, err := roleService.GetRoleByName(ctx, name)
+		if err != nil {
+			return nil, statusError(statusCode, err)
+		}
+		roles = append(roles, *role)
+	}
+	if statusCode, err := update(u.options.Users, ctx, request.GetUserId(), roles); err != nil {
+		return nil, statusError(statusCode, err)
+	}
+	u.options.publish(eventType, request.GetUserId(), map[string][]string{"roles": request.GetRoleNames()})
+	return &emptypb.Empty{}, nil
+}
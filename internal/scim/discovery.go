@@ -0,0 +1,123 @@
+package scim
+
+// MaxResults is the most resources a list request returns.
+const MaxResults = 1000
+
+// ServiceProviderConfig describes the features of this endpoint.
+func ServiceProviderConfig(baseURL string) map[string]interface{} {
+	unsupported := map[string]interface{}{"supported": false}
+	return map[string]interface{}{
+		"schemas":          []string{ServiceProviderConfigSchema},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": MaxResults},
+		"changePassword":   map[string]interface{}{"supported": true},
+		"sort":             unsupported,
+		"etag":             unsupported,
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a bearer token issued by Keycloak",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// ResourceTypes describes the User and Group resource types.
+func ResourceTypes(baseURL string) []interface{} {
+	resourceType := func(name string, endpoint string, schema string) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":     []string{ResourceTypeSchema},
+			"id":          name,
+			"name":        name,
+			"endpoint":    endpoint,
+			"description": name,
+			"schema":      schema,
+			"meta": map[string]interface{}{
+				"resourceType": "ResourceType",
+				"location":     baseURL + "/ResourceTypes/" + name,
+			},
+		}
+	}
+	return []interface{}{
+		resourceType("User", "/Users", UserSchema),
+		resourceType("Group", "/Groups", GroupSchema),
+	}
+}
+
+// Schemas describes the attributes of users and groups this endpoint
+// supports.
+func Schemas(baseURL string) []interface{} {
+	schema := func(id string, name string, attributes ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":     []string{SchemaSchema},
+			"id":          id,
+			"name":        name,
+			"description": name,
+			"attributes":  attributes,
+			"meta": map[string]interface{}{
+				"resourceType": "Schema",
+				"location":     baseURL + "/Schemas/" + id,
+			},
+		}
+	}
+	return []interface{}{
+		schema(UserSchema, "User",
+			attribute("userName", "string", false, true, "readWrite", "server"),
+			attribute("name", "complex", false, false, "readWrite", "none",
+				attribute("formatted", "string", false, false, "readOnly", "none"),
+				attribute("familyName", "string", false, false, "readWrite", "none"),
+				attribute("givenName", "string", false, false, "readWrite", "none"),
+			),
+			attribute("displayName", "string", false, false, "readOnly", "none"),
+			attribute("emails", "complex", true, false, "readWrite", "none",
+				attribute("value", "string", false, false, "readWrite", "none"),
+				attribute("type", "string", false, false, "readWrite", "none"),
+				attribute("primary", "boolean", false, false, "readWrite", "none"),
+			),
+			attribute("active", "boolean", false, false, "readWrite", "none"),
+			withReturned(attribute("password", "string", false, false, "writeOnly", "none"), "never"),
+			attribute("groups", "complex", true, false, "readOnly", "none",
+				attribute("value", "string", false, false, "readOnly", "none"),
+				attribute("$ref", "reference", false, false, "readOnly", "none"),
+				attribute("display", "string", false, false, "readOnly", "none"),
+			),
+		),
+		schema(GroupSchema, "Group",
+			attribute("displayName", "string", false, true, "readWrite", "server"),
+			attribute("members", "complex", true, false, "readWrite", "none",
+				attribute("value", "string", false, false, "immutable", "none"),
+				attribute("$ref", "reference", false, false, "immutable", "none"),
+				attribute("display", "string", false, false, "readOnly", "none"),
+				attribute("type", "string", false, false, "immutable", "none"),
+			),
+		),
+	}
+}
+
+func attribute(name string, attributeType string, multiValued bool, required bool, mutability string, uniqueness string, subAttributes ...map[string]interface{}) map[string]interface{} {
+	a := map[string]interface{}{
+		"name":        name,
+		"type":        attributeType,
+		"multiValued": multiValued,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+	}
+	if len(subAttributes) > 0 {
+		a["subAttributes"] = subAttributes
+	}
+	return a
+}
+
+func withReturned(attribute map[string]interface{}, returned string) map[string]interface{} {
+	attribute["returned"] = returned
+	return attribute
+}
@@ -0,0 +1,413 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Filter is a parsed filter expression of RFC 7644 section 3.4.2.2. It is
+// evaluated against the JSON form of a resource.
+type Filter interface {
+	Matches(resource map[string]interface{}) bool
+}
+
+// Comparison is a filter on a single attribute, e.g. userName eq "bjensen".
+type Comparison struct {
+	// Path is the attribute path without schema URN, e.g. name.givenName.
+	Path     string
+	Operator string
+	// Value is nil for the pr operator.
+	Value interface{}
+}
+
+type logical struct {
+	operator    string
+	left, right Filter
+}
+
+type not struct {
+	filter Filter
+}
+
+// valuePath filters on the entries of a multi-valued attribute, e.g.
+// emails[type eq "work" and value co "@example.com"].
+type valuePath struct {
+	path   string
+	filter Filter
+}
+
+// ParseFilter parses the operators eq, ne, co, sw, ew, gt, ge, lt, le and
+// pr, combined with and, or, not and parentheses, and value paths.
+func ParseFilter(expression string) (Filter, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, InvalidFilter, err.Error())
+	}
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err == nil && p.position < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.position].text)
+	}
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, InvalidFilter, err.Error())
+	}
+	return filter, nil
+}
+
+// Equalities returns the values a filter made only of eq comparisons joined
+// by and compares the attributes with, keyed by the path in lower case.
+func Equalities(filter Filter) (map[string]interface{}, bool) {
+	switch f := filter.(type) {
+	case *Comparison:
+		if f.Operator != "eq" {
+			return nil, false
+		}
+		return map[string]interface{}{strings.ToLower(f.Path): f.Value}, true
+	case *logical:
+		if f.operator != "and" {
+			return nil, false
+		}
+		left, ok := Equalities(f.left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := Equalities(f.right)
+		if !ok {
+			return nil, false
+		}
+		for path, value := range right {
+			if other, found := left[path]; found && other != value {
+				return nil, false
+			}
+			left[path] = value
+		}
+		return left, true
+	}
+	return nil, false
+}
+
+// Refers tells whether the filter compares the attribute, e.g. members.
+func Refers(filter Filter, attribute string) bool {
+	switch f := filter.(type) {
+	case *Comparison:
+		return strings.EqualFold(strings.SplitN(f.Path, ".", 2)[0], attribute)
+	case *logical:
+		return Refers(f.left, attribute) || Refers(f.right, attribute)
+	case *not:
+		return Refers(f.filter, attribute)
+	case *valuePath:
+		return strings.EqualFold(strings.SplitN(f.path, ".", 2)[0], attribute)
+	}
+	return false
+}
+
+type token struct {
+	text string
+	// literal is set for string, number, boolean and null values.
+	literal bool
+	value   interface{}
+}
+
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		switch ch := expression[i]; {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '(' || ch == ')' || ch == '[' || ch == ']':
+			tokens = append(tokens, token{text: string(ch)})
+			i++
+		case ch == '"':
+			end := i + 1
+			for ; end < len(expression) && expression[end] != '"'; end++ {
+				if expression[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expression) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(expression[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string %s", expression[i:end+1])
+			}
+			tokens = append(tokens, token{text: expression[i : end+1], literal: true, value: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expression) && !strings.ContainsRune(" \t()[]\"", rune(expression[end])) {
+				end++
+			}
+			word := expression[i:end]
+			t := token{text: word}
+			switch strings.ToLower(word) {
+			case "true":
+				t.literal, t.value = true, true
+			case "false":
+				t.literal, t.value = true, false
+			case "null":
+				t.literal = true
+			default:
+				var number float64
+				if json.Unmarshal([]byte(word), &number) == nil {
+					t.literal, t.value = true, number
+				}
+			}
+			tokens = append(tokens, t)
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens   []token
+	position int
+}
+
+func (p *filterParser) peek() string {
+	if p.position >= len(p.tokens) || p.tokens[p.position].literal {
+		return ""
+	}
+	return strings.ToLower(p.tokens[p.position].text)
+}
+
+func (p *filterParser) next() (token, error) {
+	if p.position >= len(p.tokens) {
+		return token{}, fmt.Errorf("unexpected end of filter")
+	}
+	p.position++
+	return p.tokens[p.position-1], nil
+}
+
+func (p *filterParser) expect(text string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.literal || t.text != text {
+		return fmt.Errorf("expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek() == "or" {
+		p.position++
+		var right Filter
+		if right, err = p.parseAnd(); err == nil {
+			left = &logical{operator: "or", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseFactor()
+	for err == nil && p.peek() == "and" {
+		p.position++
+		var right Filter
+		if right, err = p.parseFactor(); err == nil {
+			left = &logical{operator: "and", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *filterParser) parseFactor() (Filter, error) {
+	switch p.peek() {
+	case "not":
+		p.position++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseGroup()
+		return &not{filter: filter}, err
+	case "(":
+		p.position++
+		return p.parseGroup()
+	}
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.literal || t.text == ")" || t.text == "[" || t.text == "]" {
+		return nil, fmt.Errorf("expected an attribute, got %q", t.text)
+	}
+	path := attributePath(t.text)
+	if p.peek() == "[" {
+		p.position++
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return &valuePath{path: path, filter: filter}, nil
+	}
+
+	operator, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	comparison := &Comparison{Path: path, Operator: strings.ToLower(operator.text)}
+	switch comparison.Operator {
+	case "pr":
+		return comparison, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !value.literal {
+			return nil, fmt.Errorf("expected a value, got %q", value.text)
+		}
+		comparison.Value = value.value
+		return comparison, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", operator.text)
+}
+
+func (p *filterParser) parseGroup() (Filter, error) {
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return filter, p.expect(")")
+}
+
+// attributePath strips the schema URN from a fully qualified path such as
+// urn:ietf:params:scim:schemas:core:2.0:User:name.givenName.
+func attributePath(path string) string {
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		if i := strings.LastIndex(path, ":"); i >= 0 {
+			return path[i+1:]
+		}
+	}
+	return path
+}
+
+func (l *logical) Matches(resource map[string]interface{}) bool {
+	if l.operator == "and" {
+		return l.left.Matches(resource) && l.right.Matches(resource)
+	}
+	return l.left.Matches(resource) || l.right.Matches(resource)
+}
+
+func (n *not) Matches(resource map[string]interface{}) bool {
+	return !n.filter.Matches(resource)
+}
+
+func (v *valuePath) Matches(resource map[string]interface{}) bool {
+	values, _ := lookup(resource, v.path).([]interface{})
+	for _, value := range values {
+		if entry, ok := value.(map[string]interface{}); ok && v.filter.Matches(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Comparison) Matches(resource map[string]interface{}) bool {
+	parts := strings.SplitN(c.Path, ".", 2)
+	value := lookup(resource, parts[0])
+	if values, ok := value.([]interface{}); ok {
+		// A multi-valued attribute matches when any of its values does.
+		for _, v := range values {
+			if c.matchesValue(v, parts) {
+				return true
+			}
+		}
+		return c.Operator == "ne" && len(values) == 0
+	}
+	return c.matchesValue(value, parts)
+}
+
+func (c *Comparison) matchesValue(value interface{}, parts []string) bool {
+	if len(parts) == 2 {
+		object, _ := value.(map[string]interface{})
+		value = lookup(object, parts[1])
+	} else if object, ok := value.(map[string]interface{}); ok {
+		// emails eq "x" compares with the value sub-attribute.
+		value = lookup(object, "value")
+	}
+	if c.Operator == "pr" {
+		return value != nil && value != ""
+	}
+	if c.Operator == "ne" {
+		return !compare(value, "eq", c.Value)
+	}
+	return compare(value, c.Operator, c.Value)
+}
+
+// lookup gets an attribute by its case-insensitive name.
+func lookup(resource map[string]interface{}, name string) interface{} {
+	if value, ok := resource[name]; ok {
+		return value
+	}
+	for key, value := range resource {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// compare compares strings case-insensitively, as every string attribute
+// this endpoint serves is caseExact false.
+func compare(actual interface{}, operator string, expected interface{}) bool {
+	switch e := expected.(type) {
+	case nil:
+		return operator == "eq" && actual == nil
+	case string:
+		a, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		a, e = strings.ToLower(a), strings.ToLower(e)
+		switch operator {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case float64:
+		a, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch operator {
+		case "eq":
+			return a == e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case bool:
+		a, ok := actual.(bool)
+		return ok && operator == "eq" && a == e
+	}
+	return false
+}
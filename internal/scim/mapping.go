@@ -0,0 +1,141 @@
+package scim
+
+import (
+	"github.com/miguoliang/keycloakadminclient"
+	"strings"
+	"time"
+)
+
+// ExternalIdAttribute is the Keycloak user or group attribute the SCIM
+// externalId is kept in.
+const ExternalIdAttribute = "scimExternalId"
+
+// UserFromKeycloak converts a Keycloak user; groups may be nil when they
+// were not loaded. baseURL is the URL of the SCIM endpoint, e.g.
+// https://example.com/scim/v2.
+func UserFromKeycloak(user *keycloakadminclient.UserRepresentation, groups []keycloakadminclient.GroupRepresentation, baseURL string) *User {
+	u := &User{
+		Schemas:    []string{UserSchema},
+		Id:         user.GetId(),
+		ExternalId: firstAttribute(user.Attributes, ExternalIdAttribute),
+		UserName:   user.GetUsername(),
+		Active:     keycloakadminclient.PtrBool(user.GetEnabled()),
+		Meta: &Meta{
+			ResourceType: "User",
+			Location:     baseURL + "/Users/" + user.GetId(),
+		},
+	}
+	if user.GetFirstName() != "" || user.GetLastName() != "" {
+		u.Name = &Name{
+			GivenName:  user.GetFirstName(),
+			FamilyName: user.GetLastName(),
+			Formatted:  strings.TrimSpace(user.GetFirstName() + " " + user.GetLastName()),
+		}
+		u.DisplayName = u.Name.Formatted
+	}
+	if user.GetEmail() != "" {
+		u.Emails = []MultiValued{{Value: user.GetEmail(), Type: "work", Primary: true}}
+	}
+	if created := user.GetCreatedTimestamp(); created > 0 {
+		t := time.UnixMilli(created).UTC()
+		u.Meta.Created = &t
+	}
+	for _, group := range groups {
+		u.Groups = append(u.Groups, MultiValued{
+			Value:   group.GetId(),
+			Ref:     baseURL + "/Groups/" + group.GetId(),
+			Display: group.GetName(),
+		})
+	}
+	return u
+}
+
+// ToKeycloak copies the attributes of u onto user, which is either empty
+// or the user being replaced. Attributes SCIM does not know are kept.
+func (u *User) ToKeycloak(user *keycloakadminclient.UserRepresentation) {
+	user.Username = keycloakadminclient.PtrString(u.UserName)
+	var firstName, lastName string
+	if u.Name != nil {
+		firstName, lastName = u.Name.GivenName, u.Name.FamilyName
+	}
+	user.FirstName = keycloakadminclient.PtrString(firstName)
+	user.LastName = keycloakadminclient.PtrString(lastName)
+	email := ""
+	for _, e := range u.Emails {
+		if e.Primary || email == "" {
+			email = e.Value
+		}
+	}
+	user.Email = keycloakadminclient.PtrString(email)
+	user.Enabled = keycloakadminclient.PtrBool(u.Active == nil || *u.Active)
+	user.Attributes = setAttribute(user.Attributes, ExternalIdAttribute, u.ExternalId)
+	if u.Password != "" {
+		user.Credentials = []keycloakadminclient.CredentialRepresentation{{
+			Type:      keycloakadminclient.PtrString("password"),
+			Value:     keycloakadminclient.PtrString(u.Password),
+			Temporary: keycloakadminclient.PtrBool(false),
+		}}
+	}
+}
+
+// GroupFromKeycloak converts a Keycloak group; members may be nil when they
+// were not loaded.
+func GroupFromKeycloak(group *keycloakadminclient.GroupRepresentation, members []keycloakadminclient.UserRepresentation, baseURL string) *Group {
+	g := &Group{
+		Schemas:     []string{GroupSchema},
+		Id:          group.GetId(),
+		ExternalId:  firstAttribute(group.Attributes, ExternalIdAttribute),
+		DisplayName: group.GetName(),
+		Meta: &Meta{
+			ResourceType: "Group",
+			Location:     baseURL + "/Groups/" + group.GetId(),
+		},
+	}
+	for _, member := range members {
+		g.Members = append(g.Members, MultiValued{
+			Value:   member.GetId(),
+			Ref:     baseURL + "/Users/" + member.GetId(),
+			Display: member.GetUsername(),
+			Type:    "User",
+		})
+	}
+	return g
+}
+
+// ToKeycloak copies the attributes of g onto group. Members are not part of
+// a Keycloak group representation and are handled by the caller.
+func (g *Group) ToKeycloak(group *keycloakadminclient.GroupRepresentation) {
+	group.Name = keycloakadminclient.PtrString(g.DisplayName)
+	group.Attributes = setAttribute(group.Attributes, ExternalIdAttribute, g.ExternalId)
+}
+
+// MemberIds returns the ids of the members of g.
+func (g *Group) MemberIds() map[string]bool {
+	ids := make(map[string]bool, len(g.Members))
+	for _, member := range g.Members {
+		ids[member.Value] = true
+	}
+	return ids
+}
+
+func firstAttribute(attributes *map[string][]string, name string) string {
+	if attributes == nil || len((*attributes)[name]) == 0 {
+		return ""
+	}
+	return (*attributes)[name][0]
+}
+
+func setAttribute(attributes *map[string][]string, name string, value string) *map[string][]string {
+	if attributes == nil {
+		if value == "" {
+			return nil
+		}
+		attributes = &map[string][]string{}
+	}
+	if value == "" {
+		delete(*attributes, name)
+	} else {
+		(*attributes)[name] = []string{value}
+	}
+	return attributes
+}
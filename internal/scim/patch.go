@@ -0,0 +1,206 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Patch applies PATCH operations (RFC 7644 section 3.5.2) to the JSON form
+// of a resource.
+func Patch(resource map[string]interface{}, operations []PatchOperation) error {
+	for i, operation := range operations {
+		if err := patch(resource, operation); err != nil {
+			if scimError, ok := err.(*Error); ok {
+				scimError.Detail = fmt.Sprintf("operation %d: %s", i, scimError.Detail)
+				return scimError
+			}
+			return NewError(http.StatusBadRequest, InvalidValue, fmt.Sprintf("operation %d: %s", i, err))
+		}
+	}
+	return nil
+}
+
+func patch(resource map[string]interface{}, operation PatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return NewError(http.StatusBadRequest, InvalidSyntax, fmt.Sprintf("unknown op %q", operation.Op))
+	}
+	var value interface{}
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return err
+		}
+	}
+	if operation.Path == "" {
+		if op == "remove" {
+			return NewError(http.StatusBadRequest, NoTarget, "remove requires a path")
+		}
+		attributes, ok := value.(map[string]interface{})
+		if !ok {
+			return NewError(http.StatusBadRequest, InvalidValue, "value must be an object when there is no path")
+		}
+		for path, v := range attributes {
+			if err := patchPath(resource, op, path, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if op != "remove" && value == nil {
+		return NewError(http.StatusBadRequest, InvalidValue, op+" requires a value")
+	}
+	return patchPath(resource, op, operation.Path, value)
+}
+
+func patchPath(resource map[string]interface{}, op string, path string, value interface{}) error {
+	attribute, filterExpression, subAttribute, err := splitPath(path)
+	if err != nil {
+		return err
+	}
+	if filterExpression == "" {
+		if name, sub, ok := strings.Cut(attribute, "."); ok {
+			object, _ := lookup(resource, name).(map[string]interface{})
+			if object == nil {
+				if op == "remove" {
+					return nil
+				}
+				object = map[string]interface{}{}
+				resource[key(resource, name)] = object
+			}
+			return patchAttribute(object, op, sub, value)
+		}
+		return patchAttribute(resource, op, attribute, value)
+	}
+
+	filter, err := ParseFilter(filterExpression)
+	if err != nil {
+		return NewError(http.StatusBadRequest, InvalidPath, err.Error())
+	}
+	name := key(resource, attribute)
+	values, _ := resource[name].([]interface{})
+	kept := make([]interface{}, 0, len(values))
+	matched := false
+	for _, v := range values {
+		entry, ok := v.(map[string]interface{})
+		if !ok || !filter.Matches(entry) {
+			kept = append(kept, v)
+			continue
+		}
+		matched = true
+		switch {
+		case op == "remove" && subAttribute == "":
+			continue
+		case subAttribute != "":
+			if err := patchAttribute(entry, op, subAttribute, value); err != nil {
+				return err
+			}
+		case op == "replace":
+			if replacement, ok := value.(map[string]interface{}); ok {
+				entry = replacement
+			}
+		default:
+			if addition, ok := value.(map[string]interface{}); ok {
+				for k, v := range addition {
+					entry[k] = v
+				}
+			}
+		}
+		kept = append(kept, entry)
+	}
+	if !matched {
+		if op == "remove" {
+			return nil
+		}
+		return NewError(http.StatusBadRequest, NoTarget, fmt.Sprintf("no value of %s matches %s", attribute, filterExpression))
+	}
+	resource[name] = kept
+	return nil
+}
+
+func patchAttribute(object map[string]interface{}, op string, attribute string, value interface{}) error {
+	name := key(object, attribute)
+	switch op {
+	case "remove":
+		delete(object, name)
+	case "add":
+		existing := object[name]
+		if values, ok := existing.([]interface{}); ok {
+			additions, ok := value.([]interface{})
+			if !ok {
+				additions = []interface{}{value}
+			}
+			object[name] = appendNew(values, additions)
+			return nil
+		}
+		if current, ok := existing.(map[string]interface{}); ok {
+			if additions, ok := value.(map[string]interface{}); ok {
+				for k, v := range additions {
+					current[key(current, k)] = v
+				}
+				return nil
+			}
+		}
+		object[name] = value
+	case "replace":
+		object[name] = value
+	}
+	return nil
+}
+
+// appendNew appends the values that are not present yet; entries with the
+// same value sub-attribute are the same.
+func appendNew(values []interface{}, additions []interface{}) []interface{} {
+	for _, addition := range additions {
+		duplicate := false
+		for _, v := range values {
+			if sameValue(v, addition) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			values = append(values, addition)
+		}
+	}
+	return values
+}
+
+func sameValue(a interface{}, b interface{}) bool {
+	aEntry, aOk := a.(map[string]interface{})
+	bEntry, bOk := b.(map[string]interface{})
+	if aOk && bOk {
+		return aEntry["value"] != nil && aEntry["value"] == bEntry["value"]
+	}
+	return a == b
+}
+
+// splitPath splits members[value eq "2819c223"].display into its attribute,
+// value filter and sub-attribute.
+func splitPath(path string) (string, string, string, error) {
+	open := strings.Index(path, "[")
+	if open < 0 {
+		return attributePath(path), "", "", nil
+	}
+	end := strings.LastIndex(path, "]")
+	if end < open {
+		return "", "", "", NewError(http.StatusBadRequest, InvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+	subAttribute := strings.TrimPrefix(path[end+1:], ".")
+	return attributePath(path[:open]), path[open+1 : end], subAttribute, nil
+}
+
+// key returns the name under which attribute is stored in object, matching
+// case-insensitively.
+func key(object map[string]interface{}, attribute string) string {
+	if _, ok := object[attribute]; ok {
+		return attribute
+	}
+	for k := range object {
+		if strings.EqualFold(k, attribute) {
+			return k
+		}
+	}
+	return attribute
+}
@@ -0,0 +1,105 @@
+package secret
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Cache keeps the secrets fetched from a provider, and fetches them again
+// every refresh interval, so a rotated secret is picked up without a
+// restart. When a fetch fails, the last value is kept.
+type Cache struct {
+	provider Provider
+	refresh  time.Duration
+
+	mutex  sync.Mutex
+	values map[string]string
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewCache(provider Provider, refresh time.Duration) *Cache {
+	c := &Cache{
+		provider: provider,
+		refresh:  refresh,
+		values:   map[string]string{},
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if refresh > 0 {
+		go c.run()
+	} else {
+		close(c.stopped)
+	}
+	return c
+}
+
+func (c *Cache) Get(ctx context.Context, name string) (string, error) {
+	c.mutex.Lock()
+	value, ok := c.values[name]
+	c.mutex.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	value, err := c.provider.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	c.mutex.Lock()
+	c.values[name] = value
+	c.mutex.Unlock()
+	return value, nil
+}
+
+// Stop ends the refreshing.
+func (c *Cache) Stop() {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.stopped
+}
+
+func (c *Cache) run() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(c.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.refreshAll()
+		}
+	}
+}
+
+func (c *Cache) refreshAll() {
+	c.mutex.Lock()
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	c.mutex.Unlock()
+
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), c.refresh)
+		value, err := c.provider.Get(ctx, name)
+		cancel()
+		if err != nil {
+			slog.Warn("failed to refresh secret, keeping the last value", "secret", name, "error", err)
+			continue
+		}
+		c.mutex.Lock()
+		if c.values[name] != value {
+			slog.Info("secret rotated", "secret", name)
+		}
+		c.values[name] = value
+		c.mutex.Unlock()
+	}
+}
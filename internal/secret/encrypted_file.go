@@ -0,0 +1,110 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+type EncryptedFileOptions struct {
+	// Path is the file Seal wrote.
+	Path string `mapstructure:"path"`
+	// KeyEnv is the environment variable holding the base64 encoded
+	// 32 byte AES-256 key.
+	KeyEnv string `mapstructure:"key-env"`
+}
+
+// EncryptedFileProvider reads secrets from a JSON object of names and values
+// encrypted with AES-256-GCM. The file is read again on every Get, so it can
+// be replaced while the service runs.
+type EncryptedFileProvider struct {
+	path string
+	aead cipher.AEAD
+}
+
+func NewEncryptedFileProvider(options EncryptedFileOptions) (*EncryptedFileProvider, error) {
+	encoded, ok := os.LookupEnv(options.KeyEnv)
+	if !ok {
+		return nil, fmt.Errorf("secret key: environment variable %s is not set", options.KeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("secret key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedFileProvider{path: options.Path, aead: aead}, nil
+}
+
+func (e *EncryptedFileProvider) Get(_ context.Context, name string) (string, error) {
+	sealed, err := os.ReadFile(e.path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+	plaintext, err := open(e.aead, sealed)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", e.path, err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return "", fmt.Errorf("%s: decode secrets: %w", e.path, err)
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s has no %s", ErrNotFound, e.path, name)
+	}
+	return value, nil
+}
+
+// Seal encrypts plaintext with key for the encrypted file provider. The
+// result is the base64 encoded nonce followed by the ciphertext.
+func Seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+	return append(encoded, '\n'), nil
+}
+
+func open(aead cipher.AEAD, encoded []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decode secret file: %w", err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("secret file is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("secret file cannot be decrypted with the key")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secret key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,101 @@
+// Package secret fetches the credentials the service needs from where the
+// deployment keeps them, instead of the configuration file.
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderEnv           = "env"
+	ProviderFile          = "file"
+	ProviderEncryptedFile = "encrypted-file"
+	ProviderVault         = "vault"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Provider fetches the current value of a secret. The meaning of name
+// depends on the provider: an environment variable, a file, or a key.
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Options select and configure the provider.
+type Options struct {
+	// Provider is env, file, encrypted-file or vault.
+	Provider string `mapstructure:"provider"`
+	// AdminPassword is the name of the secret holding the password of the
+	// Keycloak admin user.
+	AdminPassword string `mapstructure:"admin-password"`
+	// Refresh is how often the secrets are fetched again, so rotated ones
+	// are picked up. With 0 they are fetched once.
+	Refresh       time.Duration        `mapstructure:"refresh"`
+	File          FileOptions          `mapstructure:"file"`
+	EncryptedFile EncryptedFileOptions `mapstructure:"encrypted-file"`
+	Vault         VaultOptions         `mapstructure:"vault"`
+}
+
+type FileOptions struct {
+	// Dir holds one file per secret, as mounted by Docker or Kubernetes.
+	Dir string `mapstructure:"dir"`
+}
+
+// New creates the provider options select, behind a Cache.
+func New(options Options) (*Cache, error) {
+	var provider Provider
+	switch options.Provider {
+	case ProviderEnv:
+		provider = EnvProvider{}
+	case ProviderFile:
+		provider = FileProvider{Dir: options.File.Dir}
+	case ProviderEncryptedFile:
+		encrypted, err := NewEncryptedFileProvider(options.EncryptedFile)
+		if err != nil {
+			return nil, err
+		}
+		provider = encrypted
+	case ProviderVault:
+		provider = NewVaultProvider(options.Vault)
+	default:
+		return nil, fmt.Errorf("unknown secret provider %q", options.Provider)
+	}
+	return NewCache(provider, options.Refresh), nil
+}
+
+// EnvProvider reads secrets from environment variables.
+type EnvProvider struct{}
+
+func (EnvProvider) Get(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// FileProvider reads secrets from the files in a directory, one per secret.
+// A trailing newline is not part of the secret.
+type FileProvider struct {
+	Dir string
+}
+
+func (f FileProvider) Get(_ context.Context, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid secret file name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: no file %s in %s", ErrNotFound, name, f.Dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,77 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type VaultOptions struct {
+	Address string `mapstructure:"address"`
+	Token   string `mapstructure:"token"`
+	// Namespace is only used by Vault Enterprise.
+	Namespace string `mapstructure:"namespace"`
+	// Mount is where the KV version 2 engine is mounted.
+	Mount string `mapstructure:"mount"`
+	// Path is the secret whose keys are the secret names.
+	Path    string        `mapstructure:"path"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// VaultProvider reads secrets from the keys of a secret in the HashiCorp
+// Vault KV version 2 engine.
+type VaultProvider struct {
+	options VaultOptions
+	client  *http.Client
+}
+
+func NewVaultProvider(options VaultOptions) *VaultProvider {
+	return &VaultProvider{options: options, client: &http.Client{Timeout: options.Timeout}}
+}
+
+func (v *VaultProvider) Get(ctx context.Context, name string) (string, error) {
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s",
+		strings.TrimSuffix(v.options.Address, "/"),
+		url.PathEscape(strings.Trim(v.options.Mount, "/")),
+		strings.Trim(v.options.Path, "/"))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("X-Vault-Token", v.options.Token)
+	if v.options.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.options.Namespace)
+	}
+	response, err := v.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("read vault secret: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: no vault secret %s/%s", ErrNotFound, v.options.Mount, v.options.Path)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return "", fmt.Errorf("read vault secret: status %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decode vault secret: %w", err)
+	}
+	value, ok := secret.Data.Data[name].(string)
+	if !ok {
+		return "", fmt.Errorf("%w: vault secret %s/%s has no %s", ErrNotFound, v.options.Mount, v.options.Path, name)
+	}
+	return value, nil
+}
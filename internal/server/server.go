@@ -0,0 +1,120 @@
+// Package server builds the HTTP server of the API from the configuration.
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Options configure the HTTP server.
+type Options struct {
+	Address           string        `mapstructure:"address"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read-header-timeout"`
+	ReadTimeout       time.Duration `mapstructure:"read-timeout"`
+	// WriteTimeout bounds writing a response. Streaming handlers lift it
+	// for their own responses.
+	WriteTimeout    time.Duration `mapstructure:"write-timeout"`
+	IdleTimeout     time.Duration `mapstructure:"idle-timeout"`
+	MaxHeaderBytes  int           `mapstructure:"max-header-bytes"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout"`
+	TLS             TLSOptions    `mapstructure:"tls"`
+}
+
+// TLSOptions enable TLS when CertFile and KeyFile are set. With ClientCAFile
+// set as well, clients must present a certificate signed by that CA.
+type TLSOptions struct {
+	CertFile     string `mapstructure:"cert-file"`
+	KeyFile      string `mapstructure:"key-file"`
+	ClientCAFile string `mapstructure:"client-ca-file"`
+}
+
+// Enabled reports whether both the certificate and its key are set.
+func (t TLSOptions) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// New returns a server for handler. The certificate is loaded here, so a
+// bad TLS setup fails the start instead of the first handshake.
+func New(handler http.Handler, options Options) (*http.Server, error) {
+	if options.Address == "" {
+		return nil, errors.New("server address is not set")
+	}
+	s := &http.Server{
+		Addr:              options.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: options.ReadHeaderTimeout,
+		ReadTimeout:       options.ReadTimeout,
+		WriteTimeout:      options.WriteTimeout,
+		IdleTimeout:       options.IdleTimeout,
+		MaxHeaderBytes:    options.MaxHeaderBytes,
+	}
+	if options.TLS.Enabled() {
+		config, err := tlsConfig(options.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.TLSConfig = config
+	}
+	return s, nil
+}
+
+func tlsConfig(options TLSOptions) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if options.ClientCAFile != "" {
+		pem, err := os.ReadFile(options.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in client CA %s", options.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ListenAndServe listens on the address of the server and serves.
+func ListenAndServe(s *http.Server) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return Serve(s, listener)
+}
+
+// Serve serves with TLS when the server has a TLS config. It returns nil
+// once the server was shut down.
+func Serve(s *http.Server, listener net.Listener) error {
+	var err error
+	if s.TLSConfig != nil {
+		// The certificate is in the TLS config already.
+		err = s.ServeTLS(listener, "", "")
+	} else {
+		err = s.Serve(listener)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// LiftWriteDeadline removes the write timeout for the response w belongs
+// to, for responses that stream for longer than it.
+func LiftWriteDeadline(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
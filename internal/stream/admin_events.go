@@ -0,0 +1,187 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/keycloakadminclient"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminEventPageSize bounds how many admin events are read per poll; bursts
+// larger than that between two polls are partly missed.
+const adminEventPageSize = 500
+
+var adminResourceTypes = []string{"USER", "GROUP", "GROUP_MEMBERSHIP", "REALM_ROLE", "REALM_ROLE_MAPPING"}
+
+// AdminEventPoller turns the admin events Keycloak records into identity
+// change events, which covers changes made in the admin console or by other
+// clients. The realm must have admin events enabled.
+type AdminEventPoller struct {
+	service  keycloak.EventService
+	interval time.Duration
+	handler  event.Handler
+	// mutex serializes the polls, so an event is handed over once.
+	mutex sync.Mutex
+	// since is the time of the newest event handled so far, seen the keys of
+	// the events at that exact time.
+	since int64
+	seen  map[string]bool
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewAdminEventPoller polls every interval and hands the events recorded
+// after it started to handler.
+func NewAdminEventPoller(service keycloak.EventService, interval time.Duration, handler event.Handler) *AdminEventPoller {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	p := &AdminEventPoller{
+		service:  service,
+		interval: interval,
+		handler:  handler,
+		since:    time.Now().UnixMilli(),
+		seen:     map[string]bool{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *AdminEventPoller) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+}
+
+func (p *AdminEventPoller) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Poll()
+		}
+	}
+}
+
+// Poll hands the admin events recorded since the previous poll to the
+// handler, oldest first. Concurrent polls run one after the other.
+func (p *AdminEventPoller) Poll() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	// Keycloak filters on dates only, and in its own time zone.
+	dateFrom := time.UnixMilli(p.since).UTC().Add(-24 * time.Hour).Format("2006-01-02")
+	events, _, err := p.service.ListAdminEvents(context.Background(), &keycloak.AdminEventQuery{
+		ResourceTypes: adminResourceTypes,
+		DateFrom:      dateFrom,
+		Max:           adminEventPageSize,
+	})
+	if err != nil {
+		slog.Warn("failed to poll admin events", "error", err)
+		return
+	}
+	adminEvents := *events
+	sort.SliceStable(adminEvents, func(i, j int) bool {
+		return adminEvents[i].GetTime() < adminEvents[j].GetTime()
+	})
+	for _, adminEvent := range adminEvents {
+		at := adminEvent.GetTime()
+		key := adminEvent.GetOperationType() + " " + adminEvent.GetResourcePath()
+		if at < p.since || (at == p.since && p.seen[key]) {
+			continue
+		}
+		if at > p.since {
+			p.since = at
+			p.seen = map[string]bool{}
+		}
+		p.seen[key] = true
+		if e, ok := AdminEventToEvent(&adminEvent); ok {
+			p.handler(e)
+		}
+	}
+}
+
+// AdminEventToEvent maps a Keycloak admin event onto the identity change
+// event this API publishes for the same change.
+func AdminEventToEvent(adminEvent *keycloakadminclient.AdminEventRepresentation) (event.Event, bool) {
+	if adminEvent.GetError() != "" {
+		return event.Event{}, false
+	}
+	path := strings.Split(strings.Trim(adminEvent.GetResourcePath(), "/"), "/")
+	operation := adminEvent.GetOperationType()
+	var eventType, subject string
+	var data interface{}
+	if representation := adminEvent.GetRepresentation(); representation != "" && json.Valid([]byte(representation)) {
+		data = json.RawMessage(representation)
+	}
+
+	switch adminEvent.GetResourceType() {
+	case "USER":
+		if len(path) != 2 || path[0] != "users" {
+			return event.Event{}, false
+		}
+		subject = path[1]
+		eventType = map[string]string{"CREATE": event.UserCreated, "UPDATE": event.UserUpdated, "DELETE": event.UserDeleted}[operation]
+		var user struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if eventType == event.UserUpdated && data != nil && json.Unmarshal([]byte(adminEvent.GetRepresentation()), &user) == nil && user.Enabled != nil && !*user.Enabled {
+			eventType = event.UserDisabled
+		}
+	case "GROUP_MEMBERSHIP":
+		if len(path) != 4 || path[0] != "users" || path[2] != "groups" {
+			return event.Event{}, false
+		}
+		subject = path[1]
+		eventType = map[string]string{"CREATE": event.UserJoinedGroup, "DELETE": event.UserLeftGroup}[operation]
+		data = map[string]string{"groupId": path[3]}
+	case "REALM_ROLE_MAPPING":
+		if len(path) < 2 || path[0] != "users" {
+			return event.Event{}, false
+		}
+		subject = path[1]
+		eventType = map[string]string{"CREATE": event.UserRolesAdded, "DELETE": event.UserRolesRemoved}[operation]
+	case "GROUP":
+		if len(path) != 2 || path[0] != "groups" {
+			return event.Event{}, false
+		}
+		subject = path[1]
+		eventType = map[string]string{"CREATE": event.GroupCreated, "UPDATE": event.GroupUpdated, "DELETE": event.GroupDeleted}[operation]
+	case "REALM_ROLE":
+		if len(path) != 2 || (path[0] != "roles" && path[0] != "roles-by-id") {
+			return event.Event{}, false
+		}
+		subject = path[1]
+		// The events of this API are about role ids, while Keycloak records
+		// the changes made by name under the name, e.g. the creates. The
+		// representation, recorded with the admin event details, has the id.
+		var role struct {
+			Id string `json:"id"`
+		}
+		if path[0] == "roles" && data != nil && json.Unmarshal([]byte(adminEvent.GetRepresentation()), &role) == nil && role.Id != "" {
+			subject = role.Id
+		}
+		eventType = map[string]string{"CREATE": event.RoleCreated, "UPDATE": event.RoleUpdated, "DELETE": event.RoleDeleted}[operation]
+	}
+	if eventType == "" {
+		return event.Event{}, false
+	}
+	e := event.New(eventType, subject, data)
+	if at := adminEvent.GetTime(); at > 0 {
+		e.Time = time.UnixMilli(at).UTC()
+	}
+	return e, true
+}
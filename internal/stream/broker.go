@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/event"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is an event with its position in the stream.
+type Entry struct {
+	// Id is <broker start>-<sequence>, so ids from before a restart are
+	// recognized as unknown instead of being mistaken for recent ones.
+	Id    string
+	Event event.Event
+}
+
+// Broker keeps the latest events and fans new ones out to the subscribers.
+type Broker struct {
+	mutex       sync.Mutex
+	epoch       string
+	sequence    uint64
+	entries     []Entry
+	size        int
+	subscribers map[*Subscription]struct{}
+	// published remembers when an event for type and subject was last
+	// handled, to drop the admin events Keycloak records for the changes
+	// this API made itself.
+	published   map[string]time.Time
+	dedupWindow time.Duration
+	closed      bool
+}
+
+// Subscription receives the events of the wanted types. C is closed when the
+// subscriber falls too far behind; it can resume with the last id it got.
+type Subscription struct {
+	C      chan Entry
+	broker *Broker
+	types  []string
+}
+
+func NewBroker(size int, dedupWindow time.Duration) *Broker {
+	if size <= 0 {
+		size = 1000
+	}
+	return &Broker{
+		epoch:       strconv.FormatInt(time.Now().UnixMilli(), 36),
+		size:        size,
+		subscribers: map[*Subscription]struct{}{},
+		published:   map[string]time.Time{},
+		dedupWindow: dedupWindow,
+	}
+}
+
+// Handle adds an event published by this API. It is meant to be subscribed
+// to the event bus.
+func (b *Broker) Handle(e event.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.published[e.Type+" "+e.Subject] = time.Now()
+	b.add(e)
+}
+
+// HandleExternal adds an event that was made outside of this API, unless
+// this API published the same change recently.
+func (b *Broker) HandleExternal(e event.Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	for key, at := range b.published {
+		if now.Sub(at) > b.dedupWindow {
+			delete(b.published, key)
+		}
+	}
+	if _, ok := b.published[e.Type+" "+e.Subject]; ok {
+		return
+	}
+	b.add(e)
+}
+
+// add appends the event and sends it to the subscribers; the caller must
+// hold the lock.
+func (b *Broker) add(e event.Event) {
+	b.sequence++
+	entry := Entry{Id: fmt.Sprintf("%s-%d", b.epoch, b.sequence), Event: e}
+	b.entries = append(b.entries, entry)
+	if len(b.entries) > b.size {
+		b.entries = append(b.entries[:0:0], b.entries[len(b.entries)-b.size:]...)
+	}
+	for subscription := range b.subscribers {
+		if !subscription.wants(e.Type) {
+			continue
+		}
+		select {
+		case subscription.C <- entry:
+		default:
+			delete(b.subscribers, subscription)
+			close(subscription.C)
+		}
+	}
+}
+
+// Subscribe returns the retained events after lastId followed by the new
+// ones. An empty or unknown lastId starts with the new events only, unless
+// lastId is from this broker and has been evicted, in which case every
+// retained event is replayed. types filters on event type; "user" matches
+// every user.* type.
+func (b *Broker) Subscribe(lastId string, types []string) (*Subscription, []Entry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscription := &Subscription{C: make(chan Entry, 256), broker: b, types: types}
+	if b.closed {
+		close(subscription.C)
+		return subscription, nil
+	}
+	b.subscribers[subscription] = struct{}{}
+
+	var backlog []Entry
+	if sequence, ok := b.parseId(lastId); ok {
+		for _, entry := range b.entries {
+			if entrySequence, _ := b.parseId(entry.Id); entrySequence > sequence && subscription.wants(entry.Event.Type) {
+				backlog = append(backlog, entry)
+			}
+		}
+	}
+	return subscription, backlog
+}
+
+func (b *Broker) parseId(id string) (uint64, bool) {
+	epoch, sequence, ok := strings.Cut(id, "-")
+	if !ok || epoch != b.epoch {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(sequence, 10, 64)
+	return parsed, err == nil && parsed <= b.sequence
+}
+
+// Close ends every subscription, so the streams return and the server can
+// shut down. Later subscriptions end right away; clients reconnect to
+// another instance with their last event id.
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	for subscription := range b.subscribers {
+		delete(b.subscribers, subscription)
+		close(subscription.C)
+	}
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.broker.mutex.Lock()
+	defer s.broker.mutex.Unlock()
+	if _, ok := s.broker.subscribers[s]; ok {
+		delete(s.broker.subscribers, s)
+		close(s.C)
+	}
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	if len(s.types) == 0 {
+		return true
+	}
+	for _, t := range s.types {
+		if t == eventType || strings.HasPrefix(eventType, t+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Package tracing sets up the OpenTelemetry tracer provider and the W3C
+// trace context propagation the handlers, services and outbound Keycloak
+// calls share.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"os"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOtlp   = "otlp"
+)
+
+// Options configure the tracer provider.
+type Options struct {
+	// Exporter is none, stdout or otlp. With none, spans are not recorded
+	// but the trace context is still propagated.
+	Exporter    string `mapstructure:"exporter"`
+	ServiceName string `mapstructure:"service-name"`
+	// SampleRatio is the fraction of new traces sampled. Traces started by
+	// a caller follow the caller's decision.
+	SampleRatio float64     `mapstructure:"sample-ratio"`
+	Otlp        OtlpOptions `mapstructure:"otlp"`
+}
+
+type OtlpOptions struct {
+	// Protocol is grpc or http.
+	Protocol string `mapstructure:"protocol"`
+	Endpoint string `mapstructure:"endpoint"`
+	Insecure bool   `mapstructure:"insecure"`
+}
+
+// Propagator extracts and injects the W3C traceparent and baggage headers.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Setup installs the global tracer provider and propagator. The returned
+// function flushes the pending spans and must be called on shutdown.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(Propagator())
+	if options.Exporter == "" || options.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(options.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, options Options) (sdktrace.SpanExporter, error) {
+	switch options.Exporter {
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOtlp:
+		switch options.Otlp.Protocol {
+		case "", "grpc":
+			clientOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Otlp.Endpoint)}
+			if options.Otlp.Insecure {
+				clientOptions = append(clientOptions, otlptracegrpc.WithInsecure())
+			}
+			return otlptracegrpc.New(ctx, clientOptions...)
+		case "http":
+			clientOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(options.Otlp.Endpoint)}
+			if options.Otlp.Insecure {
+				clientOptions = append(clientOptions, otlptracehttp.WithInsecure())
+			}
+			return otlptracehttp.New(ctx, clientOptions...)
+		}
+		return nil, fmt.Errorf("unknown otlp protocol %q", options.Otlp.Protocol)
+	}
+	return nil, fmt.Errorf("unknown tracing exporter %q", options.Exporter)
+}
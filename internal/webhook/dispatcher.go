@@ -0,0 +1,281 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderId        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature carries sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+	// keyed with the subscription secret.
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+type Options struct {
+	Workers        int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	// QueueSize bounds the deliveries waiting for a worker, 1024 by default.
+	QueueSize int
+	// AllowedNetworks may be delivered to although they are loopback,
+	// link-local or private, see CheckAddress.
+	AllowedNetworks []netip.Prefix
+}
+
+// Dispatcher delivers events to the subscriptions that want them. Failed
+// deliveries are retried with exponential backoff and end up in the dead
+// letter list once they run out of attempts. Deliveries that are waiting for
+// a retry are kept in memory only and are lost on restart, deliveries that
+// find the queue full are moved to the dead letters.
+type Dispatcher struct {
+	store   Store
+	options Options
+	client  *http.Client
+	queue   chan *Delivery
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+	// deadLetters serializes moving deliveries to and from the dead
+	// letters, so a redelivery that fails at once is not deleted with the
+	// dead letter it came from.
+	deadLetters sync.Mutex
+}
+
+func NewDispatcher(store Store, options Options) *Dispatcher {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = time.Second
+	}
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = options.InitialBackoff
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 1024
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl(options.AllowedNetworks),
+	}).DialContext
+	d := &Dispatcher{
+		store:   store,
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout, Transport: transport},
+		queue:   make(chan *Delivery, options.QueueSize),
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < options.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Handle queues a delivery of e for every subscription that wants it. It
+// is meant to be subscribed to the event bus.
+func (d *Dispatcher) Handle(e event.Event) {
+	subscriptions, err := d.store.ListSubscriptions()
+	if err != nil {
+		slog.Error("failed to list webhook subscriptions", "error", err)
+		return
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.Wants(e.Type) {
+			continue
+		}
+		d.schedule(&Delivery{
+			Id:             str.NewUUID(),
+			SubscriptionId: subscription.Id,
+			Event:          e,
+			CreatedAt:      time.Now().UTC(),
+		})
+	}
+}
+
+// CheckURL tells whether deliveries may be sent to rawURL, see CheckURL.
+func (d *Dispatcher) CheckURL(ctx context.Context, rawURL string) error {
+	return CheckURL(ctx, rawURL, d.options.AllowedNetworks)
+}
+
+// Redeliver moves a dead letter back to the queue with a fresh set of
+// attempts. The dead letter is kept when the queue is full.
+func (d *Dispatcher) Redeliver(id string) (*Delivery, error) {
+	d.deadLetters.Lock()
+	defer d.deadLetters.Unlock()
+	delivery, err := d.store.GetDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+	redelivery := *delivery
+	redelivery.Attempts = 0
+	if !d.enqueue(&redelivery) {
+		return nil, ErrQueueFull
+	}
+	if err := d.store.DeleteDeadLetter(id); err != nil {
+		return nil, err
+	}
+	return &redelivery, nil
+}
+
+// Stop waits for in-flight deliveries and drops the ones waiting for a retry.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() {
+		close(d.stop)
+	})
+	d.wg.Wait()
+}
+
+// enqueue never blocks the caller, it reports false when the queue is full
+// or the dispatcher stopped.
+func (d *Dispatcher) enqueue(delivery *Delivery) bool {
+	select {
+	case <-d.stop:
+		return false
+	default:
+	}
+	select {
+	case d.queue <- delivery:
+		return true
+	default:
+		return false
+	}
+}
+
+// schedule queues delivery, or moves it to the dead letters when the queue
+// is full.
+func (d *Dispatcher) schedule(delivery *Delivery) {
+	if d.enqueue(delivery) {
+		return
+	}
+	select {
+	case <-d.stop:
+		// Dropped like the deliveries waiting for a retry.
+		return
+	default:
+	}
+	delivery.LastError = ErrQueueFull.Error()
+	d.deadLetter(delivery)
+}
+
+func (d *Dispatcher) deadLetter(delivery *Delivery) {
+	d.deadLetters.Lock()
+	defer d.deadLetters.Unlock()
+	if err := d.store.SaveDeadLetter(delivery); err != nil {
+		slog.Error("failed to store webhook dead letter", "delivery", delivery.Id, "error", err)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) attempt(delivery *Delivery) {
+	subscription, err := d.store.GetSubscription(delivery.SubscriptionId)
+	if err != nil {
+		// The subscription was deleted while the delivery was pending.
+		return
+	}
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.LastStatusCode, err = d.send(subscription, delivery)
+	if err == nil {
+		return
+	}
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= d.options.MaxAttempts {
+		d.deadLetter(delivery)
+		return
+	}
+	time.AfterFunc(d.backoff(delivery.Attempts), func() {
+		d.schedule(delivery)
+	})
+}
+
+// backoff doubles the delay after every attempt, up to MaxBackoff, and adds
+// up to 50% jitter so failing receivers are not hit in lockstep.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.options.InitialBackoff
+	for i := 1; i < attempts && delay < d.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.options.MaxBackoff {
+		delay = d.options.MaxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+func (d *Dispatcher) send(subscription *Subscription, delivery *Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "arch-go-webhooks")
+	request.Header.Set(HeaderId, delivery.Id)
+	request.Header.Set(HeaderEvent, delivery.Event.Type)
+	request.Header.Set(HeaderTimestamp, timestamp)
+	request.Header.Set(HeaderSignature, "sha256="+Sign(subscription.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// Sign computes the hex encoded signature receivers use to verify a delivery.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
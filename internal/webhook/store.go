@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+type memoryStore struct {
+	mutex         sync.RWMutex
+	subscriptions map[string]Subscription
+	deadLetters   map[string]Delivery
+	// path is where the store is persisted after every change, if set.
+	path string
+}
+
+// NewMemoryStore returns a Store local to this process.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		subscriptions: map[string]Subscription{},
+		deadLetters:   map[string]Delivery{},
+	}
+}
+
+// NewFileStore returns a Store that is kept in memory and written to a JSON
+// file on every change, so subscriptions and dead letters survive restarts.
+func NewFileStore(path string) (Store, error) {
+	store := &memoryStore{
+		subscriptions: map[string]Subscription{},
+		deadLetters:   map[string]Delivery{},
+		path:          path,
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, os.MkdirAll(filepath.Dir(path), 0o755)
+	} else if err != nil {
+		return nil, err
+	}
+	var snapshot fileSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("read webhook store %s: %w", path, err)
+	}
+	for _, subscription := range snapshot.Subscriptions {
+		store.subscriptions[subscription.Id] = subscription
+	}
+	for _, delivery := range snapshot.DeadLetters {
+		store.deadLetters[delivery.Id] = delivery
+	}
+	return store, nil
+}
+
+type fileSnapshot struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+	DeadLetters   []Delivery     `json:"deadLetters"`
+}
+
+// persist writes the store to its file; the caller must hold the lock.
+func (m *memoryStore) persist() error {
+	if m.path == "" {
+		return nil
+	}
+	snapshot := fileSnapshot{
+		Subscriptions: m.listSubscriptions(),
+		DeadLetters:   m.listDeadLetters(),
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func (m *memoryStore) SaveSubscription(subscription *Subscription) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.subscriptions[subscription.Id] = *subscription
+	return m.persist()
+}
+
+func (m *memoryStore) GetSubscription(id string) (*Subscription, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	subscription, ok := m.subscriptions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &subscription, nil
+}
+
+func (m *memoryStore) ListSubscriptions() ([]Subscription, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.listSubscriptions(), nil
+}
+
+func (m *memoryStore) listSubscriptions() []Subscription {
+	subscriptions := make([]Subscription, 0, len(m.subscriptions))
+	for _, subscription := range m.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions
+}
+
+func (m *memoryStore) DeleteSubscription(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.subscriptions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.subscriptions, id)
+	return m.persist()
+}
+
+func (m *memoryStore) SaveDeadLetter(delivery *Delivery) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.deadLetters[delivery.Id] = *delivery
+	return m.persist()
+}
+
+func (m *memoryStore) GetDeadLetter(id string) (*Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	delivery, ok := m.deadLetters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &delivery, nil
+}
+
+func (m *memoryStore) ListDeadLetters() ([]Delivery, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.listDeadLetters(), nil
+}
+
+func (m *memoryStore) listDeadLetters() []Delivery {
+	deliveries := make([]Delivery, 0, len(m.deadLetters))
+	for _, delivery := range m.deadLetters {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
+
+func (m *memoryStore) DeleteDeadLetter(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.deadLetters[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.deadLetters, id)
+	return m.persist()
+}
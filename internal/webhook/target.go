@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"syscall"
+)
+
+// blockedNetworks are refused as delivery targets, they reach this host or
+// services behind the firewall rather than a receiver on the internet.
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+}
+
+// ParseNetworks parses CIDRs such as 10.1.0.0/16.
+func ParseNetworks(cidrs []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// CheckAddress refuses loopback, link-local and private addresses unless
+// one of the allowed networks contains them.
+func CheckAddress(ip netip.Addr, allowed []netip.Prefix) error {
+	ip = ip.Unmap()
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return fmt.Errorf("address %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+// CheckURL resolves the host of rawURL and checks every address it resolves
+// to.
+func CheckURL(ctx context.Context, rawURL string, allowed []netip.Prefix) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := target.Hostname()
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return CheckAddress(ip, allowed)
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if err := CheckAddress(ip, allowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dialControl checks the address a delivery connects to, which may differ
+// from the one CheckURL saw when the host resolves differently by now.
+func dialControl(allowed []netip.Prefix) func(network, address string, _ syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		return CheckAddress(addrPort.Addr(), allowed)
+	}
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"errors"
+	"github.com/miguoliang/arch-go/internal/event"
+	"time"
+)
+
+// AllEvents subscribes to every event type.
+const AllEvents = "*"
+
+var ErrNotFound = errors.New("not found")
+
+// ErrQueueFull is returned when a redelivery finds the queue full, and is
+// the error of deliveries moved to the dead letters for it.
+var ErrQueueFull = errors.New("webhook queue is full")
+
+// Subscription asks for events of the given types to be POSTed to URL.
+type Subscription struct {
+	Id         string    `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Redacted returns a copy without the secret, for API responses.
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	return s
+}
+
+// Wants reports whether the subscription receives events of eventType.
+func (s *Subscription) Wants(eventType string) bool {
+	for _, t := range s.EventTypes {
+		if t == AllEvents || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an attempt to deliver one event to one subscription.
+type Delivery struct {
+	Id             string      `json:"id"`
+	SubscriptionId string      `json:"subscriptionId"`
+	Event          event.Event `json:"event"`
+	Attempts       int         `json:"attempts"`
+	LastStatusCode int         `json:"lastStatusCode,omitempty"`
+	LastError      string      `json:"lastError,omitempty"`
+	CreatedAt      time.Time   `json:"createdAt"`
+	LastAttemptAt  *time.Time  `json:"lastAttemptAt,omitempty"`
+}
+
+// Store keeps subscriptions and the deliveries that ran out of attempts.
+type Store interface {
+	SaveSubscription(subscription *Subscription) error
+	GetSubscription(id string) (*Subscription, error)
+	ListSubscriptions() ([]Subscription, error)
+	DeleteSubscription(id string) error
+	SaveDeadLetter(delivery *Delivery) error
+	GetDeadLetter(id string) (*Delivery, error)
+	ListDeadLetters() ([]Delivery, error)
+	DeleteDeadLetter(id string) error
+}
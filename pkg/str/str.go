@@ -1,7 +1,9 @@
 package str
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"strings"
 )
 
@@ -17,3 +19,14 @@ func StructToJson(o interface{}) string {
 func StructToJsonReader(o interface{}) *strings.Reader {
 	return strings.NewReader(StructToJson(o))
 }
+
+// NewUUID returns a random (version 4) UUID in its canonical textual form.
+func NewUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
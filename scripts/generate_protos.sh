@@ -0,0 +1,7 @@
+#!/bin/bash
+
+# Requires buf, protoc-gen-go and protoc-gen-go-grpc on the PATH:
+#   go install github.com/bufbuild/buf/cmd/buf@v1.34.0
+#   go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.34.2
+#   go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.5.1
+buf generate api/proto --template api/proto/buf.gen.yaml
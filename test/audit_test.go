@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+	sink   *audit.FileSink
+	r      *gin.Engine
+	things map[string]map[string]interface{}
+}
+
+func (s *AuditTestSuite) SetupTest() {
+	sink, err := audit.NewFileSink(filepath.Join(s.T().TempDir(), "audit.jsonl"))
+	s.NoError(err)
+	s.sink = sink
+	s.things = map[string]map[string]interface{}{}
+
+	load := func(_ context.Context, id string) (interface{}, error) {
+		return s.things[id], nil
+	}
+	s.r = gin.New()
+	s.r.Use(requestid.Middleware())
+	s.r.Use(audit.Middleware(audit.NewRecorder(sink), map[string]audit.Resource{
+		"/things":     {Type: "thing", Load: load},
+		"/things/:id": {Type: "thing", IdParam: "id", Load: load},
+	}, "X-Authenticated-User"))
+	s.r.POST("/things", func(c *gin.Context) {
+		s.things["1"] = map[string]interface{}{"name": "a", "enabled": true}
+		c.JSON(http.StatusCreated, gin.H{"id": "1"})
+	})
+	s.r.PUT("/things/:id", func(c *gin.Context) {
+		if _, ok := s.things[c.Param("id")]; !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "thing not found"})
+			return
+		}
+		s.things[c.Param("id")]["name"] = "b"
+		c.Status(http.StatusNoContent)
+	})
+	s.r.GET("/things/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.things[c.Param("id")])
+	})
+}
+
+func (s *AuditTestSuite) TearDownTest() {
+	s.NoError(s.sink.Close())
+}
+
+func (s *AuditTestSuite) request(method string, url string, headers ...string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, strings.NewReader(`{}`))
+	req.Header.Set(requestid.Header, "req-"+method)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	s.r.ServeHTTP(w, req)
+}
+
+func (s *AuditTestSuite) TestRecordCreateAndUpdate() {
+
+	s.request("POST", "/things")
+	s.request("PUT", "/things/1")
+	s.request("GET", "/things/1")
+
+	entries, err := s.sink.Query(&audit.Filter{ResourceId: "1"})
+	s.NoError(err)
+	s.Len(entries, 2)
+
+	update := entries[0]
+	s.Equal("PUT", update.Method)
+	s.Equal("/things/:id", update.Route)
+	s.Equal("req-PUT", update.RequestId)
+	s.Equal(audit.OutcomeSuccess, update.Outcome)
+	s.Equal([]audit.Change{{Path: "name", Before: "a", After: "b"}}, update.Changes)
+
+	create := entries[1]
+	s.Equal("thing", create.ResourceType)
+	s.Nil(create.Before)
+	s.JSONEq(`{"name":"a","enabled":true}`, string(create.After))
+}
+
+func (s *AuditTestSuite) TestRecordFailure() {
+
+	s.request("PUT", "/things/2")
+
+	entries, err := s.sink.Query(&audit.Filter{Outcome: audit.OutcomeFailure})
+	s.NoError(err)
+	s.Len(entries, 1)
+	s.Equal(http.StatusNotFound, entries[0].StatusCode)
+	s.Equal("thing not found", entries[0].Error)
+	s.Empty(entries[0].Changes)
+}
+
+func (s *AuditTestSuite) TestAttribution() {
+
+	forged := bearer(map[string]string{"sub": "admin", "preferred_username": "admin"})
+	s.request("POST", "/things", "Authorization", forged)
+	s.request("PUT", "/things/1", "Authorization", forged, "X-Authenticated-User", "alice")
+
+	entries, err := s.sink.Query(&audit.Filter{ResourceId: "1"})
+	s.NoError(err)
+	s.Len(entries, 2)
+	s.Equal(audit.Attribution{Caller: "header:alice", ClaimedSubject: "admin", ClaimedUsername: "admin"}, entries[0].Attribution)
+	s.Equal(audit.Attribution{Caller: "ip:", ClaimedSubject: "admin", ClaimedUsername: "admin"}, entries[1].Attribution)
+}
+
+func (s *AuditTestSuite) TestDiff() {
+
+	changes := audit.Diff(
+		json.RawMessage(`{"a":1,"b":{"c":[1,2]},"d":"x"}`),
+		json.RawMessage(`{"a":1,"b":{"c":[1,3]},"e":true}`),
+	)
+	s.Equal([]audit.Change{
+		{Path: "b.c[1]", Before: float64(2), After: float64(3)},
+		{Path: "d", Before: "x"},
+		{Path: "e", After: true},
+	}, changes)
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type BatchTestSuite struct {
+	Suite
+}
+
+func (s *BatchTestSuite) TestBatchWithReferencesSucceed() {
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{
+			{Ref: "user", Op: "createUser", Body: json.RawMessage(`{"username":"batch-user"}`)},
+			{Ref: "group", Op: "createGroup", Body: json.RawMessage(`{"name":"batch-group"}`)},
+			{Op: "joinGroup", Params: map[string]string{"userId": "${user.id}", "groupId": "${group.id}"}},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Len(response.Results, 3)
+	s.Equal(http.StatusCreated, response.Results[0].StatusCode)
+	s.Equal(http.StatusCreated, response.Results[1].StatusCode)
+	s.Equal(http.StatusNoContent, response.Results[2].StatusCode)
+
+	userId := response.Results[0].Body.(map[string]interface{})["id"].(string)
+	w = s.Get("/api/v1/users/" + userId + "/groups")
+	s.Equal(http.StatusOK, w.Code)
+	s.Contains(w.Body.String(), "batch-group")
+}
+
+func (s *BatchTestSuite) TestBatchStopOnError() {
+
+	request := dto.BatchRequest{
+		StopOnError: true,
+		Operations: []dto.BatchOperation{
+			{Op: "deleteUser", Params: map[string]string{"userId": "not-exist"}},
+			{Op: "createGroup", Body: json.RawMessage(`{"name":"never-created"}`)},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Equal(http.StatusNotFound, response.Results[0].StatusCode)
+	s.True(response.Results[1].Skipped)
+}
+
+func (s *BatchTestSuite) TestBatchUnknownReference() {
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{
+			{Op: "deleteGroup", Params: map[string]string{"groupId": "${missing.id}"}},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Equal(http.StatusBadRequest, response.Results[0].StatusCode)
+}
+
+func (s *BatchTestSuite) TestBatchBadRequestWhenOpIsUnknown() {
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{{Op: "dropRealm"}},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *BatchTestSuite) TestBatchRequiresParams() {
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{
+			{Op: "deleteUser", Params: map[string]string{"userId": ""}},
+			{Op: "joinGroup", Params: map[string]string{"userId": "someone"}},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Equal(http.StatusBadRequest, response.Results[0].StatusCode)
+	s.Contains(response.Results[0].Body.(map[string]interface{})["message"], "userId")
+	s.Equal(http.StatusBadRequest, response.Results[1].StatusCode)
+	s.Contains(response.Results[1].Body.(map[string]interface{})["message"], "groupId")
+}
+
+func (s *BatchTestSuite) TestBatchDisableUserPublishesEvent() {
+	var mutex sync.Mutex
+	var disabled []string
+	resource.Events.Subscribe(func(e event.Event) {
+		if e.Type == event.UserDisabled {
+			mutex.Lock()
+			defer mutex.Unlock()
+			disabled = append(disabled, e.Subject)
+		}
+	})
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{
+			{Ref: "user", Op: "createUser", Body: json.RawMessage(`{"username":"batch-disabled","enabled":true}`)},
+			{Op: "updateUser", Params: map[string]string{"userId": "${user.id}"}, Body: json.RawMessage(`{"enabled":false}`)},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	s.Less(response.Results[1].StatusCode, 300)
+
+	userId := response.Results[0].Body.(map[string]interface{})["id"].(string)
+	mutex.Lock()
+	defer mutex.Unlock()
+	s.Equal([]string{userId}, disabled)
+}
+
+func (s *BatchTestSuite) TestBatchAuditsEachOperation() {
+	sink, err := audit.NewFileSink(filepath.Join(s.T().TempDir(), "audit.jsonl"))
+	s.NoError(err)
+	defer sink.Close()
+	recorder := resource.Audit
+	resource.Audit = audit.NewRecorder(sink)
+	defer func() { resource.Audit = recorder }()
+
+	request := dto.BatchRequest{
+		Operations: []dto.BatchOperation{
+			{Ref: "user", Op: "createUser", Body: json.RawMessage(`{"username":"batch-audited","enabled":true}`)},
+			{Op: "updateUser", Params: map[string]string{"userId": "${user.id}"}, Body: json.RawMessage(`{"enabled":false}`)},
+			{Op: "deleteGroup", Params: map[string]string{"groupId": "not-exist"}},
+		},
+	}
+	w := s.Post("/api/v1/batch", request)
+	s.Equal(http.StatusOK, w.Code)
+	var response dto.BatchResponse
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	userId := response.Results[0].Body.(map[string]interface{})["id"].(string)
+
+	entries, err := sink.Query(&audit.Filter{ResourceId: userId})
+	s.NoError(err)
+	s.Len(entries, 2)
+	update, create := entries[0], entries[1]
+	s.Equal("PUT", update.Method)
+	s.Equal("/api/v1/users/:id", update.Route)
+	s.Equal("/api/v1/batch#operations[1].updateUser", update.Path)
+	s.Equal("user", update.ResourceType)
+	s.Equal(audit.OutcomeSuccess, update.Outcome)
+	s.Contains(update.Changes, audit.Change{Path: "enabled", Before: true, After: false})
+	s.Equal("POST", create.Method)
+	s.Nil(create.Before)
+	s.NotNil(create.After)
+
+	entries, err = sink.Query(&audit.Filter{ResourceId: "not-exist"})
+	s.NoError(err)
+	s.Len(entries, 1)
+	s.Equal("group", entries[0].ResourceType)
+	s.Equal(audit.OutcomeFailure, entries[0].Outcome)
+	s.Equal(http.StatusNotFound, entries[0].StatusCode)
+}
+
+func TestBatchTestSuite(t *testing.T) {
+	suite.Run(t, new(BatchTestSuite))
+}
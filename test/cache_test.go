@@ -0,0 +1,262 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countedRoles answers the role lookups from memory and counts them.
+type countedRoles struct {
+	keycloak.RoleService
+	lookups atomic.Int32
+	// release, when set, holds the lookups until it is closed.
+	release chan struct{}
+	roles   []keycloakadminclient.RoleRepresentation
+}
+
+func (r *countedRoles) ListRoles(_ context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	r.lookups.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	roles := append([]keycloakadminclient.RoleRepresentation{}, r.roles...)
+	return &roles, http.StatusOK, nil
+}
+
+func (r *countedRoles) GetRoleByName(_ context.Context, roleName string) (*keycloakadminclient.RoleRepresentation, int, error) {
+	r.lookups.Add(1)
+	for _, role := range r.roles {
+		if role.GetName() == roleName {
+			return &role, http.StatusOK, nil
+		}
+	}
+	return nil, http.StatusNotFound, errors.New("role not found")
+}
+
+func (r *countedRoles) CreateRole(_ context.Context, role *keycloakadminclient.RoleRepresentation) (string, int, error) {
+	r.roles = append(r.roles, *role)
+	return role.GetName(), http.StatusCreated, nil
+}
+
+// groupedUsers gives the users the roles of the groups they are in, as
+// Keycloak does.
+type groupedUsers struct {
+	keycloak.UserService
+	groupRoles map[string][]keycloakadminclient.RoleRepresentation
+	members    map[string]string
+}
+
+func (u *groupedUsers) JoinGroup(_ context.Context, userId string, groupId string) (int, error) {
+	u.members[userId] = groupId
+	return http.StatusNoContent, nil
+}
+
+func (u *groupedUsers) LeaveGroup(_ context.Context, userId string, _ string) (int, error) {
+	delete(u.members, userId)
+	return http.StatusNoContent, nil
+}
+
+func (u *groupedUsers) ListEffectiveRoles(_ context.Context, userId string) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	roles := append([]keycloakadminclient.RoleRepresentation{}, u.groupRoles[u.members[userId]]...)
+	return &roles, http.StatusOK, nil
+}
+
+type CacheTestSuite struct {
+	suite.Suite
+	ctx context.Context
+}
+
+func (s *CacheTestSuite) SetupTest() {
+	s.ctx = context.Background()
+}
+
+func (s *CacheTestSuite) TestMemoryStoreEvictsLeastRecentlyUsed() {
+	store := cache.NewMemoryStore(2)
+	s.NoError(store.Set(s.ctx, "a", []byte("1"), 0))
+	s.NoError(store.Set(s.ctx, "b", []byte("2"), 0))
+	_, ok, _ := store.Get(s.ctx, "a")
+	s.True(ok)
+	s.NoError(store.Set(s.ctx, "c", []byte("3"), 0))
+
+	s.Equal(2, store.Len())
+	_, ok, _ = store.Get(s.ctx, "b")
+	s.False(ok)
+	_, ok, _ = store.Get(s.ctx, "a")
+	s.True(ok)
+}
+
+func (s *CacheTestSuite) TestMemoryStoreExpires() {
+	store := cache.NewMemoryStore(0)
+	s.NoError(store.Set(s.ctx, "a", []byte("1"), 20*time.Millisecond))
+	_, ok, _ := store.Get(s.ctx, "a")
+	s.True(ok)
+	time.Sleep(40 * time.Millisecond)
+	_, ok, _ = store.Get(s.ctx, "a")
+	s.False(ok)
+
+	added, err := store.Add(s.ctx, "a", []byte("2"))
+	s.NoError(err)
+	s.True(added)
+	added, _ = store.Add(s.ctx, "a", []byte("3"))
+	s.False(added)
+}
+
+func (s *CacheTestSuite) TestConcurrentLookupsLoadOnce() {
+	roles := &countedRoles{release: make(chan struct{}), roles: []keycloakadminclient.RoleRepresentation{{Name: str.Ptr("editor")}}}
+	service := keycloak.CacheRoleService(roles, cache.NewWithStore(cache.NewMemoryStore(0)), time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			list, statusCode, err := service.ListRoles(s.ctx)
+			s.NoError(err)
+			s.Equal(http.StatusOK, statusCode)
+			s.Len(*list, 1)
+		}()
+	}
+	s.Eventually(func() bool { return roles.lookups.Load() == 1 }, time.Second, time.Millisecond)
+	// Let the other lookups reach the load in progress before it finishes.
+	time.Sleep(20 * time.Millisecond)
+	close(roles.release)
+	wg.Wait()
+
+	s.Equal(int32(1), roles.lookups.Load())
+	_, _, err := service.ListRoles(s.ctx)
+	s.NoError(err)
+	s.Equal(int32(1), roles.lookups.Load())
+}
+
+func (s *CacheTestSuite) TestWritesInvalidate() {
+	roles := &countedRoles{}
+	service := keycloak.CacheRoleService(roles, cache.NewWithStore(cache.NewMemoryStore(0)), time.Minute)
+
+	list, _, err := service.ListRoles(s.ctx)
+	s.NoError(err)
+	s.Empty(*list)
+	// Failures are not kept.
+	_, statusCode, err := service.GetRoleByName(s.ctx, "editor")
+	s.Error(err)
+	s.Equal(http.StatusNotFound, statusCode)
+
+	_, _, err = service.CreateRole(s.ctx, &keycloakadminclient.RoleRepresentation{Name: str.Ptr("editor")})
+	s.NoError(err)
+	list, _, err = service.ListRoles(s.ctx)
+	s.NoError(err)
+	s.Len(*list, 1)
+	role, _, err := service.GetRoleByName(s.ctx, "editor")
+	s.NoError(err)
+	s.Equal("editor", role.GetName())
+	s.Equal(int32(4), roles.lookups.Load())
+}
+
+func (s *CacheTestSuite) TestGroupMembershipInvalidatesEffectiveRoles() {
+	users := &groupedUsers{
+		groupRoles: map[string][]keycloakadminclient.RoleRepresentation{"staff": {{Name: str.Ptr("editor")}}},
+		members:    map[string]string{},
+	}
+	service := keycloak.CacheUserService(users, cache.NewWithStore(cache.NewMemoryStore(0)), time.Minute)
+
+	roles, _, err := service.ListEffectiveRoles(s.ctx, "alice")
+	s.NoError(err)
+	s.Empty(*roles)
+
+	_, err = service.JoinGroup(s.ctx, "alice", "staff")
+	s.NoError(err)
+	roles, _, err = service.ListEffectiveRoles(s.ctx, "alice")
+	s.NoError(err)
+	s.Require().Len(*roles, 1)
+	s.Equal("editor", (*roles)[0].GetName())
+
+	_, err = service.LeaveGroup(s.ctx, "alice", "staff")
+	s.NoError(err)
+	roles, _, err = service.ListEffectiveRoles(s.ctx, "alice")
+	s.NoError(err)
+	s.Empty(*roles)
+}
+
+func (s *CacheTestSuite) TestAdminEventsInvalidate() {
+	c := cache.NewWithStore(cache.NewMemoryStore(0))
+	roles := &countedRoles{}
+	service := keycloak.CacheRoleService(roles, c, time.Minute)
+
+	_, _, err := service.ListRoles(s.ctx)
+	s.NoError(err)
+	// Made in Keycloak, not through the service.
+	roles.roles = append(roles.roles, keycloakadminclient.RoleRepresentation{Name: str.Ptr("auditor")})
+	list, _, _ := service.ListRoles(s.ctx)
+	s.Empty(*list)
+
+	keycloak.InvalidateCache(c)(event.Event{Type: event.RoleCreated, Subject: "auditor"})
+	list, _, _ = service.ListRoles(s.ctx)
+	s.Len(*list, 1)
+}
+
+func (s *CacheTestSuite) TestRedisStore() {
+	server := miniredis.RunT(s.T())
+	c, err := cache.New(cache.Options{Backend: cache.BackendRedis, Redis: cache.RedisOptions{Address: server.Addr(), KeyPrefix: "cache-test:"}})
+	s.Require().NoError(err)
+	defer c.Close()
+	roles := &countedRoles{roles: []keycloakadminclient.RoleRepresentation{{Name: str.Ptr("editor")}}}
+	service := keycloak.CacheRoleService(roles, c, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		role, _, err := service.GetRoleByName(s.ctx, "editor")
+		s.NoError(err)
+		s.Equal("editor", role.GetName())
+	}
+	s.Equal(int32(1), roles.lookups.Load())
+	s.True(server.Exists("cache-test:generation:roles"))
+
+	server.FastForward(2 * time.Minute)
+	_, _, err = service.GetRoleByName(s.ctx, "editor")
+	s.NoError(err)
+	s.Equal(int32(2), roles.lookups.Load())
+
+	// Without Redis the lookups go to Keycloak.
+	server.Close()
+	role, _, err := service.GetRoleByName(s.ctx, "editor")
+	s.NoError(err)
+	s.Equal("editor", role.GetName())
+	s.Equal(int32(3), roles.lookups.Load())
+}
+
+func (s *CacheTestSuite) TestLookupMetrics() {
+	// The counters are global, the namespace keeps them apart from the
+	// other runs.
+	namespace := "cache-test-" + str.NewUUID()
+	c := cache.NewWithStore(cache.NewMemoryStore(0))
+	load := func(context.Context) (string, int, error) { return "value", http.StatusOK, nil }
+	for i := 0; i < 3; i++ {
+		value, _, err := cache.Load(s.ctx, c, namespace, "key", time.Minute, load)
+		s.NoError(err)
+		s.Equal("value", value)
+	}
+	c.Invalidate(s.ctx, namespace)
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/prometheus", nil))
+	body := recorder.Body.String()
+	s.Contains(body, `cache_lookups_total{namespace="`+namespace+`",result="hit"} 2`)
+	s.Contains(body, `cache_lookups_total{namespace="`+namespace+`",result="miss"} 1`)
+	s.Contains(body, `cache_invalidations_total{namespace="`+namespace+`"} 1`)
+}
+
+func TestCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(CacheTestSuite))
+}
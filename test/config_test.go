@@ -0,0 +1,303 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/ratelimit"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// adminPassword is the admin password, which the defaults leave out.
+const adminPassword = "ARCH_GO_KEYCLOAK_ADMIN_PASSWORD=admin"
+
+// adminPasswordYaml is the admin password in a configuration file.
+const adminPasswordYaml = "keycloak:\n  admin:\n    password: admin\n"
+
+type ConfigTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func (s *ConfigTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+}
+
+func (s *ConfigTestSuite) write(name string, content string) string {
+	path := filepath.Join(s.dir, name)
+	s.Require().NoError(os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func (s *ConfigTestSuite) TestDefaults() {
+	cfg, err := configs.Load(configs.Options{Environ: []string{adminPassword}})
+	s.Require().NoError(err)
+	s.Equal("custom", cfg.Keycloak.Custom.Realm)
+	s.Equal("admin-cli", cfg.Keycloak.Admin.ClientId)
+	s.Equal(30*time.Second, cfg.Server.ShutdownTimeout)
+	s.Equal("http://localhost:8080/auth/health/ready", cfg.KeycloakHealthURL())
+}
+
+func (s *ConfigTestSuite) TestDefaultsRequirePassword() {
+	_, err := configs.Load(configs.Options{Environ: []string{}})
+	s.ErrorContains(err, "keycloak.admin.password")
+
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileDev, Environ: []string{}})
+	s.Require().NoError(err)
+	s.Equal("admin", cfg.Keycloak.Admin.Password)
+}
+
+func (s *ConfigTestSuite) TestEnvOverridesFile() {
+	cfg, err := configs.Load(configs.Options{Environ: []string{
+		"ARCH_GO_KEYCLOAK_ADMIN_PASSWORD=from-env",
+		"ARCH_GO_JOBS_WORKERS=7",
+		"ARCH_GO_SERVER_SHUTDOWN_TIMEOUT=5s",
+		"ARCH_GO_AUDIT_SINKS=file,gelf",
+	}})
+	s.Require().NoError(err)
+	s.Equal("from-env", cfg.Keycloak.Admin.Password)
+	s.Equal(7, cfg.Jobs.Workers)
+	s.Equal(5*time.Second, cfg.Server.ShutdownTimeout)
+	s.Equal([]string{"file", "gelf"}, cfg.Audit.Sinks)
+}
+
+func (s *ConfigTestSuite) TestSecretFromFile() {
+	secret := s.write("password", "from-file\n")
+	cfg, err := configs.Load(configs.Options{Environ: []string{
+		"ARCH_GO_KEYCLOAK_ADMIN_PASSWORD=from-env",
+		"ARCH_GO_KEYCLOAK_ADMIN_PASSWORD_FILE=" + secret,
+	}})
+	s.Require().NoError(err)
+	s.Equal("from-file", cfg.Keycloak.Admin.Password)
+}
+
+func (s *ConfigTestSuite) TestMissingSecretFile() {
+	_, err := configs.Load(configs.Options{Environ: []string{
+		"ARCH_GO_KEYCLOAK_ADMIN_PASSWORD_FILE=" + filepath.Join(s.dir, "missing"),
+	}})
+	s.ErrorContains(err, "ARCH_GO_KEYCLOAK_ADMIN_PASSWORD_FILE")
+}
+
+func (s *ConfigTestSuite) TestProfile() {
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileTest, Environ: []string{}})
+	s.Require().NoError(err)
+	s.Equal(configs.ProfileTest, cfg.Profile)
+	s.Equal("memory", cfg.Jobs.Store)
+	s.Empty(cfg.Audit.Sinks)
+	s.False(cfg.Grpc.Enabled)
+}
+
+func (s *ConfigTestSuite) TestProdProfileRequiresPassword() {
+	_, err := configs.Load(configs.Options{Profile: configs.ProfileProd, Environ: []string{}})
+	s.ErrorContains(err, "keycloak.admin.password")
+
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileProd, Environ: []string{
+		"ARCH_GO_KEYCLOAK_ADMIN_PASSWORD=secret",
+	}})
+	s.Require().NoError(err)
+	s.Equal("secret", cfg.Keycloak.Admin.Password)
+}
+
+func (s *ConfigTestSuite) TestSecretsProviderReplacesPassword() {
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileProd, Environ: []string{
+		"ARCH_GO_SECRETS_PROVIDER=file",
+	}})
+	s.Require().NoError(err)
+	s.Empty(cfg.Keycloak.Admin.Password)
+	s.Equal("/run/secrets", cfg.Secrets.File.Dir)
+
+	_, err = configs.Load(configs.Options{Environ: []string{
+		"ARCH_GO_SECRETS_PROVIDER=vault",
+	}})
+	s.ErrorContains(err, "secrets.vault.token")
+}
+
+func (s *ConfigTestSuite) TestCache() {
+	cfg, err := configs.Load(configs.Options{Environ: []string{adminPassword}})
+	s.Require().NoError(err)
+	s.True(cfg.Cache.Enabled)
+	s.Equal(cache.BackendMemory, cfg.Cache.Backend)
+	s.Equal(5*time.Minute, cfg.Cache.TTL.Roles)
+
+	_, err = configs.Load(configs.Options{Environ: []string{
+		"ARCH_GO_CACHE_BACKEND=redis",
+		"ARCH_GO_CACHE_REDIS_ADDRESS=",
+	}})
+	s.ErrorContains(err, "cache.redis.address")
+}
+
+func (s *ConfigTestSuite) TestRateLimit() {
+	cfg, err := configs.Load(configs.Options{Environ: []string{adminPassword}})
+	s.Require().NoError(err)
+	s.True(cfg.RateLimit.Enabled)
+	s.Equal(ratelimit.KeyIP, cfg.RateLimit.Key)
+	s.Equal("DELETE /api/v1/users/:id", cfg.RateLimit.Routes[0].Route)
+	s.Equal(30, cfg.RateLimit.Routes[0].Requests)
+
+	file := s.write("config.yaml", `
+rate-limit:
+  routes:
+    - route: /api/v1/batch
+      requests: 0
+      period: 1m
+`)
+	_, err = configs.Load(configs.Options{File: file, Environ: []string{}})
+	s.ErrorContains(err, "rate-limit.routes[0].route")
+	s.ErrorContains(err, "rate-limit.routes[0].requests")
+
+	_, err = configs.Load(configs.Options{Environ: []string{"ARCH_GO_RATE_LIMIT_KEY=header"}})
+	s.ErrorContains(err, "rate-limit.header")
+}
+
+func (s *ConfigTestSuite) TestUnknownProfile() {
+	_, err := configs.Load(configs.Options{Profile: "staging", Environ: []string{}})
+	s.ErrorContains(err, `unknown profile "staging"`)
+}
+
+func (s *ConfigTestSuite) TestConfigFile() {
+	file := s.write("config.yaml", `
+keycloak:
+  url: https://keycloak.example.com
+  custom:
+    realm: people
+`)
+	s.write("config.dev.yaml", `
+jobs:
+  workers: 9
+`)
+	cfg, err := configs.Load(configs.Options{File: file, Profile: configs.ProfileDev, Environ: []string{adminPassword}})
+	s.Require().NoError(err)
+	s.Equal(file, cfg.File)
+	s.Equal("https://keycloak.example.com", cfg.Keycloak.URL)
+	s.Equal("people", cfg.Keycloak.Custom.Realm)
+	// The profile next to the file wins over the one built in.
+	s.Equal(9, cfg.Jobs.Workers)
+	// Keys the file leaves out keep their defaults.
+	s.Equal("admin-cli", cfg.Keycloak.Admin.ClientId)
+}
+
+func (s *ConfigTestSuite) TestValidationListsEveryError() {
+	file := s.write("config.yaml", `
+keycloak:
+  url: keycloak:8080
+jobs:
+  store: s3
+  workers: 0
+tracing:
+  sample-ratio: 2
+`)
+	_, err := configs.Load(configs.Options{File: file, Environ: []string{}})
+	s.Require().Error(err)
+	s.ErrorContains(err, "invalid configuration")
+	s.ErrorContains(err, "keycloak.url")
+	s.ErrorContains(err, "jobs.store")
+	s.ErrorContains(err, "jobs.workers")
+	s.ErrorContains(err, "tracing.sample-ratio")
+}
+
+func (s *ConfigTestSuite) TestInvalidDuration() {
+	_, err := configs.Load(configs.Options{Environ: []string{"ARCH_GO_SERVER_IDLE_TIMEOUT=soon"}})
+	s.ErrorContains(err, "decode configuration")
+}
+
+func (s *ConfigTestSuite) watch(content string) (*configs.Watcher, string) {
+	file := s.write("config.yaml", content)
+	cfg, err := configs.Load(configs.Options{File: file})
+	s.Require().NoError(err)
+	return configs.NewWatcher(cfg), file
+}
+
+func (s *ConfigTestSuite) TestReloadAppliesChanges() {
+	watcher, file := s.watch(adminPasswordYaml + "logging:\n  level: info\n")
+	var changes []string
+	watcher.OnChange(func(old *configs.Config, new *configs.Config) {
+		changes = append(changes, old.Logging.Level+"->"+new.Logging.Level)
+	})
+
+	s.Require().NoError(watcher.Reload())
+	s.Equal(1, watcher.Status().Version, "an unchanged configuration keeps its version")
+	s.Empty(changes)
+
+	s.write("config.yaml", adminPasswordYaml+"logging:\n  level: debug\n")
+	s.Require().NoError(watcher.Reload())
+	s.Equal(2, watcher.Status().Version)
+	s.Equal(file, watcher.Status().File)
+	s.Equal("debug", watcher.Current().Logging.Level)
+	s.Equal([]string{"info->debug"}, changes)
+}
+
+func (s *ConfigTestSuite) TestReloadRejectsInvalidConfig() {
+	watcher, _ := s.watch(adminPasswordYaml + "logging:\n  level: info\n")
+	called := false
+	watcher.OnChange(func(*configs.Config, *configs.Config) { called = true })
+
+	s.write("config.yaml", adminPasswordYaml+"logging:\n  level: loud\n")
+	s.ErrorContains(watcher.Reload(), "logging.level")
+	s.False(called)
+	s.Equal("info", watcher.Current().Logging.Level)
+	status := watcher.Status()
+	s.Equal(1, status.Version)
+	s.Contains(status.Error, "logging.level")
+	s.NotNil(status.RejectedAt)
+
+	s.write("config.yaml", adminPasswordYaml+"logging:\n  level: warn\n")
+	s.Require().NoError(watcher.Reload())
+	status = watcher.Status()
+	s.Equal(2, status.Version)
+	s.Empty(status.Error)
+	s.Nil(status.RejectedAt)
+}
+
+func (s *ConfigTestSuite) TestWatchReloadsOnWrite() {
+	watcher, _ := s.watch("keycloak:\n  admin:\n    password: old\n")
+	changed := make(chan string, 16)
+	watcher.OnChange(func(_ *configs.Config, new *configs.Config) {
+		changed <- new.Keycloak.Admin.Password
+	})
+	watcher.Watch()
+
+	s.write("config.yaml", "keycloak:\n  admin:\n    password: rotated\n")
+	// A write can be seen more than once, half done at first.
+	timeout := time.After(5 * time.Second)
+	for password := ""; password != "rotated"; {
+		select {
+		case password = <-changed:
+		case <-timeout:
+			s.FailNow("the configuration was not reloaded")
+		}
+	}
+	s.Equal("rotated", watcher.Current().Keycloak.Admin.Password)
+}
+
+func (s *ConfigTestSuite) TestStatusEndpoint() {
+	watcher, _ := s.watch(adminPasswordYaml)
+	s.write("config.yaml", adminPasswordYaml+"jobs:\n  workers: 3\n")
+	s.Require().NoError(watcher.Reload())
+
+	previous := resource.ConfigWatcher
+	defer func() { resource.ConfigWatcher = previous }()
+	resource.ConfigWatcher = watcher
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/admin/config", resource.GetConfigStatusHandler)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/admin/config", nil))
+	s.Equal(http.StatusOK, recorder.Code)
+	var status configs.Status
+	s.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &status))
+	s.Equal(2, status.Version)
+	s.Empty(status.Error)
+}
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
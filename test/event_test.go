@@ -0,0 +1,59 @@
+package test
+
+import (
+	"encoding/json"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"testing"
+)
+
+type EventTestSuite struct {
+	Suite
+}
+
+func (s *EventTestSuite) TestListEventsSucceed() {
+
+	w := s.Get("/api/v1/events?type=LOGIN&dateFrom=2024-01-01&max=10")
+	s.Equal(http.StatusOK, w.Code)
+	var events []keycloakadminclient.EventRepresentation
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &events))
+}
+
+func (s *EventTestSuite) TestListEventsBadRequestWhenDateIsInvalid() {
+
+	w := s.Get("/api/v1/events?dateFrom=01/01/2024")
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *EventTestSuite) TestListAdminEventsBadRequestWhenPageIsInvalid() {
+
+	w := s.Get("/api/v1/admin-events?max=0")
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (s *EventTestSuite) TestUpdateEventsConfigSucceed() {
+
+	enabled := true
+	expiration := int64(3600)
+	config := &keycloakadminclient.RealmEventsConfigRepresentation{
+		EventsEnabled:      &enabled,
+		EventsExpiration:   &expiration,
+		EnabledEventTypes:  []string{"LOGIN", "LOGIN_ERROR"},
+		AdminEventsEnabled: &enabled,
+	}
+	w := s.Put("/api/v1/events/config", config)
+	s.Equal(http.StatusNoContent, w.Code)
+
+	w = s.Get("/api/v1/events/config")
+	s.Equal(http.StatusOK, w.Code)
+	var got keycloakadminclient.RealmEventsConfigRepresentation
+	s.NoError(json.Unmarshal(w.Body.Bytes(), &got))
+	s.True(got.GetEventsEnabled())
+	s.Equal(expiration, got.GetEventsExpiration())
+	s.ElementsMatch(config.EnabledEventTypes, got.EnabledEventTypes)
+}
+
+func TestEventTestSuite(t *testing.T) {
+	suite.Run(t, new(EventTestSuite))
+}
@@ -0,0 +1,252 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/graph"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type GraphqlTestSuite struct {
+	suite.Suite
+	directory *graphDirectory
+	sink      *audit.FileSink
+	events    []event.Event
+	server    *graph.Server
+}
+
+// graphDirectory keeps users, groups and memberships in memory and counts
+// the calls made to it.
+type graphDirectory struct {
+	mu      sync.Mutex
+	users   []keycloakadminclient.UserRepresentation
+	groups  []keycloakadminclient.GroupRepresentation
+	members map[string][]string
+	calls   map[string]int
+}
+
+func (d *graphDirectory) count(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls[name]++
+}
+
+type graphUsers struct {
+	keycloak.UserService
+	*graphDirectory
+}
+
+func (u *graphUsers) GetUserById(_ context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	u.count("GetUserById")
+	for _, user := range u.users {
+		if user.GetId() == userId {
+			return &user, 200, nil
+		}
+	}
+	return nil, 404, errors.New("404 Not Found")
+}
+
+func (u *graphUsers) ListUsersPage(_ context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	end := int(first + max)
+	if end > len(u.users) {
+		end = len(u.users)
+	}
+	page := append([]keycloakadminclient.UserRepresentation{}, u.users[first:end]...)
+	return &page, 200, nil
+}
+
+func (u *graphUsers) ListGroups(_ context.Context, userId string) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	u.count("ListGroups")
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	groups := []keycloakadminclient.GroupRepresentation{}
+	for _, group := range u.groups {
+		for _, member := range u.members[group.GetId()] {
+			if member == userId {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return &groups, 200, nil
+}
+
+func (u *graphUsers) JoinGroup(_ context.Context, userId string, groupId string) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.members[groupId] = append(u.members[groupId], userId)
+	return 204, nil
+}
+
+type graphGroups struct {
+	keycloak.GroupService
+	*graphDirectory
+}
+
+func (g *graphGroups) ListMembers(_ context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	g.count("ListMembers")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := []keycloakadminclient.UserRepresentation{}
+	for _, memberId := range g.members[groupId] {
+		for _, user := range g.users {
+			if user.GetId() == memberId {
+				members = append(members, user)
+			}
+		}
+	}
+	return &members, 200, nil
+}
+
+func (s *GraphqlTestSuite) SetupTest() {
+	user := func(id string) keycloakadminclient.UserRepresentation {
+		return keycloakadminclient.UserRepresentation{Id: keycloakadminclient.PtrString(id), Username: keycloakadminclient.PtrString(id), Enabled: keycloakadminclient.PtrBool(true)}
+	}
+	group := func(id string) keycloakadminclient.GroupRepresentation {
+		return keycloakadminclient.GroupRepresentation{Id: keycloakadminclient.PtrString(id), Name: keycloakadminclient.PtrString(id)}
+	}
+	s.directory = &graphDirectory{
+		users:   []keycloakadminclient.UserRepresentation{user("alice"), user("bob"), user("carol")},
+		groups:  []keycloakadminclient.GroupRepresentation{group("admins"), group("staff")},
+		members: map[string][]string{"admins": {"alice"}, "staff": {"alice", "bob", "carol"}},
+		calls:   map[string]int{},
+	}
+	sink, err := audit.NewFileSink(filepath.Join(s.T().TempDir(), "audit.jsonl"))
+	s.NoError(err)
+	s.sink = sink
+	s.events = nil
+	bus := event.NewBus()
+	bus.Subscribe(func(e event.Event) {
+		s.events = append(s.events, e)
+	})
+	s.server, err = graph.NewServer(graph.Options{
+		Users:  &graphUsers{graphDirectory: s.directory},
+		Groups: &graphGroups{graphDirectory: s.directory},
+		Events: bus,
+		Audit:  audit.NewRecorder(sink),
+	})
+	s.NoError(err)
+}
+
+func (s *GraphqlTestSuite) TearDownTest() {
+	s.NoError(s.sink.Close())
+}
+
+func (s *GraphqlTestSuite) execute(ctx context.Context, query string, variables map[string]interface{}) map[string]interface{} {
+	result := s.server.Execute(ctx, graph.Request{Query: query, Variables: variables})
+	data, err := json.Marshal(result)
+	s.NoError(err)
+	var response map[string]interface{}
+	s.NoError(json.Unmarshal(data, &response))
+	return response
+}
+
+func (s *GraphqlTestSuite) TestNestedRelationshipsAreBatched() {
+	response := s.execute(context.Background(), `{
+		users(first: 3) {
+			nodes {
+				username
+				groups { name members { nodes { username } } }
+			}
+		}
+	}`, nil)
+	s.Nil(response["errors"])
+	nodes := response["data"].(map[string]interface{})["users"].(map[string]interface{})["nodes"].([]interface{})
+	s.Len(nodes, 3)
+	alice := nodes[0].(map[string]interface{})
+	s.Equal("alice", alice["username"])
+	s.Len(alice["groups"], 2)
+
+	// Every user and every group is fetched once, however often it appears.
+	s.Equal(3, s.directory.calls["ListGroups"])
+	s.Equal(2, s.directory.calls["ListMembers"])
+}
+
+func (s *GraphqlTestSuite) TestConnectionPages() {
+	query := `query($after: String) { users(first: 2, after: $after) { edges { cursor node { id } } pageInfo { hasNextPage endCursor } } }`
+	response := s.execute(context.Background(), query, nil)
+	users := response["data"].(map[string]interface{})["users"].(map[string]interface{})
+	s.Len(users["edges"], 2)
+	pageInfo := users["pageInfo"].(map[string]interface{})
+	s.Equal(true, pageInfo["hasNextPage"])
+
+	response = s.execute(context.Background(), query, map[string]interface{}{"after": pageInfo["endCursor"]})
+	users = response["data"].(map[string]interface{})["users"].(map[string]interface{})
+	s.Len(users["edges"], 1)
+	s.Equal("carol", users["edges"].([]interface{})[0].(map[string]interface{})["node"].(map[string]interface{})["id"])
+	s.Equal(false, users["pageInfo"].(map[string]interface{})["hasNextPage"])
+
+	response = s.execute(context.Background(), `{ users(after: "bogus") { nodes { id } } }`, nil)
+	errs := response["errors"].([]interface{})
+	s.Equal("BAD_USER_INPUT", errs[0].(map[string]interface{})["extensions"].(map[string]interface{})["code"])
+}
+
+func (s *GraphqlTestSuite) TestMissingUserIsNull() {
+	response := s.execute(context.Background(), `{ user(id: "nobody") { id } }`, nil)
+	s.Nil(response["errors"])
+	s.Nil(response["data"].(map[string]interface{})["user"])
+}
+
+func (s *GraphqlTestSuite) TestJoinGroupMutation() {
+	ctx := graph.WithCaller(requestid.NewContext(context.Background(), "graphql-test"), audit.Attribution{Caller: "ip:127.0.0.1"})
+	response := s.execute(ctx, `{ user(id: "bob") { groups { name } } }`, nil)
+	s.Len(response["data"].(map[string]interface{})["user"].(map[string]interface{})["groups"], 1)
+
+	response = s.execute(ctx, `mutation {
+		joinGroup(userId: "bob", groupId: "admins") { groups { name } }
+	}`, nil)
+	s.Nil(response["errors"])
+	s.Len(response["data"].(map[string]interface{})["joinGroup"].(map[string]interface{})["groups"], 2)
+	s.Len(s.events, 1)
+	s.Equal(event.UserJoinedGroup, s.events[0].Type)
+
+	entries, err := s.sink.Query(&audit.Filter{RequestId: "graphql-test"})
+	s.NoError(err)
+	s.Len(entries, 1)
+	s.Equal("mutation.joinGroup", entries[0].Path)
+	s.Equal("bob", entries[0].ResourceId)
+	s.Equal("ip:127.0.0.1", entries[0].Caller)
+	s.Equal(audit.OutcomeSuccess, entries[0].Outcome)
+}
+
+func (s *GraphqlTestSuite) TestLimits() {
+	server, err := graph.NewServer(graph.Options{
+		Users:  &graphUsers{graphDirectory: s.directory},
+		Groups: &graphGroups{graphDirectory: s.directory},
+		Limits: graph.Limits{MaxDepth: 4, MaxCost: 1000, MaxListSize: 1},
+	})
+	s.Require().NoError(err)
+	s.server = server
+	rejected := func(query string, variables map[string]interface{}, message string) {
+		response := s.execute(context.Background(), query, variables)
+		s.Nil(response["data"], query)
+		errs := response["errors"].([]interface{})
+		s.Contains(errs[0].(map[string]interface{})["message"], message, query)
+		s.Equal("BAD_USER_INPUT", errs[0].(map[string]interface{})["extensions"].(map[string]interface{})["code"], query)
+	}
+
+	rejected(`{ users { nodes { groups { subGroups { name } } } } }`, nil, "query depth 5 exceeds the maximum of 4")
+	rejected(`query { ...deep } fragment deep on Query { users { nodes { groups { subGroups { id } } } } }`, nil, "query depth 5")
+	rejected(`query($first: Int) { users(first: $first) { nodes { groups { name } } } }`,
+		map[string]interface{}{"first": float64(500)}, "query cost 1501 exceeds the maximum of 1000")
+	s.Empty(s.directory.calls, "rejected queries do not reach Keycloak")
+
+	response := s.execute(context.Background(), `{ user(id: "alice") { groups { name } } }`, nil)
+	s.Nil(response["errors"])
+	s.Len(response["data"].(map[string]interface{})["user"].(map[string]interface{})["groups"], 1, "nested lists are capped")
+
+	response = s.execute(context.Background(), `{ user(id: "alice") { groups { members(first: 2) { totalCount } } } }`, nil)
+	s.Contains(response["errors"].([]interface{})[0].(map[string]interface{})["message"], "first must be between 0 and 1")
+}
+
+func TestGraphqlTestSuite(t *testing.T) {
+	suite.Run(t, new(GraphqlTestSuite))
+}
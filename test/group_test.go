@@ -2,7 +2,6 @@ package test
 
 import (
 	"encoding/json"
-	_ "github.com/miguoliang/arch-go/configs"
 	"github.com/miguoliang/arch-go/internal/dto"
 	"github.com/miguoliang/arch-go/pkg/str"
 	"github.com/miguoliang/keycloakadminclient"
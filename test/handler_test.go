@@ -0,0 +1,105 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// handlerUserService keeps users in memory and counts the calls.
+type handlerUserService struct {
+	keycloak.UserService
+	users map[string]keycloakadminclient.UserRepresentation
+	calls int
+}
+
+func (h *handlerUserService) GetUserById(_ context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	h.calls++
+	user, ok := h.users[userId]
+	if !ok {
+		return nil, 404, errors.New("404 Not Found")
+	}
+	return &user, 200, nil
+}
+
+func (h *handlerUserService) CreateUser(_ context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error) {
+	h.calls++
+	user.Id = str.Ptr("id-" + user.GetUsername())
+	h.users[user.GetId()] = *user
+	return user.GetId(), 201, nil
+}
+
+// handlerRoleService fails every call, as when Keycloak is down.
+type handlerRoleService struct {
+	keycloak.RoleService
+}
+
+func (handlerRoleService) ListRoles(context.Context) (*[]keycloakadminclient.RoleRepresentation, int, error) {
+	return nil, 503, errors.New("503 Service Unavailable")
+}
+
+type HandlerTestSuite struct {
+	suite.Suite
+	users *handlerUserService
+	r     *gin.Engine
+}
+
+func (s *HandlerTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	s.users = &handlerUserService{users: map[string]keycloakadminclient.UserRepresentation{}}
+	h := &resource.Handler{Users: s.users, Roles: handlerRoleService{}}
+	s.r = gin.New()
+	s.r.GET("/users/:id", h.GetUserHandler)
+	s.r.POST("/users", h.CreateUserHandler)
+	s.r.GET("/roles", h.ListRolesHandler)
+}
+
+func (s *HandlerTestSuite) do(method string, path string, body interface{}) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, nil)
+	if body != nil {
+		request = httptest.NewRequest(method, path, str.StructToJsonReader(body))
+	}
+	s.r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *HandlerTestSuite) TestUsesInjectedService() {
+	created := s.do(http.MethodPost, "/users", keycloakadminclient.UserRepresentation{Username: str.Ptr("alice")})
+	s.Equal(http.StatusCreated, created.Code)
+	var response dto.CreatedResponse
+	s.NoError(json.Unmarshal(created.Body.Bytes(), &response))
+	s.Equal("id-alice", response.Id)
+
+	found := s.do(http.MethodGet, "/users/id-alice", nil)
+	s.Equal(http.StatusOK, found.Code)
+	var user keycloakadminclient.UserRepresentation
+	s.NoError(json.Unmarshal(found.Body.Bytes(), &user))
+	s.Equal("alice", user.GetUsername())
+	s.Equal(2, s.users.calls)
+}
+
+func (s *HandlerTestSuite) TestServiceErrors() {
+	missing := s.do(http.MethodGet, "/users/nobody", nil)
+	s.Equal(http.StatusNotFound, missing.Code)
+
+	unavailable := s.do(http.MethodGet, "/roles", nil)
+	s.Equal(http.StatusServiceUnavailable, unavailable.Code)
+	var response dto.ErrorResponse
+	s.NoError(json.Unmarshal(unavailable.Body.Bytes(), &response))
+	s.Equal("503 Service Unavailable", response.Message)
+}
+
+func TestHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(HandlerTestSuite))
+}
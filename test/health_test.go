@@ -0,0 +1,174 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/health"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type HealthTestSuite struct {
+	suite.Suite
+	r         *gin.Engine
+	readiness *health.Checker
+}
+
+func (s *HealthTestSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	s.r = gin.New()
+	s.r.GET("/healthz", resource.LivenessHandler)
+	s.r.GET("/readyz", resource.ReadinessHandler)
+	s.readiness = resource.Readiness
+}
+
+func (s *HealthTestSuite) TearDownTest() {
+	resource.Readiness = s.readiness
+}
+
+func (s *HealthTestSuite) get(path string) (int, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	s.r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]interface{}
+	s.NoError(json.Unmarshal(recorder.Body.Bytes(), &body))
+	return recorder.Code, body
+}
+
+func (s *HealthTestSuite) TestLiveness() {
+	resource.Readiness = health.NewChecker(time.Minute, time.Second, health.Check{
+		Name: "down",
+		Run:  func(context.Context) error { return errors.New("down") },
+	})
+	statusCode, body := s.get("/healthz")
+	s.Equal(http.StatusOK, statusCode)
+	s.Equal("up", body["status"])
+}
+
+func (s *HealthTestSuite) TestReadinessReportsEveryCheck() {
+	var runs int32
+	resource.Readiness = health.NewChecker(time.Minute, 50*time.Millisecond,
+		health.Check{Name: "keycloak-token", Run: func(context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		}},
+		health.Check{Name: "keycloak-realm", Run: func(context.Context) error {
+			return errors.New("realm custom: status 404")
+		}},
+		health.Check{Name: "slow", Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}},
+	)
+
+	statusCode, body := s.get("/readyz")
+	s.Equal(http.StatusServiceUnavailable, statusCode)
+	s.Equal("down", body["status"])
+	checks := body["checks"].(map[string]interface{})
+	s.Equal("up", checks["keycloak-token"].(map[string]interface{})["status"])
+	realm := checks["keycloak-realm"].(map[string]interface{})
+	s.Equal("down", realm["status"])
+	s.Equal("realm custom: status 404", realm["error"])
+	s.Contains(checks["slow"].(map[string]interface{})["error"], "timed out")
+
+	// The results are reused within the ttl.
+	s.get("/readyz")
+	s.Equal(int32(1), atomic.LoadInt32(&runs))
+}
+
+func (s *HealthTestSuite) TestReadinessRechecksAfterTtl() {
+	var healthy atomic.Bool
+	resource.Readiness = health.NewChecker(10*time.Millisecond, time.Second, health.Check{
+		Name: "keycloak-realm",
+		Run: func(context.Context) error {
+			if !healthy.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	})
+	statusCode, _ := s.get("/readyz")
+	s.Equal(http.StatusServiceUnavailable, statusCode)
+
+	healthy.Store(true)
+	time.Sleep(20 * time.Millisecond)
+	statusCode, body := s.get("/readyz")
+	s.Equal(http.StatusOK, statusCode)
+	s.Equal("up", body["status"])
+}
+
+func (s *HealthTestSuite) TestReadinessOutlivesProber() {
+	resource.Readiness = health.NewChecker(time.Minute, time.Second, health.Check{
+		Name: "keycloak-realm",
+		Run: func(ctx context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+			return ctx.Err()
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Equal(health.StatusUp, resource.Readiness.Check(ctx).Status)
+
+	// The next prober gets the cached result of the first one.
+	statusCode, _ := s.get("/readyz")
+	s.Equal(http.StatusOK, statusCode)
+}
+
+func (s *HealthTestSuite) TestCheckTokenEndsWithContext() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	keycloak.Configure(keycloak.AdminOptions{
+		URL: server.URL, Realm: "master", ClientId: "admin-cli", Username: "admin", Password: "admin",
+		Client: keycloak.ClientOptions{Retry: retries(1)},
+	})
+	defer keycloak.Configure(keycloak.AdminOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	s.Error(keycloak.CheckToken(ctx))
+	// The admin client is not left waiting for the abandoned token request.
+	keycloak.GetAdminClient()
+	s.Less(time.Since(start), 500*time.Millisecond)
+}
+
+func (s *HealthTestSuite) TestWaitFor() {
+	var probes int32
+	keycloak := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/auth/health/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.AddInt32(&probes, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer keycloak.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.NoError(health.WaitFor(ctx, keycloak.URL+"/auth/health/ready", 10*time.Millisecond))
+	s.Equal(int32(3), atomic.LoadInt32(&probes))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	s.Error(health.WaitFor(ctx, keycloak.URL+"/missing", 10*time.Millisecond))
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
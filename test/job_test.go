@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/job"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type JobTestSuite struct {
+	suite.Suite
+	store job.Store
+}
+
+type countJob struct {
+	steps int
+	block chan struct{}
+}
+
+func (j *countJob) Type() string {
+	return "count"
+}
+
+func (j *countJob) Run(ctx context.Context, progress job.Progress) (interface{}, error) {
+	for i := 1; i <= j.steps; i++ {
+		if j.block != nil {
+			select {
+			case <-j.block:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		progress(i, j.steps)
+	}
+	return map[string]int{"count": j.steps}, nil
+}
+
+type failingJob struct{}
+
+func (j *failingJob) Type() string {
+	return "fail"
+}
+
+func (j *failingJob) Run(context.Context, job.Progress) (interface{}, error) {
+	return nil, errors.New("boom")
+}
+
+func (s *JobTestSuite) SetupTest() {
+	store, err := job.NewFileStore(s.T().TempDir())
+	s.NoError(err)
+	s.store = store
+}
+
+func (s *JobTestSuite) waitFinished(runner *job.Runner, id string) *job.Record {
+	var record *job.Record
+	s.Eventually(func() bool {
+		var err error
+		record, err = runner.Get(id)
+		s.NoError(err)
+		return record.State.Finished()
+	}, 5*time.Second, 10*time.Millisecond)
+	return record
+}
+
+func (s *JobTestSuite) TestJobSucceed() {
+
+	runner, err := job.NewRunner(s.store, 2)
+	s.NoError(err)
+	defer runner.Stop()
+
+	record, err := runner.Submit(&countJob{steps: 3})
+	s.NoError(err)
+	s.Equal(job.StatePending, record.State)
+
+	record = s.waitFinished(runner, record.Id)
+	s.Equal(job.StateSucceeded, record.State)
+	s.Equal(3, record.Done)
+	s.Equal(3, record.Total)
+	s.JSONEq(`{"count":3}`, string(record.Result))
+
+	stored, err := s.store.Get(record.Id)
+	s.NoError(err)
+	s.Equal(job.StateSucceeded, stored.State)
+}
+
+func (s *JobTestSuite) TestJobFailed() {
+
+	runner, err := job.NewRunner(s.store, 1)
+	s.NoError(err)
+	defer runner.Stop()
+
+	record, err := runner.Submit(&failingJob{})
+	s.NoError(err)
+	record = s.waitFinished(runner, record.Id)
+	s.Equal(job.StateFailed, record.State)
+	s.Equal("boom", record.Error)
+}
+
+func (s *JobTestSuite) TestJobCanceled() {
+
+	runner, err := job.NewRunner(s.store, 1)
+	s.NoError(err)
+	defer runner.Stop()
+
+	record, err := runner.Submit(&countJob{steps: 3, block: make(chan struct{})})
+	s.NoError(err)
+	_, err = runner.Cancel(record.Id)
+	s.NoError(err)
+
+	record = s.waitFinished(runner, record.Id)
+	s.Equal(job.StateCanceled, record.State)
+
+	_, err = runner.Cancel(record.Id)
+	s.ErrorIs(err, job.ErrFinished)
+}
+
+func (s *JobTestSuite) TestJobNotFound() {
+
+	runner, err := job.NewRunner(s.store, 1)
+	s.NoError(err)
+	defer runner.Stop()
+
+	_, err = runner.Get("not-exist")
+	s.ErrorIs(err, job.ErrNotFound)
+}
+
+func (s *JobTestSuite) TestInterruptedJobFailsAfterRestart() {
+
+	s.NoError(s.store.Save(&job.Record{Id: "interrupted", Type: "count", State: job.StateRunning}))
+
+	runner, err := job.NewRunner(s.store, 1)
+	s.NoError(err)
+	defer runner.Stop()
+
+	record, err := runner.Get("interrupted")
+	s.NoError(err)
+	s.Equal(job.StateFailed, record.State)
+	s.NotNil(record.FinishedAt)
+}
+
+func TestJobTestSuite(t *testing.T) {
+	suite.Run(t, new(JobTestSuite))
+}
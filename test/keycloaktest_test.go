@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/keycloak/keycloaktest"
+	"github.com/miguoliang/arch-go/internal/stream"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+type KeycloakFakeTestSuite struct {
+	suite.Suite
+	server *keycloaktest.Server
+	ctx    context.Context
+}
+
+func (s *KeycloakFakeTestSuite) SetupTest() {
+	s.server = keycloaktest.NewServer(keycloaktest.Options{Username: "admin", Password: "secret"})
+	s.ctx = context.Background()
+	keycloak.Configure(keycloak.AdminOptions{
+		URL: s.server.URL, Realm: "master", ClientId: "admin-cli", Username: "admin", Password: "secret",
+	})
+	s.server.AddRealm("fake")
+}
+
+func (s *KeycloakFakeTestSuite) TearDownTest() {
+	s.server.Close()
+	keycloak.Configure(keycloak.AdminOptions{})
+}
+
+func (s *KeycloakFakeTestSuite) TestRequiresToken() {
+	response, err := http.Get(s.server.URL + "/admin/realms/fake/users")
+	s.Require().NoError(err)
+	response.Body.Close()
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+
+	response, err = http.PostForm(s.server.URL+"/realms/master/protocol/openid-connect/token", url.Values{
+		"grant_type": {"password"}, "client_id": {"admin-cli"}, "username": {"admin"}, "password": {"wrong"},
+	})
+	s.Require().NoError(err)
+	response.Body.Close()
+	s.Equal(http.StatusUnauthorized, response.StatusCode)
+	s.NoError(keycloak.CheckToken(s.ctx))
+}
+
+func (s *KeycloakFakeTestSuite) TestCreateAndConflict() {
+	users := keycloak.NewUserService("fake")
+	userId, statusCode, err := users.CreateUser(s.ctx, &keycloakadminclient.UserRepresentation{Username: str.Ptr("Alice")})
+	s.Require().NoError(err)
+	s.Equal(http.StatusCreated, statusCode)
+	s.Len(userId, 36)
+
+	user, _, err := users.GetUserByUsername(s.ctx, "alice")
+	s.Require().NoError(err)
+	s.Equal(userId, user.GetId())
+
+	_, statusCode, err = users.CreateUser(s.ctx, &keycloakadminclient.UserRepresentation{Username: str.Ptr("alice")})
+	s.Error(err)
+	s.Equal(http.StatusConflict, statusCode)
+
+	_, statusCode, _ = users.GetUserById(s.ctx, "not-exist")
+	s.Equal(http.StatusNotFound, statusCode)
+}
+
+func (s *KeycloakFakeTestSuite) TestMembershipAndRoles() {
+	users := keycloak.NewUserService("fake")
+	groups := keycloak.NewGroupService("fake")
+	roles := keycloak.NewRoleService("fake")
+
+	userId, _, err := users.CreateUser(s.ctx, &keycloakadminclient.UserRepresentation{Username: str.Ptr("bob")})
+	s.Require().NoError(err)
+	groupId, _, err := groups.CreateGroup(s.ctx, &keycloakadminclient.GroupRepresentation{Name: str.Ptr("staff")})
+	s.Require().NoError(err)
+	_, err = users.JoinGroup(s.ctx, userId, groupId)
+	s.Require().NoError(err)
+
+	members, _, err := groups.ListMembers(s.ctx, groupId)
+	s.Require().NoError(err)
+	s.Len(*members, 1)
+	s.Equal("bob", (*members)[0].GetUsername())
+
+	_, _, err = roles.CreateRole(s.ctx, &keycloakadminclient.RoleRepresentation{Name: str.Ptr("editor")})
+	s.Require().NoError(err)
+	editor, _, err := roles.GetRoleByName(s.ctx, "editor")
+	s.Require().NoError(err)
+	_, err = users.AddRoleMappings(s.ctx, userId, []keycloakadminclient.RoleRepresentation{*editor})
+	s.Require().NoError(err)
+
+	// The default roles are composite, so the effective roles include theirs.
+	effective, _, err := users.ListEffectiveRoles(s.ctx, userId)
+	s.Require().NoError(err)
+	var names []string
+	for _, role := range *effective {
+		names = append(names, role.GetName())
+	}
+	s.ElementsMatch([]string{"default-roles-fake", "editor", "offline_access", "uma_authorization"}, names)
+}
+
+func (s *KeycloakFakeTestSuite) TestRecordsAdminEvents() {
+	enabled := true
+	events := keycloak.NewEventService("fake")
+	_, err := events.UpdateEventsConfig(s.ctx, &keycloakadminclient.RealmEventsConfigRepresentation{AdminEventsEnabled: &enabled})
+	s.Require().NoError(err)
+	groups := keycloak.NewGroupService("fake")
+	groupId, _, err := groups.CreateGroup(s.ctx, &keycloakadminclient.GroupRepresentation{Name: str.Ptr("audited")})
+	s.Require().NoError(err)
+	_, err = groups.DeleteGroup(s.ctx, groupId)
+	s.Require().NoError(err)
+
+	adminEvents, _, err := events.ListAdminEvents(s.ctx, &keycloak.AdminEventQuery{
+		ResourceTypes: []string{"GROUP"}, Max: 10,
+	})
+	s.Require().NoError(err)
+	s.Require().Len(*adminEvents, 2)
+	deleted, ok := stream.AdminEventToEvent(&(*adminEvents)[0])
+	s.True(ok)
+	s.Equal(event.GroupDeleted, deleted.Type)
+	s.Equal(groupId, deleted.Subject)
+}
+
+func TestKeycloakFakeTestSuite(t *testing.T) {
+	suite.Run(t, new(KeycloakFakeTestSuite))
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/logging"
+	"github.com/miguoliang/arch-go/internal/requestid"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/Graylog2/go-gelf.v2/gelf"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gelfMessages keeps the messages written to it.
+type gelfMessages struct {
+	messages []*gelf.Message
+}
+
+func (g *gelfMessages) Close() error                { return nil }
+func (g *gelfMessages) Write(p []byte) (int, error) { return len(p), nil }
+func (g *gelfMessages) WriteMessage(m *gelf.Message) error {
+	g.messages = append(g.messages, m)
+	return nil
+}
+
+type LoggingTestSuite struct {
+	suite.Suite
+	logger *slog.Logger
+}
+
+func (s *LoggingTestSuite) SetupTest() {
+	s.logger = slog.Default()
+}
+
+func (s *LoggingTestSuite) TearDownTest() {
+	slog.SetDefault(s.logger)
+	s.NoError(logging.SetLevel("info"))
+}
+
+func (s *LoggingTestSuite) records(buffer *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var record map[string]interface{}
+		s.Require().NoError(json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func (s *LoggingTestSuite) TestRequestScopedAccessLog() {
+	var buffer bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buffer, nil)))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestid.Middleware())
+	r.Use(logging.Middleware())
+	r.Use(logging.Recovery())
+	r.GET("/logging-test/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("handling", "id", c.Param("id"))
+		c.Status(http.StatusNotFound)
+	})
+	r.GET("/logging-test-panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/logging-test/42", nil)
+	request.Header.Set(requestid.Header, "logging-test-1")
+	request.RemoteAddr = "192.0.2.1:1234"
+	r.ServeHTTP(httptest.NewRecorder(), request)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/logging-test-panic", nil))
+	s.Equal(http.StatusInternalServerError, recorder.Code)
+
+	records := s.records(&buffer)
+	s.Require().Len(records, 4)
+	s.Equal("handling", records[0]["msg"])
+	s.Equal("logging-test-1", records[0]["request_id"])
+	s.Equal("ip:192.0.2.1", records[0]["caller"])
+	s.Equal("42", records[0]["id"])
+
+	s.Equal("request", records[1]["msg"])
+	s.Equal("WARN", records[1]["level"])
+	s.Equal("logging-test-1", records[1]["request_id"])
+	s.Equal("/logging-test/:id", records[1]["route"])
+	s.Equal("/logging-test/42", records[1]["path"])
+	s.Equal(float64(http.StatusNotFound), records[1]["status"])
+
+	s.Equal("panic serving request", records[2]["msg"])
+	s.Equal("boom", records[2]["panic"])
+	s.Equal("ERROR", records[3]["level"])
+	s.Equal(records[2]["request_id"], records[3]["request_id"])
+}
+
+func (s *LoggingTestSuite) TestFromContextWithoutRequestLogger() {
+	var buffer bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buffer, nil)))
+
+	ctx := requestid.NewContext(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "logging-test-2")
+	logging.FromContext(ctx).Info("polled")
+
+	records := s.records(&buffer)
+	s.Equal("logging-test-2", records[0]["request_id"])
+}
+
+func (s *LoggingTestSuite) TestGelfHandler() {
+	writer := &gelfMessages{}
+	var level slog.LevelVar
+	logger := slog.New(logging.NewGelfHandler(writer, &level)).With("id", "reserved")
+
+	logger.Debug("dropped")
+	logger.WithGroup("job").Warn("failed", "attempt", 2, "error", errors.New("timeout"))
+
+	s.Require().Len(writer.messages, 1)
+	message := writer.messages[0]
+	s.Equal("failed", message.Short)
+	s.Equal(int32(gelf.LOG_WARNING), message.Level)
+	s.Equal("reserved", message.Extra["_id_"])
+	s.Equal(int64(2), message.Extra["_job.attempt"])
+	s.Equal("timeout", message.Extra["_job.error"])
+}
+
+func (s *LoggingTestSuite) TestSetupFileOutput() {
+	file := filepath.Join(s.T().TempDir(), "arch-go.log")
+	logs, err := logging.Setup(logging.Options{Level: "warn", Format: logging.FormatJson, Outputs: []string{logging.OutputFile}, File: file})
+	s.Require().NoError(err)
+	slog.Info("dropped")
+	slog.Warn("kept", "key", "value")
+	s.NoError(logs.Close())
+
+	data, err := os.ReadFile(file)
+	s.NoError(err)
+	records := s.records(bytes.NewBuffer(data))
+	s.Require().Len(records, 1)
+	s.Equal("kept", records[0]["msg"])
+	s.Equal("value", records[0]["key"])
+}
+
+func (s *LoggingTestSuite) TestInvalidOptions() {
+	s.Error(logging.SetLevel("verbose"))
+	_, err := logging.Setup(logging.Options{Outputs: []string{"syslog"}})
+	s.Error(err)
+	_, err = logging.Setup(logging.Options{Format: "xml"})
+	s.Error(err)
+}
+
+func TestLoggingTestSuite(t *testing.T) {
+	suite.Run(t, new(LoggingTestSuite))
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/metrics"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+}
+
+func (s *MetricsTestSuite) scrape() string {
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/prometheus", nil))
+	s.Equal(http.StatusOK, recorder.Code)
+	return recorder.Body.String()
+}
+
+func (s *MetricsTestSuite) TestHttpRequestsByRouteTemplate() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metrics.Middleware())
+	r.GET("/metrics-test/:id", func(c *gin.Context) {
+		c.Status(http.StatusTeapot)
+	})
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := s.scrape()
+	s.Contains(body, `http_requests_total{method="GET",route="/metrics-test/:id",status="418"} 2`)
+	s.Contains(body, `http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	s.Contains(body, `http_request_duration_seconds_count{method="GET",route="/metrics-test/:id",status="418"} 2`)
+	s.Contains(body, `http_requests_in_flight{method="GET",route="/metrics-test/:id"} 0`)
+}
+
+func (s *MetricsTestSuite) TestKeycloakOperations() {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: metrics.Transport(nil)}
+	response, err := client.Get(upstream.URL + "/auth/admin/realms/metrics-test/users/2b8b2a9e-3f0c-4a5e-9c1d-7f2e8a6b4c3d/groups")
+	s.NoError(err)
+	_, _ = io.Copy(io.Discard, response.Body)
+	s.NoError(response.Body.Close())
+
+	body := s.scrape()
+	s.Contains(body, `keycloak_request_errors_total{operation="GET /admin/realms/{realm}/users/{id}/groups",status="404"}`)
+	s.Contains(body, `keycloak_request_duration_seconds_count{operation="GET /admin/realms/{realm}/users/{id}/groups",status="404"}`)
+}
+
+func (s *MetricsTestSuite) TestKeycloakOperationsByName() {
+	uuid := "2b8b2a9e-3f0c-4a5e-9c1d-7f2e8a6b4c3d"
+	for path, operation := range map[string]string{
+		"/admin/realms/master/roles/realm-admin":                       "GET /admin/realms/{realm}/roles/{name}",
+		"/admin/realms/master/roles/offline_access/composites":         "GET /admin/realms/{realm}/roles/{name}/composites",
+		"/admin/realms/master/roles/roles/users":                       "GET /admin/realms/{realm}/roles/{name}/users",
+		"/admin/realms/master/roles-by-id/not-a-uuid/composites":       "GET /admin/realms/{realm}/roles-by-id/{id}/composites",
+		"/admin/realms/master/clients/" + uuid + "/roles/manage-users": "GET /admin/realms/{realm}/clients/{id}/roles/{name}",
+		"/admin/realms/master/group-by-path/staff/admins":              "GET /admin/realms/{realm}/group-by-path/{path}",
+		"/admin/realms/master/roles":                                   "GET /admin/realms/{realm}/roles",
+	} {
+		s.Equal(operation, metrics.Operation(http.MethodGet, path), path)
+	}
+}
+
+func (s *MetricsTestSuite) TestTokenRefreshes() {
+	expiry := time.Unix(1900000000, 0)
+	metrics.TokenRefreshed("password", nil, expiry)
+	body := s.scrape()
+	s.Contains(body, `keycloak_token_refreshes_total{grant="password",result="success"}`)
+	s.Contains(body, `keycloak_token_expiry_timestamp_seconds 1.9e+09`)
+}
+
+func TestMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
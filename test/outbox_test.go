@@ -0,0 +1,203 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/outbox"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type OutboxTestSuite struct {
+	suite.Suite
+	path string
+}
+
+// flakyPublisher fails while down is set.
+type flakyPublisher struct {
+	*outbox.MemoryPublisher
+	down int32
+}
+
+func (f *flakyPublisher) Publish(ctx context.Context, e *outbox.CloudEvent) error {
+	if atomic.LoadInt32(&f.down) == 1 {
+		return errors.New("broker unavailable")
+	}
+	return f.MemoryPublisher.Publish(ctx, e)
+}
+
+func (s *OutboxTestSuite) SetupTest() {
+	s.path = s.T().TempDir() + "/outbox.jsonl"
+}
+
+func (s *OutboxTestSuite) newRelay(store outbox.Store, publisher outbox.Publisher) *outbox.Relay {
+	relay := outbox.NewRelay(store, publisher, outbox.Options{
+		Source:     "/arch-go",
+		TypePrefix: "test.",
+		Interval:   10 * time.Millisecond,
+		MaxBackoff: 20 * time.Millisecond,
+	})
+	s.T().Cleanup(relay.Stop)
+	return relay
+}
+
+func (s *OutboxTestSuite) TestCloudEvent() {
+	e := event.New(event.UserCreated, "user-id", map[string]string{"username": "alice"})
+	cloudEvent, err := outbox.NewCloudEvent(e, "/arch-go", "test.")
+	s.NoError(err)
+	data, err := json.Marshal(cloudEvent)
+	s.NoError(err)
+
+	var envelope map[string]interface{}
+	s.NoError(json.Unmarshal(data, &envelope))
+	s.Equal("1.0", envelope["specversion"])
+	s.Equal(e.Id, envelope["id"])
+	s.Equal("/arch-go", envelope["source"])
+	s.Equal("test.user.created", envelope["type"])
+	s.Equal("user-id", envelope["subject"])
+	s.Equal("application/json", envelope["datacontenttype"])
+	s.Equal(map[string]interface{}{"username": "alice"}, envelope["data"])
+}
+
+func (s *OutboxTestSuite) TestPublishInOrderAfterOutage() {
+	store, err := outbox.NewFileStore(s.path)
+	s.NoError(err)
+	publisher := &flakyPublisher{MemoryPublisher: outbox.NewMemoryPublisher(), down: 1}
+	relay := s.newRelay(store, publisher)
+
+	first := event.New(event.GroupCreated, "group", nil)
+	second := event.New(event.GroupDeleted, "group", nil)
+	relay.Handle(first)
+	relay.Handle(second)
+	time.Sleep(50 * time.Millisecond)
+	s.Empty(publisher.Events())
+	pending, _ := store.Pending(0)
+	s.Len(pending, 2)
+	s.Positive(pending[0].Attempts)
+
+	atomic.StoreInt32(&publisher.down, 0)
+	s.Eventually(func() bool {
+		return len(publisher.Events()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(first.Id, publisher.Events()[0].Id)
+	s.Equal(second.Id, publisher.Events()[1].Id)
+	pending, _ = store.Pending(0)
+	s.Empty(pending)
+}
+
+func (s *OutboxTestSuite) TestPendingSurvivesRestart() {
+	store, err := outbox.NewFileStore(s.path)
+	s.NoError(err)
+	relay := s.newRelay(store, &flakyPublisher{MemoryPublisher: outbox.NewMemoryPublisher(), down: 1})
+	published := event.New(event.RoleCreated, "role", nil)
+	pending := event.New(event.RoleDeleted, "role", nil)
+	s.NoError(store.Append(&outbox.Record{Event: outbox.CloudEvent{Id: published.Id}}))
+	s.NoError(store.Published(published.Id))
+	relay.Handle(pending)
+	relay.Stop()
+
+	reopened, err := outbox.NewFileStore(s.path)
+	s.NoError(err)
+	records, err := reopened.Pending(0)
+	s.NoError(err)
+	s.Len(records, 1)
+	s.Equal(pending.Id, records[0].Event.Id)
+
+	publisher := outbox.NewMemoryPublisher()
+	s.newRelay(reopened, publisher)
+	s.Eventually(func() bool {
+		return len(publisher.Events()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal("test.role.deleted", publisher.Events()[0].Type)
+}
+
+func (s *OutboxTestSuite) TestLogTruncatedWhenNothingPending() {
+	store, err := outbox.NewFileStore(s.path)
+	s.NoError(err)
+	first := outbox.Record{Event: outbox.CloudEvent{Id: "first"}}
+	second := outbox.Record{Event: outbox.CloudEvent{Id: "second"}}
+	s.NoError(store.Append(&first))
+	s.NoError(store.Append(&second))
+	s.NoError(store.Published("first"))
+	info, err := os.Stat(s.path)
+	s.NoError(err)
+	s.Positive(info.Size())
+
+	s.NoError(store.Published("second"))
+	info, err = os.Stat(s.path)
+	s.NoError(err)
+	s.Zero(info.Size())
+
+	third := outbox.Record{Event: outbox.CloudEvent{Id: "third"}}
+	s.NoError(store.Append(&third))
+	reopened, err := outbox.NewFileStore(s.path)
+	s.NoError(err)
+	records, err := reopened.Pending(0)
+	s.NoError(err)
+	s.Len(records, 1)
+	s.Equal("third", records[0].Event.Id)
+}
+
+// fakeNats speaks enough of the NATS protocol to accept publishes, and
+// sends the subjects published to.
+func fakeNats(s *OutboxTestSuite, subjects chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(`INFO {"server_id":"fake","version":"2.10.0","proto":1,"headers":true,"max_payload":1048576}` + "\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				_, _ = conn.Write([]byte("PONG\r\n"))
+			case fields[0] == "HPUB" && len(fields) == 4:
+				size, _ := strconv.Atoi(fields[3])
+				if _, err := io.CopyN(io.Discard, reader, int64(size)+2); err != nil {
+					return
+				}
+				subjects <- fields[1]
+			}
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func (s *OutboxTestSuite) TestNatsSubjectWithoutTypePrefix() {
+	subjects := make(chan string, 1)
+	publisher, err := outbox.NewNATSPublisher(fakeNats(s, subjects), "identity", "test.", false)
+	s.Require().NoError(err)
+	defer publisher.Close()
+
+	cloudEvent, err := outbox.NewCloudEvent(event.New(event.UserCreated, "user-id", nil), "/arch-go", "test.")
+	s.Require().NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Require().NoError(publisher.Publish(ctx, cloudEvent))
+	s.Equal("identity.user.created", <-subjects)
+}
+
+func TestOutboxTestSuite(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
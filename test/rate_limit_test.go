@@ -0,0 +1,201 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/cache"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/internal/ratelimit"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// bearer is an unsigned token with the claims, as the limiter does not
+// verify them.
+func bearer(claims map[string]string) string {
+	payload, _ := json.Marshal(claims)
+	return "Bearer e30." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+}
+
+type RateLimitTestSuite struct {
+	suite.Suite
+}
+
+func (s *RateLimitTestSuite) router(limiter *ratelimit.Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(limiter.Middleware())
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.DELETE("/users/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return r
+}
+
+func (s *RateLimitTestSuite) do(r *gin.Engine, method string, path string, authorization string, headers ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, nil)
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func perMinute(requests int) ratelimit.Limit {
+	return ratelimit.Limit{Requests: requests, Period: time.Minute}
+}
+
+func (s *RateLimitTestSuite) TestTurnsDownWithHeaders() {
+	r := s.router(ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeySubject, Default: perMinute(2),
+	}))
+
+	first := s.do(r, http.MethodGet, "/users/1", "")
+	s.Equal(http.StatusOK, first.Code)
+	s.Equal("2", first.Header().Get("RateLimit-Limit"))
+	s.Equal("1", first.Header().Get("RateLimit-Remaining"))
+	s.Equal("30", first.Header().Get("RateLimit-Reset"))
+	s.Equal("2;w=60;burst=2", first.Header().Get("RateLimit-Policy"))
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/2", "").Code)
+
+	limited := s.do(r, http.MethodGet, "/users/3", "")
+	s.Equal(http.StatusTooManyRequests, limited.Code)
+	s.Equal("0", limited.Header().Get("RateLimit-Remaining"))
+	s.Equal("30", limited.Header().Get("Retry-After"))
+	var response dto.ErrorResponse
+	s.NoError(json.Unmarshal(limited.Body.Bytes(), &response))
+	s.Contains(response.Message, "rate limit exceeded")
+}
+
+func (s *RateLimitTestSuite) TestKeyedByCaller() {
+	r := s.router(ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeySubject, Default: perMinute(1),
+	}))
+	alice := bearer(map[string]string{"sub": "alice", "azp": "script"})
+	bob := bearer(map[string]string{"sub": "bob", "azp": "script"})
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", alice).Code)
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", bob).Code)
+	s.Equal(http.StatusTooManyRequests, s.do(r, http.MethodGet, "/users/1", alice).Code)
+
+	r = s.router(ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeyClient, Default: perMinute(1),
+	}))
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", alice).Code)
+	s.Equal(http.StatusTooManyRequests, s.do(r, http.MethodGet, "/users/1", bob).Code)
+}
+
+func (s *RateLimitTestSuite) TestKeyedByGatewayHeader() {
+	r := s.router(ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeyHeader, Header: "X-Authenticated-User", Default: perMinute(1),
+	}))
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", "", "X-Authenticated-User", "alice").Code)
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", "", "X-Authenticated-User", "bob").Code)
+	// The token does not matter, a forged subject gets no bucket of its own.
+	forged := bearer(map[string]string{"sub": "mallory"})
+	s.Equal(http.StatusTooManyRequests, s.do(r, http.MethodGet, "/users/1", forged, "X-Authenticated-User", "alice").Code)
+
+	// Without the header the callers are told apart by ip.
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", forged).Code)
+	s.Equal(http.StatusTooManyRequests, s.do(r, http.MethodGet, "/users/1", "").Code)
+}
+
+func (s *RateLimitTestSuite) TestRouteOverrides() {
+	r := s.router(ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeyIP, Default: perMinute(10),
+		Routes: []ratelimit.RouteLimit{{Route: "delete /users/:id", Limit: perMinute(1)}},
+	}))
+	s.Equal(http.StatusNoContent, s.do(r, http.MethodDelete, "/users/1", "").Code)
+	deleted := s.do(r, http.MethodDelete, "/users/2", "")
+	s.Equal(http.StatusTooManyRequests, deleted.Code)
+	s.Equal("1;w=60;burst=1", deleted.Header().Get("RateLimit-Policy"))
+
+	read := s.do(r, http.MethodGet, "/users/1", "")
+	s.Equal(http.StatusOK, read.Code)
+	s.Equal("9", read.Header().Get("RateLimit-Remaining"))
+}
+
+func (s *RateLimitTestSuite) TestGrpcCalls() {
+	limiter := ratelimit.NewWithStore(ratelimit.NewMemoryStore(), ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeyIP, Default: perMinute(1),
+		Routes: []ratelimit.RouteLimit{{Route: "GRPC /identity.v1.UserService/DeleteUser", Limit: perMinute(1)}},
+	})
+	r := s.router(limiter)
+	interceptor := limiter.UnaryServerInterceptor()
+	call := func(method string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}})
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		_, err := interceptor(ctx, nil, info, func(context.Context, interface{}) (interface{}, error) { return nil, nil })
+		return err
+	}
+
+	s.NoError(call("/identity.v1.UserService/DeleteUser"))
+	err := call("/identity.v1.UserService/DeleteUser")
+	s.Equal(codes.ResourceExhausted, status.Code(err))
+	s.Contains(status.Convert(err).Message(), "rate limit exceeded")
+
+	// The other calls take from the bucket of the requests from the same ip.
+	s.NoError(call("/identity.v1.UserService/GetUser"))
+	request := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	request.RemoteAddr = "192.0.2.1:6000"
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	s.Equal(http.StatusTooManyRequests, recorder.Code)
+}
+
+func (s *RateLimitTestSuite) TestSetOptions() {
+	options := ratelimit.Options{Enabled: true, Key: ratelimit.KeyIP, Default: perMinute(1)}
+	limiter := ratelimit.NewWithStore(ratelimit.NewMemoryStore(), options)
+	r := s.router(limiter)
+	s.Equal(http.StatusOK, s.do(r, http.MethodGet, "/users/1", "").Code)
+	s.Equal(http.StatusTooManyRequests, s.do(r, http.MethodGet, "/users/1", "").Code)
+
+	options.Enabled = false
+	limiter.SetOptions(options)
+	allowed := s.do(r, http.MethodGet, "/users/1", "")
+	s.Equal(http.StatusOK, allowed.Code)
+	s.Empty(allowed.Header().Get("RateLimit-Limit"))
+}
+
+func (s *RateLimitTestSuite) TestRedisSharedBetweenReplicas() {
+	server := miniredis.RunT(s.T())
+	options := ratelimit.Options{
+		Enabled: true, Key: ratelimit.KeyIP, Backend: ratelimit.BackendRedis, Default: perMinute(3),
+		Redis: cache.RedisOptions{Address: server.Addr(), KeyPrefix: "rate-limit-test:"},
+	}
+	var replicas []*gin.Engine
+	for i := 0; i < 2; i++ {
+		limiter, err := ratelimit.New(options)
+		s.Require().NoError(err)
+		defer limiter.Close()
+		replicas = append(replicas, s.router(limiter))
+	}
+
+	s.Equal(http.StatusOK, s.do(replicas[0], http.MethodGet, "/users/1", "").Code)
+	s.Equal(http.StatusOK, s.do(replicas[1], http.MethodGet, "/users/1", "").Code)
+	last := s.do(replicas[0], http.MethodGet, "/users/1", "")
+	s.Equal(http.StatusOK, last.Code)
+	s.Equal("0", last.Header().Get("RateLimit-Remaining"))
+	s.Equal(http.StatusTooManyRequests, s.do(replicas[1], http.MethodGet, "/users/1", "").Code)
+	s.NotEmpty(server.Keys())
+
+	// Without Redis the requests are let through.
+	server.Close()
+	s.Equal(http.StatusOK, s.do(replicas[0], http.MethodGet, "/users/1", "").Code)
+}
+
+func TestRateLimitTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitTestSuite))
+}
@@ -0,0 +1,254 @@
+package test
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
+	"github.com/miguoliang/arch-go/internal/graph"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type ResilienceTestSuite struct {
+	suite.Suite
+	upstream *httptest.Server
+	// answer answers the calls other than the token requests, with the
+	// number of the call, starting from 1.
+	answer func(w http.ResponseWriter, r *http.Request, call int)
+	calls  atomic.Int32
+	ctx    context.Context
+}
+
+func (s *ResilienceTestSuite) SetupTest() {
+	s.ctx = context.Background()
+	s.calls.Store(0)
+	s.upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/token") {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":"token","expires_in":300}`))
+			return
+		}
+		s.answer(w, r, int(s.calls.Add(1)))
+	}))
+}
+
+func (s *ResilienceTestSuite) TearDownTest() {
+	keycloak.Configure(keycloak.AdminOptions{})
+	s.upstream.Close()
+}
+
+func (s *ResilienceTestSuite) configure(client keycloak.ClientOptions) {
+	keycloak.Configure(keycloak.AdminOptions{
+		URL: s.upstream.URL, Realm: "master", ClientId: "admin-cli", Username: "admin", Password: "admin",
+		Client: client,
+	})
+}
+
+func retries(maxAttempts int) keycloak.RetryOptions {
+	return keycloak.RetryOptions{MaxAttempts: maxAttempts, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+}
+
+func (s *ResilienceTestSuite) TestTimeout() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, _ int) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+	s.configure(keycloak.ClientOptions{Timeout: 50 * time.Millisecond, Retry: retries(1)})
+
+	start := time.Now()
+	_, statusCode, err := keycloak.NewUserService("resilience").GetUserById(s.ctx, "alice")
+	s.Error(err)
+	s.Equal(http.StatusGatewayTimeout, statusCode)
+	s.Less(time.Since(start), 500*time.Millisecond)
+}
+
+func (s *ResilienceTestSuite) TestRetriesIdempotentCalls() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, call int) {
+		if call < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id":"alice","username":"alice"}`))
+	}
+	s.configure(keycloak.ClientOptions{Retry: retries(3)})
+
+	user, statusCode, err := keycloak.NewUserService("resilience").GetUserById(s.ctx, "alice")
+	s.Require().NoError(err)
+	s.Equal(http.StatusOK, statusCode)
+	s.Equal("alice", user.GetUsername())
+	s.Equal(int32(3), s.calls.Load())
+}
+
+func (s *ResilienceTestSuite) TestRetriesOnlyRejectedCreates() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, call int) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	s.configure(keycloak.ClientOptions{Retry: retries(3)})
+	roles := keycloak.NewRoleService("resilience")
+
+	_, statusCode, err := roles.CreateRole(s.ctx, &keycloakadminclient.RoleRepresentation{Name: str.Ptr("editor")})
+	s.Error(err)
+	s.Equal(http.StatusServiceUnavailable, statusCode)
+	s.Equal(int32(1), s.calls.Load())
+
+	s.calls.Store(0)
+	s.answer = func(w http.ResponseWriter, r *http.Request, call int) {
+		switch {
+		case call == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		default:
+			// The role is read back after it was created.
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"id":"editor","name":"editor"}]`))
+		}
+	}
+	_, statusCode, err = roles.CreateRole(s.ctx, &keycloakadminclient.RoleRepresentation{Name: str.Ptr("editor")})
+	s.NoError(err)
+	s.Equal(http.StatusCreated, statusCode)
+	s.Equal(int32(3), s.calls.Load())
+}
+
+func (s *ResilienceTestSuite) TestBreakerFailsFast() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, _ int) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	s.configure(keycloak.ClientOptions{
+		Retry:   retries(1),
+		Breaker: keycloak.BreakerOptions{Failures: 2, OpenTimeout: time.Minute},
+	})
+	roles := keycloak.NewRoleService("resilience")
+
+	_, statusCode, _ := roles.ListRoles(s.ctx)
+	s.Equal(http.StatusBadGateway, statusCode)
+	s.Zero(keycloak.RetryAfter())
+	_, statusCode, _ = roles.ListRoles(s.ctx)
+	s.Equal(http.StatusBadGateway, statusCode)
+	_, statusCode, err := roles.ListRoles(s.ctx)
+	s.Error(err)
+	s.Equal(http.StatusServiceUnavailable, statusCode)
+	s.Equal(int32(2), s.calls.Load())
+	s.Greater(keycloak.RetryAfter(), 59*time.Second)
+
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileTest})
+	s.Require().NoError(err)
+	gin.SetMode(gin.TestMode)
+	r := resource.SetupRoutes(resource.Dependencies{Config: cfg})
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/roles", nil))
+	s.Equal(http.StatusServiceUnavailable, recorder.Code)
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	s.NoError(err)
+	s.InDelta(60, retryAfter, 1)
+	s.Equal(int32(2), s.calls.Load())
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/scim/v2/Groups", nil))
+	s.Equal(http.StatusServiceUnavailable, recorder.Code)
+	s.NotEmpty(recorder.Header().Get("Retry-After"))
+}
+
+func (s *ResilienceTestSuite) TestBreakerGraphqlRetryAfter() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, _ int) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	s.configure(keycloak.ClientOptions{
+		Retry:   retries(1),
+		Breaker: keycloak.BreakerOptions{Failures: 1, OpenTimeout: time.Minute},
+	})
+	server, err := graph.NewServer(graph.Options{
+		Roles: keycloak.NewRoleService("resilience"),
+	})
+	s.Require().NoError(err)
+	previous := resource.Graph
+	resource.Graph = server
+	defer func() { resource.Graph = previous }()
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileTest})
+	s.Require().NoError(err)
+	gin.SetMode(gin.TestMode)
+	r := resource.SetupRoutes(resource.Dependencies{Config: cfg})
+
+	query := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(`{"query":"{ roles { nodes { name } } }"}`)))
+		return recorder
+	}
+	s.Empty(query().Header().Get("Retry-After"), "502 is not a failing fast")
+	recorder := query()
+	s.Equal(http.StatusOK, recorder.Code)
+	s.Contains(recorder.Body.String(), "UNAVAILABLE")
+	s.NotEmpty(recorder.Header().Get("Retry-After"))
+}
+
+func (s *ResilienceTestSuite) TestBreakerIgnoresCallsStartedBeforeOpening() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	s.configure(keycloak.ClientOptions{
+		Retry:   retries(1),
+		Breaker: keycloak.BreakerOptions{Failures: 1, OpenTimeout: time.Minute},
+	})
+	roles := keycloak.NewRoleService("resilience")
+
+	slow := make(chan int)
+	go func() {
+		_, statusCode, _ := roles.ListRoles(s.ctx)
+		slow <- statusCode
+	}()
+	time.Sleep(30 * time.Millisecond)
+	_, statusCode, _ := roles.ListRoles(s.ctx)
+	s.Equal(http.StatusBadGateway, statusCode)
+	s.Equal(http.StatusOK, <-slow)
+	s.Greater(keycloak.RetryAfter(), 59*time.Second, "the slow call does not close the circuit")
+}
+
+func (s *ResilienceTestSuite) TestBreakerProbes() {
+	s.answer = func(w http.ResponseWriter, r *http.Request, call int) {
+		if call == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[]`))
+	}
+	s.configure(keycloak.ClientOptions{
+		Retry:   retries(1),
+		Breaker: keycloak.BreakerOptions{Failures: 1, OpenTimeout: 50 * time.Millisecond},
+	})
+	roles := keycloak.NewRoleService("resilience")
+
+	_, statusCode, _ := roles.ListRoles(s.ctx)
+	s.Equal(http.StatusServiceUnavailable, statusCode)
+	s.Greater(keycloak.RetryAfter(), time.Duration(0))
+
+	time.Sleep(60 * time.Millisecond)
+	_, statusCode, err := roles.ListRoles(s.ctx)
+	s.NoError(err)
+	s.Equal(http.StatusOK, statusCode)
+	s.Zero(keycloak.RetryAfter())
+}
+
+func TestResilienceTestSuite(t *testing.T) {
+	suite.Run(t, new(ResilienceTestSuite))
+}
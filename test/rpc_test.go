@@ -0,0 +1,194 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/miguoliang/arch-go/internal/audit"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/rpc"
+	identityv1 "github.com/miguoliang/arch-go/pkg/api/identity/v1"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+type RpcTestSuite struct {
+	suite.Suite
+	sink   *audit.FileSink
+	events []event.Event
+	conn   *grpc.ClientConn
+	users  identityv1.UserServiceClient
+}
+
+// rpcUserService keeps users in memory.
+type rpcUserService struct {
+	keycloak.UserService
+	users map[string]keycloakadminclient.UserRepresentation
+}
+
+func (r *rpcUserService) GetUserById(_ context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	user, ok := r.users[userId]
+	if !ok {
+		return nil, 404, errors.New("404 Not Found")
+	}
+	return &user, 200, nil
+}
+
+func (r *rpcUserService) CreateUser(_ context.Context, user *keycloakadminclient.UserRepresentation) (string, int, error) {
+	for _, existing := range r.users {
+		if existing.GetUsername() == user.GetUsername() {
+			return "", 409, errors.New("409 Conflict")
+		}
+	}
+	user.Id = keycloakadminclient.PtrString("id-" + user.GetUsername())
+	r.users[user.GetId()] = *user
+	return user.GetId(), 201, nil
+}
+
+func (r *rpcUserService) UpdateUser(_ context.Context, user *keycloakadminclient.UserRepresentation) (*keycloakadminclient.UserRepresentation, int, error) {
+	r.users[user.GetId()] = *user
+	return user, 200, nil
+}
+
+func (s *RpcTestSuite) SetupTest() {
+	sink, err := audit.NewFileSink(filepath.Join(s.T().TempDir(), "audit.jsonl"))
+	s.NoError(err)
+	s.sink = sink
+	s.events = nil
+	bus := event.NewBus()
+	bus.Subscribe(func(e event.Event) {
+		s.events = append(s.events, e)
+	})
+	users := &rpcUserService{users: map[string]keycloakadminclient.UserRepresentation{}}
+	server := rpc.NewServer(rpc.Options{
+		Users:  users,
+		Events: bus,
+		Audit:  audit.NewRecorder(sink),
+	})
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	s.T().Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	s.NoError(err)
+	s.conn = conn
+	s.users = identityv1.NewUserServiceClient(conn)
+}
+
+func (s *RpcTestSuite) TearDownTest() {
+	s.NoError(s.conn.Close())
+	s.NoError(s.sink.Close())
+}
+
+func (s *RpcTestSuite) TestCreateAndGetUser() {
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "rpc-test")
+	user, err := s.users.CreateUser(ctx, &identityv1.CreateUserRequest{
+		User: &identityv1.User{Username: "alice", Enabled: true},
+	}, grpc.Header(&header))
+	s.NoError(err)
+	s.Equal("id-alice", user.GetId())
+	s.Equal([]string{"rpc-test"}, header.Get("x-request-id"))
+	s.Len(s.events, 1)
+	s.Equal(event.UserCreated, s.events[0].Type)
+
+	fetched, err := s.users.GetUser(context.Background(), &identityv1.GetUserRequest{Id: "id-alice"})
+	s.NoError(err)
+	s.Equal("alice", fetched.GetUsername())
+
+	entries, err := s.sink.Query(&audit.Filter{RequestId: "rpc-test"})
+	s.NoError(err)
+	s.Len(entries, 1)
+	s.Equal("user", entries[0].ResourceType)
+	s.Equal("id-alice", entries[0].ResourceId)
+	s.Equal(audit.OutcomeSuccess, entries[0].Outcome)
+}
+
+func (s *RpcTestSuite) TestUpdateUserMask() {
+	_, err := s.users.CreateUser(context.Background(), &identityv1.CreateUserRequest{
+		User: &identityv1.User{Username: "carol", FirstName: "Carol", Enabled: true},
+	})
+	s.NoError(err)
+
+	// Only the email is updated, the fields left out keep their values.
+	updated, err := s.users.UpdateUser(context.Background(), &identityv1.UpdateUserRequest{
+		User:       &identityv1.User{Id: "id-carol", Email: "carol@example.com"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+	})
+	s.NoError(err)
+	s.Equal("carol@example.com", updated.GetEmail())
+	s.Equal("carol", updated.GetUsername())
+	s.Equal("Carol", updated.GetFirstName())
+	s.True(updated.GetEnabled())
+
+	// Without a mask the fields set are updated.
+	updated, err = s.users.UpdateUser(context.Background(), &identityv1.UpdateUserRequest{
+		User: &identityv1.User{Id: "id-carol", LastName: "Smith"},
+	})
+	s.NoError(err)
+	s.Equal("Smith", updated.GetLastName())
+	s.Equal("carol@example.com", updated.GetEmail())
+	s.True(updated.GetEnabled())
+
+	// A zero value is set when the mask names it.
+	updated, err = s.users.UpdateUser(context.Background(), &identityv1.UpdateUserRequest{
+		User:       &identityv1.User{Id: "id-carol"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"enabled"}},
+	})
+	s.NoError(err)
+	s.False(updated.GetEnabled())
+	s.Equal(event.UserDisabled, s.events[len(s.events)-1].Type)
+
+	_, err = s.users.UpdateUser(context.Background(), &identityv1.UpdateUserRequest{
+		User:       &identityv1.User{Id: "id-carol"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"created_timestamp"}},
+	})
+	s.Equal(codes.InvalidArgument, status.Code(err))
+}
+
+func (s *RpcTestSuite) TestErrorCodes() {
+	_, err := s.users.GetUser(context.Background(), &identityv1.GetUserRequest{Id: "missing"})
+	s.Equal(codes.NotFound, status.Code(err))
+
+	_, err = s.users.CreateUser(context.Background(), &identityv1.CreateUserRequest{User: &identityv1.User{}})
+	s.Equal(codes.InvalidArgument, status.Code(err))
+
+	request := &identityv1.CreateUserRequest{User: &identityv1.User{Username: "bob"}}
+	_, err = s.users.CreateUser(context.Background(), request)
+	s.NoError(err)
+	_, err = s.users.CreateUser(context.Background(), request)
+	s.Equal(codes.AlreadyExists, status.Code(err))
+
+	_, err = s.users.ListRoleMappings(context.Background(), &identityv1.ListRoleMappingsRequest{UserId: "id-bob"})
+	s.Equal(codes.Internal, status.Code(err), "a panic in the handler is recovered")
+}
+
+func (s *RpcTestSuite) TestHealth() {
+	response, err := healthv1.NewHealthClient(s.conn).Check(context.Background(), &healthv1.HealthCheckRequest{
+		Service: identityv1.UserService_ServiceDesc.ServiceName,
+	})
+	s.NoError(err)
+	s.Equal(healthv1.HealthCheckResponse_SERVING, response.GetStatus())
+}
+
+func TestRpcTestSuite(t *testing.T) {
+	suite.Run(t, new(RpcTestSuite))
+}
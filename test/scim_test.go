@@ -0,0 +1,209 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/miguoliang/arch-go/internal/scim"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scimUserService answers the searches and pages, and records them.
+type scimUserService struct {
+	keycloak.UserService
+	users   []keycloakadminclient.UserRepresentation
+	queries []keycloak.UserQuery
+	pages   [][2]int32
+}
+
+func (u *scimUserService) SearchUsers(_ context.Context, query keycloak.UserQuery) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	u.queries = append(u.queries, query)
+	found := []keycloakadminclient.UserRepresentation{}
+	for _, user := range u.users {
+		if user.GetUsername() == query.Username {
+			found = append(found, user)
+		}
+	}
+	return &found, 200, nil
+}
+
+func (u *scimUserService) CountUsers(context.Context) (int32, int, error) {
+	return int32(len(u.users)), 200, nil
+}
+
+func (u *scimUserService) ListUsersPage(_ context.Context, first int32, max int32) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	u.pages = append(u.pages, [2]int32{first, max})
+	page := u.users[min(int(first), len(u.users)):min(int(first+max), len(u.users))]
+	return &page, 200, nil
+}
+
+// scimGroupService counts the member lists.
+type scimGroupService struct {
+	keycloak.GroupService
+	groups  []keycloakadminclient.GroupRepresentation
+	members []string
+}
+
+func (g *scimGroupService) ListGroups(context.Context) (*[]keycloakadminclient.GroupRepresentation, int, error) {
+	return &g.groups, 200, nil
+}
+
+func (g *scimGroupService) ListMembers(_ context.Context, groupId string) (*[]keycloakadminclient.UserRepresentation, int, error) {
+	g.members = append(g.members, groupId)
+	return &[]keycloakadminclient.UserRepresentation{{Id: str.Ptr("member-of-" + groupId)}}, 200, nil
+}
+
+type ScimTestSuite struct {
+	suite.Suite
+}
+
+func (s *ScimTestSuite) resource(value string) map[string]interface{} {
+	var resource map[string]interface{}
+	s.NoError(json.Unmarshal([]byte(value), &resource))
+	return resource
+}
+
+func (s *ScimTestSuite) TestFilter() {
+	user := s.resource(`{
+		"userName": "bjensen",
+		"name": {"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": [{"value": "bjensen@example.com", "type": "work"}, {"value": "babs@home.org", "type": "home"}],
+		"active": true
+	}`)
+	for expression, expected := range map[string]bool{
+		`userName eq "BJensen"`:                                     true,
+		`userName eq "jsmith"`:                                      false,
+		`userName ne "jsmith"`:                                      true,
+		`name.familyName co "ens"`:                                  true,
+		`userName sw "bj" and active eq true`:                       true,
+		`userName sw "js" or name.givenName eq "barbara"`:           true,
+		`userName sw "js" or (active eq false and userName pr)`:     false,
+		`emails co "example.com"`:                                   true,
+		`emails[type eq "home" and value ew ".org"]`:                true,
+		`emails[type eq "work" and value ew ".org"]`:                false,
+		`not (displayName pr)`:                                      true,
+		`urn:ietf:params:scim:schemas:core:2.0:User:userName pr`:    true,
+		`userName eq "bjensen" and not (emails.type eq "personal")`: true,
+	} {
+		filter, err := scim.ParseFilter(expression)
+		s.NoError(err, expression)
+		s.Equal(expected, filter.Matches(user), expression)
+	}
+
+	for _, expression := range []string{`userName eq`, `userName xx "a"`, `(userName pr`, `userName eq "a" and`, `"a" eq userName`} {
+		_, err := scim.ParseFilter(expression)
+		s.Error(err, expression)
+		s.Equal(scim.InvalidFilter, err.(*scim.Error).ScimType, expression)
+	}
+}
+
+func (s *ScimTestSuite) TestPatch() {
+	group := s.resource(`{"displayName": "admins", "members": [{"value": "1"}, {"value": "2"}]}`)
+	var operations []scim.PatchOperation
+	s.NoError(json.Unmarshal([]byte(`[
+		{"op": "Add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]},
+		{"op": "remove", "path": "members[value eq \"1\"]"},
+		{"op": "replace", "value": {"displayName": "owners"}}
+	]`), &operations))
+	s.NoError(scim.Patch(group, operations))
+	s.Equal(s.resource(`{"displayName": "owners", "members": [{"value": "2"}, {"value": "3"}]}`), group)
+
+	user := s.resource(`{"userName": "bjensen", "active": true, "emails": [{"value": "a@example.com", "type": "work"}]}`)
+	s.NoError(json.Unmarshal([]byte(`[
+		{"op": "replace", "path": "active", "value": false},
+		{"op": "add", "path": "name.givenName", "value": "Barbara"},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "b@example.com"}
+	]`), &operations))
+	s.NoError(scim.Patch(user, operations))
+	s.Equal(s.resource(`{
+		"userName": "bjensen",
+		"active": false,
+		"name": {"givenName": "Barbara"},
+		"emails": [{"value": "b@example.com", "type": "work"}]
+	}`), user)
+
+	s.NoError(json.Unmarshal([]byte(`[{"op": "replace", "path": "emails[type eq \"home\"].value", "value": "x"}]`), &operations))
+	err := scim.Patch(user, operations)
+	s.Error(err)
+	s.Equal(scim.NoTarget, err.(*scim.Error).ScimType)
+}
+
+func (s *ScimTestSuite) TestListResponse() {
+	resources := []interface{}{"a", "b", "c"}
+	response := scim.NewListResponse(resources, 2, 5)
+	s.Equal(3, response.TotalResults)
+	s.Equal(2, response.ItemsPerPage)
+	s.Equal([]interface{}{"b", "c"}, response.Resources)
+	s.Empty(scim.NewListResponse(resources, 10, 5).Resources)
+}
+
+func (s *ScimTestSuite) list(h *resource.Handler, path string) *scim.ListResponse {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/scim/v2/Users", h.ListScimUsersHandler)
+	r.GET("/scim/v2/Groups", h.ListScimGroupsHandler)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	s.Require().Equal(http.StatusOK, recorder.Code, recorder.Body.String())
+	var response scim.ListResponse
+	s.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	return &response
+}
+
+func (s *ScimTestSuite) TestListUsersInKeycloak() {
+	users := &scimUserService{}
+	for i := 1; i <= 5; i++ {
+		users.users = append(users.users, keycloakadminclient.UserRepresentation{
+			Id: str.Ptr(fmt.Sprint(i)), Username: str.Ptr(fmt.Sprint("user", i)),
+			Attributes: &map[string][]string{scim.ExternalIdAttribute: {fmt.Sprint("x-", i)}},
+		})
+	}
+	h := &resource.Handler{Users: users}
+
+	page := s.list(h, "/scim/v2/Users?startIndex=2&count=2")
+	s.Equal(5, page.TotalResults)
+	s.Equal(2, page.ItemsPerPage)
+	s.Equal([][2]int32{{1, 2}}, users.pages, "the page is listed in Keycloak")
+
+	found := s.list(h, `/scim/v2/Users?filter=userName+eq+"user3"+and+externalId+eq+"x-3"`)
+	s.Equal(1, found.TotalResults)
+	s.Equal([]keycloak.UserQuery{{Username: "user3", Attributes: map[string]string{scim.ExternalIdAttribute: "x-3"}}}, users.queries)
+
+	missing := s.list(h, `/scim/v2/Users?filter=userName+eq+"nobody"`)
+	s.Equal(0, missing.TotalResults)
+	s.Empty(missing.Resources)
+	s.Len(users.pages, 1, "a missing user does not scan the users")
+}
+
+func (s *ScimTestSuite) TestListGroupsLoadsMembersOfPage() {
+	groups := &scimGroupService{}
+	for _, id := range []string{"a", "b", "c"} {
+		groups.groups = append(groups.groups, keycloakadminclient.GroupRepresentation{Id: str.Ptr(id), Name: str.Ptr("group-" + id)})
+	}
+	h := &resource.Handler{Groups: groups}
+
+	page := s.list(h, "/scim/v2/Groups?startIndex=2&count=1")
+	s.Equal(3, page.TotalResults)
+	s.Equal([]string{"b"}, groups.members)
+
+	groups.members = nil
+	s.list(h, "/scim/v2/Groups?excludedAttributes=members")
+	s.Empty(groups.members)
+
+	filtered := s.list(h, `/scim/v2/Groups?filter=members+eq+"member-of-c"&excludedAttributes=members`)
+	s.Equal(1, filtered.TotalResults)
+	s.Len(groups.members, 3, "filtering on the members lists them")
+	s.NotContains(filtered.Resources[0], "members")
+}
+
+func TestScimTestSuite(t *testing.T) {
+	suite.Run(t, new(ScimTestSuite))
+}
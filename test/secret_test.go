@@ -0,0 +1,263 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/secret"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault serves one secret of the KV version 2 engine, like Vault does.
+type fakeVault struct {
+	token string
+	path  string
+
+	mutex sync.Mutex
+	data  map[string]interface{}
+}
+
+func (f *fakeVault) set(key string, value string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.data[key] = value
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	if r.Method != http.MethodGet || r.URL.Path != f.path {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"data":     f.data,
+			"metadata": map[string]interface{}{"version": 1},
+		},
+	})
+}
+
+// countingProvider counts the fetches and fails them while failing is set.
+type countingProvider struct {
+	mutex   sync.Mutex
+	value   string
+	fetches int
+	failing bool
+}
+
+func (c *countingProvider) set(value string, failing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value, c.failing = value, failing
+}
+
+func (c *countingProvider) Get(context.Context, string) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.fetches++
+	if c.failing {
+		return "", secret.ErrNotFound
+	}
+	return c.value, nil
+}
+
+type SecretTestSuite struct {
+	suite.Suite
+	dir   string
+	vault *fakeVault
+	url   string
+}
+
+func (s *SecretTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.vault = &fakeVault{
+		token: "root",
+		path:  "/v1/secret/data/arch-go",
+		data:  map[string]interface{}{"keycloak-admin-password": "from-vault"},
+	}
+	server := httptest.NewServer(s.vault)
+	s.T().Cleanup(server.Close)
+	s.url = server.URL
+}
+
+func (s *SecretTestSuite) key() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	s.Require().NoError(err)
+	s.T().Setenv("ARCH_GO_TEST_SECRETS_KEY", base64.StdEncoding.EncodeToString(key))
+	return key
+}
+
+func (s *SecretTestSuite) TestEnv() {
+	s.T().Setenv("ARCH_GO_TEST_SECRET", "from-env")
+	value, err := secret.EnvProvider{}.Get(context.Background(), "ARCH_GO_TEST_SECRET")
+	s.Require().NoError(err)
+	s.Equal("from-env", value)
+
+	_, err = secret.EnvProvider{}.Get(context.Background(), "ARCH_GO_TEST_MISSING")
+	s.ErrorIs(err, secret.ErrNotFound)
+}
+
+func (s *SecretTestSuite) TestFile() {
+	s.Require().NoError(os.WriteFile(filepath.Join(s.dir, "password"), []byte("from-file\n"), 0600))
+	provider := secret.FileProvider{Dir: s.dir}
+
+	value, err := provider.Get(context.Background(), "password")
+	s.Require().NoError(err)
+	s.Equal("from-file", value)
+
+	_, err = provider.Get(context.Background(), "missing")
+	s.ErrorIs(err, secret.ErrNotFound)
+	_, err = provider.Get(context.Background(), "../password")
+	s.ErrorContains(err, "invalid secret file name")
+}
+
+func (s *SecretTestSuite) TestEncryptedFile() {
+	key := s.key()
+	sealed, err := secret.Seal(key, []byte(`{"keycloak-admin-password":"sealed"}`))
+	s.Require().NoError(err)
+	path := filepath.Join(s.dir, "secrets.enc")
+	s.Require().NoError(os.WriteFile(path, sealed, 0600))
+
+	provider, err := secret.NewEncryptedFileProvider(secret.EncryptedFileOptions{Path: path, KeyEnv: "ARCH_GO_TEST_SECRETS_KEY"})
+	s.Require().NoError(err)
+	value, err := provider.Get(context.Background(), "keycloak-admin-password")
+	s.Require().NoError(err)
+	s.Equal("sealed", value)
+
+	_, err = provider.Get(context.Background(), "missing")
+	s.ErrorIs(err, secret.ErrNotFound)
+
+	// Another key cannot open the file.
+	s.key()
+	other, err := secret.NewEncryptedFileProvider(secret.EncryptedFileOptions{Path: path, KeyEnv: "ARCH_GO_TEST_SECRETS_KEY"})
+	s.Require().NoError(err)
+	_, err = other.Get(context.Background(), "keycloak-admin-password")
+	s.ErrorContains(err, "cannot be decrypted")
+}
+
+func (s *SecretTestSuite) TestEncryptedFileRequiresKey() {
+	_, err := secret.NewEncryptedFileProvider(secret.EncryptedFileOptions{Path: "secrets.enc", KeyEnv: "ARCH_GO_TEST_NO_KEY"})
+	s.ErrorContains(err, "ARCH_GO_TEST_NO_KEY")
+}
+
+func (s *SecretTestSuite) TestVault() {
+	provider := secret.NewVaultProvider(secret.VaultOptions{
+		Address: s.url, Token: "root", Mount: "secret", Path: "arch-go", Timeout: time.Second,
+	})
+	value, err := provider.Get(context.Background(), "keycloak-admin-password")
+	s.Require().NoError(err)
+	s.Equal("from-vault", value)
+
+	_, err = provider.Get(context.Background(), "missing")
+	s.ErrorIs(err, secret.ErrNotFound)
+
+	denied := secret.NewVaultProvider(secret.VaultOptions{
+		Address: s.url, Token: "wrong", Mount: "secret", Path: "arch-go", Timeout: time.Second,
+	})
+	_, err = denied.Get(context.Background(), "keycloak-admin-password")
+	s.ErrorContains(err, "status 403")
+}
+
+func (s *SecretTestSuite) TestCacheRefetches() {
+	provider := &countingProvider{value: "first"}
+	cache := secret.NewCache(provider, 20*time.Millisecond)
+	defer cache.Stop()
+
+	value, err := cache.Get(context.Background(), "password")
+	s.Require().NoError(err)
+	s.Equal("first", value)
+
+	provider.set("rotated", false)
+	s.Eventually(func() bool {
+		value, _ := cache.Get(context.Background(), "password")
+		return value == "rotated"
+	}, time.Second, 10*time.Millisecond)
+
+	// A failing fetch keeps the last value.
+	provider.set("", true)
+	time.Sleep(60 * time.Millisecond)
+	value, err = cache.Get(context.Background(), "password")
+	s.Require().NoError(err)
+	s.Equal("rotated", value)
+}
+
+func (s *SecretTestSuite) TestCacheWithoutRefresh() {
+	provider := &countingProvider{value: "once"}
+	cache := secret.NewCache(provider, 0)
+	defer cache.Stop()
+
+	for i := 0; i < 3; i++ {
+		value, err := cache.Get(context.Background(), "password")
+		s.Require().NoError(err)
+		s.Equal("once", value)
+	}
+	s.Equal(1, provider.fetches)
+}
+
+func (s *SecretTestSuite) TestAdminClientLogsInWithSecret() {
+	var mutex sync.Mutex
+	var passwords []string
+	keycloakServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mutex.Lock()
+		passwords = append(passwords, r.PostForm.Get("password"))
+		mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 0})
+	}))
+	defer keycloakServer.Close()
+	defer keycloak.Configure(keycloak.AdminOptions{})
+
+	cache, err := secret.New(secret.Options{
+		Provider:      secret.ProviderVault,
+		AdminPassword: "keycloak-admin-password",
+		Refresh:       20 * time.Millisecond,
+		Vault:         secret.VaultOptions{Address: s.url, Token: "root", Mount: "secret", Path: "arch-go", Timeout: time.Second},
+	})
+	s.Require().NoError(err)
+	defer cache.Stop()
+	keycloak.Configure(keycloak.AdminOptions{
+		URL:            keycloakServer.URL,
+		Realm:          "master",
+		ClientId:       "admin-cli",
+		Username:       "admin",
+		Password:       "from-config",
+		Secrets:        cache,
+		PasswordSecret: "keycloak-admin-password",
+	})
+
+	// The token expires at once, so every check logs in again.
+	_ = keycloak.CheckToken(context.Background())
+	s.vault.set("keycloak-admin-password", "rotated")
+	s.Eventually(func() bool {
+		_ = keycloak.CheckToken(context.Background())
+		mutex.Lock()
+		defer mutex.Unlock()
+		return passwords[len(passwords)-1] == "rotated"
+	}, time.Second, 20*time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	s.Equal("from-vault", passwords[0])
+	s.NotContains(passwords, "from-config")
+}
+
+func TestSecretTestSuite(t *testing.T) {
+	suite.Run(t, new(SecretTestSuite))
+}
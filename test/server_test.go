@@ -0,0 +1,200 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/server"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+}
+
+// start serves handler on a random port and returns its base URL.
+func (s *ServerTestSuite) start(handler http.Handler, options server.Options) (*http.Server, string) {
+	options.Address = "127.0.0.1:0"
+	httpServer, err := server.New(handler, options)
+	s.Require().NoError(err)
+	listener, err := net.Listen("tcp", options.Address)
+	s.Require().NoError(err)
+	go func() {
+		s.NoError(server.Serve(httpServer, listener))
+	}()
+	scheme := "http"
+	if options.TLS.Enabled() {
+		scheme = "https"
+	}
+	return httpServer, scheme + "://" + listener.Addr().String()
+}
+
+func (s *ServerTestSuite) TestOptions() {
+	httpServer, err := server.New(http.NotFoundHandler(), server.Options{
+		Address:           ":0",
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      2 * time.Second,
+		MaxHeaderBytes:    4096,
+	})
+	s.NoError(err)
+	s.Equal(time.Second, httpServer.ReadHeaderTimeout)
+	s.Equal(2*time.Second, httpServer.WriteTimeout)
+	s.Equal(4096, httpServer.MaxHeaderBytes)
+	s.Nil(httpServer.TLSConfig)
+
+	_, err = server.New(http.NotFoundHandler(), server.Options{})
+	s.Error(err)
+	_, err = server.New(http.NotFoundHandler(), server.Options{Address: ":0", TLS: server.TLSOptions{CertFile: "missing.pem", KeyFile: "missing.key"}})
+	s.Error(err)
+	s.False(server.TLSOptions{CertFile: "cert.pem"}.Enabled())
+	s.False(server.TLSOptions{KeyFile: "key.pem"}.Enabled())
+	s.True(server.TLSOptions{CertFile: "cert.pem", KeyFile: "key.pem"}.Enabled())
+}
+
+func (s *ServerTestSuite) TestShutdownDrainsRequests() {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	})
+	httpServer, url := s.start(handler, server.Options{})
+
+	body := make(chan string, 1)
+	go func() {
+		response, err := http.Get(url)
+		s.NoError(err)
+		data, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		body <- string(data)
+	}()
+	<-started
+	s.NoError(httpServer.Shutdown(context.Background()))
+	s.Equal("done", <-body)
+
+	_, err := http.Get(url)
+	s.Error(err)
+}
+
+func (s *ServerTestSuite) TestStreamingLiftsWriteTimeout() {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/stream", func(c *gin.Context) {
+		server.LiftWriteDeadline(c.Writer)
+		time.Sleep(150 * time.Millisecond)
+		c.String(http.StatusOK, "late")
+	})
+	r.GET("/slow", func(c *gin.Context) {
+		time.Sleep(150 * time.Millisecond)
+		c.String(http.StatusOK, "late")
+	})
+	httpServer, url := s.start(r, server.Options{WriteTimeout: 50 * time.Millisecond})
+	defer httpServer.Close()
+
+	response, err := http.Get(url + "/stream")
+	s.Require().NoError(err)
+	data, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	s.Equal("late", string(data))
+
+	_, err = http.Get(url + "/slow")
+	s.Error(err)
+}
+
+func (s *ServerTestSuite) TestMutualTLS() {
+	dir := s.T().TempDir()
+	caKey, ca := s.certificate(nil, nil, "test CA", true)
+	serverKey, serverCert := s.certificate(caKey, ca, "127.0.0.1", false)
+	clientKey, clientCert := s.certificate(caKey, ca, "client", false)
+	s.writePem(filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Raw)
+	s.writePem(filepath.Join(dir, "server.pem"), "CERTIFICATE", serverCert.Raw)
+	s.writeKey(filepath.Join(dir, "server.key"), serverKey)
+
+	httpServer, url := s.start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}), server.Options{TLS: server.TLSOptions{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}})
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err := anonymous.Get(url)
+	s.Error(err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: roots,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{clientCert.Raw},
+			PrivateKey:  clientKey,
+		}},
+	}}}
+	response, err := client.Get(url)
+	s.Require().NoError(err)
+	data, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	s.Equal("client", string(data))
+}
+
+// certificate issues a certificate for name, signed by parent or self-signed
+// when parent is nil.
+func (s *ServerTestSuite) certificate(parentKey *ecdsa.PrivateKey, parent *x509.Certificate, name string, isCA bool) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	s.Require().NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	s.Require().NoError(err)
+	certificate, err := x509.ParseCertificate(der)
+	s.Require().NoError(err)
+	return key, certificate
+}
+
+func (s *ServerTestSuite) writePem(path string, blockType string, data []byte) {
+	s.Require().NoError(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600))
+}
+
+func (s *ServerTestSuite) writeKey(path string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	s.Require().NoError(err)
+	s.writePem(path, "EC PRIVATE KEY", der)
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
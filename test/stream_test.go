@@ -0,0 +1,223 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/resource"
+	"github.com/miguoliang/arch-go/internal/stream"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type StreamTestSuite struct {
+	suite.Suite
+	broker *stream.Broker
+}
+
+// adminEventService serves a fixed list of admin events.
+type adminEventService struct {
+	keycloak.EventService
+	events []keycloakadminclient.AdminEventRepresentation
+}
+
+func (a *adminEventService) ListAdminEvents(context.Context, *keycloak.AdminEventQuery) (*[]keycloakadminclient.AdminEventRepresentation, int, error) {
+	events := append([]keycloakadminclient.AdminEventRepresentation(nil), a.events...)
+	return &events, 200, nil
+}
+
+func adminEvent(at time.Time, resourceType string, operation string, path string) keycloakadminclient.AdminEventRepresentation {
+	adminEvent := keycloakadminclient.AdminEventRepresentation{}
+	adminEvent.SetTime(at.UnixMilli())
+	adminEvent.SetResourceType(resourceType)
+	adminEvent.SetOperationType(operation)
+	adminEvent.SetResourcePath(path)
+	return adminEvent
+}
+
+func (s *StreamTestSuite) SetupTest() {
+	s.broker = stream.NewBroker(3, time.Minute)
+}
+
+func (s *StreamTestSuite) receive(subscription *stream.Subscription) stream.Entry {
+	select {
+	case entry := <-subscription.C:
+		return entry
+	case <-time.After(time.Second):
+		s.FailNow("no event received")
+		return stream.Entry{}
+	}
+}
+
+func (s *StreamTestSuite) TestFilterAndResume() {
+	subscription, backlog := s.broker.Subscribe("", []string{"user.group"})
+	defer subscription.Close()
+	s.Empty(backlog)
+
+	s.broker.Handle(event.New(event.UserCreated, "alice", nil))
+	s.broker.Handle(event.New(event.UserJoinedGroup, "alice", nil))
+	s.broker.Handle(event.New(event.UserLeftGroup, "alice", nil))
+	joined := s.receive(subscription)
+	s.Equal(event.UserJoinedGroup, joined.Event.Type)
+	s.Equal(event.UserLeftGroup, s.receive(subscription).Event.Type)
+
+	resumed, backlog := s.broker.Subscribe(joined.Id, nil)
+	defer resumed.Close()
+	s.Len(backlog, 1)
+	s.Equal(event.UserLeftGroup, backlog[0].Event.Type)
+
+	// Evicted ids replay everything retained, unknown ones nothing.
+	s.broker.Handle(event.New(event.GroupCreated, "group", nil))
+	s.broker.Handle(event.New(event.GroupDeleted, "group", nil))
+	evicted, backlog := s.broker.Subscribe(joined.Id, nil)
+	defer evicted.Close()
+	s.Len(backlog, 3)
+	unknown, backlog := s.broker.Subscribe("other-1", nil)
+	defer unknown.Close()
+	s.Empty(backlog)
+}
+
+func (s *StreamTestSuite) TestCloseEndsSubscriptions() {
+	subscription, _ := s.broker.Subscribe("", nil)
+	s.broker.Close()
+	_, ok := <-subscription.C
+	s.False(ok)
+	subscription.Close()
+
+	later, _ := s.broker.Subscribe("", nil)
+	_, ok = <-later.C
+	s.False(ok)
+}
+
+func (s *StreamTestSuite) TestAdminEvents() {
+	start := time.Now().Add(time.Second)
+	service := &adminEventService{events: []keycloakadminclient.AdminEventRepresentation{
+		adminEvent(start.Add(-time.Hour), "USER", "CREATE", "users/old"),
+		adminEvent(start.Add(2*time.Millisecond), "GROUP_MEMBERSHIP", "CREATE", "users/bob/groups/admins"),
+		adminEvent(start.Add(time.Millisecond), "USER", "CREATE", "users/bob"),
+		adminEvent(start.Add(3*time.Millisecond), "USER", "UPDATE", "users/alice"),
+		adminEvent(start.Add(4*time.Millisecond), "CLIENT", "CREATE", "clients/x"),
+	}}
+	subscription, _ := s.broker.Subscribe("", nil)
+	defer subscription.Close()
+	// alice was updated through this API, Keycloak's admin event duplicates it.
+	s.broker.Handle(event.New(event.UserUpdated, "alice", nil))
+	s.receive(subscription)
+
+	poller := stream.NewAdminEventPoller(service, time.Hour, s.broker.HandleExternal)
+	defer poller.Stop()
+	poller.Poll()
+	poller.Poll()
+
+	created := s.receive(subscription)
+	s.Equal(event.UserCreated, created.Event.Type)
+	s.Equal("bob", created.Event.Subject)
+	joined := s.receive(subscription)
+	s.Equal(event.UserJoinedGroup, joined.Event.Type)
+	s.Equal(map[string]string{"groupId": "admins"}, joined.Event.Data)
+	select {
+	case entry := <-subscription.C:
+		s.Failf("unexpected event", "%s %s", entry.Event.Type, entry.Event.Subject)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *StreamTestSuite) TestConcurrentPollsHandEventsOnce() {
+	start := time.Now().Add(time.Second)
+	service := &adminEventService{events: []keycloakadminclient.AdminEventRepresentation{
+		adminEvent(start.Add(time.Millisecond), "USER", "CREATE", "users/bob"),
+		adminEvent(start.Add(time.Millisecond), "GROUP", "CREATE", "groups/admins"),
+	}}
+	var handled atomic.Int32
+	poller := stream.NewAdminEventPoller(service, time.Hour, func(event.Event) { handled.Add(1) })
+	defer poller.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			poller.Poll()
+		}()
+	}
+	wg.Wait()
+	s.Equal(int32(2), handled.Load())
+}
+
+func (s *StreamTestSuite) TestRoleCreatedByNameHasRoleId() {
+	created := adminEvent(time.Now(), "REALM_ROLE", "CREATE", "roles/editor")
+	created.SetRepresentation(`{"id":"role-id","name":"editor"}`)
+	e, ok := stream.AdminEventToEvent(&created)
+	s.True(ok)
+	s.Equal(event.RoleCreated, e.Type)
+	s.Equal("role-id", e.Subject)
+
+	deleted := adminEvent(time.Now(), "REALM_ROLE", "DELETE", "roles-by-id/role-id")
+	e, ok = stream.AdminEventToEvent(&deleted)
+	s.True(ok)
+	s.Equal("role-id", e.Subject)
+}
+
+func (s *StreamTestSuite) TestStreamEventsHandler() {
+	previous := resource.Stream
+	resource.Stream = s.broker
+	defer func() { resource.Stream = previous }()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events/stream", resource.StreamEventsHandler)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events/stream?type=user", nil)
+	response, err := http.DefaultClient.Do(request)
+	s.Require().NoError(err)
+	defer response.Body.Close()
+	s.Equal(http.StatusOK, response.StatusCode)
+	s.Equal("text/event-stream", response.Header.Get("Content-Type"))
+	s.Equal("no-cache", response.Header.Get("Cache-Control"))
+
+	reader := bufio.NewReader(response.Body)
+	// frame reads the lines up to the next blank one.
+	frame := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			s.Require().NoError(err)
+			if line = strings.TrimRight(line, "\n"); line == "" {
+				return lines
+			}
+			lines = append(lines, line)
+		}
+	}
+	s.Equal([]string{"retry: 3000"}, frame())
+
+	s.broker.Handle(event.New(event.GroupCreated, "admins", nil))
+	created := event.New(event.UserCreated, "alice", nil)
+	s.broker.Handle(created)
+	lines := frame()
+	s.Require().Len(lines, 3)
+	s.True(strings.HasPrefix(lines[0], "id:"))
+	s.Equal("event:"+event.UserCreated, lines[1])
+	data, ok := strings.CutPrefix(lines[2], "data:")
+	s.Require().True(ok)
+	var received event.Event
+	s.Require().NoError(json.Unmarshal([]byte(data), &received))
+	s.Equal(created.Id, received.Id)
+	s.Equal("alice", received.Subject)
+}
+
+func TestStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(StreamTestSuite))
+}
@@ -2,7 +2,9 @@ package test
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/configs"
 	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/keycloak/keycloaktest"
 	"github.com/miguoliang/arch-go/internal/resource"
 	"github.com/miguoliang/arch-go/pkg/str"
 	"github.com/stretchr/testify/suite"
@@ -15,14 +17,40 @@ import (
 
 type Suite struct {
 	suite.Suite
-	r *gin.Engine
+	r   *gin.Engine
+	cfg *configs.Config
+	// keycloak is the fake the suite runs against, nil when it runs against
+	// the Keycloak at ARCH_GO_TEST_KEYCLOAK_URL.
+	keycloak *keycloaktest.Server
 }
 
 func (s *Suite) SetupSuite() {
 	log.Println("Setup suite")
+	cfg, err := configs.Load(configs.Options{Profile: configs.ProfileTest})
+	if err != nil {
+		panic(err)
+	}
+	s.cfg = cfg
+	if url := os.Getenv("ARCH_GO_TEST_KEYCLOAK_URL"); url != "" {
+		cfg.Keycloak.URL = url
+	} else {
+		s.keycloak = keycloaktest.NewServer(keycloaktest.Options{
+			Realm:    cfg.Keycloak.Admin.Realm,
+			Username: cfg.Keycloak.Admin.Username,
+			Password: cfg.Keycloak.Admin.Password,
+		})
+		cfg.Keycloak.URL = s.keycloak.URL
+	}
+	keycloak.Configure(cfg.AdminOptions(nil))
 	s.deleteCustomRealm()
 	s.createCustomRealm()
-	s.r = resource.SetupRoutes()
+	s.r = resource.SetupRoutes(resource.Dependencies{Config: cfg})
+}
+
+func (s *Suite) TearDownSuite() {
+	if s.keycloak != nil {
+		s.keycloak.Close()
+	}
 }
 
 func (s *Suite) createCustomRealm() {
@@ -43,7 +71,7 @@ func (s *Suite) createCustomRealm() {
 
 func (s *Suite) deleteCustomRealm() {
 	client := keycloak.GetAdminClient()
-	response, err := client.RealmsAdminAPI.AdminRealmsRealmDelete(context.Background(), resource.CustomRealmName).
+	response, err := client.RealmsAdminAPI.AdminRealmsRealmDelete(context.Background(), s.cfg.Keycloak.Custom.Realm).
 		Execute()
 	if err != nil {
 		return
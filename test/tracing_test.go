@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/miguoliang/arch-go/internal/keycloak"
+	"github.com/miguoliang/arch-go/internal/tracing"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// tracedUsers answers GetUserById by calling upstream, as the generated
+// client does against Keycloak.
+type tracedUsers struct {
+	keycloak.UserService
+	client   *http.Client
+	upstream string
+}
+
+func (u *tracedUsers) GetUserById(ctx context.Context, userId string) (*keycloakadminclient.UserRepresentation, int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.upstream+"/admin/realms/trace-test/users/"+userId, nil)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	defer response.Body.Close()
+	return &keycloakadminclient.UserRepresentation{Id: &userId}, response.StatusCode, nil
+}
+
+type TracingTestSuite struct {
+	suite.Suite
+	spans    *tracetest.SpanRecorder
+	provider trace.TracerProvider
+}
+
+func (s *TracingTestSuite) SetupTest() {
+	_, err := tracing.Setup(context.Background(), tracing.Options{Exporter: tracing.ExporterNone})
+	s.NoError(err)
+	s.spans = tracetest.NewSpanRecorder()
+	s.provider = otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s.spans)))
+}
+
+func (s *TracingTestSuite) TearDownTest() {
+	otel.SetTracerProvider(s.provider)
+}
+
+func (s *TracingTestSuite) span(name string) sdktrace.ReadOnlySpan {
+	for _, span := range s.spans.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	s.FailNow("span not recorded", name)
+	return nil
+}
+
+func (s *TracingTestSuite) TestRequestIsTracedIntoKeycloak() {
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	users := keycloak.TraceUserService(&tracedUsers{
+		client:   &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		upstream: upstream.URL,
+	}, "trace-test")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(otelgin.Middleware("arch-go"))
+	r.GET("/trace-test/:id", func(c *gin.Context) {
+		_, statusCode, _ := users.GetUserById(c.Request.Context(), c.Param("id"))
+		c.Status(statusCode)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/trace-test/42", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	s.Equal(http.StatusOK, recorder.Code)
+
+	handler := s.span("/trace-test/:id")
+	service := s.span("UserService.GetUserById")
+	s.Equal("4bf92f3577b34da6a3ce929d0e0e4736", handler.SpanContext().TraceID().String())
+	s.Equal("00f067aa0ba902b7", handler.Parent().SpanID().String())
+	s.Equal(handler.SpanContext().SpanID(), service.Parent().SpanID())
+	s.Equal(handler.SpanContext().TraceID(), service.SpanContext().TraceID())
+
+	var outbound sdktrace.ReadOnlySpan
+	for _, span := range s.spans.Ended() {
+		if span.SpanKind() == trace.SpanKindClient {
+			outbound = span
+		}
+	}
+	s.Require().NotNil(outbound)
+	s.Equal(service.SpanContext().SpanID(), outbound.Parent().SpanID())
+	s.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-"+outbound.SpanContext().SpanID().String()+"-01", traceparent)
+}
+
+func (s *TracingTestSuite) TestServiceErrorsMarkTheSpan() {
+	users := keycloak.TraceUserService(&rpcUserService{users: map[string]keycloakadminclient.UserRepresentation{}}, "trace-test")
+	_, statusCode, err := users.GetUserById(context.Background(), "missing")
+	s.Error(err)
+	s.Equal(http.StatusNotFound, statusCode)
+
+	span := s.span("UserService.GetUserById")
+	s.Equal(codes.Error, span.Status().Code)
+	s.Len(span.Events(), 1)
+}
+
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/miguoliang/arch-go/internal/dto"
+	"github.com/miguoliang/arch-go/pkg/str"
+	"github.com/miguoliang/keycloakadminclient"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type UserExportTestSuite struct {
+	Suite
+}
+
+func (s *UserExportTestSuite) createUser(username string) string {
+	user := &keycloakadminclient.UserRepresentation{
+		Username: str.Ptr(username),
+	}
+	w := s.Post("/api/v1/users", user)
+	s.Equal(http.StatusCreated, w.Code)
+	var created dto.CreatedResponse
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	s.NoError(err)
+	return created.Id
+}
+
+func (s *UserExportTestSuite) TestExportNdjsonSucceed() {
+
+	userId := s.createUser("export-ndjson")
+
+	w := s.Get("/api/v1/users/export?format=ndjson&groups=true&roles=true&pageSize=1")
+	s.Equal(http.StatusOK, w.Code)
+	s.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+
+	found := false
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var record dto.UserExportRecord
+		s.NoError(json.Unmarshal(scanner.Bytes(), &record))
+		if record.User.GetId() == userId {
+			found = true
+			s.Equal("export-ndjson", record.User.GetUsername())
+			s.NotEmpty(record.Roles)
+		}
+	}
+	s.True(found)
+}
+
+func (s *UserExportTestSuite) TestExportCsvSucceed() {
+
+	s.createUser("export-csv")
+
+	w := s.Get("/api/v1/users/export?format=csv")
+	s.Equal(http.StatusOK, w.Code)
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	s.NoError(err)
+	s.Equal("id", rows[0][0])
+	s.Equal("username", rows[0][1])
+
+	found := false
+	for _, row := range rows[1:] {
+		if row[1] == "export-csv" {
+			found = true
+		}
+	}
+	s.True(found)
+}
+
+func (s *UserExportTestSuite) TestExportBadRequestWhenFormatIsUnknown() {
+
+	w := s.Get("/api/v1/users/export?format=xml")
+	s.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestUserExportTestSuite(t *testing.T) {
+	suite.Run(t, new(UserExportTestSuite))
+}
@@ -0,0 +1,199 @@
+package test
+
+import (
+	"context"
+	"github.com/miguoliang/arch-go/internal/event"
+	"github.com/miguoliang/arch-go/internal/webhook"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type WebhookTestSuite struct {
+	suite.Suite
+	store webhook.Store
+}
+
+func (s *WebhookTestSuite) SetupTest() {
+	store, err := webhook.NewFileStore(s.T().TempDir() + "/webhooks.json")
+	s.NoError(err)
+	s.store = store
+}
+
+// newDispatcher delivers to the receivers on loopback.
+func (s *WebhookTestSuite) newDispatcher(maxAttempts int) *webhook.Dispatcher {
+	return s.newDispatcherWith(webhook.Options{
+		Workers:         2,
+		MaxAttempts:     maxAttempts,
+		InitialBackoff:  10 * time.Millisecond,
+		MaxBackoff:      50 * time.Millisecond,
+		Timeout:         time.Second,
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	})
+}
+
+func (s *WebhookTestSuite) newDispatcherWith(options webhook.Options) *webhook.Dispatcher {
+	dispatcher := webhook.NewDispatcher(s.store, options)
+	s.T().Cleanup(dispatcher.Stop)
+	return dispatcher
+}
+
+func (s *WebhookTestSuite) subscribe(url string, eventTypes ...string) {
+	s.NoError(s.store.SaveSubscription(&webhook.Subscription{
+		Id:         "subscription-" + strings.Join(eventTypes, ","),
+		URL:        url,
+		EventTypes: eventTypes,
+		Secret:     "secret",
+		CreatedAt:  time.Now(),
+	}))
+}
+
+func (s *WebhookTestSuite) TestDeliverSigned() {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer receiver.Close()
+	s.subscribe(receiver.URL, event.UserCreated)
+
+	dispatcher := s.newDispatcher(1)
+	dispatcher.Handle(event.New(event.GroupCreated, "group", nil))
+	dispatcher.Handle(event.New(event.UserCreated, "user", nil))
+
+	select {
+	case r := <-received:
+		s.Equal(event.UserCreated, r.Header.Get(webhook.HeaderEvent))
+		expected := "sha256=" + webhook.Sign("secret", r.Header.Get(webhook.HeaderTimestamp), body)
+		s.Equal(expected, r.Header.Get(webhook.HeaderSignature))
+		s.Contains(string(body), `"subject":"user"`)
+	case <-time.After(5 * time.Second):
+		s.Fail("delivery not received")
+	}
+	select {
+	case r := <-received:
+		s.Failf("unexpected delivery", "event %s", r.Header.Get(webhook.HeaderEvent))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (s *WebhookTestSuite) TestRetryDeadLetterAndRedeliver() {
+	var attempts, healthy int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+	s.subscribe(receiver.URL, webhook.AllEvents)
+
+	dispatcher := s.newDispatcher(3)
+	dispatcher.Handle(event.New(event.RoleDeleted, "role", nil))
+
+	var deadLetters []webhook.Delivery
+	s.Eventually(func() bool {
+		deadLetters, _ = s.store.ListDeadLetters()
+		return len(deadLetters) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(int32(3), atomic.LoadInt32(&attempts))
+	s.Equal(3, deadLetters[0].Attempts)
+	s.Equal(http.StatusServiceUnavailable, deadLetters[0].LastStatusCode)
+
+	atomic.StoreInt32(&healthy, 1)
+	_, err := dispatcher.Redeliver(deadLetters[0].Id)
+	s.NoError(err)
+	s.Eventually(func() bool {
+		return atomic.LoadInt32(&attempts) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	deadLetters, _ = s.store.ListDeadLetters()
+	s.Empty(deadLetters)
+
+	_, err = dispatcher.Redeliver("missing")
+	s.ErrorIs(err, webhook.ErrNotFound)
+}
+
+func (s *WebhookTestSuite) TestRefusePrivateTargets() {
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hook",
+		"http://172.16.5.4/hook",
+		"http://192.168.1.1/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	} {
+		s.Error(webhook.CheckURL(context.Background(), url, nil), url)
+	}
+	s.NoError(webhook.CheckURL(context.Background(), "https://93.184.216.34/hook", nil))
+	allowed, err := webhook.ParseNetworks([]string{"10.1.0.0/16"})
+	s.NoError(err)
+	s.NoError(webhook.CheckURL(context.Background(), "http://10.1.2.3/hook", allowed))
+	s.Error(webhook.CheckURL(context.Background(), "http://10.2.2.3/hook", allowed))
+}
+
+func (s *WebhookTestSuite) TestRefusePrivateTargetsWhenDelivering() {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+	}))
+	defer receiver.Close()
+	s.subscribe(receiver.URL, webhook.AllEvents)
+
+	dispatcher := s.newDispatcherWith(webhook.Options{MaxAttempts: 1, Timeout: time.Second})
+	dispatcher.Handle(event.New(event.UserCreated, "user", nil))
+
+	s.Eventually(func() bool {
+		deadLetters, _ := s.store.ListDeadLetters()
+		return len(deadLetters) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(int32(0), atomic.LoadInt32(&attempts))
+}
+
+func (s *WebhookTestSuite) TestFullQueueMovesToDeadLetters() {
+	received := make(chan struct{}, 8)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer receiver.Close()
+	defer close(release)
+	s.subscribe(receiver.URL, webhook.AllEvents)
+
+	dispatcher := s.newDispatcherWith(webhook.Options{
+		Workers:         1,
+		MaxAttempts:     1,
+		Timeout:         5 * time.Second,
+		QueueSize:       1,
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	})
+	dispatcher.Handle(event.New(event.UserCreated, "in-flight", nil))
+	<-received
+	dispatcher.Handle(event.New(event.UserCreated, "queued", nil))
+	dispatcher.Handle(event.New(event.UserCreated, "dropped", nil))
+
+	deadLetters, err := s.store.ListDeadLetters()
+	s.NoError(err)
+	s.Len(deadLetters, 1)
+	s.Equal("dropped", deadLetters[0].Event.Subject)
+	s.Equal(webhook.ErrQueueFull.Error(), deadLetters[0].LastError)
+
+	_, err = dispatcher.Redeliver(deadLetters[0].Id)
+	s.ErrorIs(err, webhook.ErrQueueFull)
+	deadLetters, err = s.store.ListDeadLetters()
+	s.NoError(err)
+	s.Len(deadLetters, 1)
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}